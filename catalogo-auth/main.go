@@ -1,9 +1,18 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"net/http"
-	"os"
+	"strings"
+
+	"catalogo-comun/basedatos"
+	"catalogo-comun/condicional"
+	"catalogo-comun/idempotencia"
+	"catalogo-comun/listado"
+	"catalogo-comun/papelera"
+	"catalogo-comun/parche"
+	dominio "catalogo-dominio"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/postgres"
@@ -15,18 +24,54 @@ var db *gorm.DB
 // PerfilImpresion representa la configuración de impresión para un material específico
 type PerfilImpresion struct {
 	gorm.Model
-	MaterialID         uint    `gorm:"not null" json:"material_id"`
-	Nombre             string  `gorm:"not null" json:"nombre"`
-	Descripcion        string  `json:"descripcion"`
-	TemperaturaNozzle  int     `json:"temperatura_nozzle"` // °C
-	TemperaturaCama    int     `json:"temperatura_cama"`   // °C
-	VelocidadImpresion int     `json:"velocidad_impresion"` // mm/s
-	AlturaCapa         float64 `json:"altura_capa"`         // mm
-	Relleno            int     `json:"relleno"`             // %
-	VelocidadRetraccion int    `json:"velocidad_retraccion"` // mm/s
+	MaterialID          uint    `gorm:"not null" json:"material_id"`
+	Nombre              string  `gorm:"not null" json:"nombre"`
+	Descripcion         string  `json:"descripcion"`
+	TemperaturaNozzle   int     `json:"temperatura_nozzle"`   // °C
+	TemperaturaCama     int     `json:"temperatura_cama"`     // °C
+	VelocidadImpresion  int     `json:"velocidad_impresion"`  // mm/s
+	AlturaCapa          float64 `json:"altura_capa"`          // mm
+	Relleno             int     `json:"relleno"`              // %
+	VelocidadRetraccion int     `json:"velocidad_retraccion"` // mm/s
 	DistanciaRetraccion float64 `json:"distancia_retraccion"` // mm
-	VelocidadVentilador int    `json:"velocidad_ventilador"` // %
-	EsRecomendado      bool    `gorm:"default:false" json:"es_recomendado"`
+	VelocidadVentilador int     `json:"velocidad_ventilador"` // %
+	EsRecomendado       bool    `gorm:"default:false" json:"es_recomendado"`
+	Version             uint    `gorm:"not null;default:1" json:"version"` // Se incrementa en cada escritura
+}
+
+// ordenablesPerfiles son los campos por los que se puede ordenar el listado
+// de perfiles. Las claves coinciden con las columnas en PostgreSQL
+var ordenablesPerfiles = map[string]func(PerfilImpresion) any{
+	"id":                  func(p PerfilImpresion) any { return float64(p.ID) },
+	"material_id":         func(p PerfilImpresion) any { return float64(p.MaterialID) },
+	"nombre":              func(p PerfilImpresion) any { return p.Nombre },
+	"temperatura_nozzle":  func(p PerfilImpresion) any { return float64(p.TemperaturaNozzle) },
+	"temperatura_cama":    func(p PerfilImpresion) any { return float64(p.TemperaturaCama) },
+	"velocidad_impresion": func(p PerfilImpresion) any { return float64(p.VelocidadImpresion) },
+	"altura_capa":         func(p PerfilImpresion) any { return p.AlturaCapa },
+	"relleno":             func(p PerfilImpresion) any { return float64(p.Relleno) },
+}
+
+// camposPerfiles son los campos que se pueden pedir con ?fields=. Los de
+// gorm.Model se serializan con su nombre en Go
+var camposPerfiles = map[string]bool{
+	"ID":                   true,
+	"CreatedAt":            true,
+	"UpdatedAt":            true,
+	"DeletedAt":            true,
+	"material_id":          true,
+	"nombre":               true,
+	"descripcion":          true,
+	"temperatura_nozzle":   true,
+	"temperatura_cama":     true,
+	"velocidad_impresion":  true,
+	"altura_capa":          true,
+	"relleno":              true,
+	"velocidad_retraccion": true,
+	"distancia_retraccion": true,
+	"velocidad_ventilador": true,
+	"es_recomendado":       true,
+	"version":              true,
 }
 
 func main() {
@@ -36,49 +81,66 @@ func main() {
 
 	r := gin.Default()
 
+	// Las claves de Idempotency-Key se guardan en la misma base de datos
+	almacenIdempotencia := idempotencia.SQL{DB: db}
+
 	// Rutas API
-	api := r.Group("/api/v1")
+	api := r.Group("/api/v1", idempotencia.Middleware(almacenIdempotencia, nil))
 	{
 		// Perfiles de impresión
 		api.GET("/perfiles-impresion", getPerfilesImpresion)
+		api.GET("/perfiles-impresion/papelera", getPerfilesPapelera)
 		api.GET("/perfiles-impresion/:id", getPerfilImpresion)
 		api.GET("/perfiles-impresion/material/:materialId", getPerfilesPorMaterial)
 		api.GET("/perfiles-impresion/recomendados", getPerfilesRecomendados)
 		api.POST("/perfiles-impresion", createPerfilImpresion)
 		api.PUT("/perfiles-impresion/:id", updatePerfilImpresion)
+		api.PATCH("/perfiles-impresion/:id", patchPerfilImpresion)
 		api.DELETE("/perfiles-impresion/:id", deletePerfilImpresion)
+		api.POST("/perfiles-impresion/:id/restaurar", restaurarPerfilImpresion)
 	}
 
+	papelera.IniciarPurga(purgarPapelera)
+	idempotencia.IniciarPurga(almacenIdempotencia)
+
 	log.Printf("Iniciando servicio de configuraciones de impresión en :8083")
 	r.Run(":8083")
 }
 
 func setupDB() error {
-	postgresEndpoint := os.Getenv("POSTGRES_ENDPOINT")
-	if postgresEndpoint == "" {
-		postgresEndpoint = "localhost"
-	}
-
-	dsn := "host=" + postgresEndpoint + " user=postgres password=postgres123 dbname=configuraciones port=5432 sslmode=disable"
 	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err = gorm.Open(postgres.Open(basedatos.DSN("configuraciones")), &gorm.Config{})
 	if err != nil {
 		return err
 	}
 
 	// Migrar el esquema
-	return db.AutoMigrate(&PerfilImpresion{})
+	return db.AutoMigrate(&PerfilImpresion{}, &idempotencia.Registro{})
 }
 
-// getPerfilesImpresion obtiene todos los perfiles de impresión
+// getPerfilesImpresion obtiene una página de perfiles de impresión. Admite
+// page/page_size o cursor, sort y fields
 func getPerfilesImpresion(c *gin.Context) {
-	var perfiles []PerfilImpresion
-	if err := db.Find(&perfiles).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener perfiles"})
+	params, err := listado.Parse(c, ordenablesPerfiles, camposPerfiles)
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": perfiles})
+	q := db.Model(&PerfilImpresion{}).Session(&gorm.Session{})
+	var res listado.Resultado
+	if err := q.Count(&res.Total).Error; err != nil {
+		responderMensaje(c, http.StatusInternalServerError, "Error al obtener perfiles")
+		return
+	}
+
+	perfiles, err := listado.SQL(q, params.Orden, params.Ventana, ordenablesPerfiles, nil, &res)
+	if err != nil {
+		responderMensaje(c, http.StatusInternalServerError, "Error al obtener perfiles")
+		return
+	}
+
+	responderPagina(c, perfiles, res, params.Ventana, params.Campos)
 }
 
 // getPerfilImpresion obtiene un perfil de impresión por ID
@@ -87,10 +149,11 @@ func getPerfilImpresion(c *gin.Context) {
 	var perfil PerfilImpresion
 
 	if err := db.First(&perfil, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Perfil no encontrado"})
+		responderMensaje(c, http.StatusNotFound, "Perfil no encontrado")
 		return
 	}
 
+	c.Header("ETag", condicional.ETag(int(perfil.Version)))
 	c.JSON(http.StatusOK, gin.H{"data": perfil})
 }
 
@@ -100,7 +163,7 @@ func getPerfilesPorMaterial(c *gin.Context) {
 	var perfiles []PerfilImpresion
 
 	if err := db.Where("material_id = ?", materialID).Find(&perfiles).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener perfiles"})
+		responderMensaje(c, http.StatusInternalServerError, "Error al obtener perfiles")
 		return
 	}
 
@@ -112,7 +175,7 @@ func getPerfilesRecomendados(c *gin.Context) {
 	var perfiles []PerfilImpresion
 
 	if err := db.Where("es_recomendado = ?", true).Find(&perfiles).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener perfiles recomendados"})
+		responderMensaje(c, http.StatusInternalServerError, "Error al obtener perfiles recomendados")
 		return
 	}
 
@@ -123,55 +186,170 @@ func getPerfilesRecomendados(c *gin.Context) {
 func createPerfilImpresion(c *gin.Context) {
 	var perfil PerfilImpresion
 	if err := c.ShouldBindJSON(&perfil); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := validarPerfil(perfil); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	perfil.Version = 1
 	if err := db.Create(&perfil).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al crear el perfil"})
+		responderMensaje(c, http.StatusInternalServerError, "Error al crear el perfil")
 		return
 	}
 
+	c.Header("ETag", condicional.ETag(int(perfil.Version)))
 	c.JSON(http.StatusCreated, gin.H{"data": perfil})
 }
 
-// updatePerfilImpresion actualiza un perfil existente
+// updatePerfilImpresion actualiza un perfil existente. Si se envía If-Match
+// solo se actualiza cuando coincide con la versión actual
 func updatePerfilImpresion(c *gin.Context) {
 	id := c.Param("id")
 	var perfil PerfilImpresion
 
 	if err := db.First(&perfil, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Perfil no encontrado"})
+		responderMensaje(c, http.StatusNotFound, "Perfil no encontrado")
+		return
+	}
+
+	if !condicional.CumpleIfMatch(c, int(perfil.Version)) {
+		responderMensaje(c, http.StatusPreconditionFailed, "El perfil fue modificado por otro usuario")
 		return
 	}
 
+	actual := perfil
 	if err := c.ShouldBindJSON(&perfil); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	// El perfil es el de la ruta y las fechas de gorm.Model las administra el
+	// servicio, aunque el cuerpo traiga otros valores
+	perfil.ID = actual.ID
+	perfil.CreatedAt = actual.CreatedAt
+	perfil.DeletedAt = actual.DeletedAt
+
+	if err := validarPerfil(perfil); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if err := db.Save(&perfil).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar el perfil"})
+	guardarPerfil(c, &perfil, actual.Version)
+}
+
+// patchPerfilImpresion aplica un JSON Merge Patch (RFC 7386) o un JSON Patch
+// (RFC 6902) sobre un perfil y valida el resultado antes de guardarlo
+func patchPerfilImpresion(c *gin.Context) {
+	id := c.Param("id")
+	var actual PerfilImpresion
+
+	if err := db.First(&actual, id).Error; err != nil {
+		responderMensaje(c, http.StatusNotFound, "Perfil no encontrado")
 		return
 	}
 
+	if !condicional.CumpleIfMatch(c, int(actual.Version)) {
+		responderMensaje(c, http.StatusPreconditionFailed, "El perfil fue modificado por otro usuario")
+		return
+	}
+
+	var perfil PerfilImpresion
+	if err := parche.Aplicar(c, actual, &perfil); err != nil {
+		responderMensaje(c, parche.Estado(err), err.Error())
+		return
+	}
+	if perfil.ID != actual.ID {
+		responderMensaje(c, http.StatusBadRequest, "El ID del perfil no se puede modificar")
+		return
+	}
+	// Las fechas de gorm.Model las administra el servicio, no el cliente
+	perfil.CreatedAt = actual.CreatedAt
+	perfil.DeletedAt = actual.DeletedAt
+
+	if err := validarPerfil(perfil); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	guardarPerfil(c, &perfil, actual.Version)
+}
+
+// guardarPerfil escribe el perfil solo si su versión sigue siendo anterior,
+// lo que evita perder escrituras concurrentes entre la lectura y la
+// actualización, y responde con el perfil guardado
+func guardarPerfil(c *gin.Context, perfil *PerfilImpresion, anterior uint) {
+	perfil.Version = anterior + 1
+	res := db.Model(perfil).Where("version = ?", anterior).Select("*").Updates(perfil)
+	if res.Error != nil {
+		responderMensaje(c, http.StatusInternalServerError, "Error al actualizar el perfil")
+		return
+	}
+	if res.RowsAffected == 0 {
+		responderMensaje(c, http.StatusPreconditionFailed, "El perfil fue modificado por otro usuario")
+		return
+	}
+
+	c.Header("ETag", condicional.ETag(int(perfil.Version)))
 	c.JSON(http.StatusOK, gin.H{"data": perfil})
 }
 
-// deletePerfilImpresion elimina un perfil
+// validarPerfil comprueba que un perfil tenga valores coherentes antes de guardarlo
+func validarPerfil(p PerfilImpresion) error {
+	switch {
+	case strings.TrimSpace(p.Nombre) == "":
+		return errors.New("nombre es obligatorio")
+	case p.MaterialID == 0:
+		return errors.New("material_id es obligatorio")
+	case p.AlturaCapa < 0:
+		return errors.New("altura_capa no puede ser negativa")
+	case p.Relleno < 0 || p.Relleno > 100:
+		return errors.New("relleno debe estar entre 0 y 100")
+	case p.VelocidadVentilador < 0 || p.VelocidadVentilador > 100:
+		return errors.New("velocidad_ventilador debe estar entre 0 y 100")
+	}
+	return nil
+}
+
+// deletePerfilImpresion elimina un perfil. Si se envía If-Match solo se
+// elimina cuando coincide con la versión actual
 func deletePerfilImpresion(c *gin.Context) {
 	id := c.Param("id")
 	var perfil PerfilImpresion
 
 	if err := db.First(&perfil, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Perfil no encontrado"})
+		responderMensaje(c, http.StatusNotFound, "Perfil no encontrado")
 		return
 	}
 
-	if err := db.Delete(&perfil).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al eliminar el perfil"})
+	if !condicional.CumpleIfMatch(c, int(perfil.Version)) {
+		responderMensaje(c, http.StatusPreconditionFailed, "El perfil fue modificado por otro usuario")
+		return
+	}
+
+	res := db.Where("version = ?", perfil.Version).Delete(&perfil)
+	if res.Error != nil {
+		responderMensaje(c, http.StatusInternalServerError, "Error al eliminar el perfil")
+		return
+	}
+	if res.RowsAffected == 0 {
+		responderMensaje(c, http.StatusPreconditionFailed, "El perfil fue modificado por otro usuario")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Perfil eliminado correctamente"})
 }
+
+// responderMensaje responde un error con el código de su estado
+func responderMensaje(c *gin.Context, estado int, mensaje string) {
+	c.JSON(estado, dominio.NuevoError(estado, mensaje))
+}
+
+// responderPagina escribe el sobre {"data", "meta", "links"} de un listado paginado
+func responderPagina[T any](c *gin.Context, elementos []T, res listado.Resultado, v listado.Ventana, campos []string) {
+	if err := listado.Responder(c, elementos, res, v, campos); err != nil {
+		responderMensaje(c, http.StatusInternalServerError, "Error al serializar la respuesta")
+	}
+}
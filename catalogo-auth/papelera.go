@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"catalogo-comun/condicional"
+	"catalogo-comun/listado"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// purgarPapelera borra definitivamente los perfiles eliminados antes de
+// limite y devuelve cuántos borró
+func purgarPapelera(ctx context.Context, limite time.Time) (int64, error) {
+	res := db.WithContext(ctx).Unscoped().Where("deleted_at < ?", limite).Delete(&PerfilImpresion{})
+	return res.RowsAffected, res.Error
+}
+
+// getPerfilesPapelera obtiene una página de perfiles eliminados que aún no se
+// han purgado, con la misma paginación, orden y campos que el listado
+func getPerfilesPapelera(c *gin.Context) {
+	params, err := listado.Parse(c, ordenablesPerfiles, camposPerfiles)
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	q := db.Unscoped().Model(&PerfilImpresion{}).Where("deleted_at IS NOT NULL").Session(&gorm.Session{})
+	var res listado.Resultado
+	if err := q.Count(&res.Total).Error; err != nil {
+		responderMensaje(c, http.StatusInternalServerError, "Error al obtener la papelera")
+		return
+	}
+
+	perfiles, err := listado.SQL(q, params.Orden, params.Ventana, ordenablesPerfiles, nil, &res)
+	if err != nil {
+		responderMensaje(c, http.StatusInternalServerError, "Error al obtener la papelera")
+		return
+	}
+
+	responderPagina(c, perfiles, res, params.Ventana, params.Campos)
+}
+
+// restaurarPerfilImpresion devuelve un perfil eliminado a los listados
+func restaurarPerfilImpresion(c *gin.Context) {
+	id := c.Param("id")
+
+	res := db.Unscoped().Model(&PerfilImpresion{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Updates(map[string]any{"deleted_at": nil, "version": gorm.Expr("version + 1")})
+	if res.Error != nil {
+		responderMensaje(c, http.StatusInternalServerError, "Error al restaurar el perfil")
+		return
+	}
+	if res.RowsAffected == 0 {
+		responderMensaje(c, http.StatusNotFound, "Perfil no encontrado en la papelera")
+		return
+	}
+
+	var perfil PerfilImpresion
+	if err := db.First(&perfil, id).Error; err != nil {
+		responderMensaje(c, http.StatusInternalServerError, "Error al restaurar el perfil")
+		return
+	}
+
+	c.Header("ETag", condicional.ETag(int(perfil.Version)))
+	c.JSON(http.StatusOK, gin.H{"data": perfil})
+}
@@ -0,0 +1,38 @@
+// Package basedatos arma la cadena de conexión a PostgreSQL de los servicios a
+// partir de las variables de entorno, para que ninguna credencial quede
+// escrita en el código.
+package basedatos
+
+import (
+	"net"
+	"net/url"
+	"os"
+)
+
+// DSN devuelve la cadena de conexión a PostgreSQL. Lee POSTGRES_ENDPOINT
+// (por defecto localhost), POSTGRES_PORT (5432), POSTGRES_USER (postgres),
+// POSTGRES_PASSWORD, POSTGRES_DB (baseDatos) y POSTGRES_SSLMODE (disable).
+// Sin POSTGRES_PASSWORD se conecta sin contraseña, como con autenticación
+// trust o peer
+func DSN(baseDatos string) string {
+	u := url.URL{
+		Scheme: "postgres",
+		Host:   net.JoinHostPort(entorno("POSTGRES_ENDPOINT", "localhost"), entorno("POSTGRES_PORT", "5432")),
+		Path:   "/" + entorno("POSTGRES_DB", baseDatos),
+	}
+	usuario := entorno("POSTGRES_USER", "postgres")
+	if clave, ok := os.LookupEnv("POSTGRES_PASSWORD"); ok {
+		u.User = url.UserPassword(usuario, clave)
+	} else {
+		u.User = url.User(usuario)
+	}
+	u.RawQuery = url.Values{"sslmode": {entorno("POSTGRES_SSLMODE", "disable")}}.Encode()
+	return u.String()
+}
+
+func entorno(nombre, porDefecto string) string {
+	if v := os.Getenv(nombre); v != "" {
+		return v
+	}
+	return porDefecto
+}
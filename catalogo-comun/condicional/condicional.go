@@ -0,0 +1,41 @@
+// Package condicional implementa las peticiones condicionales con ETag e
+// If-Match a partir del número de versión de un recurso, que se incrementa
+// en cada escritura.
+package condicional
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ETag construye el valor de la cabecera ETag a partir de la versión del recurso
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// VersionIfMatch interpreta la cabecera If-Match. Devuelve 0 cuando la
+// cabecera no viene o es "*", es decir, cuando cualquier versión es válida.
+// Devuelve ok=false si el valor no corresponde a ninguna versión posible,
+// lo que debe responderse con 412
+func VersionIfMatch(c *gin.Context) (version int, ok bool) {
+	valor := strings.TrimSpace(c.GetHeader("If-Match"))
+	if valor == "" || valor == "*" {
+		return 0, true
+	}
+	valor = strings.TrimPrefix(valor, "W/")
+	valor = strings.Trim(valor, `"`)
+	version, err := strconv.Atoi(valor)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+	return version, true
+}
+
+// CumpleIfMatch indica si la cabecera If-Match, cuando viene, corresponde a
+// la versión actual del recurso
+func CumpleIfMatch(c *gin.Context, actual int) bool {
+	version, ok := VersionIfMatch(c)
+	return ok && (version == 0 || version == actual)
+}
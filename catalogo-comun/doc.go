@@ -0,0 +1,13 @@
+// Package comun agrupa, en subpaquetes, el código HTTP que comparten los
+// servicios del catálogo y que no depende de ningún recurso concreto:
+//
+//   - listado: paginación por página o por cursor, orden y selección de campos
+//   - parche: JSON Merge Patch y JSON Patch sobre un recurso
+//   - condicional: ETag e If-Match a partir de la versión de un recurso
+//   - idempotencia: el middleware de Idempotency-Key y sus almacenes
+//   - papelera: la purga periódica de lo eliminado
+//   - basedatos: la conexión a PostgreSQL a partir del entorno
+//
+// Los tipos del dominio siguen en catalogo-dominio, que no depende de gin ni
+// de gorm.
+package comun
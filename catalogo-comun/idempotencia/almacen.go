@@ -0,0 +1,128 @@
+package idempotencia
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Registro guarda la respuesta a un POST con Idempotency-Key para repetirla
+// en los reintentos. Estado es 0 mientras la petición original está en
+// curso. Huella resume el método, la URL y el cuerpo de la petición para
+// rechazar la misma clave con otra petición
+type Registro struct {
+	Clave         string `gorm:"primaryKey"`
+	Huella        string `gorm:"not null;default:''"`
+	Estado        int    `gorm:"not null;default:0"`
+	TipoContenido string `gorm:"not null;default:''"`
+	ETag          string `gorm:"column:etag;not null;default:''"`
+	Cuerpo        []byte
+	CaducaEn      time.Time `gorm:"not null;index"`
+}
+
+func (Registro) TableName() string {
+	return "idempotencia"
+}
+
+// Almacen guarda los registros de idempotencia. Para que los reintentos
+// funcionen entre instancias del servicio debe ser compartido, como SQL;
+// Memoria solo sirve para una instancia.
+//
+// ReservarIdempotencia marca la clave como en curso hasta caduca si no existe
+// o si su registro caducó antes de ahora; si no, devuelve el registro
+// existente y false. De dos reservas simultáneas de la misma clave solo una
+// puede tener éxito. CompletarIdempotencia guarda la respuesta,
+// LiberarIdempotencia borra la clave para que se pueda reintentar y
+// PurgarIdempotencia borra las caducadas antes de limite
+type Almacen interface {
+	ReservarIdempotencia(ctx context.Context, clave string, ahora, caduca time.Time) (Registro, bool, error)
+	CompletarIdempotencia(ctx context.Context, registro Registro) error
+	LiberarIdempotencia(ctx context.Context, clave string) error
+	PurgarIdempotencia(ctx context.Context, limite time.Time) (int64, error)
+}
+
+// SQL guarda los registros en la tabla idempotencia de PostgreSQL
+type SQL struct {
+	DB *gorm.DB
+}
+
+func (s SQL) ReservarIdempotencia(ctx context.Context, clave string, ahora, caduca time.Time) (Registro, bool, error) {
+	// La inserción y el reemplazo de un registro caducado son atómicos: de
+	// dos peticiones simultáneas con la misma clave solo una la reserva
+	res := s.DB.WithContext(ctx).Exec(`INSERT INTO idempotencia (clave, huella, estado, tipo_contenido, etag, cuerpo, caduca_en)
+		VALUES (?, '', 0, '', '', NULL, ?)
+		ON CONFLICT (clave) DO UPDATE SET huella = '', estado = 0, tipo_contenido = '', etag = '', cuerpo = NULL, caduca_en = EXCLUDED.caduca_en
+		WHERE idempotencia.caduca_en < ?`, clave, caduca, ahora)
+	if res.Error != nil {
+		return Registro{}, false, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return Registro{}, true, nil
+	}
+	var previo Registro
+	err := s.DB.WithContext(ctx).First(&previo, "clave = ?", clave).Error
+	return previo, false, err
+}
+
+func (s SQL) CompletarIdempotencia(ctx context.Context, registro Registro) error {
+	return s.DB.WithContext(ctx).Save(&registro).Error
+}
+
+func (s SQL) LiberarIdempotencia(ctx context.Context, clave string) error {
+	return s.DB.WithContext(ctx).Delete(&Registro{}, "clave = ?", clave).Error
+}
+
+func (s SQL) PurgarIdempotencia(ctx context.Context, limite time.Time) (int64, error) {
+	res := s.DB.WithContext(ctx).Where("caduca_en < ?", limite).Delete(&Registro{})
+	return res.RowsAffected, res.Error
+}
+
+// Memoria guarda los registros en memoria, para los repositorios en memoria
+// de desarrollo y pruebas. El valor cero no sirve; se crea con NuevaMemoria
+type Memoria struct {
+	mu        sync.Mutex
+	registros map[string]Registro
+}
+
+func NuevaMemoria() *Memoria {
+	return &Memoria{registros: make(map[string]Registro)}
+}
+
+func (m *Memoria) ReservarIdempotencia(ctx context.Context, clave string, ahora, caduca time.Time) (Registro, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if previo, ok := m.registros[clave]; ok && !previo.CaducaEn.Before(ahora) {
+		return previo, false, nil
+	}
+	m.registros[clave] = Registro{Clave: clave, CaducaEn: caduca}
+	return Registro{}, true, nil
+}
+
+func (m *Memoria) CompletarIdempotencia(ctx context.Context, registro Registro) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registros[registro.Clave] = registro
+	return nil
+}
+
+func (m *Memoria) LiberarIdempotencia(ctx context.Context, clave string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.registros, clave)
+	return nil
+}
+
+func (m *Memoria) PurgarIdempotencia(ctx context.Context, limite time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for clave, reg := range m.registros {
+		if reg.CaducaEn.Before(limite) {
+			delete(m.registros, clave)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,239 @@
+// Package idempotencia implementa la cabecera Idempotency-Key de los POST:
+// repetir una petición con la misma clave devuelve la respuesta guardada en
+// vez de volver a ejecutarla. Los registros se guardan en un Almacen.
+package idempotencia
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"maps"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	dominio "catalogo-dominio"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	Cabecera = "Idempotency-Key"
+	// longitudMaximaClave limita la clave que envía el cliente
+	longitudMaximaClave = 255
+	// horasPorDefecto es cuánto se recuerda una clave si no se configura
+	// IDEMPOTENCIA_TTL_HORAS
+	horasPorDefecto = 24
+	// espera es cuánto se reserva una clave mientras su petición está en
+	// curso; si el servicio se cae a medias, pasado ese tiempo la clave se
+	// puede volver a usar
+	espera = 5 * time.Minute
+)
+
+// Traductor traduce un mensaje de error al idioma de la petición
+type Traductor func(c *gin.Context, mensaje string) string
+
+// TTL lee IDEMPOTENCIA_TTL_HORAS, cuánto se recuerda la respuesta de una clave
+func TTL() time.Duration {
+	if n, err := strconv.Atoi(os.Getenv("IDEMPOTENCIA_TTL_HORAS")); err == nil && n > 0 {
+		return time.Duration(n) * time.Hour
+	}
+	return horasPorDefecto * time.Hour
+}
+
+// respuestaGrabada copia el cuerpo de la respuesta mientras se escribe
+type respuestaGrabada struct {
+	gin.ResponseWriter
+	cuerpo bytes.Buffer
+}
+
+func (w *respuestaGrabada) Write(b []byte) (int, error) {
+	w.cuerpo.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *respuestaGrabada) WriteString(s string) (int, error) {
+	w.cuerpo.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Middleware hace que repetir un POST con la misma cabecera Idempotency-Key
+// no vuelva a ejecutarlo: la primera petición se ejecuta y su respuesta se
+// guarda en almacen; los reintentos con la misma petición reciben esa
+// respuesta con Idempotent-Replayed: true. La clave con una petición distinta
+// se rechaza con 422 y, mientras la primera sigue en curso, con 409. Las
+// respuestas 5xx no se guardan para que el reintento se ejecute de nuevo.
+// traducir, si no es nil, traduce los mensajes de error
+func Middleware(almacen Almacen, traducir Traductor) gin.HandlerFunc {
+	if traducir == nil {
+		traducir = func(c *gin.Context, mensaje string) string { return mensaje }
+	}
+	return func(c *gin.Context) {
+		clave := c.GetHeader(Cabecera)
+		if c.Request.Method != http.MethodPost || clave == "" {
+			c.Next()
+			return
+		}
+		if len(clave) > longitudMaximaClave {
+			abortar(c, http.StatusBadRequest, traducir(c, "Idempotency-Key admite como máximo 255 caracteres"))
+			return
+		}
+
+		ctx := c.Request.Context()
+		ahora := time.Now().UTC()
+		previo, reservada, err := almacen.ReservarIdempotencia(ctx, clave, ahora, ahora.Add(espera))
+		if err != nil {
+			log.Printf("Error al reservar la Idempotency-Key %q: %v", clave, err)
+			abortar(c, http.StatusInternalServerError, traducir(c, "Error interno del servidor"))
+			return
+		}
+		if !reservada {
+			repetir(c, previo, traducir)
+			return
+		}
+
+		// La huella se calcula a medida que el handler lee el cuerpo, sin
+		// guardarlo entero en memoria
+		huella := nuevaHuella(c.Request)
+		cuerpo := c.Request.Body
+		leido := io.TeeReader(cuerpo, huella)
+		if !esMultipart(c.Request) {
+			c.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{leido, cuerpo}
+		}
+		w := &respuestaGrabada{ResponseWriter: c.Writer}
+		c.Writer = w
+
+		c.Next()
+
+		// Lo que el handler no llegó a leer también cuenta para la huella. Si
+		// el cliente se desconecta la respuesta se guarda igualmente
+		if err := completarHuella(huella, c.Request, leido); err != nil {
+			log.Printf("Error al calcular la huella de la Idempotency-Key %q: %v", clave, err)
+		}
+		ctx = context.WithoutCancel(ctx)
+		if w.Status() >= http.StatusInternalServerError {
+			if err := almacen.LiberarIdempotencia(ctx, clave); err != nil {
+				log.Printf("Error al liberar la Idempotency-Key %q: %v", clave, err)
+			}
+			return
+		}
+		registro := Registro{
+			Clave:         clave,
+			Huella:        hex.EncodeToString(huella.Sum(nil)),
+			Estado:        w.Status(),
+			TipoContenido: w.Header().Get("Content-Type"),
+			ETag:          w.Header().Get("ETag"),
+			Cuerpo:        w.cuerpo.Bytes(),
+			CaducaEn:      time.Now().UTC().Add(TTL()),
+		}
+		if err := almacen.CompletarIdempotencia(ctx, registro); err != nil {
+			log.Printf("Error al guardar la respuesta de la Idempotency-Key %q: %v", clave, err)
+		}
+	}
+}
+
+// repetir responde a un reintento con la respuesta guardada
+func repetir(c *gin.Context, previo Registro, traducir Traductor) {
+	if previo.Estado == 0 {
+		c.AbortWithStatusJSON(http.StatusConflict, dominio.RespuestaError{
+			Error:  traducir(c, "Hay otra petición en curso con la misma Idempotency-Key"),
+			Codigo: dominio.CodigoIdempotenciaEnCurso,
+		})
+		return
+	}
+	huella := nuevaHuella(c.Request)
+	if err := completarHuella(huella, c.Request, c.Request.Body); err != nil {
+		abortar(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if hex.EncodeToString(huella.Sum(nil)) != previo.Huella {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, dominio.RespuestaError{
+			Error:  traducir(c, "La Idempotency-Key ya se usó con otra petición"),
+			Codigo: dominio.CodigoIdempotenciaReutilizada,
+		})
+		return
+	}
+
+	if previo.ETag != "" {
+		c.Header("ETag", previo.ETag)
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(previo.Estado, previo.TipoContenido, previo.Cuerpo)
+	c.Abort()
+}
+
+func abortar(c *gin.Context, estado int, mensaje string) {
+	c.AbortWithStatusJSON(estado, dominio.NuevoError(estado, mensaje))
+}
+
+// nuevaHuella empieza la huella de una petición con su método y su URL
+func nuevaHuella(r *http.Request) hash.Hash {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	return h
+}
+
+func esMultipart(r *http.Request) bool {
+	tipo, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return tipo == "multipart/form-data"
+}
+
+// completarHuella añade a la huella lo que quede por leer del cuerpo. Un
+// formulario multipart se resume por sus campos y archivos, no por sus bytes,
+// porque cada reintento suele llevar un separador distinto
+func completarHuella(h hash.Hash, r *http.Request, cuerpo io.Reader) error {
+	if !esMultipart(r) {
+		_, err := io.Copy(h, cuerpo)
+		return err
+	}
+	if r.MultipartForm == nil {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return err
+		}
+	}
+	form := r.MultipartForm
+	for _, campo := range slices.Sorted(maps.Keys(form.Value)) {
+		for _, v := range form.Value[campo] {
+			fmt.Fprintf(h, "%q=%q\n", campo, v)
+		}
+	}
+	for _, campo := range slices.Sorted(maps.Keys(form.File)) {
+		for _, fh := range form.File[campo] {
+			fmt.Fprintf(h, "%q:%q:%d\n", campo, fh.Filename, fh.Size)
+			f, err := fh.Open()
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// IniciarPurga borra de almacen, al arrancar y luego cada hora, las claves
+// caducadas
+func IniciarPurga(almacen Almacen) {
+	go func() {
+		for {
+			if _, err := almacen.PurgarIdempotencia(context.Background(), time.Now().UTC()); err != nil {
+				log.Printf("Error al purgar las Idempotency-Key caducadas: %v", err)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
@@ -0,0 +1,171 @@
+package idempotencia
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// servidor monta el middleware sobre un POST que cuenta sus ejecuciones y
+// responde con el número de ejecución y el estado pedido en ?estado=
+func servidor(almacen Almacen) (*gin.Engine, *int) {
+	gin.SetMode(gin.TestMode)
+	ejecuciones := 0
+	r := gin.New()
+	r.Use(Middleware(almacen, nil))
+	r.POST("/recursos", func(c *gin.Context) {
+		ejecuciones++
+		// Como los handlers de subida, los formularios se leen con
+		// MultipartForm y el resto del cuerpo directamente
+		var err error
+		if esMultipart(c.Request) {
+			_, err = c.MultipartForm()
+		} else {
+			_, err = io.ReadAll(c.Request.Body)
+		}
+		if err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		estado := http.StatusCreated
+		if s := c.Query("estado"); s != "" {
+			estado, _ = strconv.Atoi(s)
+		}
+		c.Header("ETag", `"`+strconv.Itoa(ejecuciones)+`"`)
+		c.JSON(estado, gin.H{"ejecucion": ejecuciones})
+	})
+	return r, &ejecuciones
+}
+
+func enviar(r http.Handler, ruta, clave, tipo string, cuerpo []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, ruta, bytes.NewReader(cuerpo))
+	req.Header.Set("Content-Type", tipo)
+	if clave != "" {
+		req.Header.Set(Cabecera, clave)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRepetir(t *testing.T) {
+	r, ejecuciones := servidor(NuevaMemoria())
+	cuerpo := []byte(`{"nombre": "Jarrón"}`)
+
+	primera := enviar(r, "/recursos", "clave-1", "application/json", cuerpo)
+	if primera.Code != http.StatusCreated {
+		t.Fatalf("primera petición: %d", primera.Code)
+	}
+	repetida := enviar(r, "/recursos", "clave-1", "application/json", cuerpo)
+	if repetida.Code != http.StatusCreated || repetida.Body.String() != primera.Body.String() ||
+		repetida.Header().Get("Idempotent-Replayed") != "true" || repetida.Header().Get("ETag") != `"1"` {
+		t.Errorf("reintento: %d %s %v", repetida.Code, repetida.Body, repetida.Header())
+	}
+	if *ejecuciones != 1 {
+		t.Errorf("el handler se ejecutó %d veces", *ejecuciones)
+	}
+
+	// La misma clave con otro cuerpo o en otra URL es otra petición
+	if w := enviar(r, "/recursos", "clave-1", "application/json", []byte(`{"nombre": "Lámpara"}`)); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("otro cuerpo: %d %s", w.Code, w.Body)
+	}
+	if w := enviar(r, "/recursos?estado=201", "clave-1", "application/json", cuerpo); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("otra URL: %d %s", w.Code, w.Body)
+	}
+	// Sin clave, u otra clave, se ejecuta siempre
+	enviar(r, "/recursos", "", "application/json", cuerpo)
+	enviar(r, "/recursos", "clave-2", "application/json", cuerpo)
+	if *ejecuciones != 3 {
+		t.Errorf("el handler se ejecutó %d veces, se esperaban 3", *ejecuciones)
+	}
+}
+
+func TestRepetirErrores(t *testing.T) {
+	almacen := NuevaMemoria()
+	r, ejecuciones := servidor(almacen)
+	cuerpo := []byte(`{}`)
+
+	if w := enviar(r, "/recursos", strings.Repeat("x", longitudMaximaClave+1), "application/json", cuerpo); w.Code != http.StatusBadRequest || *ejecuciones != 0 {
+		t.Errorf("clave demasiado larga: %d", w.Code)
+	}
+
+	// Un 5xx no se guarda y el reintento vuelve a ejecutarse; un 4xx sí
+	enviar(r, "/recursos?estado=503", "clave-5xx", "application/json", cuerpo)
+	enviar(r, "/recursos?estado=503", "clave-5xx", "application/json", cuerpo)
+	enviar(r, "/recursos?estado=409", "clave-4xx", "application/json", cuerpo)
+	if w := enviar(r, "/recursos?estado=409", "clave-4xx", "application/json", cuerpo); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("reintento de un 4xx: %d %v", w.Code, w.Header())
+	}
+	if *ejecuciones != 3 {
+		t.Errorf("el handler se ejecutó %d veces, se esperaban 3", *ejecuciones)
+	}
+
+	// Mientras la primera está en curso, 409
+	ahora := time.Now().UTC()
+	if _, ok, err := almacen.ReservarIdempotencia(context.Background(), "en-curso", ahora, ahora.Add(time.Minute)); !ok || err != nil {
+		t.Fatalf("reservar: %v, %v", ok, err)
+	}
+	if w := enviar(r, "/recursos", "en-curso", "application/json", cuerpo); w.Code != http.StatusConflict {
+		t.Errorf("clave en curso: %d %s", w.Code, w.Body)
+	}
+
+	// Una clave caducada se puede volver a usar con otra petición
+	if err := almacen.CompletarIdempotencia(context.Background(), Registro{Clave: "caducada", Huella: "otra", Estado: http.StatusCreated, CaducaEn: ahora.Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if w := enviar(r, "/recursos", "caducada", "application/json", cuerpo); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("clave caducada: %d %v", w.Code, w.Header())
+	}
+}
+
+// TestRepetirMultipart comprueba que un formulario con el mismo contenido y
+// otro separador se reconoce como la misma petición
+func TestRepetirMultipart(t *testing.T) {
+	r, ejecuciones := servidor(NuevaMemoria())
+	formulario := func(contenido string) (string, []byte) {
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		w.WriteField("descripcion", "Foto")
+		f, _ := w.CreateFormFile("archivo", "foto.png")
+		f.Write([]byte(contenido))
+		w.Close()
+		return w.FormDataContentType(), b.Bytes()
+	}
+
+	tipo, cuerpo := formulario("png")
+	enviar(r, "/recursos", "clave", tipo, cuerpo)
+	tipo, cuerpo = formulario("png")
+	if w := enviar(r, "/recursos", "clave", tipo, cuerpo); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("mismo formulario con otro separador: %d %s", w.Code, w.Body)
+	}
+	tipo, cuerpo = formulario("otro png")
+	if w := enviar(r, "/recursos", "clave", tipo, cuerpo); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("otro archivo: %d %s", w.Code, w.Body)
+	}
+	if *ejecuciones != 1 {
+		t.Errorf("el handler se ejecutó %d veces", *ejecuciones)
+	}
+}
+
+func TestPurgar(t *testing.T) {
+	m := NuevaMemoria()
+	ahora := time.Now().UTC()
+	ctx := context.Background()
+	m.CompletarIdempotencia(ctx, Registro{Clave: "vieja", CaducaEn: ahora.Add(-time.Hour)})
+	m.CompletarIdempotencia(ctx, Registro{Clave: "nueva", CaducaEn: ahora.Add(time.Hour)})
+	if n, err := m.PurgarIdempotencia(ctx, ahora); n != 1 || err != nil {
+		t.Errorf("purgadas %d, %v", n, err)
+	}
+	if _, ok, _ := m.ReservarIdempotencia(ctx, "nueva", ahora, ahora.Add(time.Minute)); ok {
+		t.Error("se purgó una clave vigente")
+	}
+}
@@ -0,0 +1,207 @@
+// Package listado implementa los parámetros comunes a los listados de la API:
+// paginación por número de página o por cursor, orden con ?sort=, selección
+// de campos con ?fields= y el sobre {"data", "meta", "links"} de la respuesta.
+// La ventana se aplica sobre un listado en memoria con EnMemoria o sobre una
+// consulta de gorm con SQL.
+package listado
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	TamanoPorDefecto = 20
+	TamanoMaximo     = 100
+)
+
+// CampoOrden es un criterio de ordenamiento pedido con ?sort=campo o ?sort=-campo
+type CampoOrden struct {
+	Campo string
+	Desc  bool
+}
+
+// Cursor identifica el elemento frontera de una página. Valores contiene los
+// valores de los campos de orden de ese elemento, incluido el ID que se usa
+// como desempate. Antes indica que se piden los elementos previos al frontera
+type Cursor struct {
+	Valores []any `json:"v"`
+	Antes   bool  `json:"a,omitempty"`
+}
+
+// Ventana selecciona qué parte de un listado devolver: por número de página
+// o, si Cursor no es nil, a partir de un cursor
+type Ventana struct {
+	Pagina int
+	Tamano int
+	Cursor *Cursor
+}
+
+// Offset devuelve el desplazamiento correspondiente a la página pedida
+func (v Ventana) Offset() int {
+	return (v.Pagina - 1) * v.Tamano
+}
+
+// Resultado es la parte de un listado que se devuelve además de los elementos
+type Resultado struct {
+	Total     int64
+	Anterior  *Cursor
+	Siguiente *Cursor
+}
+
+var ErrCursorInvalido = errors.New("cursor inválido")
+
+// Codificar devuelve el cursor como se envía al cliente
+func (c Cursor) Codificar() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodificarCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrCursorInvalido
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || len(c.Valores) == 0 {
+		return nil, ErrCursorInvalido
+	}
+	return &c, nil
+}
+
+// Parametros agrupa los parámetros de query comunes a los listados
+type Parametros struct {
+	Orden   []CampoOrden
+	Ventana Ventana
+	Campos  []string
+}
+
+// Parse lee sort, fields, page, page_size y cursor de la query. ordenables
+// da, para cada campo por el que se puede ordenar, su valor en un elemento,
+// que debe ser float64 o string; campos son los campos JSON que se pueden
+// pedir en fields
+func Parse[T any](c *gin.Context, ordenables map[string]func(T) any, campos map[string]bool) (Parametros, error) {
+	return ParseOrdenado(c, "", ordenables, campos)
+}
+
+// ParseOrdenado es Parse con un orden por defecto para cuando la query no
+// trae sort
+func ParseOrdenado[T any](c *gin.Context, ordenPorDefecto string, ordenables map[string]func(T) any, campos map[string]bool) (Parametros, error) {
+	var p Parametros
+	var err error
+	if p.Orden, err = parseOrden(c.DefaultQuery("sort", ordenPorDefecto), ordenables); err != nil {
+		return p, err
+	}
+	if p.Campos, err = parseCampos(c.Query("fields"), campos); err != nil {
+		return p, err
+	}
+	if p.Ventana, err = parseVentana(c); err != nil {
+		return p, err
+	}
+	return p, validarCursor(p.Ventana.Cursor, p.Orden, ordenables)
+}
+
+// parseVentana lee page, page_size y cursor de la query
+func parseVentana(c *gin.Context) (Ventana, error) {
+	v := Ventana{Pagina: 1, Tamano: TamanoPorDefecto}
+	if s := c.Query("page_size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > TamanoMaximo {
+			return v, fmt.Errorf("page_size debe estar entre 1 y %d", TamanoMaximo)
+		}
+		v.Tamano = n
+	}
+	if s := c.Query("cursor"); s != "" {
+		if c.Query("page") != "" {
+			return v, errors.New("page y cursor no pueden usarse juntos")
+		}
+		cursor, err := decodificarCursor(s)
+		if err != nil {
+			return v, err
+		}
+		v.Cursor = cursor
+		return v, nil
+	}
+	if s := c.Query("page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return v, errors.New("page debe ser un entero positivo")
+		}
+		v.Pagina = n
+	}
+	return v, nil
+}
+
+// parseOrden interpreta ?sort=precio_base,-nombre validando contra los
+// campos ordenables. Siempre agrega el ID al final como desempate para que el
+// orden sea total y los cursores sean estables
+func parseOrden[T any](valor string, ordenables map[string]func(T) any) ([]CampoOrden, error) {
+	var orden []CampoOrden
+	if valor != "" {
+		for _, parte := range strings.Split(valor, ",") {
+			parte = strings.TrimSpace(parte)
+			co := CampoOrden{Campo: strings.TrimPrefix(parte, "-"), Desc: strings.HasPrefix(parte, "-")}
+			if _, ok := ordenables[co.Campo]; !ok {
+				return nil, fmt.Errorf("no se puede ordenar por %q", co.Campo)
+			}
+			orden = append(orden, co)
+		}
+	}
+	return append(orden, CampoOrden{Campo: "id"}), nil
+}
+
+// validarCursor comprueba que el cursor corresponde al orden pedido, es
+// decir, que tiene un valor del tipo correcto por cada campo de orden
+func validarCursor[T any](cursor *Cursor, orden []CampoOrden, ordenables map[string]func(T) any) error {
+	if cursor == nil {
+		return nil
+	}
+	if len(cursor.Valores) != len(orden) {
+		return ErrCursorInvalido
+	}
+	var cero T
+	for i, co := range orden {
+		switch ordenables[co.Campo](cero).(type) {
+		case float64:
+			if _, ok := cursor.Valores[i].(float64); !ok {
+				return ErrCursorInvalido
+			}
+		case string:
+			if _, ok := cursor.Valores[i].(string); !ok {
+				return ErrCursorInvalido
+			}
+		}
+	}
+	return nil
+}
+
+// parseCampos interpreta ?fields=id,nombre validando contra los campos permitidos
+func parseCampos(valor string, permitidos map[string]bool) ([]string, error) {
+	if valor == "" {
+		return nil, nil
+	}
+	var campos []string
+	for _, campo := range strings.Split(valor, ",") {
+		campo = strings.TrimSpace(campo)
+		if !permitidos[campo] {
+			return nil, fmt.Errorf("campo desconocido %q", campo)
+		}
+		campos = append(campos, campo)
+	}
+	return campos, nil
+}
+
+// ClaveOrden devuelve los valores de orden de un elemento
+func ClaveOrden[T any](e T, orden []CampoOrden, ordenables map[string]func(T) any) []any {
+	clave := make([]any, len(orden))
+	for i, co := range orden {
+		clave[i] = ordenables[co.Campo](e)
+	}
+	return clave
+}
@@ -0,0 +1,198 @@
+package listado
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+
+	dominio "catalogo-dominio"
+
+	"github.com/gin-gonic/gin"
+)
+
+type elemento struct {
+	ID     string  `json:"id"`
+	Nombre string  `json:"nombre"`
+	Precio float64 `json:"precio"`
+}
+
+var ordenables = map[string]func(elemento) any{
+	"id":     func(e elemento) any { return e.ID },
+	"nombre": func(e elemento) any { return e.Nombre },
+	"precio": func(e elemento) any { return e.Precio },
+}
+
+var campos = map[string]bool{"id": true, "nombre": true, "precio": true}
+
+func contexto(query string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/elementos?"+query, nil)
+	return c, w
+}
+
+// elementos devuelve n elementos con precios repetidos, para que el orden
+// por precio necesite el ID como desempate
+func elementos(n int) []elemento {
+	lista := make([]elemento, n)
+	for i := range lista {
+		lista[i] = elemento{ID: fmt.Sprintf("e%02d", i), Nombre: fmt.Sprintf("Elemento %d", n-i), Precio: float64(i % 3)}
+	}
+	return lista
+}
+
+func ids(lista []elemento) []string {
+	var r []string
+	for _, e := range lista {
+		r = append(r, e.ID)
+	}
+	return r
+}
+
+func TestCursorIdaYVuelta(t *testing.T) {
+	original := Cursor{Valores: []any{2.5, "Jarrón", "e01"}, Antes: true}
+	decodificado, err := decodificarCursor(original.Codificar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*decodificado, original) {
+		t.Errorf("cursor decodificado: %+v, original %+v", *decodificado, original)
+	}
+
+	for _, invalido := range []string{"no es base64!", "bm8gZXMganNvbg", Cursor{}.Codificar()} {
+		if _, err := decodificarCursor(invalido); err != ErrCursorInvalido {
+			t.Errorf("cursor %q: %v", invalido, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	cursor := Cursor{Valores: []any{1.0, "e04"}}.Codificar()
+	c, _ := contexto("sort=-precio&fields=id,nombre&page_size=5&cursor=" + cursor)
+	p, err := Parse(c, ordenables, campos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []CampoOrden{{Campo: "precio", Desc: true}, {Campo: "id"}}; !reflect.DeepEqual(p.Orden, want) {
+		t.Errorf("orden: %+v", p.Orden)
+	}
+	if !slices.Equal(p.Campos, []string{"id", "nombre"}) || p.Ventana.Tamano != 5 || p.Ventana.Cursor == nil {
+		t.Errorf("parámetros: %+v", p)
+	}
+
+	c, _ = contexto("")
+	if p, err := ParseOrdenado(c, "nombre", ordenables, campos); err != nil || p.Orden[0].Campo != "nombre" || p.Ventana != (Ventana{Pagina: 1, Tamano: TamanoPorDefecto}) {
+		t.Errorf("sin parámetros: %+v, %v", p, err)
+	}
+
+	for _, query := range []string{
+		"sort=color",
+		"fields=id,color",
+		"page_size=0",
+		fmt.Sprintf("page_size=%d", TamanoMaximo+1),
+		"page=0",
+		"page=2&cursor=" + cursor,
+		"cursor=" + cursor + "&sort=nombre",
+		"cursor=" + Cursor{Valores: []any{"uno", "e04"}}.Codificar() + "&sort=precio",
+	} {
+		c, _ := contexto(query)
+		if _, err := Parse(c, ordenables, campos); err == nil {
+			t.Errorf("%s: se aceptó", query)
+		}
+	}
+}
+
+// TestEnMemoriaCursores recorre el listado con cursores hacia delante y
+// hacia atrás y comprueba que ve lo mismo que paginando por número
+func TestEnMemoriaCursores(t *testing.T) {
+	lista := elementos(11)
+	orden := []CampoOrden{{Campo: "precio", Desc: true}, {Campo: "id"}}
+	completo, _ := EnMemoria(lista, orden, Ventana{Pagina: 1, Tamano: len(lista)}, ordenables)
+
+	var adelante []string
+	var paginas [][]elemento
+	v := Ventana{Pagina: 1, Tamano: 4}
+	for {
+		pagina, res := EnMemoria(lista, orden, v, ordenables)
+		if res.Total != int64(len(lista)) {
+			t.Fatalf("total %d", res.Total)
+		}
+		adelante = append(adelante, ids(pagina)...)
+		paginas = append(paginas, pagina)
+		if res.Siguiente == nil {
+			break
+		}
+		v.Cursor = res.Siguiente
+	}
+	if !slices.Equal(adelante, ids(completo)) {
+		t.Fatalf("hacia delante: %v, se esperaba %v", adelante, ids(completo))
+	}
+	for i := range paginas {
+		porNumero, _ := EnMemoria(lista, orden, Ventana{Pagina: i + 1, Tamano: 4}, ordenables)
+		if !slices.Equal(ids(paginas[i]), ids(porNumero)) {
+			t.Errorf("página %d: %v por cursor, %v por número", i+1, ids(paginas[i]), ids(porNumero))
+		}
+	}
+
+	// Desde la última página, Anterior lleva de vuelta a las anteriores
+	_, res := EnMemoria(lista, orden, Ventana{Pagina: 3, Tamano: 4}, ordenables)
+	v = Ventana{Tamano: 4, Cursor: res.Anterior}
+	for i := len(paginas) - 2; i >= 0; i-- {
+		pagina, res := EnMemoria(lista, orden, v, ordenables)
+		if !slices.Equal(ids(pagina), ids(paginas[i])) {
+			t.Errorf("hacia atrás, página %d: %v, se esperaba %v", i+1, ids(pagina), ids(paginas[i]))
+		}
+		if (res.Anterior == nil) != (i == 0) || res.Siguiente == nil {
+			t.Errorf("hacia atrás, página %d: cursores %+v", i+1, res)
+		}
+		v.Cursor = res.Anterior
+	}
+}
+
+func TestCondicionCursor(t *testing.T) {
+	orden := []CampoOrden{{Campo: "precio", Desc: true}, {Campo: "id"}}
+	condicion, args := condicionCursor(orden, []any{2.0, "e05"}, false)
+	if want := "((precio < ?) OR (precio = ? AND id > ?))"; condicion != want {
+		t.Errorf("condición: %s, se esperaba %s", condicion, want)
+	}
+	if !reflect.DeepEqual(args, []any{2.0, 2.0, "e05"}) {
+		t.Errorf("argumentos: %v", args)
+	}
+	if condicion, _ := condicionCursor(orden, []any{2.0, "e05"}, true); condicion != "((precio > ?) OR (precio = ? AND id < ?))" {
+		t.Errorf("condición hacia atrás: %s", condicion)
+	}
+}
+
+func TestResponder(t *testing.T) {
+	lista := elementos(5)
+	orden := []CampoOrden{{Campo: "id"}}
+	v := Ventana{Pagina: 2, Tamano: 2}
+	pagina, res := EnMemoria(lista, orden, v, ordenables)
+	c, w := contexto("page=2&page_size=2&fields=id")
+	if err := Responder(c, pagina, res, v, []string{"id"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var r struct {
+		Data  []map[string]any   `json:"data"`
+		Meta  dominio.Paginacion `json:"meta"`
+		Links dominio.Enlaces    `json:"links"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Data) != 2 || len(r.Data[0]) != 1 || r.Data[0]["id"] != "e02" {
+		t.Errorf("data: %v", r.Data)
+	}
+	if r.Meta.Total != 5 || r.Meta.Page != 2 || r.Meta.PageSize != 2 || r.Meta.NextCursor == "" || r.Meta.PrevCursor == "" {
+		t.Errorf("meta: %+v", r.Meta)
+	}
+	if r.Links.Next != "/elementos?fields=id&page=3&page_size=2" || r.Links.Prev != "/elementos?fields=id&page=1&page_size=2" {
+		t.Errorf("links: %+v", r.Links)
+	}
+}
@@ -0,0 +1,80 @@
+package listado
+
+import (
+	"slices"
+	"strings"
+)
+
+// EnMemoria aplica orden y ventana sobre un listado completo
+func EnMemoria[T any](elementos []T, orden []CampoOrden, v Ventana, ordenables map[string]func(T) any) ([]T, Resultado) {
+	clave := func(e T) []any { return ClaveOrden(e, orden, ordenables) }
+	ordenados := slices.Clone(elementos)
+	slices.SortStableFunc(ordenados, func(a, b T) int {
+		return compararClaves(clave(a), clave(b), orden)
+	})
+	res := Resultado{Total: int64(len(ordenados))}
+
+	var inicio, fin int
+	switch {
+	case v.Cursor == nil:
+		inicio = min(v.Offset(), len(ordenados))
+		fin = min(inicio+v.Tamano, len(ordenados))
+	case v.Cursor.Antes:
+		// Elementos estrictamente anteriores al cursor
+		for fin < len(ordenados) && compararClaves(clave(ordenados[fin]), v.Cursor.Valores, orden) < 0 {
+			fin++
+		}
+		inicio = max(fin-v.Tamano, 0)
+	default:
+		// Elementos estrictamente posteriores al cursor
+		for inicio < len(ordenados) && compararClaves(clave(ordenados[inicio]), v.Cursor.Valores, orden) <= 0 {
+			inicio++
+		}
+		fin = min(inicio+v.Tamano, len(ordenados))
+	}
+
+	pagina := ordenados[inicio:fin]
+	if len(pagina) > 0 {
+		if inicio > 0 {
+			res.Anterior = &Cursor{Valores: clave(pagina[0]), Antes: true}
+		}
+		if fin < len(ordenados) {
+			res.Siguiente = &Cursor{Valores: clave(pagina[len(pagina)-1])}
+		}
+	}
+	return pagina, res
+}
+
+// compararValores compara dos valores de orden, que son float64 o string
+func compararValores(a, b any) int {
+	switch x := a.(type) {
+	case float64:
+		y, _ := b.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case string:
+		y, _ := b.(string)
+		return strings.Compare(x, y)
+	}
+	return 0
+}
+
+// compararClaves compara dos listas de valores de orden respetando la
+// dirección de cada campo
+func compararClaves(a, b []any, orden []CampoOrden) int {
+	for i, co := range orden {
+		r := compararValores(a[i], b[i])
+		if co.Desc {
+			r = -r
+		}
+		if r != 0 {
+			return r
+		}
+	}
+	return 0
+}
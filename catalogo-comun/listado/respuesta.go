@@ -0,0 +1,88 @@
+package listado
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	dominio "catalogo-dominio"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Responder escribe el sobre {"data", "meta", "links"} de un listado
+// paginado, con solo los campos pedidos. Si no puede serializar los
+// elementos no escribe nada y devuelve el error
+func Responder[T any](c *gin.Context, elementos []T, res Resultado, v Ventana, campos []string) error {
+	data, err := seleccionarCampos(elementos, campos)
+	if err != nil {
+		return err
+	}
+
+	meta := dominio.Paginacion{Total: res.Total, PageSize: v.Tamano}
+	links := dominio.Enlaces{Self: c.Request.URL.String()}
+
+	if v.Cursor == nil {
+		meta.Page = v.Pagina
+		if res.Siguiente != nil {
+			links.Next = enlacePagina(c, "page", strconv.Itoa(v.Pagina+1))
+		}
+		if res.Anterior != nil {
+			links.Prev = enlacePagina(c, "page", strconv.Itoa(v.Pagina-1))
+		}
+	} else {
+		if res.Siguiente != nil {
+			links.Next = enlacePagina(c, "cursor", res.Siguiente.Codificar())
+		}
+		if res.Anterior != nil {
+			links.Prev = enlacePagina(c, "cursor", res.Anterior.Codificar())
+		}
+	}
+	if res.Siguiente != nil {
+		meta.NextCursor = res.Siguiente.Codificar()
+	}
+	if res.Anterior != nil {
+		meta.PrevCursor = res.Anterior.Codificar()
+	}
+
+	c.JSON(http.StatusOK, dominio.Pagina{Data: data, Meta: meta, Links: links})
+	return nil
+}
+
+// enlacePagina reescribe la URL actual cambiando el parámetro de ventana
+func enlacePagina(c *gin.Context, param, valor string) string {
+	u := *c.Request.URL
+	q := u.Query()
+	q.Del("page")
+	q.Del("cursor")
+	q.Set(param, valor)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// seleccionarCampos reduce cada elemento a los campos JSON pedidos. Si no se
+// pidieron campos devuelve los elementos sin cambios
+func seleccionarCampos[T any](elementos []T, campos []string) (any, error) {
+	if len(campos) == 0 {
+		return elementos, nil
+	}
+	reducidos := make([]map[string]json.RawMessage, 0, len(elementos))
+	for _, e := range elementos {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		var completo map[string]json.RawMessage
+		if err := json.Unmarshal(b, &completo); err != nil {
+			return nil, err
+		}
+		reducido := make(map[string]json.RawMessage, len(campos))
+		for _, campo := range campos {
+			if v, ok := completo[campo]; ok {
+				reducido[campo] = v
+			}
+		}
+		reducidos = append(reducidos, reducido)
+	}
+	return reducidos, nil
+}
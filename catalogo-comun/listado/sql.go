@@ -0,0 +1,94 @@
+package listado
+
+import (
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// SQL aplica orden y ventana a una consulta y devuelve la página. columnas
+// traduce los campos de orden cuyo nombre no coincide con su columna en la
+// consulta; puede ser nil. res.Total debe venir ya contado, porque decide si
+// hay página siguiente. Con cursor usa paginación por conjunto de claves
+// (keyset), que no se degrada con páginas profundas
+func SQL[T any](q *gorm.DB, orden []CampoOrden, v Ventana, ordenables map[string]func(T) any, columnas map[string]string, res *Resultado) ([]T, error) {
+	var elementos []T
+	antes := v.Cursor != nil && v.Cursor.Antes
+	clave := func(e T) []any { return ClaveOrden(e, orden, ordenables) }
+
+	// Los nombres de campo ya fueron validados contra la lista de ordenables
+	ordenSQL := make([]CampoOrden, len(orden))
+	for i, co := range orden {
+		if col, ok := columnas[co.Campo]; ok {
+			co.Campo = col
+		}
+		ordenSQL[i] = co
+		if co.Desc != antes {
+			q = q.Order(co.Campo + " DESC")
+		} else {
+			q = q.Order(co.Campo)
+		}
+	}
+
+	if v.Cursor == nil {
+		if err := q.Offset(v.Offset()).Limit(v.Tamano).Find(&elementos).Error; err != nil {
+			return nil, err
+		}
+		if len(elementos) > 0 {
+			if v.Offset() > 0 {
+				res.Anterior = &Cursor{Valores: clave(elementos[0]), Antes: true}
+			}
+			if int64(v.Offset()+len(elementos)) < res.Total {
+				res.Siguiente = &Cursor{Valores: clave(elementos[len(elementos)-1])}
+			}
+		}
+		return elementos, nil
+	}
+
+	condicion, args := condicionCursor(ordenSQL, v.Cursor.Valores, antes)
+	if err := q.Where(condicion, args...).Limit(v.Tamano + 1).Find(&elementos).Error; err != nil {
+		return nil, err
+	}
+	hayMas := len(elementos) > v.Tamano
+	if hayMas {
+		elementos = elementos[:v.Tamano]
+	}
+	if antes {
+		slices.Reverse(elementos)
+	}
+	if len(elementos) > 0 {
+		// Viniendo desde un cursor siempre hay elementos del otro lado
+		if hayMas || !antes {
+			res.Anterior = &Cursor{Valores: clave(elementos[0]), Antes: true}
+		}
+		if hayMas || antes {
+			res.Siguiente = &Cursor{Valores: clave(elementos[len(elementos)-1])}
+		}
+	}
+	return elementos, nil
+}
+
+// condicionCursor construye la condición "posterior a" (o "anterior a" si
+// antes es true) para una lista de campos de orden con direcciones mixtas:
+// (a > x) OR (a = x AND b > y) OR ...
+func condicionCursor(orden []CampoOrden, valores []any, antes bool) (string, []any) {
+	var disyuncion []string
+	var args []any
+	for i, co := range orden {
+		var conjuncion []string
+		for _, previo := range orden[:i] {
+			conjuncion = append(conjuncion, previo.Campo+" = ?")
+		}
+		args = append(args, valores[:i]...)
+
+		op := ">"
+		if co.Desc != antes {
+			op = "<"
+		}
+		conjuncion = append(conjuncion, co.Campo+" "+op+" ?")
+		args = append(args, valores[i])
+		disyuncion = append(disyuncion, "("+strings.Join(conjuncion, " AND ")+")")
+	}
+	return "(" + strings.Join(disyuncion, " OR ") + ")", args
+}
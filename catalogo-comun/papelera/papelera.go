@@ -0,0 +1,50 @@
+// Package papelera purga periódicamente los recursos eliminados, que se
+// conservan en la papelera durante PAPELERA_DIAS días por si se restauran.
+package papelera
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// diasPorDefecto es cuánto se conserva un recurso eliminado si no se
+	// configura PAPELERA_DIAS
+	diasPorDefecto = 30
+	intervaloPurga = time.Hour
+)
+
+// Dias lee PAPELERA_DIAS; 0 desactiva la purga y lo eliminado se conserva
+// hasta que se restaura
+func Dias() int {
+	if n, err := strconv.Atoi(os.Getenv("PAPELERA_DIAS")); err == nil && n >= 0 {
+		return n
+	}
+	return diasPorDefecto
+}
+
+// IniciarPurga borra definitivamente con purgar, al arrancar y luego cada
+// hora, lo que lleva en la papelera más de PAPELERA_DIAS días. purgar
+// devuelve cuántos recursos borró
+func IniciarPurga(purgar func(ctx context.Context, limite time.Time) (int64, error)) {
+	dias := Dias()
+	if dias == 0 {
+		log.Printf("Purga de la papelera desactivada")
+		return
+	}
+	go func() {
+		for {
+			limite := time.Now().UTC().AddDate(0, 0, -dias)
+			n, err := purgar(context.Background(), limite)
+			if err != nil {
+				log.Printf("Error al purgar la papelera: %v", err)
+			} else if n > 0 {
+				log.Printf("Papelera purgada: %d eliminados hace más de %d días", n, dias)
+			}
+			time.Sleep(intervaloPurga)
+		}
+	}()
+}
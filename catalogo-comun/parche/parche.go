@@ -0,0 +1,103 @@
+// Package parche aplica a un recurso los parches de las peticiones PATCH:
+// JSON Merge Patch (RFC 7386) y JSON Patch (RFC 6902).
+package parche
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	TipoMergePatch = "application/merge-patch+json" // RFC 7386
+	TipoJSONPatch  = "application/json-patch+json"  // RFC 6902
+)
+
+var ErrTipoNoSoportado = errors.New("Content-Type debe ser " + TipoMergePatch + " o " + TipoJSONPatch)
+
+// Aplicar aplica a actual el parche recibido en el cuerpo de la petición,
+// según su Content-Type, y decodifica el resultado en destino. Los campos
+// desconocidos se rechazan para que un error de escritura en el parche no se
+// ignore en silencio
+func Aplicar[T any](c *gin.Context, actual T, destino *T) error {
+	tipo, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if tipo != TipoMergePatch && tipo != TipoJSONPatch {
+		return ErrTipoNoSoportado
+	}
+
+	parche, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	original, err := json.Marshal(actual)
+	if err != nil {
+		return err
+	}
+
+	var resultado []byte
+	if tipo == TipoMergePatch {
+		resultado, err = jsonpatch.MergePatch(original, parche)
+	} else {
+		var ops jsonpatch.Patch
+		if ops, err = jsonpatch.DecodePatch(parche); err == nil {
+			resultado, err = ops.Apply(original)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return decodificarEstricto(resultado, destino)
+}
+
+// Fusionar aplica a actual un JSON Merge Patch y decodifica el resultado en
+// destino, como Aplicar con un parche que no viene en el cuerpo de la petición
+func Fusionar[T any](actual T, parche []byte, destino *T) error {
+	original, err := json.Marshal(actual)
+	if err != nil {
+		return err
+	}
+	resultado, err := jsonpatch.MergePatch(original, parche)
+	if err != nil {
+		return err
+	}
+	return decodificarEstricto(resultado, destino)
+}
+
+// decodificarEstricto decodifica JSON rechazando los campos desconocidos
+func decodificarEstricto[T any](datos []byte, destino *T) error {
+	dec := json.NewDecoder(bytes.NewReader(datos))
+	dec.DisallowUnknownFields()
+	return dec.Decode(destino)
+}
+
+// Estado devuelve el estado HTTP con el que responder a un error de Aplicar
+// o de Fusionar: 415 si el Content-Type no es de parche, 409 si falla una
+// operación test de JSON Patch y 400 en los demás casos
+func Estado(err error) int {
+	switch {
+	case errors.Is(err, ErrTipoNoSoportado):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(causa(err), jsonpatch.ErrTestFailed):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// causa devuelve el error original de jsonpatch, que envuelve sus errores con
+// github.com/pkg/errors: esa versión expone Cause pero no Unwrap, así que
+// errors.Is no los atraviesa
+func causa(err error) error {
+	for {
+		c, ok := err.(interface{ Cause() error })
+		if !ok || c.Cause() == nil {
+			return err
+		}
+		err = c.Cause()
+	}
+}
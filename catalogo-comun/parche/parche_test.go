@@ -0,0 +1,115 @@
+package parche
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type recurso struct {
+	Nombre      string            `json:"nombre"`
+	Descripcion *string           `json:"descripcion"`
+	Stock       int               `json:"stock"`
+	Etiquetas   []string          `json:"etiquetas"`
+	Atributos   map[string]string `json:"atributos"`
+}
+
+func contexto(tipo, cuerpo string) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(cuerpo))
+	c.Request.Header.Set("Content-Type", tipo)
+	return c
+}
+
+func actual() recurso {
+	descripcion := "Jarrón de cerámica"
+	return recurso{
+		Nombre:      "Jarrón",
+		Descripcion: &descripcion,
+		Stock:       3,
+		Etiquetas:   []string{"azul", "grande"},
+		Atributos:   map[string]string{"color": "azul", "forma": "alto"},
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	var r recurso
+	c := contexto(TipoMergePatch+"; charset=utf-8", `{"nombre": "Jarrón azul", "descripcion": null, "atributos": {"forma": null}}`)
+	if err := Aplicar(c, actual(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Nombre != "Jarrón azul" || r.Descripcion != nil || r.Stock != 3 {
+		t.Errorf("recurso parcheado: %+v", r)
+	}
+	// null quita la clave de un objeto anidado y conserva el resto
+	if len(r.Atributos) != 1 || r.Atributos["color"] != "azul" {
+		t.Errorf("atributos: %v", r.Atributos)
+	}
+	// Las listas se sustituyen enteras, no se fusionan
+	c = contexto(TipoMergePatch, `{"etiquetas": ["roja"]}`)
+	if err := Aplicar(c, actual(), &r); err != nil || len(r.Etiquetas) != 1 || r.Etiquetas[0] != "roja" {
+		t.Errorf("etiquetas: %v, %v", r.Etiquetas, err)
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	var r recurso
+	c := contexto(TipoJSONPatch, `[
+		{"op": "test", "path": "/stock", "value": 3},
+		{"op": "replace", "path": "/stock", "value": 5},
+		{"op": "add", "path": "/etiquetas/-", "value": "nueva"},
+		{"op": "remove", "path": "/atributos/forma"}
+	]`)
+	if err := Aplicar(c, actual(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Stock != 5 || len(r.Etiquetas) != 3 || r.Etiquetas[2] != "nueva" || len(r.Atributos) != 1 {
+		t.Errorf("recurso parcheado: %+v", r)
+	}
+
+	// Si falla un test no se aplica ninguna operación y se responde 409
+	c = contexto(TipoJSONPatch, `[{"op": "replace", "path": "/nombre", "value": "Otro"}, {"op": "test", "path": "/stock", "value": 4}]`)
+	var sinCambios recurso
+	err := Aplicar(c, actual(), &sinCambios)
+	if err == nil || Estado(err) != http.StatusConflict || sinCambios.Nombre != "" {
+		t.Errorf("test fallido: %v, estado %d, %+v", err, Estado(err), sinCambios)
+	}
+}
+
+func TestAplicarErrores(t *testing.T) {
+	for nombre, caso := range map[string]struct {
+		tipo, cuerpo string
+		estado       int
+	}{
+		"JSON normal":           {"application/json", `{"nombre": "Otro"}`, http.StatusUnsupportedMediaType},
+		"sin Content-Type":      {"", `{"nombre": "Otro"}`, http.StatusUnsupportedMediaType},
+		"campo desconocido":     {TipoMergePatch, `{"nombbre": "Otro"}`, http.StatusBadRequest},
+		"tipo incorrecto":       {TipoMergePatch, `{"stock": "muchos"}`, http.StatusBadRequest},
+		"merge mal formado":     {TipoMergePatch, `{"nombre": `, http.StatusBadRequest},
+		"operación desconocida": {TipoJSONPatch, `[{"op": "mover", "path": "/stock"}]`, http.StatusBadRequest},
+		"ruta inexistente":      {TipoJSONPatch, `[{"op": "replace", "path": "/color", "value": "rojo"}]`, http.StatusBadRequest},
+	} {
+		var r recurso
+		err := Aplicar(contexto(caso.tipo, caso.cuerpo), actual(), &r)
+		if err == nil || Estado(err) != caso.estado {
+			t.Errorf("%s: %v, estado %d, se esperaba %d", nombre, err, Estado(err), caso.estado)
+		}
+	}
+}
+
+func TestFusionar(t *testing.T) {
+	var r recurso
+	if err := Fusionar(actual(), []byte(`{"stock": 0, "descripcion": null}`), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Stock != 0 || r.Descripcion != nil || r.Nombre != "Jarrón" {
+		t.Errorf("recurso fusionado: %+v", r)
+	}
+	if err := Fusionar(actual(), []byte(`{"otro": 1}`), &r); err == nil {
+		t.Error("se aceptó un campo desconocido")
+	}
+}
@@ -0,0 +1,230 @@
+package dominio
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// UnidadLongitud es la unidad en que se expresan las dimensiones de un
+// producto. Se guardan siempre en milímetros
+type UnidadLongitud string
+
+const (
+	UnidadMilimetro  UnidadLongitud = "mm"
+	UnidadCentimetro UnidadLongitud = "cm"
+	UnidadPulgada    UnidadLongitud = "in"
+)
+
+// milimetrosPor es el número de milímetros de cada unidad
+var milimetrosPor = map[UnidadLongitud]float64{
+	UnidadMilimetro:  1,
+	UnidadCentimetro: 10,
+	UnidadPulgada:    25.4,
+}
+
+// EjesDimensiones son los nombres de las dimensiones en la API y en los filtros
+var EjesDimensiones = []string{"ancho", "alto", "profundo"}
+
+// decimalesLongitud limita los decimales de las longitudes convertidas para
+// que las conversiones de ida y vuelta no acumulen ruido de coma flotante
+const decimalesLongitud = 4
+
+func redondearLongitud(v float64) float64 {
+	f := math.Pow10(decimalesLongitud)
+	return math.Round(v*f) / f
+}
+
+// Dimensiones son las medidas de un producto. Al escribir se aceptan en
+// cualquier unidad y se guardan en milímetros
+type Dimensiones struct {
+	Ancho    float64        `json:"ancho" validate:"required" example:"100"`
+	Alto     float64        `json:"alto" validate:"required" example:"50"`
+	Profundo float64        `json:"profundo" validate:"required" example:"30"`
+	Unidad   UnidadLongitud `json:"unidad" enums:"mm,cm,in" example:"mm"`
+}
+
+// ParseUnidad interpreta una unidad; vacía equivale a milímetros
+func ParseUnidad(texto string) (UnidadLongitud, error) {
+	u := UnidadLongitud(strings.ToLower(strings.TrimSpace(texto)))
+	if u == "" {
+		return UnidadMilimetro, nil
+	}
+	if _, ok := milimetrosPor[u]; !ok {
+		return "", fmt.Errorf("unidad debe ser %s, %s o %s", UnidadMilimetro, UnidadCentimetro, UnidadPulgada)
+	}
+	return u, nil
+}
+
+// Normalizar expresa las dimensiones en milímetros. Sin unidad se entienden
+// en milímetros; una unidad desconocida se conserva para que la validación
+// la rechace
+func (d *Dimensiones) Normalizar() {
+	u, err := ParseUnidad(string(d.Unidad))
+	if err != nil {
+		return
+	}
+	if u != UnidadMilimetro {
+		f := milimetrosPor[u]
+		d.Ancho = redondearLongitud(d.Ancho * f)
+		d.Alto = redondearLongitud(d.Alto * f)
+		d.Profundo = redondearLongitud(d.Profundo * f)
+	}
+	d.Unidad = UnidadMilimetro
+}
+
+// En devuelve las dimensiones, guardadas en milímetros, expresadas en unidad.
+// Una unidad vacía o desconocida se trata como milímetros, igual que en
+// ParseUnidad, en lugar de dividir entre cero
+func (d Dimensiones) En(unidad UnidadLongitud) Dimensiones {
+	f, ok := milimetrosPor[unidad]
+	if !ok || unidad == UnidadMilimetro {
+		d.Unidad = UnidadMilimetro
+		return d
+	}
+	return Dimensiones{
+		Ancho:    redondearLongitud(d.Ancho / f),
+		Alto:     redondearLongitud(d.Alto / f),
+		Profundo: redondearLongitud(d.Profundo / f),
+		Unidad:   unidad,
+	}
+}
+
+// Eje devuelve la dimensión con ese nombre de EjesDimensiones
+func (d Dimensiones) Eje(nombre string) float64 {
+	switch nombre {
+	case "ancho":
+		return d.Ancho
+	case "alto":
+		return d.Alto
+	default:
+		return d.Profundo
+	}
+}
+
+// Validar añade a errs los errores de unas dimensiones ya normalizadas; campo
+// es el prefijo de los nombres de campo, p. ej. dimensiones
+func (d Dimensiones) Validar(campo string, errs *ErroresValidacion) {
+	for _, eje := range EjesDimensiones {
+		if d.Eje(eje) <= 0 {
+			errs.Agregar(campo+"."+eje, "debe ser mayor que 0")
+		}
+	}
+	if d.Unidad != UnidadMilimetro {
+		errs.Agregar(campo+".unidad", fmt.Sprintf("debe ser %q, %q o %q", UnidadMilimetro, UnidadCentimetro, UnidadPulgada))
+	}
+}
+
+// FiltroDimension limita una dimensión de los productos; Min y Max están en
+// milímetros
+type FiltroDimension struct {
+	Eje string
+	Min *float64
+	Max *float64
+}
+
+// Cumple indica si la dimensión está en el rango del filtro
+func (f FiltroDimension) Cumple(d Dimensiones) bool {
+	v := d.Eje(f.Eje)
+	return (f.Min == nil || v >= *f.Min) && (f.Max == nil || v <= *f.Max)
+}
+
+// ParseFiltrosDimensiones lee ?dimensiones.<eje>.min= y .max=. Los valores
+// admiten unidad como sufijo, p. ej. 2in o 5cm; sin sufijo se expresan en
+// ?unidad=, por defecto milímetros
+func ParseFiltrosDimensiones(query url.Values) ([]FiltroDimension, error) {
+	unidad, err := ParseUnidad(query.Get("unidad"))
+	if err != nil {
+		return nil, err
+	}
+	var filtros []FiltroDimension
+	for _, eje := range EjesDimensiones {
+		f := FiltroDimension{Eje: eje}
+		for _, limite := range []string{"min", "max"} {
+			clave := "dimensiones." + eje + "." + limite
+			texto := query.Get(clave)
+			if texto == "" {
+				continue
+			}
+			mm, err := LeerLongitud(texto, unidad)
+			if err != nil {
+				return nil, fmt.Errorf("%s %v", clave, err)
+			}
+			if limite == "min" {
+				f.Min = &mm
+			} else {
+				f.Max = &mm
+			}
+		}
+		if f.Min != nil || f.Max != nil {
+			filtros = append(filtros, f)
+		}
+	}
+	for clave := range query {
+		eje, limite, _ := strings.Cut(strings.TrimPrefix(clave, "dimensiones."), ".")
+		if strings.HasPrefix(clave, "dimensiones.") && (!slices.Contains(EjesDimensiones, eje) || limite != "min" && limite != "max") {
+			return nil, fmt.Errorf("filtro no soportado: %s", clave)
+		}
+	}
+	return filtros, nil
+}
+
+// LeerLongitud interpreta una longitud con sufijo de unidad opcional y la
+// devuelve en milímetros
+func LeerLongitud(texto string, unidad UnidadLongitud) (float64, error) {
+	texto = strings.ToLower(strings.TrimSpace(texto))
+	for u := range milimetrosPor {
+		if numero, ok := strings.CutSuffix(texto, string(u)); ok {
+			texto, unidad = strings.TrimSpace(numero), u
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(texto, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("debe ser un número, con unidad %s, %s o %s opcional", UnidadMilimetro, UnidadCentimetro, UnidadPulgada)
+	}
+	return n * milimetrosPor[unidad], nil
+}
+
+// RangoDimensiones limita las medidas de los productos en una búsqueda; un
+// límite a 0 no filtra. Los límites se expresan en Unidad, por defecto
+// milímetros
+type RangoDimensiones struct {
+	MinAncho    float64        `json:"min_ancho"`
+	MaxAncho    float64        `json:"max_ancho"`
+	MinAlto     float64        `json:"min_alto"`
+	MaxAlto     float64        `json:"max_alto"`
+	MinProfundo float64        `json:"min_profundo"`
+	MaxProfundo float64        `json:"max_profundo"`
+	Unidad      UnidadLongitud `json:"unidad" enums:"mm,cm,in"`
+}
+
+// Consulta traduce el rango a los parámetros dimensiones.<eje>.min y .max
+// que entiende ParseFiltrosDimensiones. Los límites llevan la unidad como
+// sufijo para que ?unidad= solo cambie la unidad de la respuesta
+func (r RangoDimensiones) Consulta() (url.Values, error) {
+	unidad, err := ParseUnidad(string(r.Unidad))
+	if err != nil {
+		return nil, fmt.Errorf("dimensiones.%v", err)
+	}
+	consulta := url.Values{}
+	for clave, v := range map[string]float64{
+		"dimensiones.ancho.min":    r.MinAncho,
+		"dimensiones.ancho.max":    r.MaxAncho,
+		"dimensiones.alto.min":     r.MinAlto,
+		"dimensiones.alto.max":     r.MaxAlto,
+		"dimensiones.profundo.min": r.MinProfundo,
+		"dimensiones.profundo.max": r.MaxProfundo,
+	} {
+		if v < 0 {
+			return nil, fmt.Errorf("%s no puede ser negativo", clave)
+		}
+		if v > 0 {
+			consulta.Set(clave, strconv.FormatFloat(v, 'f', -1, 64)+string(unidad))
+		}
+	}
+	return consulta, nil
+}
@@ -0,0 +1,136 @@
+package dominio
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseUnidad(t *testing.T) {
+	for texto, want := range map[string]UnidadLongitud{
+		"":     UnidadMilimetro,
+		"mm":   UnidadMilimetro,
+		" CM ": UnidadCentimetro,
+		"in":   UnidadPulgada,
+	} {
+		if u, err := ParseUnidad(texto); err != nil || u != want {
+			t.Errorf("ParseUnidad(%q) = %q, %v; se esperaba %q", texto, u, err, want)
+		}
+	}
+	for _, texto := range []string{"ft", "m", "pulgadas"} {
+		if u, err := ParseUnidad(texto); err == nil {
+			t.Errorf("ParseUnidad(%q) = %q, se esperaba un error", texto, u)
+		}
+	}
+}
+
+func TestLeerLongitud(t *testing.T) {
+	casos := []struct {
+		texto  string
+		unidad UnidadLongitud
+		want   float64
+	}{
+		{"12", UnidadMilimetro, 12},
+		{"12", UnidadCentimetro, 120},
+		{"2in", UnidadMilimetro, 50.8},
+		{"2 IN", UnidadCentimetro, 50.8},
+		{"5cm", UnidadPulgada, 50},
+		{"0.5mm", UnidadPulgada, 0.5},
+	}
+	for _, c := range casos {
+		if got, err := LeerLongitud(c.texto, c.unidad); err != nil || got != c.want {
+			t.Errorf("LeerLongitud(%q, %s) = %v, %v; se esperaba %v", c.texto, c.unidad, got, err, c.want)
+		}
+	}
+	for _, texto := range []string{"", "dos", "2ft", "NaN", "Infin"} {
+		if got, err := LeerLongitud(texto, UnidadMilimetro); err == nil {
+			t.Errorf("LeerLongitud(%q) = %v, se esperaba un error", texto, got)
+		}
+	}
+}
+
+// TestDimensionesIdaYVuelta normaliza unas dimensiones en cada unidad y las
+// vuelve a expresar en ella: deben quedar como estaban
+func TestDimensionesIdaYVuelta(t *testing.T) {
+	for _, unidad := range []UnidadLongitud{UnidadMilimetro, UnidadCentimetro, UnidadPulgada} {
+		original := Dimensiones{Ancho: 3.3, Alto: 12.7, Profundo: 0.1, Unidad: unidad}
+		d := original
+		d.Normalizar()
+		if d.Unidad != UnidadMilimetro {
+			t.Errorf("%s: normalizadas en %q", unidad, d.Unidad)
+		}
+		if vuelta := d.En(unidad); vuelta != original {
+			t.Errorf("%s: %+v, tras normalizar y volver %+v", unidad, original, vuelta)
+		}
+	}
+
+	d := Dimensiones{Ancho: 25.4, Alto: 10, Profundo: 1}
+	d.Normalizar()
+	if d != (Dimensiones{Ancho: 25.4, Alto: 10, Profundo: 1, Unidad: UnidadMilimetro}) {
+		t.Errorf("sin unidad se entienden en milímetros: %+v", d)
+	}
+	if en := d.En(UnidadPulgada); en.Ancho != 1 || en.Unidad != UnidadPulgada {
+		t.Errorf("en pulgadas: %+v", en)
+	}
+	// Una unidad vacía o desconocida se trata como milímetros
+	for _, unidad := range []UnidadLongitud{"", "ft"} {
+		if en := d.En(unidad); en != d {
+			t.Errorf("En(%q): %+v", unidad, en)
+		}
+	}
+
+	// Una unidad desconocida se conserva para que Validar la rechace
+	desconocida := Dimensiones{Ancho: 1, Alto: 1, Profundo: 1, Unidad: "ft"}
+	desconocida.Normalizar()
+	var errs ErroresValidacion
+	desconocida.Validar("dimensiones", &errs)
+	if len(errs) != 1 || errs[0].Campo != "dimensiones.unidad" {
+		t.Errorf("errores de una unidad desconocida: %v", errs)
+	}
+}
+
+func TestParseFiltrosDimensiones(t *testing.T) {
+	query, _ := url.ParseQuery("unidad=cm&dimensiones.ancho.min=2&dimensiones.ancho.max=1in&dimensiones.alto.max=30mm")
+	filtros, err := ParseFiltrosDimensiones(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtros) != 2 || filtros[0].Eje != "ancho" || *filtros[0].Min != 20 || *filtros[0].Max != 25.4 ||
+		filtros[1].Eje != "alto" || filtros[1].Min != nil || *filtros[1].Max != 30 {
+		t.Fatalf("filtros: %+v", filtros)
+	}
+	if !filtros[0].Cumple(Dimensiones{Ancho: 22}) || filtros[0].Cumple(Dimensiones{Ancho: 26}) {
+		t.Error("Cumple no respeta el rango")
+	}
+
+	for _, q := range []string{"dimensiones.largo.min=1", "dimensiones.ancho.desde=1", "dimensiones.ancho.min=uno", "unidad=ft"} {
+		query, _ := url.ParseQuery(q)
+		if _, err := ParseFiltrosDimensiones(query); err == nil {
+			t.Errorf("%s: se aceptó", q)
+		}
+	}
+}
+
+// TestRangoDimensionesConsulta comprueba que el rango de catalogo-filtros se
+// traduce a parámetros que ParseFiltrosDimensiones lee en la misma unidad
+func TestRangoDimensionesConsulta(t *testing.T) {
+	consulta, err := RangoDimensiones{MinAncho: 2, MaxAlto: 4, Unidad: UnidadPulgada}.Consulta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(consulta) != 2 || consulta.Get("dimensiones.ancho.min") != "2in" || consulta.Get("dimensiones.alto.max") != "4in" {
+		t.Fatalf("consulta: %v", consulta)
+	}
+	// ?unidad= no cambia los límites, que llevan su unidad
+	consulta.Set("unidad", "cm")
+	filtros, err := ParseFiltrosDimensiones(consulta)
+	if err != nil || len(filtros) != 2 || *filtros[0].Min != 50.8 || *filtros[1].Max != 101.6 {
+		t.Errorf("filtros: %+v, %v", filtros, err)
+	}
+
+	if _, err := (RangoDimensiones{MinAncho: -1}).Consulta(); err == nil {
+		t.Error("se aceptó un límite negativo")
+	}
+	if _, err := (RangoDimensiones{Unidad: "ft"}).Consulta(); err == nil {
+		t.Error("se aceptó una unidad desconocida")
+	}
+}
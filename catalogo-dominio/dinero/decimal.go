@@ -0,0 +1,215 @@
+package dinero
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Escala es el número de decimales que conserva un Decimal. Basta para los
+// importes de cualquier moneda ISO 4217 y para las tasas de cambio
+const Escala = 8
+
+const factorEscala = 100_000_000 // 10^Escala
+
+// ErrDecimalInvalido se devuelve al interpretar un texto que no es un decimal
+// o que tiene más de Escala decimales
+var ErrDecimalInvalido = fmt.Errorf("debe ser un número decimal con como máximo %d decimales", Escala)
+
+// ErrDesbordamiento se devuelve cuando el resultado de una operación no cabe
+// en un Decimal, es decir, cuando su valor absoluto supera unos 92.000
+// millones
+var ErrDesbordamiento = errors.New("el valor excede el máximo representable")
+
+// Decimal es un número decimal exacto de coma fija: el valor multiplicado por
+// 10^Escala. Se serializa en JSON como texto, "25.99", para que los clientes
+// no lo conviertan a coma flotante; al leer JSON acepta también números
+type Decimal int64
+
+// Uno es el decimal 1
+const Uno Decimal = factorEscala
+
+// ParseDecimal interpreta un decimal con punto, p. ej. "-12.5". No admite
+// exponentes ni separadores de miles
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	negativo := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	entera, fraccion, _ := strings.Cut(s, ".")
+	if entera == "" && fraccion == "" || len(fraccion) > Escala || !soloDigitos(entera) || !soloDigitos(fraccion) {
+		return 0, ErrDecimalInvalido
+	}
+	if entera == "" {
+		entera = "0"
+	}
+	n, err := strconv.ParseInt(entera+fraccion+strings.Repeat("0", Escala-len(fraccion)), 10, 64)
+	if err != nil {
+		return 0, ErrDecimalInvalido
+	}
+	if negativo {
+		n = -n
+	}
+	return Decimal(n), nil
+}
+
+func soloDigitos(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String escribe el decimal sin ceros finales, p. ej. "25.99" o "3"
+func (d Decimal) String() string {
+	n := int64(d)
+	signo := ""
+	if n < 0 {
+		signo, n = "-", -n
+	}
+	entera, fraccion := n/factorEscala, n%factorEscala
+	if fraccion == 0 {
+		return signo + strconv.FormatInt(entera, 10)
+	}
+	f := strings.TrimRight(fmt.Sprintf("%0*d", Escala, fraccion), "0")
+	return signo + strconv.FormatInt(entera, 10) + "." + f
+}
+
+// Decimales es el número de decimales significativos
+func (d Decimal) Decimales() int {
+	s := d.String()
+	if _, f, ok := strings.Cut(s, "."); ok {
+		return len(f)
+	}
+	return 0
+}
+
+// Float64 aproxima el decimal en coma flotante; solo para ordenar o mostrar
+func (d Decimal) Float64() float64 {
+	return float64(d) / factorEscala
+}
+
+// Sumar devuelve d+k
+func (d Decimal) Sumar(k Decimal) (Decimal, error) {
+	return aDecimal(new(big.Int).Add(big.NewInt(int64(d)), big.NewInt(int64(k))))
+}
+
+// Multiplicar devuelve d·k redondeado a Escala decimales
+func (d Decimal) Multiplicar(k Decimal) (Decimal, error) {
+	p := new(big.Int).Mul(big.NewInt(int64(d)), big.NewInt(int64(k)))
+	return aDecimal(dividirRedondeando(p, big.NewInt(factorEscala)))
+}
+
+// MultiplicarEntero devuelve d·n, p. ej. un precio por una cantidad
+func (d Decimal) MultiplicarEntero(n int64) (Decimal, error) {
+	return aDecimal(new(big.Int).Mul(big.NewInt(int64(d)), big.NewInt(n)))
+}
+
+// Inverso devuelve 1/d redondeado a Escala decimales. d no puede ser 0. El
+// resultado siempre cabe: como mucho es 1/10^-Escala
+func (d Decimal) Inverso() Decimal {
+	uno := new(big.Int).Mul(big.NewInt(factorEscala), big.NewInt(factorEscala))
+	return Decimal(dividirRedondeando(uno, big.NewInt(int64(d))).Int64())
+}
+
+// Redondear deja el decimal con como máximo decimales cifras decimales,
+// redondeando la mitad hacia fuera del cero. Solo desborda al redondear
+// hacia arriba un valor muy próximo al máximo
+func (d Decimal) Redondear(decimales int) (Decimal, error) {
+	if decimales >= Escala {
+		return d, nil
+	}
+	paso := big.NewInt(1)
+	for i := decimales; i < Escala; i++ {
+		paso.Mul(paso, big.NewInt(10))
+	}
+	q := dividirRedondeando(big.NewInt(int64(d)), paso)
+	return aDecimal(q.Mul(q, paso))
+}
+
+// dividirRedondeando divide redondeando la mitad hacia fuera del cero
+func dividirRedondeando(a, b *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(a, b, new(big.Int))
+	// |2r| >= |b| significa que el resto es al menos la mitad
+	doble := new(big.Int).Abs(new(big.Int).Mul(r, big.NewInt(2)))
+	if doble.Cmp(new(big.Int).Abs(b)) >= 0 {
+		if (a.Sign() < 0) != (b.Sign() < 0) {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// aDecimal convierte el resultado de una operación, ya en la escala de
+// Decimal, o devuelve ErrDesbordamiento si no cabe
+func aDecimal(n *big.Int) (Decimal, error) {
+	if !n.IsInt64() {
+		return 0, ErrDesbordamiento
+	}
+	return Decimal(n.Int64()), nil
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	}
+	v, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// Value guarda el decimal como texto para las columnas NUMERIC
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+func (d *Decimal) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*d = 0
+		return nil
+	case []byte:
+		return d.scanTexto(string(v))
+	case string:
+		return d.scanTexto(v)
+	case int64:
+		n, err := Decimal(v).MultiplicarEntero(factorEscala)
+		if err != nil {
+			return err
+		}
+		*d = n
+		return nil
+	case float64:
+		return d.scanTexto(strconv.FormatFloat(v, 'f', Escala, 64))
+	}
+	return fmt.Errorf("dinero: no se puede leer un Decimal de %T", src)
+}
+
+func (d *Decimal) scanTexto(s string) error {
+	v, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
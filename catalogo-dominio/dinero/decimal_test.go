@@ -0,0 +1,207 @@
+package dinero
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	casos := []struct {
+		texto string
+		want  Decimal
+	}{
+		{"0", 0},
+		{"25.99", 2_599_000_000},
+		{" -12.5 ", -1_250_000_000},
+		{"+3", 3 * Uno},
+		{".5", Uno / 2},
+		{"7.", 7 * Uno},
+		{"0.00000001", 1},
+		{"92233720368.54775807", math.MaxInt64},
+		{"-92233720368.54775807", -math.MaxInt64},
+	}
+	for _, c := range casos {
+		got, err := ParseDecimal(c.texto)
+		if err != nil || got != c.want {
+			t.Errorf("ParseDecimal(%q) = %d, %v; se esperaba %d", c.texto, got, err, c.want)
+		}
+	}
+
+	invalidos := []string{
+		"", ".", "-", "abc", "1,5", "1e3", "1.2.3", "--1", " 1 2",
+		// Más decimales de los que conserva la escala
+		"0.000000001",
+		// Fuera de rango
+		"92233720368.54775808", "100000000000",
+	}
+	for _, texto := range invalidos {
+		if got, err := ParseDecimal(texto); !errors.Is(err, ErrDecimalInvalido) {
+			t.Errorf("ParseDecimal(%q) = %d, %v; se esperaba ErrDecimalInvalido", texto, got, err)
+		}
+	}
+}
+
+func TestDecimalString(t *testing.T) {
+	casos := map[string]string{
+		"25.99":       "25.99",
+		"25.90":       "25.9",
+		"3.000":       "3",
+		"-0.5":        "-0.5",
+		"0.00000001":  "0.00000001",
+		"-1234.56789": "-1234.56789",
+	}
+	for texto, want := range casos {
+		d, err := ParseDecimal(texto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.String(); got != want {
+			t.Errorf("%q.String() = %q, se esperaba %q", texto, got, want)
+		}
+	}
+}
+
+func TestDecimalJSON(t *testing.T) {
+	var v struct {
+		Texto  Decimal `json:"texto"`
+		Numero Decimal `json:"numero"`
+	}
+	if err := json.Unmarshal([]byte(`{"texto": "10.25", "numero": 3.5}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Texto != decimal(t, "10.25") || v.Numero != decimal(t, "3.5") {
+		t.Errorf("se leyó %v y %v", v.Texto, v.Numero)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"texto":"10.25","numero":"3.5"}`; string(b) != want {
+		t.Errorf("se escribió %s, se esperaba %s", b, want)
+	}
+	if err := json.Unmarshal([]byte(`{"texto": "1e9"}`), &v); !errors.Is(err, ErrDecimalInvalido) {
+		t.Errorf("un exponente no debería aceptarse: %v", err)
+	}
+}
+
+func TestRedondear(t *testing.T) {
+	casos := []struct {
+		valor     string
+		decimales int
+		want      string
+	}{
+		{"2.344", 2, "2.34"},
+		{"2.345", 2, "2.35"},
+		{"-2.345", 2, "-2.35"},
+		{"-2.344", 2, "-2.34"},
+		{"0.5", 0, "1"},
+		{"-0.5", 0, "-1"},
+		{"0.49999999", 0, "0"},
+		{"1.23456789", 8, "1.23456789"},
+		{"1.23456789", 12, "1.23456789"},
+	}
+	for _, c := range casos {
+		got, err := decimal(t, c.valor).Redondear(c.decimales)
+		if err != nil || got != decimal(t, c.want) {
+			t.Errorf("%s.Redondear(%d) = %v, %v; se esperaba %s", c.valor, c.decimales, got, err, c.want)
+		}
+	}
+}
+
+func TestMultiplicar(t *testing.T) {
+	casos := []struct{ a, b, want string }{
+		{"25.99", "0.92", "23.9108"},
+		{"-3", "0.5", "-1.5"},
+		{"0.00000001", "0.5", "0.00000001"},
+		{"-0.00000001", "0.5", "-0.00000001"},
+		{"0.00000001", "0.49", "0"},
+		{"1000000", "1000", "1000000000"},
+	}
+	for _, c := range casos {
+		got, err := decimal(t, c.a).Multiplicar(decimal(t, c.b))
+		if err != nil || got != decimal(t, c.want) {
+			t.Errorf("%s·%s = %v, %v; se esperaba %s", c.a, c.b, got, err, c.want)
+		}
+	}
+
+	if got := decimal(t, "4").Inverso(); got != decimal(t, "0.25") {
+		t.Errorf("1/4 = %v", got)
+	}
+	if got := decimal(t, "3").Inverso(); got != decimal(t, "0.33333333") {
+		t.Errorf("1/3 = %v", got)
+	}
+	if got := Decimal(1).Inverso(); got != decimal(t, "100000000") {
+		t.Errorf("1/0.00000001 = %v", got)
+	}
+}
+
+func TestDesbordamiento(t *testing.T) {
+	maximo := Decimal(math.MaxInt64)
+	minimo := Decimal(math.MinInt64)
+
+	operaciones := map[string]func() (Decimal, error){
+		"sumar":                   func() (Decimal, error) { return maximo.Sumar(1) },
+		"restar":                  func() (Decimal, error) { return minimo.Sumar(-1) },
+		"multiplicar":             func() (Decimal, error) { return decimal(t, "100000000").Multiplicar(decimal(t, "1000")) },
+		"multiplicar negativo":    func() (Decimal, error) { return decimal(t, "-100000000").Multiplicar(decimal(t, "1000")) },
+		"multiplicar por entero":  func() (Decimal, error) { return decimal(t, "50000000000").MultiplicarEntero(2) },
+		"redondear hacia arriba":  func() (Decimal, error) { return maximo.Redondear(0) },
+		"redondear hacia abajo":   func() (Decimal, error) { return minimo.Redondear(2) },
+		"multiplicar por mínimo":  func() (Decimal, error) { return minimo.MultiplicarEntero(-1) },
+		"multiplicar dos máximos": func() (Decimal, error) { return maximo.Multiplicar(maximo) },
+	}
+	for nombre, op := range operaciones {
+		if got, err := op(); !errors.Is(err, ErrDesbordamiento) {
+			t.Errorf("%s = %v, %v; se esperaba ErrDesbordamiento", nombre, got, err)
+		}
+	}
+
+	// Justo en el límite no desborda
+	if got, err := (maximo - 1).Sumar(1); err != nil || got != maximo {
+		t.Errorf("máximo-1+1 = %v, %v", got, err)
+	}
+	if got, err := maximo.Multiplicar(Uno); err != nil || got != maximo {
+		t.Errorf("máximo·1 = %v, %v", got, err)
+	}
+	if got, err := maximo.Redondear(Escala); err != nil || got != maximo {
+		t.Errorf("máximo redondeado a %d decimales = %v, %v", Escala, got, err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	casos := []struct {
+		origen any
+		want   string
+	}{
+		{nil, "0"},
+		{"25.99", "25.99"},
+		{[]byte("-1.5"), "-1.5"},
+		{int64(7), "7"},
+		{0.1, "0.1"},
+	}
+	for _, c := range casos {
+		var d Decimal
+		if err := d.Scan(c.origen); err != nil || d != decimal(t, c.want) {
+			t.Errorf("Scan(%#v) = %v, %v; se esperaba %s", c.origen, d, err, c.want)
+		}
+	}
+
+	var d Decimal
+	if err := d.Scan(int64(math.MaxInt64 / 10)); !errors.Is(err, ErrDesbordamiento) {
+		t.Errorf("Scan de un entero fuera de rango: %v", err)
+	}
+	if err := d.Scan(true); err == nil {
+		t.Error("Scan de un bool debería fallar")
+	}
+}
+
+func decimal(t *testing.T, s string) Decimal {
+	t.Helper()
+	d, err := ParseDecimal(s)
+	if err != nil {
+		t.Fatalf("ParseDecimal(%q): %v", s, err)
+	}
+	return d
+}
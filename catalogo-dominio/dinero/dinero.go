@@ -0,0 +1,203 @@
+// Package dinero representa importes monetarios exactos con su moneda
+// ISO 4217 y los convierte entre monedas con una tabla de tasas de cambio
+// con fecha de vigencia.
+package dinero
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// decimalesMoneda son las monedas admitidas con su número de decimales
+// según ISO 4217
+var decimalesMoneda = map[string]int{
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"CHF": 2,
+	"CAD": 2,
+	"MXN": 2,
+	"ARS": 2,
+	"BRL": 2,
+	"COP": 2,
+	"PEN": 2,
+	"UYU": 2,
+	"CLP": 0,
+	"JPY": 0,
+}
+
+// MonedaPorDefecto es la moneda de los importes que no la indican. Se
+// configura con MONEDA_PREDETERMINADA; por defecto USD
+func MonedaPorDefecto() string {
+	if m := strings.ToUpper(strings.TrimSpace(os.Getenv("MONEDA_PREDETERMINADA"))); MonedaValida(m) {
+		return m
+	}
+	return "USD"
+}
+
+// MonedaValida indica si la moneda es un código ISO 4217 admitido
+func MonedaValida(moneda string) bool {
+	_, ok := decimalesMoneda[moneda]
+	return ok
+}
+
+// Monedas devuelve los códigos admitidos en orden alfabético
+func Monedas() []string {
+	monedas := make([]string, 0, len(decimalesMoneda))
+	for m := range decimalesMoneda {
+		monedas = append(monedas, m)
+	}
+	sort.Strings(monedas)
+	return monedas
+}
+
+// Decimales devuelve el número de decimales de la moneda
+func Decimales(moneda string) int {
+	return decimalesMoneda[moneda]
+}
+
+// Dinero es un importe exacto en una moneda
+type Dinero struct {
+	Importe Decimal `gorm:"column:importe" json:"importe" swaggertype:"string" example:"25.99"`
+	Moneda  string  `gorm:"column:moneda" json:"moneda" example:"USD"`
+}
+
+// Nuevo crea un importe a partir de su texto decimal
+func Nuevo(importe string, moneda string) (Dinero, error) {
+	d, err := ParseDecimal(importe)
+	if err != nil {
+		return Dinero{}, err
+	}
+	return Dinero{Importe: d, Moneda: moneda}, nil
+}
+
+func (d Dinero) String() string {
+	return d.Importe.String() + " " + d.Moneda
+}
+
+// UnmarshalJSON acepta el objeto {"importe": "25.99", "moneda": "EUR"} y,
+// por compatibilidad con los precios anteriores, un número suelto, que solo
+// cambia el importe y conserva la moneda que hubiera
+func (d *Dinero) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || b[0] != '{' {
+		return d.Importe.UnmarshalJSON(b)
+	}
+	type sinMetodos Dinero
+	return json.Unmarshal(b, (*sinMetodos)(d))
+}
+
+// Validar devuelve, por subcampo (importe o moneda), por qué el importe no
+// es válido. positivo exige un importe mayor que 0; si no, basta con que no
+// sea negativo
+func (d Dinero) Validar(positivo bool) map[string]string {
+	errs := make(map[string]string)
+	switch {
+	case positivo && d.Importe <= 0:
+		errs["importe"] = "debe ser mayor que 0"
+	case d.Importe < 0:
+		errs["importe"] = "no puede ser negativo"
+	}
+	if !MonedaValida(d.Moneda) {
+		errs["moneda"] = "debe ser un código ISO 4217 admitido: " + strings.Join(Monedas(), ", ")
+	} else if d.Importe.Decimales() > Decimales(d.Moneda) {
+		errs["importe"] = fmt.Sprintf("admite como máximo %d decimales en %s", Decimales(d.Moneda), d.Moneda)
+	}
+	return errs
+}
+
+// ErrSinTasa se devuelve cuando no hay una tasa de cambio vigente entre dos monedas
+var ErrSinTasa = errors.New("no hay una tasa de cambio vigente")
+
+// TasaCambio indica cuántas unidades de Hacia vale una unidad de Desde a
+// partir de VigenteDesde. Una tasa deja de aplicarse cuando entra en vigor
+// otra más reciente para el mismo par de monedas
+type TasaCambio struct {
+	ID           string    `gorm:"primaryKey" json:"id"`
+	Desde        string    `json:"desde"`
+	Hacia        string    `json:"hacia"`
+	Tasa         Decimal   `json:"tasa" swaggertype:"string" example:"0.92"`
+	VigenteDesde time.Time `json:"vigente_desde"`
+}
+
+func (TasaCambio) TableName() string {
+	return "tasas_cambio"
+}
+
+// Validar devuelve, por campo, por qué la tasa no es válida
+func (t TasaCambio) Validar() map[string]string {
+	errs := make(map[string]string)
+	if !MonedaValida(t.Desde) {
+		errs["desde"] = "debe ser un código ISO 4217 admitido"
+	}
+	if !MonedaValida(t.Hacia) {
+		errs["hacia"] = "debe ser un código ISO 4217 admitido"
+	}
+	if t.Desde == t.Hacia {
+		errs["hacia"] = "debe ser distinta de desde"
+	}
+	if t.Tasa <= 0 {
+		errs["tasa"] = "debe ser mayor que 0"
+	}
+	if t.VigenteDesde.IsZero() {
+		errs["vigente_desde"] = "es obligatoria"
+	}
+	return errs
+}
+
+// TasaVigente busca en tasas la tasa de desde a hacia vigente en el instante
+// en. Si solo hay tasa para el par inverso se usa su inverso; si hay de
+// ambos sentidos se usa la que entró en vigor más tarde
+func TasaVigente(tasas []TasaCambio, desde, hacia string, en time.Time) (Decimal, error) {
+	if desde == hacia {
+		return Uno, nil
+	}
+	var directa, inversa *TasaCambio
+	for i := range tasas {
+		t := &tasas[i]
+		if t.VigenteDesde.After(en) {
+			continue
+		}
+		switch {
+		case t.Desde == desde && t.Hacia == hacia:
+			if directa == nil || t.VigenteDesde.After(directa.VigenteDesde) {
+				directa = t
+			}
+		case t.Desde == hacia && t.Hacia == desde:
+			if inversa == nil || t.VigenteDesde.After(inversa.VigenteDesde) {
+				inversa = t
+			}
+		}
+	}
+	switch {
+	case directa != nil && (inversa == nil || !inversa.VigenteDesde.After(directa.VigenteDesde)):
+		return directa.Tasa, nil
+	case inversa != nil:
+		return inversa.Tasa.Inverso(), nil
+	}
+	return 0, fmt.Errorf("%w de %s a %s", ErrSinTasa, desde, hacia)
+}
+
+// Convertir pasa el importe a la moneda hacia con la tasa vigente en el
+// instante en, redondeado a los decimales de esa moneda. Devuelve
+// ErrDesbordamiento si el importe convertido no cabe en un Decimal
+func Convertir(d Dinero, hacia string, tasas []TasaCambio, en time.Time) (Dinero, error) {
+	tasa, err := TasaVigente(tasas, d.Moneda, hacia, en)
+	if err != nil {
+		return Dinero{}, err
+	}
+	importe, err := d.Importe.Multiplicar(tasa)
+	if err != nil {
+		return Dinero{}, err
+	}
+	if importe, err = importe.Redondear(Decimales(hacia)); err != nil {
+		return Dinero{}, err
+	}
+	return Dinero{Importe: importe, Moneda: hacia}, nil
+}
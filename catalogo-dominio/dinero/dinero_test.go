@@ -0,0 +1,83 @@
+package dinero
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConvertir(t *testing.T) {
+	enero := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	marzo := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	tasas := []TasaCambio{
+		{Desde: "USD", Hacia: "EUR", Tasa: decimal(t, "0.92"), VigenteDesde: enero},
+		{Desde: "USD", Hacia: "EUR", Tasa: decimal(t, "0.95"), VigenteDesde: marzo},
+		{Desde: "USD", Hacia: "JPY", Tasa: decimal(t, "149.5"), VigenteDesde: enero},
+		{Desde: "EUR", Hacia: "GBP", Tasa: decimal(t, "90000000"), VigenteDesde: enero},
+	}
+	febrero := marzo.AddDate(0, -1, 0)
+
+	casos := []struct {
+		importe, desde, hacia string
+		en                    time.Time
+		want                  string
+	}{
+		// Redondeo a los decimales de la moneda de destino
+		{"25.99", "USD", "EUR", febrero, "23.91"},
+		{"25.99", "USD", "EUR", marzo, "24.69"},
+		{"10.01", "USD", "JPY", febrero, "1496"},
+		// Sin tasa directa se usa el inverso: 1/0.92 = 1.08695652
+		{"100", "EUR", "USD", febrero, "108.7"},
+		{"5", "EUR", "EUR", febrero, "5"},
+	}
+	for _, c := range casos {
+		d, err := Nuevo(c.importe, c.desde)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Convertir(d, c.hacia, tasas, c.en)
+		if err != nil || got.Importe != decimal(t, c.want) || got.Moneda != c.hacia {
+			t.Errorf("Convertir(%s, %s) = %v, %v; se esperaba %s %s", d, c.hacia, got, err, c.want, c.hacia)
+		}
+	}
+
+	if _, err := Convertir(Dinero{Importe: Uno, Moneda: "USD"}, "EUR", tasas, enero.AddDate(0, 0, -1)); !errors.Is(err, ErrSinTasa) {
+		t.Errorf("antes de la primera tasa: %v", err)
+	}
+	grande := Dinero{Importe: decimal(t, "1000000"), Moneda: "EUR"}
+	if got, err := Convertir(grande, "GBP", tasas, febrero); !errors.Is(err, ErrDesbordamiento) {
+		t.Errorf("Convertir(%s, GBP) = %v, %v; se esperaba ErrDesbordamiento", grande, got, err)
+	}
+}
+
+func TestValidarDinero(t *testing.T) {
+	casos := []struct {
+		importe, moneda string
+		positivo        bool
+		campos          []string
+	}{
+		{"25.99", "USD", true, nil},
+		{"0", "USD", false, nil},
+		{"0", "USD", true, []string{"importe"}},
+		{"-1", "EUR", false, []string{"importe"}},
+		{"25.999", "USD", true, []string{"importe"}},
+		{"100.5", "JPY", true, []string{"importe"}},
+		{"10", "XXX", true, []string{"moneda"}},
+	}
+	for _, c := range casos {
+		d, err := Nuevo(c.importe, c.moneda)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errs := d.Validar(c.positivo)
+		if len(errs) != len(c.campos) {
+			t.Errorf("Validar(%s) = %v, se esperaban errores en %v", d, errs, c.campos)
+			continue
+		}
+		for _, campo := range c.campos {
+			if _, ok := errs[campo]; !ok {
+				t.Errorf("Validar(%s) = %v, falta el error de %s", d, errs, campo)
+			}
+		}
+	}
+}
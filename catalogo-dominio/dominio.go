@@ -0,0 +1,8 @@
+// Package dominio reúne los tipos del catálogo que comparten los servicios:
+// las dimensiones de los productos y sus unidades, el ciclo de vida de un
+// producto, los errores de validación y los códigos de error de la API, y
+// los sobres de las respuestas. Los importes están en el paquete dinero.
+//
+// La especificación OpenAPI de catalogo-productos se genera a partir de
+// estos tipos, así que cambiarlos cambia la documentación de la API.
+package dominio
@@ -0,0 +1,66 @@
+package dominio
+
+import (
+	"fmt"
+	"slices"
+)
+
+// EstadoProducto es la etapa del ciclo de vida en la que está un producto
+type EstadoProducto string
+
+const (
+	EstadoBorrador      EstadoProducto = "borrador"
+	EstadoDisponible    EstadoProducto = "disponible"
+	EstadoAgotado       EstadoProducto = "agotado"
+	EstadoDescontinuado EstadoProducto = "descontinuado"
+)
+
+// transicionesPermitidas define el ciclo de vida de un producto:
+// borrador → disponible → agotado → descontinuado. Un producto agotado puede
+// volver a estar disponible y cualquier estado salvo descontinuado puede
+// pasar a descontinuado, que es final
+var transicionesPermitidas = map[EstadoProducto][]EstadoProducto{
+	EstadoBorrador:      {EstadoDisponible, EstadoDescontinuado},
+	EstadoDisponible:    {EstadoAgotado, EstadoDescontinuado},
+	EstadoAgotado:       {EstadoDisponible, EstadoDescontinuado},
+	EstadoDescontinuado: {},
+}
+
+// EstadosIniciales son los estados con los que se puede crear un producto
+var EstadosIniciales = []EstadoProducto{EstadoBorrador, EstadoDisponible}
+
+// Valido indica si el estado pertenece al ciclo de vida
+func (e EstadoProducto) Valido() bool {
+	_, ok := transicionesPermitidas[e]
+	return ok
+}
+
+// Inicial indica si un producto se puede crear en el estado
+func (e EstadoProducto) Inicial() bool {
+	return slices.Contains(EstadosIniciales, e)
+}
+
+// PuedePasarA indica si el ciclo de vida permite ir de e a destino. Quedarse
+// en el mismo estado siempre está permitido
+func (e EstadoProducto) PuedePasarA(destino EstadoProducto) bool {
+	return e == destino || slices.Contains(transicionesPermitidas[e], destino)
+}
+
+// ValidarTransicion devuelve un error de campo si el cambio de estado no está permitido
+func ValidarTransicion(desde, hacia EstadoProducto) error {
+	if desde.PuedePasarA(hacia) {
+		return nil
+	}
+	return ErroresValidacion{{
+		Campo:   "estado",
+		Mensaje: fmt.Sprintf("no se puede pasar de %q a %q", desde, hacia),
+	}}
+}
+
+// Validar añade a errs un error si el estado no pertenece al ciclo de vida
+func (e EstadoProducto) Validar(errs *ErroresValidacion) {
+	if !e.Valido() {
+		errs.Agregar("estado", fmt.Sprintf("debe ser %q, %q, %q o %q",
+			EstadoBorrador, EstadoDisponible, EstadoAgotado, EstadoDescontinuado))
+	}
+}
@@ -0,0 +1,3 @@
+module catalogo-dominio
+
+go 1.21
@@ -0,0 +1,96 @@
+package dominio
+
+import "net/http"
+
+// Codigo identifica el tipo de un error de la API. A diferencia del mensaje,
+// que se traduce según Accept-Language, no cambia y los clientes pueden
+// basarse en él
+type Codigo string
+
+const (
+	CodigoPeticionInvalida        Codigo = "peticion_invalida"
+	CodigoNoEncontrado            Codigo = "no_encontrado"
+	CodigoNoAceptable             Codigo = "no_aceptable"
+	CodigoConflicto               Codigo = "conflicto"
+	CodigoVersionObsoleta         Codigo = "version_obsoleta"
+	CodigoDemasiadoGrande         Codigo = "demasiado_grande"
+	CodigoTipoNoSoportado         Codigo = "tipo_no_soportado"
+	CodigoValidacion              Codigo = "validacion"
+	CodigoDependenciaFallida      Codigo = "dependencia_fallida"
+	CodigoInterno                 Codigo = "interno"
+	CodigoServicioNoDisponible    Codigo = "servicio_no_disponible"
+	CodigoIdempotenciaEnCurso     Codigo = "idempotencia_en_curso"
+	CodigoIdempotenciaReutilizada Codigo = "idempotencia_reutilizada"
+)
+
+// codigosPorEstado es el código de los errores que no tienen uno propio
+var codigosPorEstado = map[int]Codigo{
+	http.StatusBadRequest:            CodigoPeticionInvalida,
+	http.StatusNotFound:              CodigoNoEncontrado,
+	http.StatusNotAcceptable:         CodigoNoAceptable,
+	http.StatusConflict:              CodigoConflicto,
+	http.StatusPreconditionFailed:    CodigoVersionObsoleta,
+	http.StatusRequestEntityTooLarge: CodigoDemasiadoGrande,
+	http.StatusUnsupportedMediaType:  CodigoTipoNoSoportado,
+	http.StatusUnprocessableEntity:   CodigoValidacion,
+	http.StatusFailedDependency:      CodigoDependenciaFallida,
+	http.StatusBadGateway:            CodigoServicioNoDisponible,
+}
+
+// CodigoDeEstado devuelve el código de un error según su estado HTTP; los
+// estados sin código propio son CodigoInterno
+func CodigoDeEstado(estado int) Codigo {
+	if codigo, ok := codigosPorEstado[estado]; ok {
+		return codigo
+	}
+	return CodigoInterno
+}
+
+// RespuestaError es el cuerpo de las respuestas de error. Errores lleva el
+// detalle por campo de los errores de validación
+type RespuestaError struct {
+	Error   string            `json:"error" example:"Producto no encontrado"`
+	Codigo  Codigo            `json:"codigo" example:"no_encontrado"`
+	Errores ErroresValidacion `json:"errores,omitempty"`
+}
+
+// NuevoError crea el cuerpo de un error con el código de su estado HTTP
+func NuevoError(estado int, mensaje string) RespuestaError {
+	return RespuestaError{Error: mensaje, Codigo: CodigoDeEstado(estado)}
+}
+
+// Respuesta es el sobre de las respuestas con un recurso o una lista sin
+// paginar. En la documentación Data se concreta con la sintaxis de swag, p.
+// ej. dominio.Respuesta{data=Producto}
+type Respuesta struct {
+	Data any `json:"data"`
+}
+
+// Pagina es el sobre de los listados paginados
+type Pagina struct {
+	Data  any        `json:"data"`
+	Meta  Paginacion `json:"meta"`
+	Links Enlaces    `json:"links"`
+}
+
+// Paginacion describe la página devuelta en un listado. Page solo se indica
+// al paginar por número de página
+type Paginacion struct {
+	Total      int64  `json:"total"`
+	Page       int    `json:"page,omitempty"`
+	PageSize   int    `json:"page_size"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// Enlaces a la página actual y a las contiguas de un listado
+type Enlaces struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// Mensaje es el cuerpo de las respuestas que solo confirman una operación
+type Mensaje struct {
+	Message string `json:"message"`
+}
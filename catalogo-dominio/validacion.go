@@ -0,0 +1,27 @@
+package dominio
+
+import "strings"
+
+// ErrorCampo describe por qué un campo concreto no es válido
+type ErrorCampo struct {
+	Campo   string `json:"campo" example:"dimensiones.ancho"`
+	Mensaje string `json:"mensaje" example:"debe ser mayor que 0"`
+}
+
+// ErroresValidacion agrupa todos los errores de campo de un recurso para
+// devolverlos juntos en vez de uno por petición. Se responde con 422 y el
+// código CodigoValidacion
+type ErroresValidacion []ErrorCampo
+
+func (e ErroresValidacion) Error() string {
+	partes := make([]string, len(e))
+	for i, ec := range e {
+		partes[i] = ec.Campo + ": " + ec.Mensaje
+	}
+	return strings.Join(partes, "; ")
+}
+
+// Agregar añade el error de un campo
+func (e *ErroresValidacion) Agregar(campo, mensaje string) {
+	*e = append(*e, ErrorCampo{Campo: campo, Mensaje: mensaje})
+}
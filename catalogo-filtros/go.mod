@@ -2,7 +2,10 @@ module catalogo-filtros
 
 go 1.23.6
 
-require github.com/gin-gonic/gin v1.10.1
+require (
+	catalogo-dominio v0.0.0
+	github.com/gin-gonic/gin v1.10.1
+)
 
 require (
 	github.com/bytedance/sonic v1.11.6 // indirect
@@ -32,3 +35,5 @@ require (
 	google.golang.org/protobuf v1.34.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace catalogo-dominio => ../catalogo-dominio
@@ -4,24 +4,35 @@ import (
 	"log"
 	"net/http"
 
+	dominio "catalogo-dominio"
+
 	"github.com/gin-gonic/gin"
 )
 
 type Busqueda struct {
-	ID          string `json:"id"`
-	Query       string `json:"query"`
-	Categoria   string `json:"categoria"`
-	Dimensiones struct {
-		MinAncho    float64 `json:"min_ancho"`
-		MaxAncho    float64 `json:"max_ancho"`
-		MinAlto     float64 `json:"min_alto"`
-		MaxAlto     float64 `json:"max_alto"`
-		MinProfundo float64 `json:"min_profundo"`
-		MaxProfundo float64 `json:"max_profundo"`
-	} `json:"dimensiones"`
-	PrecioMin    float64 `json:"precio_min"`
-	PrecioMax    float64 `json:"precio_max"`
-	TipoMaterial string  `json:"tipo_material"`
+	ID        string `json:"id"`
+	Categoria string `json:"categoria"`
+	// Dimensiones limita las medidas de los productos; 0 no limita
+	Dimensiones dominio.RangoDimensiones `json:"dimensiones"`
+	// Query, PrecioMin, PrecioMax y TipoMaterial no los filtra
+	// catalogo-productos; una búsqueda que los indique se rechaza
+	Query        string   `json:"query"`
+	PrecioMin    *float64 `json:"precio_min"`
+	PrecioMax    *float64 `json:"precio_max"`
+	TipoMaterial string   `json:"tipo_material"`
+	// Etiquetas que deben tener todos los productos
+	Etiquetas []string `json:"etiquetas"`
+	// Atributos filtra por los atributos personalizados de los productos
+	Atributos []FiltroAtributo `json:"atributos"`
+}
+
+// FiltroAtributo filtra por un atributo definido en catalogo-productos: por
+// valor exacto o, en los numéricos, por rango con min y max
+type FiltroAtributo struct {
+	Codigo string   `json:"codigo"`
+	Valor  any      `json:"valor"`
+	Min    *float64 `json:"min"`
+	Max    *float64 `json:"max"`
 }
 
 func main() {
@@ -54,13 +65,10 @@ func main() {
 	r.Run(":8083")
 }
 
+// buscar lista productos con los filtros de la query, con los mismos
+// parámetros que GET /api/v1/productos
 func buscar(c *gin.Context) {
-	log.Printf("[GET /buscar] Endpoint de búsqueda GET")
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Endpoint de búsqueda GET",
-		"info":    "Usar POST para realizar búsquedas con filtros",
-		"status":  "ok",
-	})
+	listarProductos(c, consultaQuery(c.Request.URL.Query()))
 }
 
 func getBusqueda(c *gin.Context) {
@@ -70,20 +78,24 @@ func getBusqueda(c *gin.Context) {
 	})
 }
 
+// aplicarFiltros lista productos con los filtros de una Busqueda
 func aplicarFiltros(c *gin.Context) {
-	var filtros struct {
-		Categoria string  `json:"categoria"`
-		PrecioMax float64 `json:"precio_max"`
-	}
-
+	var filtros Busqueda
 	if err := c.ShouldBindJSON(&filtros); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, dominio.NuevoError(http.StatusBadRequest, err.Error()))
 		return
 	}
 
-	// Aquí iría la lógica de filtrado que interactuaría con los otros microservicios
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Filtros aplicados correctamente",
-		"filtros": filtros,
-	})
+	consulta, err := consultaBusqueda(filtros)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dominio.NuevoError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	// La paginación, el orden y los campos se indican en la query, como en GET
+	for _, p := range []string{"page", "page_size", "cursor", "sort", "fields", "moneda", "unidad"} {
+		if v := c.Query(p); v != "" {
+			consulta.Set(p, v)
+		}
+	}
+	listarProductos(c, consulta)
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	dominio "catalogo-dominio"
+
+	"github.com/gin-gonic/gin"
+)
+
+var clienteProductos = &http.Client{Timeout: 5 * time.Second}
+
+// parametrosProductos son los parámetros de GET /buscar que se pasan tal cual
+// al listado de catalogo-productos, además de los atributo.<codigo> y los
+// dimensiones.<eje>.min y .max
+var parametrosProductos = []string{
+	"categoria_id",
+	"subcategorias",
+	"etiqueta",
+	"page",
+	"page_size",
+	"cursor",
+	"sort",
+	"fields",
+	"moneda",
+	"unidad",
+}
+
+// consultaBusqueda traduce una búsqueda a los parámetros del listado de
+// catalogo-productos. La categoría incluye sus subcategorías. Los filtros que
+// el listado no admite hacen fallar la búsqueda en lugar de ignorarse
+func consultaBusqueda(b Busqueda) (url.Values, error) {
+	var noAdmitidos []string
+	if b.Query != "" {
+		noAdmitidos = append(noAdmitidos, "query")
+	}
+	if b.PrecioMin != nil {
+		noAdmitidos = append(noAdmitidos, "precio_min")
+	}
+	if b.PrecioMax != nil {
+		noAdmitidos = append(noAdmitidos, "precio_max")
+	}
+	if b.TipoMaterial != "" {
+		noAdmitidos = append(noAdmitidos, "tipo_material")
+	}
+	if len(noAdmitidos) > 0 {
+		return nil, fmt.Errorf("filtros no admitidos: %s; catalogo-productos no filtra por ellos", strings.Join(noAdmitidos, ", "))
+	}
+
+	consulta := url.Values{}
+	if b.Categoria != "" {
+		consulta.Set("categoria_id", b.Categoria)
+		consulta.Set("subcategorias", "true")
+	}
+	for _, e := range b.Etiquetas {
+		consulta.Add("etiqueta", e)
+	}
+	for _, f := range b.Atributos {
+		if f.Codigo == "" {
+			return nil, errors.New("los filtros de atributos necesitan un codigo")
+		}
+		clave := "atributo." + f.Codigo
+		if f.Valor != nil {
+			consulta.Set(clave, fmt.Sprint(f.Valor))
+		}
+		if f.Min != nil {
+			consulta.Set(clave+".min", fmt.Sprint(*f.Min))
+		}
+		if f.Max != nil {
+			consulta.Set(clave+".max", fmt.Sprint(*f.Max))
+		}
+	}
+
+	dimensiones, err := b.Dimensiones.Consulta()
+	if err != nil {
+		return nil, err
+	}
+	for clave, valores := range dimensiones {
+		consulta[clave] = valores
+	}
+	return consulta, nil
+}
+
+// consultaQuery copia de la query de GET /buscar los parámetros que entiende
+// catalogo-productos
+func consultaQuery(query url.Values) url.Values {
+	consulta := url.Values{}
+	for clave, valores := range query {
+		if slices.Contains(parametrosProductos, clave) || strings.HasPrefix(clave, "atributo.") || strings.HasPrefix(clave, "dimensiones.") {
+			consulta[clave] = valores
+		}
+	}
+	return consulta
+}
+
+// listarProductos consulta GET /api/v1/productos en catalogo-productos
+// (PRODUCTOS_ENDPOINT, por defecto http://localhost:8081) y reenvía la
+// respuesta, con su estado, tal como llega. Los errores de validación de los
+// filtros los responde catalogo-productos
+func listarProductos(c *gin.Context, consulta url.Values) {
+	resp, err := pedirProductos(c.Request.Context(), consulta, c.GetHeader("Accept-Language"))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, dominio.NuevoError(http.StatusBadGateway, err.Error()))
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, cabecera := range []string{"Content-Language", "Vary"} {
+		if v := resp.Header.Get(cabecera); v != "" {
+			c.Header(cabecera, v)
+		}
+	}
+	c.Status(resp.StatusCode)
+	c.Header("Content-Type", resp.Header.Get("Content-Type"))
+	if _, err := io.Copy(c.Writer, resp.Body); err != nil {
+		c.Error(err)
+	}
+}
+
+func pedirProductos(ctx context.Context, consulta url.Values, idioma string) (*http.Response, error) {
+	base := os.Getenv("PRODUCTOS_ENDPOINT")
+	if base == "" {
+		base = "http://localhost:8081"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimRight(base, "/")+"/api/v1/productos?"+consulta.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if idioma != "" {
+		req.Header.Set("Accept-Language", idioma)
+	}
+	resp, err := clienteProductos.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("catalogo-productos no disponible: %v", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	dominio "catalogo-dominio"
+)
+
+// TestConcurrenciaMateriales lanza lecturas y escrituras simultáneas contra
+// el servicio. Con go test -race detecta accesos sin sincronizar al
+// repositorio en memoria; además comprueba que no se pierde ninguna
+// actualización ni ningún cambio de stock que el servicio dio por bueno
+func TestConcurrenciaMateriales(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+
+	const compartidos, trabajadores, vueltas = 4, 8, 20
+	var materiales [compartidos]Material
+	var escritos [compartidos]atomic.Int64
+	for i := range materiales {
+		materiales[i] = crearMaterialPrueba(t, srv, fmt.Sprintf("Compartido %d", i))
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < trabajadores; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := 0; v < vueltas; v++ {
+				i := (w + v) % compartidos
+				compartido := materiales[i]
+
+				if estado, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/materiales?page_size=100&sort=-stock", nil); estado != http.StatusOK {
+					t.Errorf("listar: %d %s", estado, cuerpo)
+					return
+				}
+				if estado, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/materiales/tipo/filamento", nil); estado != http.StatusOK {
+					t.Errorf("listar por tipo: %d %s", estado, cuerpo)
+					return
+				}
+				estado, cabecera, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/materiales/"+compartido.ID, nil)
+				if estado != http.StatusOK {
+					t.Errorf("leer: %d %s", estado, cuerpo)
+					return
+				}
+
+				// Solo una de las escrituras con la misma versión puede ganar;
+				// se alternan la actualización completa y la de stock
+				if v%2 == 0 {
+					cambio := materialPrueba(fmt.Sprintf("Compartido %d-%d-%d", i, w, v))
+					estado, _, cuerpo = peticion(t, srv, http.MethodPut, "/api/v1/materiales/"+compartido.ID, cambio, "If-Match", cabecera.Get("ETag"))
+				} else {
+					stock := map[string]any{"stock": w*vueltas + v}
+					estado, _, cuerpo = peticion(t, srv, http.MethodPut, "/api/v1/materiales/"+compartido.ID+"/stock", stock, "If-Match", cabecera.Get("ETag"))
+				}
+				switch estado {
+				case http.StatusOK:
+					escritos[i].Add(1)
+				case http.StatusPreconditionFailed:
+				default:
+					t.Errorf("actualizar: %d %s", estado, cuerpo)
+					return
+				}
+
+				estado, _, cuerpo = peticion(t, srv, http.MethodPost, "/api/v1/materiales", materialPrueba(fmt.Sprintf("Propio %d-%d", w, v)))
+				if estado != http.StatusCreated {
+					t.Errorf("crear: %d %s", estado, cuerpo)
+					return
+				}
+				propio := datos[Material](t, cuerpo)
+				if estado, _, cuerpo := peticion(t, srv, http.MethodPut, "/api/v1/materiales/"+propio.ID+"/stock", map[string]any{"stock": 1}); estado != http.StatusOK {
+					t.Errorf("actualizar stock: %d %s", estado, cuerpo)
+					return
+				}
+				if estado, _, cuerpo := peticion(t, srv, http.MethodDelete, "/api/v1/materiales/"+propio.ID, nil); estado != http.StatusOK {
+					t.Errorf("eliminar: %d %s", estado, cuerpo)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	for i, m := range materiales {
+		estado, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/materiales/"+m.ID, nil)
+		if estado != http.StatusOK {
+			t.Fatalf("leer: %d %s", estado, cuerpo)
+		}
+		final := datos[Material](t, cuerpo)
+		if want := m.Version + int(escritos[i].Load()); final.Version != want {
+			t.Errorf("material %d: versión %d, se esperaba %d", i, final.Version, want)
+		}
+	}
+
+	_, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/materiales", nil)
+	if total := leerPagina(t, cuerpo).Meta.Total; total != compartidos {
+		t.Errorf("quedaron %d materiales, se esperaban %d", total, compartidos)
+	}
+	_, _, cuerpo = peticion(t, srv, http.MethodGet, "/api/v1/materiales/tipo/filamento", nil)
+	if n := len(datos[[]Material](t, cuerpo)); n != compartidos {
+		t.Errorf("el índice por tipo tiene %d filamentos, se esperaban %d", n, compartidos)
+	}
+	_, _, cuerpo = peticion(t, srv, http.MethodGet, "/api/v1/materiales/papelera", nil)
+	if total := leerPagina(t, cuerpo).Meta.Total; total != trabajadores*vueltas {
+		t.Errorf("hay %d materiales en la papelera, se esperaban %d", total, trabajadores*vueltas)
+	}
+}
+
+func leerPagina(t testing.TB, cuerpo []byte) dominio.Pagina {
+	t.Helper()
+	var p dominio.Pagina
+	if err := json.Unmarshal(cuerpo, &p); err != nil {
+		t.Fatalf("página inválida %s: %v", cuerpo, err)
+	}
+	return p
+}
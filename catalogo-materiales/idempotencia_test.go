@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"catalogo-comun/idempotencia"
+	dominio "catalogo-dominio"
+)
+
+// TestIdempotencia comprueba que el reintento de un alta con la misma clave
+// repite la respuesta sin crear otro material y que la clave con otro cuerpo
+// se rechaza
+func TestIdempotencia(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	total := func() int64 {
+		estado, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/materiales", nil)
+		if estado != http.StatusOK {
+			t.Fatalf("listar materiales: %d %s", estado, cuerpo)
+		}
+		return leerPagina(t, cuerpo).Meta.Total
+	}
+	inicial := total()
+
+	estado, cabeceras, original := peticion(t, srv, http.MethodPost, "/api/v1/materiales", materialPrueba("PETG Negro"), idempotencia.Cabecera, "clave")
+	if estado != http.StatusCreated {
+		t.Fatalf("primera petición: %d %s", estado, original)
+	}
+	estado, repetida, respuesta := peticion(t, srv, http.MethodPost, "/api/v1/materiales", materialPrueba("PETG Negro"), idempotencia.Cabecera, "clave")
+	if estado != http.StatusCreated || repetida.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("reintento: %d, Idempotent-Replayed %q", estado, repetida.Get("Idempotent-Replayed"))
+	}
+	if !bytes.Equal(respuesta, original) || repetida.Get("ETag") != cabeceras.Get("ETag") {
+		t.Errorf("el reintento respondió %s con ETag %q, se esperaba %s con %q", respuesta, repetida.Get("ETag"), original, cabeceras.Get("ETag"))
+	}
+
+	estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/materiales", materialPrueba("PETG Blanco"), idempotencia.Cabecera, "clave")
+	var e dominio.RespuestaError
+	if err := json.Unmarshal(cuerpo, &e); err != nil {
+		t.Fatalf("error inválido %s: %v", cuerpo, err)
+	}
+	if estado != http.StatusUnprocessableEntity || e.Codigo != dominio.CodigoIdempotenciaReutilizada {
+		t.Errorf("la clave con otro cuerpo: %d %s, se esperaba 422", estado, cuerpo)
+	}
+
+	if n := total(); n != inicial+1 {
+		t.Errorf("hay %d materiales, se esperaban %d", n, inicial+1)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"catalogo-comun/condicional"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrTraduccionNoEncontrada se devuelve cuando el material no tiene
+// traducción al idioma solicitado
+var ErrTraduccionNoEncontrada = errors.New("traducción no encontrada")
+
+// claveIdioma guarda en el contexto de gin el idioma negociado
+const claveIdioma = "idioma"
+
+// idiomaPredeterminado es el idioma de Nombre y el que se usa cuando
+// Accept-Language no pide ninguno admitido. Se configura con
+// IDIOMA_PREDETERMINADO; por defecto es
+func idiomaPredeterminado() string {
+	if idioma := strings.ToLower(strings.TrimSpace(os.Getenv("IDIOMA_PREDETERMINADO"))); idioma != "" {
+		return idioma
+	}
+	return "es"
+}
+
+// idiomasAdmitidos son los idiomas a los que se puede traducir el nombre,
+// configurados con IDIOMAS (por defecto es,en). Siempre incluye el
+// predeterminado
+func idiomasAdmitidos() []string {
+	valor := os.Getenv("IDIOMAS")
+	if strings.TrimSpace(valor) == "" {
+		valor = "es,en"
+	}
+	idiomas := []string{idiomaPredeterminado()}
+	for _, idioma := range strings.Split(valor, ",") {
+		idioma = strings.ToLower(strings.TrimSpace(idioma))
+		if idioma != "" && !slices.Contains(idiomas, idioma) {
+			idiomas = append(idiomas, idioma)
+		}
+	}
+	return idiomas
+}
+
+// elegirIdioma devuelve el idioma admitido preferido según una cabecera
+// Accept-Language. Una variante regional equivale a su idioma base; sin
+// coincidencias se usa el predeterminado
+func elegirIdioma(cabecera string) string {
+	type preferencia struct {
+		idioma string
+		peso   float64
+	}
+	var preferencias []preferencia
+	for _, parte := range strings.Split(cabecera, ",") {
+		etiqueta, params, _ := strings.Cut(parte, ";")
+		p := preferencia{idioma: strings.ToLower(strings.TrimSpace(etiqueta)), peso: 1}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			peso, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			p.peso = peso
+		}
+		if p.idioma != "" && p.peso > 0 {
+			preferencias = append(preferencias, p)
+		}
+	}
+	slices.SortStableFunc(preferencias, func(a, b preferencia) int { return cmp.Compare(b.peso, a.peso) })
+
+	admitidos := idiomasAdmitidos()
+	for _, p := range preferencias {
+		if p.idioma == "*" {
+			break
+		}
+		base, _, _ := strings.Cut(p.idioma, "-")
+		for _, candidato := range []string{p.idioma, base} {
+			if slices.Contains(admitidos, candidato) {
+				return candidato
+			}
+		}
+	}
+	return idiomaPredeterminado()
+}
+
+// negociarIdioma elige el idioma de la respuesta a partir de Accept-Language
+// y lo indica en Content-Language
+func negociarIdioma(c *gin.Context) {
+	idioma := elegirIdioma(c.GetHeader("Accept-Language"))
+	c.Set(claveIdioma, idioma)
+	c.Header("Content-Language", idioma)
+	c.Header("Vary", "Accept-Language")
+	c.Next()
+}
+
+func idiomaDe(c *gin.Context) string {
+	if idioma := c.GetString(claveIdioma); idioma != "" {
+		return idioma
+	}
+	return idiomaPredeterminado()
+}
+
+// traducir devuelve un mensaje de la API en el idioma de la petición. Los
+// mensajes se escriben en español; los que no están en el catálogo se
+// devuelven tal cual
+func traducir(c *gin.Context, mensaje string) string {
+	if traduccion, ok := mensajes[idiomaDe(c)][mensaje]; ok {
+		return traduccion
+	}
+	return mensaje
+}
+
+// mensajes es el catálogo de mensajes de la API por idioma
+var mensajes = map[string]map[string]string{
+	"en": {
+		"Material no encontrado":                                              "Material not found",
+		"Idempotency-Key admite como máximo 255 caracteres":                   "Idempotency-Key allows at most 255 characters",
+		"Hay otra petición en curso con la misma Idempotency-Key":             "Another request with the same Idempotency-Key is in progress",
+		"La Idempotency-Key ya se usó con otra petición":                      "The Idempotency-Key was already used with a different request",
+		"El lote debe tener entre 1 y 200 operaciones":                        "A batch must have between 1 and 200 operations",
+		"No se aplicó ninguna operación del lote porque una falló":            "No operation in the batch was applied because one failed",
+		"No se aplicó porque falló otra operación del lote":                   "Not applied because another operation in the batch failed",
+		"op debe ser crear, actualizar o eliminar":                            "op must be crear, actualizar or eliminar",
+		"recurso debe ser materiales":                                         "recurso must be materiales",
+		"ref solo se admite al crear y debe ser única y alfanumérica":         "ref is only allowed when creating and must be unique and alphanumeric",
+		"id es obligatorio al actualizar o eliminar":                          "id is required to update or delete",
+		"Material eliminado":                                                  "Material deleted",
+		"El material fue modificado por otro usuario":                         "The material was modified by another user",
+		"El id del material no se puede modificar":                            "The material id cannot be changed",
+		"Error al serializar la respuesta":                                    "Error serializing the response",
+		"Error interno del servidor":                                          "Internal server error",
+		"Revisión no encontrada":                                              "Revision not found",
+		"rev debe ser un número de revisión":                                  "rev must be a revision number",
+		"Tasa de cambio no encontrada":                                        "Exchange rate not found",
+		"Tasa de cambio eliminada":                                            "Exchange rate deleted",
+		"moneda debe ser un código ISO 4217 admitido":                         "moneda must be a supported ISO 4217 code",
+		"No se puede convertir el precio":                                     "Cannot convert the price",
+		"Traducción no encontrada":                                            "Translation not found",
+		"El idioma predeterminado no se puede eliminar":                       "The default language cannot be deleted",
+		"nombre es obligatorio":                                               "nombre is required",
+		"stock no puede ser negativo":                                         "stock cannot be negative",
+		"viscosidad, tiempo_cura y tolerancia solo se aplican a resinas":      "viscosidad, tiempo_cura and tolerancia only apply to resins",
+		"diametro_filamento y densidad solo se aplican a filamentos":          "diametro_filamento and densidad only apply to filaments",
+		"precio_por_unidad.importe no puede ser negativo":                     "precio_por_unidad.importe cannot be negative",
+		"Ya existe una tasa para esas monedas con la misma fecha de vigencia": "An exchange rate for those currencies with the same effective date already exists",
+	},
+}
+
+// Traduccion es el nombre de un material en un idioma distinto del
+// predeterminado
+type Traduccion struct {
+	Nombre string `json:"nombre"`
+}
+
+// Traducciones guarda las traducciones por código de idioma
+type Traducciones map[string]Traduccion
+
+// validarTraducciones comprueba que cada traducción sea de un idioma
+// admitido distinto del predeterminado
+func validarTraducciones(m Material) error {
+	admitidos := idiomasAdmitidos()
+	for _, idioma := range slices.Sorted(maps.Keys(m.Traducciones)) {
+		if idioma == idiomaPredeterminado() || !slices.Contains(admitidos, idioma) {
+			return errors.New("traducciones: idioma no admitido: " + idioma)
+		}
+	}
+	return nil
+}
+
+// conTraduccion devuelve una copia de las traducciones con la del idioma
+// cambiada o, si traduccion es nil, eliminada
+func (t Traducciones) conTraduccion(idioma string, traduccion *Traduccion) Traducciones {
+	copia := maps.Clone(t)
+	if copia == nil {
+		copia = Traducciones{}
+	}
+	if traduccion == nil {
+		delete(copia, idioma)
+	} else {
+		copia[idioma] = *traduccion
+	}
+	return copia
+}
+
+// localizarMateriales sustituye el nombre de los materiales por su
+// traducción al idioma de la petición, si la hay
+func localizarMateriales(c *gin.Context, materiales []Material) {
+	idioma := idiomaDe(c)
+	for i := range materiales {
+		if t := materiales[i].Traducciones[idioma]; t.Nombre != "" {
+			materiales[i].Nombre = t.Nombre
+		}
+	}
+}
+
+// getMaterialTranslations devuelve el nombre del material en cada idioma,
+// incluido el predeterminado
+func getMaterialTranslations(c *gin.Context) {
+	m, err := repo.Obtener(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+
+	todas := m.Traducciones.conTraduccion(idiomaPredeterminado(), &Traduccion{Nombre: m.Nombre})
+	c.Header("ETag", condicional.ETag(m.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": todas,
+	})
+}
+
+// putMaterialTranslation guarda el nombre del material en un idioma; en el
+// predeterminado cambia el propio nombre. Admite If-Match como PUT
+func putMaterialTranslation(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+	var traduccion Traduccion
+	if err := c.ShouldBindJSON(&traduccion); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	m, err := repo.Obtener(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	if idioma := strings.ToLower(c.Param("idioma")); idioma == idiomaPredeterminado() {
+		m.Nombre = traduccion.Nombre
+	} else {
+		m.Traducciones = m.Traducciones.conTraduccion(idioma, &traduccion)
+	}
+	guardarTraducciones(c, &m, version)
+}
+
+// deleteMaterialTranslation elimina la traducción a un idioma
+func deleteMaterialTranslation(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+	idioma := strings.ToLower(c.Param("idioma"))
+	if idioma == idiomaPredeterminado() {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "El idioma predeterminado no se puede eliminar"))
+		return
+	}
+
+	m, err := repo.Obtener(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	if _, ok := m.Traducciones[idioma]; !ok {
+		responderError(c, ErrTraduccionNoEncontrada)
+		return
+	}
+	m.Traducciones = m.Traducciones.conTraduccion(idioma, nil)
+	guardarTraducciones(c, &m, version)
+}
+
+func guardarTraducciones(c *gin.Context, m *Material, version int) {
+	if err := validarMaterial(*m); err != nil {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, err.Error()))
+		return
+	}
+	if version == 0 {
+		version = m.Version
+	}
+	if err := repo.Actualizar(c.Request.Context(), m, version, actorDe(c)); err != nil {
+		responderError(c, err)
+		return
+	}
+	c.Header("ETag", condicional.ETag(m.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": m,
+	})
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTraducirErrorMoneda(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	estado, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/materiales?moneda=XXX", nil, "Accept-Language", "en")
+	var r struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(cuerpo, &r); err != nil || estado != http.StatusBadRequest || !strings.HasPrefix(r.Error, "moneda must be a supported ISO 4217 code: ") {
+		t.Errorf("moneda desconocida en inglés: %d %s", estado, cuerpo)
+	}
+}
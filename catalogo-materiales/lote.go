@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"catalogo-comun/parche"
+	dominio "catalogo-dominio"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxOperacionesLote limita las operaciones de un lote
+const maxOperacionesLote = 200
+
+// Errores de una operación mal formada
+var (
+	errOperacionLote = errors.New("op debe ser crear, actualizar o eliminar")
+	errRecursoLote   = errors.New("recurso debe ser materiales")
+	errRefLote       = errors.New("ref solo se admite al crear y debe ser única y alfanumérica")
+	errIDLote        = errors.New("id es obligatorio al actualizar o eliminar")
+)
+
+var patronRefLote = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// errorDatosLote es un error en los datos de una operación; se responde con
+// 400, como en las escrituras por separado
+type errorDatosLote struct {
+	err error
+}
+
+func (e errorDatosLote) Error() string {
+	return e.err.Error()
+}
+
+func (e errorDatosLote) Unwrap() error {
+	return e.err
+}
+
+// peticionLote es el cuerpo de POST /batch
+type peticionLote struct {
+	Operaciones []operacionPeticion `json:"operaciones"`
+}
+
+// operacionPeticion es una operación de un lote tal como la envía el
+// cliente. Datos es el material completo al crear y un JSON Merge Patch al
+// actualizar. Ref da nombre al material creado para que las operaciones
+// posteriores lo usen como "$ref" en id o en cualquier valor de datos
+type operacionPeticion struct {
+	Op      TipoOperacion   `json:"op"`
+	Recurso string          `json:"recurso"`
+	Ref     string          `json:"ref"`
+	ID      string          `json:"id"`
+	Version int             `json:"version"`
+	Datos   json.RawMessage `json:"datos"`
+}
+
+// ResultadoOperacion es el resultado de una operación de un lote. Estado es
+// el código HTTP que habría tenido la operación sola; las que no se aplicaron
+// porque falló otra tienen 424
+type ResultadoOperacion struct {
+	Indice  int            `json:"indice"`
+	Op      TipoOperacion  `json:"op"`
+	Recurso string         `json:"recurso"`
+	Ref     string         `json:"ref,omitempty"`
+	ID      string         `json:"id,omitempty"`
+	Estado  int            `json:"estado"`
+	Data    *Material      `json:"data,omitempty"`
+	Error   string         `json:"error,omitempty"`
+	Codigo  dominio.Codigo `json:"codigo,omitempty"`
+}
+
+// InformeLote resume un lote: si se aplicó y el resultado de cada operación
+type InformeLote struct {
+	Aplicado   bool                 `json:"aplicado"`
+	Resultados []ResultadoOperacion `json:"resultados"`
+}
+
+// preparacionLote es el estado de los materiales tal como lo dejan las
+// operaciones ya preparadas del lote, antes de escribir nada
+type preparacionLote struct {
+	ctx context.Context
+	// refs traduce cada ref al ID del material creado
+	refs map[string]string
+	// materiales guarda los materiales creados o modificados por el lote y
+	// eliminados los que borra
+	materiales map[string]Material
+	eliminados map[string]bool
+}
+
+// postLote aplica en orden una lista de operaciones sobre materiales, todas o
+// ninguna. Si una falla, la respuesta lleva su estado y su error y el resto
+// de operaciones, 424
+func postLote(c *gin.Context) {
+	var peticion peticionLote
+	if err := c.ShouldBindJSON(&peticion); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(peticion.Operaciones) == 0 || len(peticion.Operaciones) > maxOperacionesLote {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "El lote debe tener entre 1 y 200 operaciones"))
+		return
+	}
+
+	prep := &preparacionLote{
+		ctx:        c.Request.Context(),
+		refs:       make(map[string]string),
+		materiales: make(map[string]Material),
+		eliminados: make(map[string]bool),
+	}
+	informe := InformeLote{Resultados: make([]ResultadoOperacion, len(peticion.Operaciones))}
+	for i, op := range peticion.Operaciones {
+		informe.Resultados[i] = ResultadoOperacion{Indice: i, Op: op.Op, Recurso: op.Recurso, Ref: op.Ref}
+	}
+	ops := make([]OperacionLote, len(peticion.Operaciones))
+	for i, op := range peticion.Operaciones {
+		var err error
+		if ops[i], err = prep.preparar(op); err != nil {
+			responderFalloLote(c, informe, i, err)
+			return
+		}
+	}
+
+	if err := repo.AplicarLote(c.Request.Context(), ops, actorDe(c)); err != nil {
+		var errOp ErrorOperacion
+		if errors.As(err, &errOp) {
+			responderFalloLote(c, informe, errOp.Indice, errOp.Err)
+			return
+		}
+		responderError(c, err)
+		return
+	}
+
+	informe.Aplicado = true
+	for i, op := range ops {
+		res := &informe.Resultados[i]
+		res.ID = op.ID
+		res.Estado = http.StatusOK
+		if op.Tipo == OperacionCrear {
+			res.Estado = http.StatusCreated
+		}
+		res.Data = op.Material
+	}
+	log.Printf("Lote: %d operaciones aplicadas", len(ops))
+	c.JSON(http.StatusOK, gin.H{
+		"data": informe,
+	})
+}
+
+// responderFalloLote responde a un lote que no se aplicó porque falló la
+// operación indice. La respuesta lleva el estado de esa operación
+func responderFalloLote(c *gin.Context, informe InformeLote, indice int, err error) {
+	for i := range informe.Resultados {
+		informe.Resultados[i].Estado = http.StatusFailedDependency
+		informe.Resultados[i].Error = traducir(c, "No se aplicó porque falló otra operación del lote")
+		informe.Resultados[i].Codigo = dominio.CodigoDependenciaFallida
+	}
+	var estado int
+	var cuerpo dominio.RespuestaError
+	var errDatos errorDatosLote
+	switch {
+	case errors.As(err, &errDatos), errors.Is(err, errOperacionLote), errors.Is(err, errRecursoLote),
+		errors.Is(err, errRefLote), errors.Is(err, errIDLote):
+		estado, cuerpo = respuestaMensaje(http.StatusBadRequest, traducir(c, err.Error()))
+	default:
+		estado, cuerpo = respuestaError(c, err)
+	}
+	res := &informe.Resultados[indice]
+	res.Estado = estado
+	res.Error, res.Codigo = cuerpo.Error, cuerpo.Codigo
+	c.JSON(estado, gin.H{
+		"error":  traducir(c, "No se aplicó ninguna operación del lote porque una falló"),
+		"codigo": cuerpo.Codigo,
+		"data":   informe,
+	})
+}
+
+// preparar valida una operación frente al estado que dejan las anteriores y
+// la convierte en la escritura que se pasará al almacén
+func (p *preparacionLote) preparar(op operacionPeticion) (OperacionLote, error) {
+	if !slices.Contains([]TipoOperacion{OperacionCrear, OperacionActualizar, OperacionEliminar}, op.Op) {
+		return OperacionLote{}, errOperacionLote
+	}
+	if op.Recurso != "materiales" {
+		return OperacionLote{}, errRecursoLote
+	}
+	if op.Ref != "" {
+		if _, repetida := p.refs[op.Ref]; repetida || op.Op != OperacionCrear || !patronRefLote.MatchString(op.Ref) {
+			return OperacionLote{}, errRefLote
+		}
+	}
+	if op.Op != OperacionCrear {
+		op.ID = p.resolverRef(op.ID)
+		if op.ID == "" {
+			return OperacionLote{}, errIDLote
+		}
+	}
+	datos, err := p.resolverRefs(op.Datos)
+	if err != nil {
+		return OperacionLote{}, err
+	}
+
+	res := OperacionLote{Tipo: op.Op, ID: op.ID, Version: op.Version}
+	if op.Op == OperacionCrear {
+		var material Material
+		if err := json.Unmarshal(datos, &material); err != nil {
+			return OperacionLote{}, errorDatosLote{err}
+		}
+		material.ID = uuid.New().String()
+		completarMoneda(&material)
+		if err := validarMaterial(material); err != nil {
+			return OperacionLote{}, errorDatosLote{err}
+		}
+		res.ID = material.ID
+		res.Material = &material
+		creado := material
+		creado.Version = 1
+		p.materiales[material.ID] = creado
+		if op.Ref != "" {
+			p.refs[op.Ref] = material.ID
+		}
+		return res, nil
+	}
+
+	actual, err := p.material(op.ID)
+	if err != nil {
+		return OperacionLote{}, err
+	}
+	if op.Version != 0 && op.Version != actual.Version {
+		return OperacionLote{}, ErrConflictoVersion
+	}
+	// El almacén comprueba la versión que se leyó, o la que deja la
+	// operación anterior del lote sobre el mismo material
+	res.Version = actual.Version
+	if op.Op == OperacionEliminar {
+		delete(p.materiales, op.ID)
+		p.eliminados[op.ID] = true
+		return res, nil
+	}
+
+	var material Material
+	if err := parche.Fusionar(actual, datos, &material); err != nil {
+		return OperacionLote{}, errorDatosLote{err}
+	}
+	if material.ID != actual.ID {
+		return OperacionLote{}, errorDatosLote{errors.New("El id del material no se puede modificar")}
+	}
+	completarMoneda(&material)
+	if err := validarMaterial(material); err != nil {
+		return OperacionLote{}, errorDatosLote{err}
+	}
+	res.Material = &material
+	siguiente := material
+	siguiente.Version = actual.Version + 1
+	p.materiales[material.ID] = siguiente
+	return res, nil
+}
+
+// material devuelve un material tal como lo dejan las operaciones anteriores
+func (p *preparacionLote) material(id string) (Material, error) {
+	if p.eliminados[id] {
+		return Material{}, ErrMaterialNoEncontrado
+	}
+	if m, ok := p.materiales[id]; ok {
+		return m, nil
+	}
+	return repo.Obtener(p.ctx, id)
+}
+
+// resolverRef devuelve el ID creado con la ref si s es "$ref" y la ref ya
+// se definió en el lote; si no, devuelve s
+func (p *preparacionLote) resolverRef(s string) string {
+	if ref, ok := strings.CutPrefix(s, "$"); ok {
+		if id, ok := p.refs[ref]; ok {
+			return id
+		}
+	}
+	return s
+}
+
+// resolverRefs sustituye en datos las cadenas "$ref" por sus IDs. Los números
+// se conservan tal como vienen
+func (p *preparacionLote) resolverRefs(datos json.RawMessage) ([]byte, error) {
+	if len(datos) == 0 {
+		return []byte("{}"), nil
+	}
+	if len(p.refs) == 0 {
+		return datos, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(datos))
+	dec.UseNumber()
+	var valor any
+	if err := dec.Decode(&valor); err != nil {
+		return nil, errorDatosLote{err}
+	}
+	var resolver func(v any) any
+	resolver = func(v any) any {
+		switch v := v.(type) {
+		case string:
+			return p.resolverRef(v)
+		case []any:
+			for i := range v {
+				v[i] = resolver(v[i])
+			}
+		case map[string]any:
+			for k := range v {
+				v[k] = resolver(v[k])
+			}
+		}
+		return v
+	}
+	return json.Marshal(resolver(valor))
+}
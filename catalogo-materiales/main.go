@@ -1,13 +1,30 @@
 package main
 
 import (
+	"cmp"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"catalogo-comun/basedatos"
+	"catalogo-comun/condicional"
+	"catalogo-comun/idempotencia"
+	"catalogo-comun/listado"
+	"catalogo-comun/papelera"
+	"catalogo-comun/parche"
+	dominio "catalogo-dominio"
+	"catalogo-dominio/dinero"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/swaggo/files"
+	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
 )
 
 // @title API de Catálogo de Materiales
@@ -64,21 +81,64 @@ type Material struct {
 	Fabricante      string                  `json:"fabricante"`
 	Disponible      bool                    `json:"disponible"`
 	Stock           float64                 `json:"stock"` // En metros para filamentos, en ml para resinas
-	PrecioPorUnidad float64                 `json:"precio_por_unidad"`
+	PrecioPorUnidad dinero.Dinero           `json:"precio_por_unidad"`
 	Caracteristicas CaracteristicasMaterial `json:"caracteristicas"`
+	Version         int                     `json:"version"`
+	// EliminadoEn solo se informa en los materiales de la papelera
+	EliminadoEn *time.Time `json:"eliminado_en,omitempty"`
+	// Traducciones guarda el nombre en otros idiomas; Nombre está en el
+	// idioma predeterminado
+	Traducciones Traducciones `json:"traducciones"`
 }
 
-var materiales = []Material{}
+var repo RepositorioMateriales
+
+// ordenablesMateriales son los campos por los que se puede ordenar el listado de materiales
+var ordenablesMateriales = map[string]func(Material) any{
+	"id":                func(m Material) any { return m.ID },
+	"nombre":            func(m Material) any { return m.Nombre },
+	"tipo":              func(m Material) any { return string(m.Tipo) },
+	"fabricante":        func(m Material) any { return m.Fabricante },
+	"stock":             func(m Material) any { return m.Stock },
+	"precio_por_unidad": func(m Material) any { return m.PrecioPorUnidad.Importe.Float64() },
+}
+
+// camposMateriales son los campos que se pueden pedir con ?fields=
+var camposMateriales = map[string]bool{
+	"id":                true,
+	"nombre":            true,
+	"tipo":              true,
+	"fabricante":        true,
+	"disponible":        true,
+	"stock":             true,
+	"precio_por_unidad": true,
+	"caracteristicas":   true,
+	"version":           true,
+	"eliminado_en":      true,
+	"traducciones":      true,
+}
 
 func main() {
 	if err := setup(); err != nil {
 		log.Fatalf("Error al configurar: %v", err)
 	}
 
+	r := nuevoRouter()
+
+	papelera.IniciarPurga(repo.Purgar)
+	idempotencia.IniciarPurga(repo)
+
+	log.Printf("Iniciando servicio de materiales en :8082")
+	r.Run(":8082")
+}
+
+// nuevoRouter registra las rutas del servicio sobre el repositorio ya
+// configurado en repo
+func nuevoRouter() *gin.Engine {
 	r := gin.Default()
 
 	// Documentación Swagger
-	r.GET("/swagger/*any", ginSwagger.WrapHandler(files.Handler))
+	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
@@ -90,32 +150,72 @@ func main() {
 	})
 
 	// Rutas API
-	api := r.Group("/api/v1")
+	api := r.Group("/api/v1", negociarIdioma, idempotencia.Middleware(repo, traducir))
 	{
 		api.GET("/materiales", getMaterials)
+		api.GET("/materiales/papelera", getMaterialsPapelera)
 		api.GET("/materiales/:id", getMaterial)
 		api.GET("/materiales/tipo/:tipo", getMaterialsByType)
 		api.POST("/materiales", createMaterial)
 		api.PUT("/materiales/:id", updateMaterial)
+		api.PATCH("/materiales/:id", patchMaterial)
 		api.DELETE("/materiales/:id", deleteMaterial)
+		api.POST("/materiales/:id/restaurar", restoreMaterial)
 		api.PUT("/materiales/:id/stock", updateStock)
+		api.GET("/materiales/:id/revisiones", getMaterialRevisions)
+		api.GET("/materiales/:id/revisiones/diff", getMaterialRevisionsDiff)
+		api.GET("/materiales/:id/revisiones/:rev", getMaterialRevision)
+		api.POST("/materiales/:id/revisiones/:rev/revertir", revertMaterialRevision)
+		api.GET("/materiales/:id/traducciones", getMaterialTranslations)
+		api.PUT("/materiales/:id/traducciones/:idioma", putMaterialTranslation)
+		api.DELETE("/materiales/:id/traducciones/:idioma", deleteMaterialTranslation)
+		api.GET("/tasas-cambio", getTasasCambio)
+		api.POST("/tasas-cambio", createTasaCambio)
+		api.DELETE("/tasas-cambio/:id", deleteTasaCambio)
+
+		api.POST("/batch", postLote)
 	}
 
-	log.Printf("Iniciando servicio de materiales en :8082")
-	r.Run(":8082")
+	return r
 }
 
 func setup() error {
-	// Inicializar materiales de ejemplo
-	materiales = []Material{
+	// REPOSITORIO=memoria permite levantar el servicio sin base de datos
+	if os.Getenv("REPOSITORIO") == "memoria" {
+		log.Printf("Usando repositorio en memoria, los datos no se conservarán")
+		repo = newRepositorioMemoria(materialesEjemplo()...)
+		return nil
+	}
+
+	db, err := gorm.Open(postgres.Open(basedatos.DSN("materiales")), &gorm.Config{TranslateError: true})
+	if err != nil {
+		return err
+	}
+
+	if err := migrar(db); err != nil {
+		return err
+	}
+
+	repo = newRepositorioPostgres(db)
+	return nil
+}
+
+// materialesEjemplo son los materiales con los que arranca un catálogo vacío
+func materialesEjemplo() []Material {
+	precioUSD := func(importe string) dinero.Dinero {
+		d, _ := dinero.Nuevo(importe, "USD")
+		return d
+	}
+
+	return []Material{
 		{
 			ID:              "m001",
 			Nombre:          "PLA Premium",
 			Tipo:            TipoFilamento,
 			Fabricante:      "XYZ Filaments",
 			Disponible:      true,
-			Stock:           1000.0, // metros
-			PrecioPorUnidad: 25.99,  // por kilogramo
+			Stock:           1000.0,             // metros
+			PrecioPorUnidad: precioUSD("25.99"), // por kilogramo
 			Caracteristicas: CaracteristicasMaterial{
 				Color:                 "Natural",
 				TemperaturaImpresion:  200,
@@ -131,8 +231,8 @@ func setup() error {
 			Tipo:            TipoResina,
 			Fabricante:      "UV Resins",
 			Disponible:      true,
-			Stock:           5000.0, // ml
-			PrecioPorUnidad: 45.99,  // por litro
+			Stock:           5000.0,             // ml
+			PrecioPorUnidad: precioUSD("45.99"), // por litro
 			Caracteristicas: CaracteristicasMaterial{
 				Color:                 "Transparente",
 				TemperaturaImpresion:  25,
@@ -145,106 +245,276 @@ func setup() error {
 			},
 		},
 	}
-	return nil
 }
 
 func getMaterials(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"data": materiales,
-	})
+	params, err := listado.Parse(c, ordenablesMateriales, camposMateriales)
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	materiales, res, err := repo.Listar(c.Request.Context(), params.Orden, params.Ventana)
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	if !convertirPrecios(c, materiales) {
+		return
+	}
+	localizarMateriales(c, materiales)
+	responderPagina(c, materiales, res, params.Ventana, params.Campos)
 }
 
 func getMaterial(c *gin.Context) {
-	id := c.Param("id")
-	for _, m := range materiales {
-		if m.ID == id {
-			c.JSON(http.StatusOK, gin.H{
-				"data": m,
-			})
-			return
-		}
+	m, err := repo.Obtener(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	lista := []Material{m}
+	if !convertirPrecios(c, lista) {
+		return
 	}
-	c.JSON(http.StatusNotFound, gin.H{"error": "Material no encontrado"})
+	localizarMateriales(c, lista)
+	c.Header("ETag", condicional.ETag(m.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": lista[0],
+	})
 }
 
 func getMaterialsByType(c *gin.Context) {
-	tipo := c.Param("tipo")
-	var filtered []Material
-	for _, m := range materiales {
-		if string(m.Tipo) == tipo {
-			filtered = append(filtered, m)
-		}
+	materiales, err := repo.ListarPorTipo(c.Request.Context(), TipoMaterial(c.Param("tipo")))
+	if err != nil {
+		responderError(c, err)
+		return
 	}
+	if !convertirPrecios(c, materiales) {
+		return
+	}
+	localizarMateriales(c, materiales)
 	c.JSON(http.StatusOK, gin.H{
-		"data": filtered,
+		"data": materiales,
 	})
 }
 
 func createMaterial(c *gin.Context) {
 	var material Material
 	if err := c.ShouldBindJSON(&material); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		responderMensaje(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	material.ID = uuid.New().String()
-	materiales = append(materiales, material)
+	completarMoneda(&material)
+	if err := validarMaterial(material); err != nil {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, err.Error()))
+		return
+	}
+	if err := repo.Crear(c.Request.Context(), &material, actorDe(c)); err != nil {
+		responderError(c, err)
+		return
+	}
 
+	c.Header("ETag", condicional.ETag(material.Version))
 	c.JSON(http.StatusCreated, gin.H{
 		"data": material,
 	})
 }
 
 func updateMaterial(c *gin.Context) {
-	id := c.Param("id")
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+
 	var material Material
 	if err := c.ShouldBindJSON(&material); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		responderMensaje(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	for i, m := range materiales {
-		if m.ID == id {
-			materiales[i] = material
-			c.JSON(http.StatusOK, gin.H{
-				"data": material,
-			})
-			return
+	material.ID = c.Param("id")
+	completarMoneda(&material)
+	// Un PUT sin traducciones conserva las que hubiera; {} las elimina
+	if material.Traducciones == nil {
+		if actual, err := repo.Obtener(c.Request.Context(), material.ID); err == nil {
+			material.Traducciones = actual.Traducciones
 		}
 	}
-	c.JSON(http.StatusNotFound, gin.H{"error": "Material no encontrado"})
+	if err := validarMaterial(material); err != nil {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, err.Error()))
+		return
+	}
+	if err := repo.Actualizar(c.Request.Context(), &material, version, actorDe(c)); err != nil {
+		responderError(c, err)
+		return
+	}
+	c.Header("ETag", condicional.ETag(material.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": material,
+	})
 }
 
-func deleteMaterial(c *gin.Context) {
-	id := c.Param("id")
-	for i, m := range materiales {
-		if m.ID == id {
-			materiales = append(materiales[:i], materiales[i+1:]...)
-			c.JSON(http.StatusOK, gin.H{"message": "Material eliminado"})
-			return
+// patchMaterial aplica un JSON Merge Patch (RFC 7386) o un JSON Patch
+// (RFC 6902) sobre un material y valida el resultado antes de guardarlo
+func patchMaterial(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+
+	actual, err := repo.Obtener(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	if version != 0 && actual.Version != version {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+
+	var material Material
+	if err := parche.Aplicar(c, actual, &material); err != nil {
+		responderMensaje(c, parche.Estado(err), err.Error())
+		return
+	}
+	if material.ID != actual.ID {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "El id del material no se puede modificar"))
+		return
+	}
+	completarMoneda(&material)
+	if err := validarMaterial(material); err != nil {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, err.Error()))
+		return
+	}
+
+	// Se exige la versión leída para no pisar una escritura concurrente
+	if err := repo.Actualizar(c.Request.Context(), &material, actual.Version, actorDe(c)); err != nil {
+		responderError(c, err)
+		return
+	}
+	c.Header("ETag", condicional.ETag(material.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": material,
+	})
+}
+
+// validarMaterial comprueba que un material tenga los datos mínimos para guardarse
+func validarMaterial(m Material) error {
+	switch {
+	case strings.TrimSpace(m.Nombre) == "":
+		return errors.New("nombre es obligatorio")
+	case m.Stock < 0:
+		return errors.New("stock no puede ser negativo")
+	}
+	return cmp.Or(validarCaracteristicas(m), validarPrecio(m), validarTraducciones(m))
+}
+
+// validarCaracteristicas comprueba que el material sea de un tipo conocido y
+// no traiga características de otro tipo, que no se guardarían porque cada
+// tipo tiene las suyas en su propia tabla
+func validarCaracteristicas(m Material) error {
+	c := m.Caracteristicas
+	switch m.Tipo {
+	case TipoFilamento:
+		if c.Viscosidad != 0 || c.TiempoCura != 0 || c.Tolerancia != 0 {
+			return errors.New("viscosidad, tiempo_cura y tolerancia solo se aplican a resinas")
+		}
+	case TipoResina:
+		if c.DiametroFilamento != 0 || c.Densidad != 0 {
+			return errors.New("diametro_filamento y densidad solo se aplican a filamentos")
 		}
+	default:
+		return fmt.Errorf("tipo debe ser %q o %q", TipoFilamento, TipoResina)
+	}
+	return nil
+}
+
+func deleteMaterial(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+
+	if err := repo.Eliminar(c.Request.Context(), c.Param("id"), version); err != nil {
+		responderError(c, err)
+		return
 	}
-	c.JSON(http.StatusNotFound, gin.H{"error": "Material no encontrado"})
+	c.JSON(http.StatusOK, gin.H{"message": traducir(c, "Material eliminado")})
 }
 
 func updateStock(c *gin.Context) {
-	id := c.Param("id")
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+
 	var stockUpdate struct {
 		Stock float64 `json:"stock"`
 	}
 	if err := c.ShouldBindJSON(&stockUpdate); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if stockUpdate.Stock < 0 {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "stock no puede ser negativo"))
 		return
 	}
 
-	for i, m := range materiales {
-		if m.ID == id {
-			materiales[i].Stock = stockUpdate.Stock
-			c.JSON(http.StatusOK, gin.H{
-				"data": materiales[i],
-			})
-			return
-		}
+	m, err := repo.ActualizarStock(c.Request.Context(), c.Param("id"), stockUpdate.Stock, version, actorDe(c))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	c.Header("ETag", condicional.ETag(m.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": m,
+	})
+}
+
+// responderError traduce los errores del almacén a respuestas HTTP
+func responderError(c *gin.Context, err error) {
+	c.JSON(respuestaError(c, err))
+}
+
+// responderMensaje responde un error con el código de su estado
+func responderMensaje(c *gin.Context, estado int, mensaje string) {
+	c.JSON(respuestaMensaje(estado, mensaje))
+}
+
+// responderPagina escribe el sobre {"data", "meta", "links"} de un listado paginado
+func responderPagina[T any](c *gin.Context, elementos []T, res listado.Resultado, v listado.Ventana, campos []string) {
+	if err := listado.Responder(c, elementos, res, v, campos); err != nil {
+		responderMensaje(c, http.StatusInternalServerError, traducir(c, "Error al serializar la respuesta"))
+	}
+}
+
+func respuestaMensaje(estado int, mensaje string) (int, dominio.RespuestaError) {
+	return estado, dominio.NuevoError(estado, mensaje)
+}
+
+// respuestaError devuelve el estado y el cuerpo con los que responderError
+// responde a err
+func respuestaError(c *gin.Context, err error) (int, dominio.RespuestaError) {
+	switch {
+	case errors.Is(err, ErrMaterialNoEncontrado):
+		return respuestaMensaje(http.StatusNotFound, traducir(c, "Material no encontrado"))
+	case errors.Is(err, ErrConflictoVersion):
+		return respuestaMensaje(http.StatusPreconditionFailed, traducir(c, "El material fue modificado por otro usuario"))
+	case errors.Is(err, ErrTraduccionNoEncontrada):
+		return respuestaMensaje(http.StatusNotFound, traducir(c, "Traducción no encontrada"))
+	case errors.Is(err, ErrRevisionNoEncontrada):
+		return respuestaMensaje(http.StatusNotFound, traducir(c, "Revisión no encontrada"))
+	case errors.Is(err, ErrTasaNoEncontrada):
+		return respuestaMensaje(http.StatusNotFound, traducir(c, "Tasa de cambio no encontrada"))
+	case errors.Is(err, ErrTasaDuplicada):
+		return respuestaMensaje(http.StatusConflict, traducir(c, "Ya existe una tasa para esas monedas con la misma fecha de vigencia"))
 	}
-	c.JSON(http.StatusNotFound, gin.H{"error": "Material no encontrado"})
+	log.Printf("Error de almacén: %v", err)
+	return respuestaMensaje(http.StatusInternalServerError, traducir(c, "Error interno del servidor"))
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// nuevoServidorPrueba levanta el servicio sobre un repositorio en memoria
+// vacío. repo es global, así que los tests que lo usan no corren en paralelo
+func nuevoServidorPrueba(t *testing.T) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	repo = newRepositorioMemoria()
+	srv := httptest.NewServer(nuevoRouter())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// peticion envía cuerpo como JSON, si no es nil, y devuelve el estado, las
+// cabeceras y el cuerpo de la respuesta. cabeceras alterna nombre y valor.
+// Si la petición no llega a hacerse marca el test como fallido y devuelve
+// estado 0, así que se puede usar desde otras goroutines
+func peticion(t testing.TB, srv *httptest.Server, metodo, ruta string, cuerpo any, cabeceras ...string) (int, http.Header, []byte) {
+	t.Helper()
+	var r io.Reader
+	if cuerpo != nil {
+		b, err := json.Marshal(cuerpo)
+		if err != nil {
+			t.Error(err)
+			return 0, nil, nil
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(metodo, srv.URL+ruta, r)
+	if err != nil {
+		t.Error(err)
+		return 0, nil, nil
+	}
+	if cuerpo != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(cabeceras); i += 2 {
+		req.Header.Set(cabeceras[i], cabeceras[i+1])
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Error(err)
+		return 0, nil, nil
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Error(err)
+		return 0, nil, nil
+	}
+	return resp.StatusCode, resp.Header, b
+}
+
+// datos decodifica el campo data de una respuesta
+func datos[T any](t testing.TB, cuerpo []byte) T {
+	t.Helper()
+	var r struct {
+		Data T `json:"data"`
+	}
+	if err := json.Unmarshal(cuerpo, &r); err != nil {
+		t.Errorf("respuesta inválida %s: %v", cuerpo, err)
+	}
+	return r.Data
+}
+
+// materialPrueba devuelve el cuerpo de un filamento válido
+func materialPrueba(nombre string) map[string]any {
+	return map[string]any{
+		"nombre":            nombre,
+		"tipo":              "filamento",
+		"fabricante":        "Prusament",
+		"disponible":        true,
+		"stock":             100,
+		"precio_por_unidad": map[string]any{"importe": "0.05", "moneda": "USD"},
+		"caracteristicas":   map[string]any{"color": "negro", "diametro_filamento": 1.75, "densidad": 1.24},
+	}
+}
+
+// crearMaterialPrueba crea un material y lo devuelve
+func crearMaterialPrueba(t testing.TB, srv *httptest.Server, nombre string) Material {
+	t.Helper()
+	estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/materiales", materialPrueba(nombre))
+	if estado != http.StatusCreated {
+		t.Fatalf("crear material: %d %s", estado, cuerpo)
+	}
+	return datos[Material](t, cuerpo)
+}
+
+// TestValidarEscrituras comprueba que el alta, el PUT, el stock y el lote
+// rechazan lo mismo que el PATCH, que valida con validarMaterial
+func TestValidarEscrituras(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	m := crearMaterialPrueba(t, srv, "PLA")
+	ruta := "/api/v1/materiales/" + m.ID
+
+	sinNombre := materialPrueba("")
+	stockNegativo := materialPrueba("PLA")
+	stockNegativo["stock"] = -1
+	for nombre, caso := range map[string]struct {
+		metodo, ruta string
+		cuerpo       any
+	}{
+		"alta sin nombre":         {http.MethodPost, "/api/v1/materiales", sinNombre},
+		"alta con stock negativo": {http.MethodPost, "/api/v1/materiales", stockNegativo},
+		"PUT sin nombre":          {http.MethodPut, ruta, sinNombre},
+		"PUT con stock negativo":  {http.MethodPut, ruta, stockNegativo},
+		"stock negativo":          {http.MethodPut, ruta + "/stock", map[string]any{"stock": -5}},
+	} {
+		if estado, _, cuerpo := peticion(t, srv, caso.metodo, caso.ruta, caso.cuerpo); estado != http.StatusBadRequest {
+			t.Errorf("%s: %d %s, se esperaba 400", nombre, estado, cuerpo)
+		}
+	}
+
+	lote := map[string]any{"operaciones": []map[string]any{{"op": "crear", "recurso": "materiales", "datos": sinNombre}}}
+	if estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/batch", lote); estado != http.StatusBadRequest {
+		t.Errorf("lote con un alta sin nombre: %d %s, se esperaba 400", estado, cuerpo)
+	}
+
+	if estado, _, cuerpo := peticion(t, srv, http.MethodGet, ruta, nil); estado != http.StatusOK || datos[Material](t, cuerpo).Version != m.Version {
+		t.Errorf("el material cambió: %d %s", estado, cuerpo)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"catalogo-comun/idempotencia"
+	"catalogo-comun/listado"
+)
+
+// repositorioMemoria guarda los materiales en memoria de forma segura para
+// handlers concurrentes. Se usa con REPOSITORIO=memoria para desarrollo local
+// y como doble de pruebas. Los materiales se indexan por ID y por tipo; orden
+// conserva el orden de inserción para que los listados sean estables.
+//
+// Los materiales eliminados pasan a papelera, fuera de los índices, hasta que
+// se restauran o se purgan.
+type repositorioMemoria struct {
+	mu         sync.RWMutex
+	porID      map[string]Material
+	porTipo    map[TipoMaterial][]string
+	orden      []string
+	papelera   map[string]Material
+	revisiones map[string][]RevisionMaterial
+	*idempotencia.Memoria
+}
+
+// newRepositorioMemoria crea un repositorio con los materiales iniciales indicados
+func newRepositorioMemoria(iniciales ...Material) *repositorioMemoria {
+	r := &repositorioMemoria{
+		porID:      make(map[string]Material),
+		porTipo:    make(map[TipoMaterial][]string),
+		papelera:   make(map[string]Material),
+		revisiones: make(map[string][]RevisionMaterial),
+		Memoria:    idempotencia.NuevaMemoria(),
+	}
+	for _, m := range iniciales {
+		r.crear(&m, actorSistema)
+	}
+	return r
+}
+
+func (r *repositorioMemoria) Listar(ctx context.Context, orden []listado.CampoOrden, v listado.Ventana) ([]Material, listado.Resultado, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	materiales := make([]Material, 0, len(r.orden))
+	for _, id := range r.orden {
+		materiales = append(materiales, r.porID[id])
+	}
+	pagina, res := listado.EnMemoria(materiales, orden, v, ordenablesMateriales)
+	return pagina, res, nil
+}
+
+// ListarPorTipo devuelve los materiales de un tipo usando el índice secundario
+func (r *repositorioMemoria) ListarPorTipo(ctx context.Context, tipo TipoMaterial) ([]Material, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := r.porTipo[tipo]
+	materiales := make([]Material, 0, len(ids))
+	for _, id := range ids {
+		materiales = append(materiales, r.porID[id])
+	}
+	return materiales, nil
+}
+
+func (r *repositorioMemoria) Obtener(ctx context.Context, id string) (Material, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, ok := r.porID[id]
+	if !ok {
+		return Material{}, ErrMaterialNoEncontrado
+	}
+	return m, nil
+}
+
+func (r *repositorioMemoria) Crear(ctx context.Context, material *Material, actor string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.crear(material, actor)
+	return nil
+}
+
+// crear agrega un material con versión 1; el llamador debe tener el candado
+// de escritura
+func (r *repositorioMemoria) crear(material *Material, actor string) {
+	material.Version = 1
+	material.EliminadoEn = nil
+	r.insertar(*material)
+	r.revisiones[material.ID] = []RevisionMaterial{nuevaRevision(nil, *material, actor)}
+}
+
+func (r *repositorioMemoria) Actualizar(ctx context.Context, material *Material, version int, actor string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.actualizar(material, version, actor)
+}
+
+// actualizar reemplaza un material; el llamador debe tener el candado de escritura
+func (r *repositorioMemoria) actualizar(material *Material, version int, actor string) error {
+	anterior, ok := r.porID[material.ID]
+	if !ok {
+		return ErrMaterialNoEncontrado
+	}
+	if version != 0 && anterior.Version != version {
+		return ErrConflictoVersion
+	}
+	material.Version = anterior.Version + 1
+	material.EliminadoEn = nil
+	r.porID[material.ID] = *material
+	r.revisar(&anterior, *material, actor)
+	if anterior.Tipo != material.Tipo {
+		r.desindexar(anterior)
+		r.indexar(*material)
+	}
+	return nil
+}
+
+func (r *repositorioMemoria) ActualizarStock(ctx context.Context, id string, stock float64, version int, actor string) (Material, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.porID[id]
+	if !ok {
+		return Material{}, ErrMaterialNoEncontrado
+	}
+	if version != 0 && m.Version != version {
+		return Material{}, ErrConflictoVersion
+	}
+	anterior := m
+	m.Stock = stock
+	m.Version++
+	r.porID[id] = m
+	r.revisar(&anterior, m, actor)
+	return m, nil
+}
+
+func (r *repositorioMemoria) Eliminar(ctx context.Context, id string, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.eliminar(id, version)
+}
+
+// eliminar mueve un material a la papelera; el llamador debe tener el
+// candado de escritura
+func (r *repositorioMemoria) eliminar(id string, version int) error {
+	m, ok := r.porID[id]
+	if !ok {
+		return ErrMaterialNoEncontrado
+	}
+	if version != 0 && m.Version != version {
+		return ErrConflictoVersion
+	}
+	r.desindexar(m)
+	delete(r.porID, id)
+	r.orden = quitarID(r.orden, id)
+	ahora := time.Now().UTC()
+	m.EliminadoEn = &ahora
+	r.papelera[id] = m
+	return nil
+}
+
+// AplicarLote aplica las operaciones bajo el candado de escritura; si una
+// falla se vuelve al estado de antes del lote
+func (r *repositorioMemoria) AplicarLote(ctx context.Context, ops []OperacionLote, actor string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	restaurar := r.instantanea()
+	for i, op := range ops {
+		var err error
+		switch op.Tipo {
+		case OperacionCrear:
+			r.crear(op.Material, actor)
+		case OperacionActualizar:
+			err = r.actualizar(op.Material, op.Version, actor)
+		case OperacionEliminar:
+			err = r.eliminar(op.ID, op.Version)
+		default:
+			err = fmt.Errorf("operación de lote no soportada: %s", op.Tipo)
+		}
+		if err != nil {
+			restaurar()
+			return ErrorOperacion{Indice: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// instantanea copia el estado que puede modificar un lote y devuelve la
+// función que lo restaura; el llamador debe tener el candado de escritura.
+// Las listas de porTipo y orden se copian porque quitarID las modifica en su
+// sitio; a las revisiones solo se les añaden elementos
+func (r *repositorioMemoria) instantanea() func() {
+	porID := maps.Clone(r.porID)
+	porTipo := make(map[TipoMaterial][]string, len(r.porTipo))
+	for tipo, ids := range r.porTipo {
+		porTipo[tipo] = slices.Clone(ids)
+	}
+	orden := slices.Clone(r.orden)
+	papelera := maps.Clone(r.papelera)
+	revisiones := maps.Clone(r.revisiones)
+	return func() {
+		r.porID = porID
+		r.porTipo = porTipo
+		r.orden = orden
+		r.papelera = papelera
+		r.revisiones = revisiones
+	}
+}
+
+func (r *repositorioMemoria) ListarPapelera(ctx context.Context, orden []listado.CampoOrden, v listado.Ventana) ([]Material, listado.Resultado, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	materiales := make([]Material, 0, len(r.papelera))
+	for _, m := range r.papelera {
+		materiales = append(materiales, m)
+	}
+	pagina, res := listado.EnMemoria(materiales, orden, v, ordenablesMateriales)
+	return pagina, res, nil
+}
+
+func (r *repositorioMemoria) Restaurar(ctx context.Context, id string, actor string) (Material, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.papelera[id]
+	if !ok {
+		return Material{}, ErrMaterialNoEncontrado
+	}
+	delete(r.papelera, id)
+	m.EliminadoEn = nil
+	m.Version++
+	r.insertar(m)
+	// Restaurar no cambia el contenido, pero la revisión deja constancia de quién lo hizo
+	r.revisar(&m, m, actor)
+	return m, nil
+}
+
+func (r *repositorioMemoria) Purgar(ctx context.Context, limite time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for id, m := range r.papelera {
+		if m.EliminadoEn.Before(limite) {
+			delete(r.papelera, id)
+			delete(r.revisiones, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *repositorioMemoria) Revisiones(ctx context.Context, id string) ([]RevisionMaterial, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.porID[id]; !ok {
+		return nil, ErrMaterialNoEncontrado
+	}
+	return append([]RevisionMaterial{}, r.revisiones[id]...), nil
+}
+
+func (r *repositorioMemoria) Revision(ctx context.Context, id string, numero int) (RevisionMaterial, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.porID[id]; !ok {
+		return RevisionMaterial{}, ErrMaterialNoEncontrado
+	}
+	for _, rev := range r.revisiones[id] {
+		if rev.Numero == numero {
+			return rev, nil
+		}
+	}
+	return RevisionMaterial{}, ErrRevisionNoEncontrada
+}
+
+// revisar registra la revisión de una escritura; el llamador debe tener el
+// candado de escritura
+func (r *repositorioMemoria) revisar(anterior *Material, m Material, actor string) {
+	r.revisiones[m.ID] = append(r.revisiones[m.ID], nuevaRevision(anterior, m, actor))
+}
+
+// insertar agrega un material; el llamador debe tener el candado de escritura
+func (r *repositorioMemoria) insertar(m Material) {
+	r.porID[m.ID] = m
+	r.orden = append(r.orden, m.ID)
+	r.indexar(m)
+}
+
+func (r *repositorioMemoria) indexar(m Material) {
+	r.porTipo[m.Tipo] = append(r.porTipo[m.Tipo], m.ID)
+}
+
+func (r *repositorioMemoria) desindexar(m Material) {
+	ids := quitarID(r.porTipo[m.Tipo], m.ID)
+	if len(ids) == 0 {
+		delete(r.porTipo, m.Tipo)
+		return
+	}
+	r.porTipo[m.Tipo] = ids
+}
+
+// quitarID elimina id de la lista conservando el orden del resto
+func quitarID(ids []string, id string) []string {
+	for i, oid := range ids {
+		if oid == id {
+			return append(ids[:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// migracion es un cambio de esquema versionado. Las migraciones se aplican en
+// orden y una sola vez; nunca se modifica una migración ya publicada, se
+// agrega una nueva con la siguiente versión. Las que cargan datos usan
+// aplicar en vez de sql para pasar por el repositorio
+type migracion struct {
+	version     int
+	descripcion string
+	sql         string
+	aplicar     func(tx *gorm.DB) error
+}
+
+var migraciones = []migracion{
+	{
+		// Las características comunes a todos los tipos son columnas de
+		// materiales; las de cada tipo van en su propia tabla, con una fila
+		// solo para los materiales de ese tipo. La clave foránea incluye el
+		// tipo para que un filamento no pueda tener características de resina
+		version:     1,
+		descripcion: "crear tablas de materiales y sus características",
+		sql: `CREATE TABLE IF NOT EXISTS materiales (
+				id                        TEXT PRIMARY KEY,
+				orden                     BIGSERIAL,
+				nombre                    TEXT NOT NULL,
+				tipo                      TEXT NOT NULL CHECK (tipo IN ('filamento', 'resina')),
+				fabricante                TEXT NOT NULL DEFAULT '',
+				disponible                BOOLEAN NOT NULL DEFAULT false,
+				stock                     DOUBLE PRECISION NOT NULL DEFAULT 0,
+				precio_por_unidad_importe NUMERIC(15, 3) NOT NULL DEFAULT 0,
+				precio_por_unidad_moneda  TEXT NOT NULL DEFAULT 'USD',
+				color                     TEXT NOT NULL DEFAULT '',
+				temperatura_impresion     INTEGER NOT NULL DEFAULT 0,
+				temperatura_plataforma    INTEGER NOT NULL DEFAULT 0,
+				resistencia_tensil        DOUBLE PRECISION NOT NULL DEFAULT 0,
+				dureza                    DOUBLE PRECISION NOT NULL DEFAULT 0,
+				traducciones              JSONB DEFAULT '{}',
+				version                   INTEGER NOT NULL DEFAULT 1,
+				eliminado_en              TIMESTAMPTZ,
+				UNIQUE (id, tipo)
+			);
+			CREATE INDEX IF NOT EXISTS idx_materiales_orden ON materiales (orden);
+			CREATE INDEX IF NOT EXISTS idx_materiales_tipo ON materiales (tipo, orden) WHERE eliminado_en IS NULL;
+			CREATE INDEX IF NOT EXISTS idx_materiales_eliminado_en ON materiales (eliminado_en) WHERE eliminado_en IS NOT NULL;
+
+			CREATE TABLE IF NOT EXISTS caracteristicas_filamento (
+				material_id        TEXT PRIMARY KEY,
+				tipo               TEXT NOT NULL DEFAULT 'filamento' CHECK (tipo = 'filamento'),
+				diametro_filamento DOUBLE PRECISION NOT NULL DEFAULT 0,
+				densidad           DOUBLE PRECISION NOT NULL DEFAULT 0,
+				FOREIGN KEY (material_id, tipo) REFERENCES materiales (id, tipo) ON DELETE CASCADE
+			);
+			CREATE TABLE IF NOT EXISTS caracteristicas_resina (
+				material_id TEXT PRIMARY KEY,
+				tipo        TEXT NOT NULL DEFAULT 'resina' CHECK (tipo = 'resina'),
+				viscosidad  DOUBLE PRECISION NOT NULL DEFAULT 0,
+				tiempo_cura INTEGER NOT NULL DEFAULT 0,
+				tolerancia  DOUBLE PRECISION NOT NULL DEFAULT 0,
+				FOREIGN KEY (material_id, tipo) REFERENCES materiales (id, tipo) ON DELETE CASCADE
+			)`,
+	},
+	{
+		version:     2,
+		descripcion: "revisiones de materiales",
+		sql: `CREATE TABLE IF NOT EXISTS material_revisiones (
+			material_id TEXT NOT NULL REFERENCES materiales (id) ON DELETE CASCADE,
+			numero      INTEGER NOT NULL,
+			actor       TEXT NOT NULL,
+			fecha       TIMESTAMPTZ NOT NULL DEFAULT now(),
+			cambios     JSONB NOT NULL,
+			material    JSONB NOT NULL,
+			PRIMARY KEY (material_id, numero)
+		)`,
+	},
+	{
+		// Los mismos materiales con los que arranca el repositorio en
+		// memoria, para que una base de datos nueva no empiece vacía. Al ser
+		// una migración se cargan una sola vez y no reaparecen si se borran
+		version:     3,
+		descripcion: "materiales de ejemplo",
+		aplicar: func(tx *gorm.DB) error {
+			r := newRepositorioPostgres(tx)
+			for _, m := range materialesEjemplo() {
+				if err := r.crear(tx, &m, actorSistema); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     4,
+		descripcion: "claves de idempotencia",
+		sql: `CREATE TABLE IF NOT EXISTS idempotencia (
+			clave          TEXT PRIMARY KEY,
+			huella         TEXT NOT NULL DEFAULT '',
+			estado         INTEGER NOT NULL DEFAULT 0,
+			tipo_contenido TEXT NOT NULL DEFAULT '',
+			etag           TEXT NOT NULL DEFAULT '',
+			cuerpo         BYTEA,
+			caduca_en      TIMESTAMPTZ NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_idempotencia_caduca_en ON idempotencia (caduca_en)`,
+	},
+}
+
+func migrar(db *gorm.DB) error {
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     INTEGER PRIMARY KEY,
+		descripcion TEXT NOT NULL,
+		aplicada_en TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`).Error; err != nil {
+		return err
+	}
+
+	var aplicadas []int
+	if err := db.Raw("SELECT version FROM schema_migrations").Scan(&aplicadas).Error; err != nil {
+		return err
+	}
+	hechas := make(map[int]bool, len(aplicadas))
+	for _, v := range aplicadas {
+		hechas[v] = true
+	}
+
+	for _, m := range migraciones {
+		if hechas[m.version] {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if m.aplicar != nil {
+				if err := m.aplicar(tx); err != nil {
+					return err
+				}
+			} else if err := tx.Exec(m.sql).Error; err != nil {
+				return err
+			}
+			return tx.Exec("INSERT INTO schema_migrations (version, descripcion) VALUES (?, ?)", m.version, m.descripcion).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migración %d (%s): %w", m.version, m.descripcion, err)
+		}
+		log.Printf("Migración %d aplicada: %s", m.version, m.descripcion)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+
+	"catalogo-comun/condicional"
+	"catalogo-comun/listado"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getMaterialsPapelera(c *gin.Context) {
+	params, err := listado.Parse(c, ordenablesMateriales, camposMateriales)
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	materiales, res, err := repo.ListarPapelera(c.Request.Context(), params.Orden, params.Ventana)
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	if !convertirPrecios(c, materiales) {
+		return
+	}
+	localizarMateriales(c, materiales)
+	responderPagina(c, materiales, res, params.Ventana, params.Campos)
+}
+
+func restoreMaterial(c *gin.Context) {
+	m, err := repo.Restaurar(c.Request.Context(), c.Param("id"), actorDe(c))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	c.Header("ETag", condicional.ETag(m.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": m,
+	})
+}
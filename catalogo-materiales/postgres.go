@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"catalogo-comun/idempotencia"
+	"catalogo-comun/listado"
+	"catalogo-dominio/dinero"
+
+	"gorm.io/gorm"
+)
+
+// repositorioPostgres persiste los materiales en PostgreSQL usando GORM. Las
+// claves de idempotencia van en la tabla idempotencia de la misma base
+type repositorioPostgres struct {
+	idempotencia.SQL
+	db *gorm.DB
+}
+
+func newRepositorioPostgres(db *gorm.DB) *repositorioPostgres {
+	return &repositorioPostgres{SQL: idempotencia.SQL{DB: db}, db: db}
+}
+
+// filaMaterial es una fila de la tabla materiales con las características
+// comunes. Las de cada tipo solo se leen, con consultaMateriales, porque se
+// escriben en su propia tabla con guardarCaracteristicas
+type filaMaterial struct {
+	ID                    string
+	Nombre                string
+	Tipo                  TipoMaterial
+	Fabricante            string
+	Disponible            bool
+	Stock                 float64
+	PrecioPorUnidad       dinero.Dinero `gorm:"embedded;embeddedPrefix:precio_por_unidad_"`
+	Color                 string
+	TemperaturaImpresion  int
+	TemperaturaPlataforma int
+	ResistenciaTensil     float64
+	Dureza                float64
+	Traducciones          Traducciones `gorm:"serializer:json"`
+	Version               int
+	EliminadoEn           *time.Time
+
+	DiametroFilamento float64 `gorm:"->"`
+	Densidad          float64 `gorm:"->"`
+	Viscosidad        float64 `gorm:"->"`
+	TiempoCura        int     `gorm:"->"`
+	Tolerancia        float64 `gorm:"->"`
+}
+
+func (filaMaterial) TableName() string {
+	return "materiales"
+}
+
+// caracteristicasFilamento son las características propias de un filamento
+type caracteristicasFilamento struct {
+	MaterialID        string `gorm:"primaryKey"`
+	DiametroFilamento float64
+	Densidad          float64
+}
+
+func (caracteristicasFilamento) TableName() string {
+	return "caracteristicas_filamento"
+}
+
+// caracteristicasResina son las características propias de una resina
+type caracteristicasResina struct {
+	MaterialID string `gorm:"primaryKey"`
+	Viscosidad float64
+	TiempoCura int
+	Tolerancia float64
+}
+
+func (caracteristicasResina) TableName() string {
+	return "caracteristicas_resina"
+}
+
+func nuevaFila(m Material) filaMaterial {
+	c := m.Caracteristicas
+	return filaMaterial{
+		ID:                    m.ID,
+		Nombre:                m.Nombre,
+		Tipo:                  m.Tipo,
+		Fabricante:            m.Fabricante,
+		Disponible:            m.Disponible,
+		Stock:                 m.Stock,
+		PrecioPorUnidad:       m.PrecioPorUnidad,
+		Color:                 c.Color,
+		TemperaturaImpresion:  c.TemperaturaImpresion,
+		TemperaturaPlataforma: c.TemperaturaPlataforma,
+		ResistenciaTensil:     c.ResistenciaTensil,
+		Dureza:                c.Dureza,
+		Traducciones:          m.Traducciones,
+		Version:               m.Version,
+		EliminadoEn:           m.EliminadoEn,
+	}
+}
+
+func (f filaMaterial) material() Material {
+	return Material{
+		ID:              f.ID,
+		Nombre:          f.Nombre,
+		Tipo:            f.Tipo,
+		Fabricante:      f.Fabricante,
+		Disponible:      f.Disponible,
+		Stock:           f.Stock,
+		PrecioPorUnidad: f.PrecioPorUnidad,
+		Caracteristicas: CaracteristicasMaterial{
+			Color:                 f.Color,
+			TemperaturaImpresion:  f.TemperaturaImpresion,
+			TemperaturaPlataforma: f.TemperaturaPlataforma,
+			ResistenciaTensil:     f.ResistenciaTensil,
+			Dureza:                f.Dureza,
+			DiametroFilamento:     f.DiametroFilamento,
+			Densidad:              f.Densidad,
+			Viscosidad:            f.Viscosidad,
+			TiempoCura:            f.TiempoCura,
+			Tolerancia:            f.Tolerancia,
+		},
+		Traducciones: f.Traducciones,
+		Version:      f.Version,
+		EliminadoEn:  f.EliminadoEn,
+	}
+}
+
+// consultaMateriales une cada material con las características de su tipo;
+// las del otro tipo quedan a cero
+func consultaMateriales(tx *gorm.DB) *gorm.DB {
+	return tx.Table("materiales m").
+		Select(`m.*,
+			COALESCE(f.diametro_filamento, 0) AS diametro_filamento,
+			COALESCE(f.densidad, 0) AS densidad,
+			COALESCE(r.viscosidad, 0) AS viscosidad,
+			COALESCE(r.tiempo_cura, 0) AS tiempo_cura,
+			COALESCE(r.tolerancia, 0) AS tolerancia`).
+		Joins("LEFT JOIN caracteristicas_filamento f ON f.material_id = m.id").
+		Joins("LEFT JOIN caracteristicas_resina r ON r.material_id = m.id")
+}
+
+// listar devuelve todos los materiales que cumplen la condición en orden de
+// creación
+func listar(tx *gorm.DB, condicion string, args ...any) ([]Material, error) {
+	var filas []filaMaterial
+	if err := consultaMateriales(tx).Where(condicion, args...).Order("m.orden").Find(&filas).Error; err != nil {
+		return nil, err
+	}
+	materiales := make([]Material, len(filas))
+	for i, f := range filas {
+		materiales[i] = f.material()
+	}
+	return materiales, nil
+}
+
+// columnasOrden son las columnas de consultaMateriales por las que se ordena
+// cada campo de ordenablesMateriales. Llevan el alias de la tabla porque las
+// de características también tienen tipo
+var columnasOrden = map[string]string{
+	"id":                "m.id",
+	"nombre":            "m.nombre",
+	"tipo":              "m.tipo",
+	"fabricante":        "m.fabricante",
+	"stock":             "m.stock",
+	"precio_por_unidad": "m.precio_por_unidad_importe",
+}
+
+// ordenablesFilas son ordenablesMateriales sobre las filas que lee listado.SQL
+var ordenablesFilas = func() map[string]func(filaMaterial) any {
+	ordenables := make(map[string]func(filaMaterial) any, len(ordenablesMateriales))
+	for campo, valor := range ordenablesMateriales {
+		ordenables[campo] = func(f filaMaterial) any { return valor(f.material()) }
+	}
+	return ordenables
+}()
+
+// paginar devuelve una página de los materiales que cumplen la condición; el
+// orden y la ventana se aplican en la consulta
+func paginar(tx *gorm.DB, condicion string, orden []listado.CampoOrden, v listado.Ventana) ([]Material, listado.Resultado, error) {
+	var res listado.Resultado
+	if err := tx.Table("materiales m").Where(condicion).Count(&res.Total).Error; err != nil {
+		return nil, res, err
+	}
+	filas, err := listado.SQL(consultaMateriales(tx).Where(condicion), orden, v, ordenablesFilas, columnasOrden, &res)
+	if err != nil {
+		return nil, res, err
+	}
+	materiales := make([]Material, len(filas))
+	for i, f := range filas {
+		materiales[i] = f.material()
+	}
+	return materiales, res, nil
+}
+
+// obtener lee un material, eliminado o no según enPapelera
+func obtener(tx *gorm.DB, id string, enPapelera bool) (Material, error) {
+	q := consultaMateriales(tx).Where("m.id = ?", id)
+	if enPapelera {
+		q = q.Where("m.eliminado_en IS NOT NULL")
+	} else {
+		q = q.Where("m.eliminado_en IS NULL")
+	}
+	var fila filaMaterial
+	err := q.Take(&fila).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Material{}, ErrMaterialNoEncontrado
+	}
+	if err != nil {
+		return Material{}, err
+	}
+	return fila.material(), nil
+}
+
+func (r *repositorioPostgres) Listar(ctx context.Context, orden []listado.CampoOrden, v listado.Ventana) ([]Material, listado.Resultado, error) {
+	return paginar(r.db.WithContext(ctx), "m.eliminado_en IS NULL", orden, v)
+}
+
+func (r *repositorioPostgres) ListarPorTipo(ctx context.Context, tipo TipoMaterial) ([]Material, error) {
+	return listar(r.db.WithContext(ctx), "m.eliminado_en IS NULL AND m.tipo = ?", tipo)
+}
+
+func (r *repositorioPostgres) Obtener(ctx context.Context, id string) (Material, error) {
+	return obtener(r.db.WithContext(ctx), id, false)
+}
+
+func (r *repositorioPostgres) Crear(ctx context.Context, material *Material, actor string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return r.crear(tx, material, actor)
+	})
+}
+
+// crear inserta el material dentro de una transacción ya abierta
+func (r *repositorioPostgres) crear(tx *gorm.DB, material *Material, actor string) error {
+	material.Version = 1
+	material.EliminadoEn = nil
+	fila := nuevaFila(*material)
+	if err := tx.Create(&fila).Error; err != nil {
+		return err
+	}
+	if err := guardarCaracteristicas(tx, *material); err != nil {
+		return err
+	}
+	return registrarRevision(tx, nil, *material, actor)
+}
+
+func (r *repositorioPostgres) Actualizar(ctx context.Context, material *Material, version int, actor string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return r.actualizar(tx, material, version, actor)
+	})
+}
+
+// actualizar reemplaza el material dentro de una transacción ya abierta
+func (r *repositorioPostgres) actualizar(tx *gorm.DB, material *Material, version int, actor string) error {
+	// Se lee el material completo para calcular la revisión
+	anterior, err := obtener(tx, material.ID, false)
+	if err != nil {
+		return err
+	}
+	if version != 0 && anterior.Version != version {
+		return ErrConflictoVersion
+	}
+
+	material.Version = anterior.Version + 1
+	material.EliminadoEn = nil
+	// Las características se borran antes de escribir la fila porque su
+	// clave foránea incluye el tipo, que puede cambiar
+	if err := borrarCaracteristicas(tx, material.ID); err != nil {
+		return err
+	}
+	if err := escribirFila(tx, nuevaFila(*material), anterior.Version); err != nil {
+		return err
+	}
+	if err := guardarCaracteristicas(tx, *material); err != nil {
+		return err
+	}
+	return registrarRevision(tx, &anterior, *material, actor)
+}
+
+func (r *repositorioPostgres) ActualizarStock(ctx context.Context, id string, stock float64, version int, actor string) (Material, error) {
+	var m Material
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		anterior, err := obtener(tx, id, false)
+		if err != nil {
+			return err
+		}
+		if version != 0 && anterior.Version != version {
+			return ErrConflictoVersion
+		}
+		m = anterior
+		m.Stock = stock
+		m.Version++
+		res := tx.Model(&filaMaterial{}).
+			Where("id = ? AND version = ?", id, anterior.Version).
+			Updates(map[string]any{"stock": stock, "version": m.Version})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrConflictoVersion
+		}
+		return registrarRevision(tx, &anterior, m, actor)
+	})
+	if err != nil {
+		return Material{}, err
+	}
+	return m, nil
+}
+
+func (r *repositorioPostgres) Eliminar(ctx context.Context, id string, version int) error {
+	return r.eliminar(r.db.WithContext(ctx), id, version)
+}
+
+// eliminar mueve un material a la papelera; no cambia su versión
+func (r *repositorioPostgres) eliminar(tx *gorm.DB, id string, version int) error {
+	q := tx.Model(&filaMaterial{}).Where("id = ? AND eliminado_en IS NULL", id)
+	if version != 0 {
+		q = q.Where("version = ?", version)
+	}
+	res := q.Update("eliminado_en", time.Now().UTC())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errorSinFila(tx, id)
+	}
+	return nil
+}
+
+func (r *repositorioPostgres) AplicarLote(ctx context.Context, ops []OperacionLote, actor string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i, op := range ops {
+			var err error
+			switch op.Tipo {
+			case OperacionCrear:
+				err = r.crear(tx, op.Material, actor)
+			case OperacionActualizar:
+				err = r.actualizar(tx, op.Material, op.Version, actor)
+			case OperacionEliminar:
+				err = r.eliminar(tx, op.ID, op.Version)
+			default:
+				err = fmt.Errorf("operación de lote no soportada: %s", op.Tipo)
+			}
+			if err != nil {
+				return ErrorOperacion{Indice: i, Err: err}
+			}
+		}
+		return nil
+	})
+}
+
+func (r *repositorioPostgres) ListarPapelera(ctx context.Context, orden []listado.CampoOrden, v listado.Ventana) ([]Material, listado.Resultado, error) {
+	return paginar(r.db.WithContext(ctx), "m.eliminado_en IS NOT NULL", orden, v)
+}
+
+func (r *repositorioPostgres) Restaurar(ctx context.Context, id string, actor string) (Material, error) {
+	var m Material
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if m, err = obtener(tx, id, true); err != nil {
+			return err
+		}
+		anterior := m.Version
+		m.EliminadoEn = nil
+		m.Version++
+		res := tx.Model(&filaMaterial{}).
+			Where("id = ? AND version = ?", id, anterior).
+			Updates(map[string]any{"eliminado_en": nil, "version": m.Version})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrConflictoVersion
+		}
+		// Restaurar no cambia el contenido, pero la revisión deja constancia de quién lo hizo
+		return registrarRevision(tx, &m, m, actor)
+	})
+	if err != nil {
+		return Material{}, err
+	}
+	return m, nil
+}
+
+// Purgar borra los materiales; sus características y revisiones se borran
+// en cascada
+func (r *repositorioPostgres) Purgar(ctx context.Context, limite time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).Where("eliminado_en < ?", limite).Delete(&filaMaterial{})
+	return res.RowsAffected, res.Error
+}
+
+func (r *repositorioPostgres) Revisiones(ctx context.Context, id string) ([]RevisionMaterial, error) {
+	db := r.db.WithContext(ctx)
+	if _, err := obtener(db, id, false); err != nil {
+		return nil, err
+	}
+	var revisiones []RevisionMaterial
+	err := db.Where("material_id = ?", id).Order("numero").Find(&revisiones).Error
+	return revisiones, err
+}
+
+func (r *repositorioPostgres) Revision(ctx context.Context, id string, numero int) (RevisionMaterial, error) {
+	db := r.db.WithContext(ctx)
+	if _, err := obtener(db, id, false); err != nil {
+		return RevisionMaterial{}, err
+	}
+	var revision RevisionMaterial
+	err := db.First(&revision, "material_id = ? AND numero = ?", id, numero).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return RevisionMaterial{}, ErrRevisionNoEncontrada
+	}
+	return revision, err
+}
+
+// escribirFila reemplaza la fila de un material si sigue en la versión
+// leída; la condición evita perder escrituras concurrentes entre la lectura
+// y la actualización
+func escribirFila(tx *gorm.DB, fila filaMaterial, version int) error {
+	res := tx.Model(&filaMaterial{}).
+		Where("id = ? AND version = ? AND eliminado_en IS NULL", fila.ID, version).
+		Select("*").
+		Omit("id").
+		Updates(&fila)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrConflictoVersion
+	}
+	return nil
+}
+
+// guardarCaracteristicas inserta las características propias del tipo del
+// material; los tipos sin características propias no tienen fila
+func guardarCaracteristicas(tx *gorm.DB, m Material) error {
+	c := m.Caracteristicas
+	switch m.Tipo {
+	case TipoFilamento:
+		return tx.Create(&caracteristicasFilamento{
+			MaterialID:        m.ID,
+			DiametroFilamento: c.DiametroFilamento,
+			Densidad:          c.Densidad,
+		}).Error
+	case TipoResina:
+		return tx.Create(&caracteristicasResina{
+			MaterialID: m.ID,
+			Viscosidad: c.Viscosidad,
+			TiempoCura: c.TiempoCura,
+			Tolerancia: c.Tolerancia,
+		}).Error
+	}
+	return nil
+}
+
+func borrarCaracteristicas(tx *gorm.DB, id string) error {
+	if err := tx.Delete(&caracteristicasFilamento{}, "material_id = ?", id).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&caracteristicasResina{}, "material_id = ?", id).Error
+}
+
+// errorSinFila distingue, cuando una escritura condicionada por la versión no
+// afecta a ninguna fila, si el material no existe o cambió de versión
+func errorSinFila(tx *gorm.DB, id string) error {
+	var n int64
+	if err := tx.Model(&filaMaterial{}).Where("id = ? AND eliminado_en IS NULL", id).Count(&n).Error; err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMaterialNoEncontrado
+	}
+	return ErrConflictoVersion
+}
+
+func registrarRevision(tx *gorm.DB, anterior *Material, m Material, actor string) error {
+	revision := nuevaRevision(anterior, m, actor)
+	return tx.Create(&revision).Error
+}
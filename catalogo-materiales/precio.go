@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"catalogo-dominio/dinero"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrTasaNoEncontrada se devuelve cuando no existe una tasa de cambio con el ID solicitado
+	ErrTasaNoEncontrada = errors.New("tasa de cambio no encontrada")
+	// ErrTasaDuplicada se devuelve al crear una tasa para un par de monedas
+	// que ya tiene otra con la misma fecha de vigencia
+	ErrTasaDuplicada = errors.New("tasa de cambio duplicada")
+)
+
+// AlmacenTasas guarda en memoria las tasas de cambio usadas para convertir
+// los precios de los materiales
+type AlmacenTasas struct {
+	mu    sync.RWMutex
+	tasas []dinero.TasaCambio
+}
+
+var tasas = &AlmacenTasas{}
+
+func (a *AlmacenTasas) Listar() []dinero.TasaCambio {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.Clone(a.tasas)
+}
+
+func (a *AlmacenTasas) Crear(tasa dinero.TasaCambio) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, t := range a.tasas {
+		if t.Desde == tasa.Desde && t.Hacia == tasa.Hacia && t.VigenteDesde.Equal(tasa.VigenteDesde) {
+			return ErrTasaDuplicada
+		}
+	}
+	a.tasas = append(a.tasas, tasa)
+	return nil
+}
+
+func (a *AlmacenTasas) Eliminar(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i := slices.IndexFunc(a.tasas, func(t dinero.TasaCambio) bool { return t.ID == id })
+	if i < 0 {
+		return ErrTasaNoEncontrada
+	}
+	a.tasas = slices.Delete(a.tasas, i, i+1)
+	return nil
+}
+
+// completarMoneda asigna la moneda predeterminada a un precio que no la
+// indica, como los enviados con el formato numérico anterior
+func completarMoneda(m *Material) {
+	if m.PrecioPorUnidad.Moneda == "" {
+		m.PrecioPorUnidad.Moneda = dinero.MonedaPorDefecto()
+	}
+}
+
+// validarPrecio comprueba la moneda y que el precio no sea negativo
+func validarPrecio(m Material) error {
+	for _, campo := range []string{"importe", "moneda"} {
+		if msg, ok := m.PrecioPorUnidad.Validar(false)[campo]; ok {
+			return errors.New("precio_por_unidad." + campo + " " + msg)
+		}
+	}
+	return nil
+}
+
+// convertirPrecios aplica ?moneda= a los materiales con la tasa vigente en
+// el momento de la petición. Si no puede convertir responde el error y
+// devuelve false
+func convertirPrecios(c *gin.Context, materiales []Material) bool {
+	moneda := strings.ToUpper(c.Query("moneda"))
+	if moneda == "" {
+		return true
+	}
+	if !dinero.MonedaValida(moneda) {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "moneda debe ser un código ISO 4217 admitido")+": "+strings.Join(dinero.Monedas(), ", "))
+		return false
+	}
+
+	vigentes := tasas.Listar()
+	ahora := time.Now()
+	for i := range materiales {
+		precio, err := dinero.Convertir(materiales[i].PrecioPorUnidad, moneda, vigentes, ahora)
+		if err != nil {
+			responderMensaje(c, http.StatusUnprocessableEntity, traducir(c, "No se puede convertir el precio")+": "+err.Error())
+			return false
+		}
+		materiales[i].PrecioPorUnidad = precio
+	}
+	return true
+}
+
+func getTasasCambio(c *gin.Context) {
+	desde, hacia := strings.ToUpper(c.Query("desde")), strings.ToUpper(c.Query("hacia"))
+	lista := slices.DeleteFunc(tasas.Listar(), func(t dinero.TasaCambio) bool {
+		return desde != "" && t.Desde != desde || hacia != "" && t.Hacia != hacia
+	})
+	slices.SortFunc(lista, func(a, b dinero.TasaCambio) int {
+		if r := strings.Compare(a.Desde+a.Hacia, b.Desde+b.Hacia); r != 0 {
+			return r
+		}
+		return a.VigenteDesde.Compare(b.VigenteDesde)
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"data": lista,
+	})
+}
+
+func createTasaCambio(c *gin.Context) {
+	var tasa dinero.TasaCambio
+	if err := c.ShouldBindJSON(&tasa); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tasa.ID = uuid.New().String()
+	tasa.Desde, tasa.Hacia = strings.ToUpper(tasa.Desde), strings.ToUpper(tasa.Hacia)
+	if tasa.VigenteDesde.IsZero() {
+		tasa.VigenteDesde = time.Now().UTC()
+	}
+	errs := tasa.Validar()
+	for _, campo := range []string{"desde", "hacia", "tasa", "vigente_desde"} {
+		if msg, ok := errs[campo]; ok {
+			responderMensaje(c, http.StatusBadRequest, campo+" "+msg)
+			return
+		}
+	}
+
+	if err := tasas.Crear(tasa); err != nil {
+		responderError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"data": tasa,
+	})
+}
+
+func deleteTasaCambio(c *gin.Context) {
+	if err := tasas.Eliminar(c.Param("id")); err != nil {
+		responderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": traducir(c, "Tasa de cambio eliminada")})
+}
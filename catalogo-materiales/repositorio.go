@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"catalogo-comun/idempotencia"
+	"catalogo-comun/listado"
+)
+
+// ErrMaterialNoEncontrado se devuelve cuando no existe un material con el ID solicitado
+var ErrMaterialNoEncontrado = errors.New("material no encontrado")
+
+// ErrConflictoVersion se devuelve cuando la versión esperada de un material no
+// coincide con la almacenada, es decir, otro cliente lo modificó antes
+var ErrConflictoVersion = errors.New("la versión del material no coincide")
+
+// RepositorioMateriales abstrae el almacenamiento de materiales para que los
+// handlers no dependan de una base de datos concreta.
+//
+// Las operaciones de escritura reciben la versión que el cliente espera
+// modificar; 0 significa cualquier versión. Cada escritura incrementa
+// Material.Version y guarda una revisión con el actor indicado. Crear y
+// Actualizar ignoran Material.EliminadoEn.
+//
+// Eliminar mueve el material a la papelera: deja de aparecer en las demás
+// operaciones hasta que se restaura o se purga.
+type RepositorioMateriales interface {
+	// Listar devuelve una página de los materiales en el orden pedido, que
+	// siempre termina en el ID, ver listado.Parse
+	Listar(ctx context.Context, orden []listado.CampoOrden, v listado.Ventana) ([]Material, listado.Resultado, error)
+	ListarPorTipo(ctx context.Context, tipo TipoMaterial) ([]Material, error)
+	Obtener(ctx context.Context, id string) (Material, error)
+	Crear(ctx context.Context, material *Material, actor string) error
+	Actualizar(ctx context.Context, material *Material, version int, actor string) error
+	// ActualizarStock cambia solo el stock de un material y devuelve el
+	// material resultante
+	ActualizarStock(ctx context.Context, id string, stock float64, version int, actor string) (Material, error)
+	Eliminar(ctx context.Context, id string, version int) error
+	// AplicarLote aplica en orden las operaciones de un lote de forma
+	// atómica. Si una falla no se aplica ninguna y el error es un
+	// ErrorOperacion con la posición de la que falló
+	AplicarLote(ctx context.Context, ops []OperacionLote, actor string) error
+
+	// ListarPapelera devuelve una página de los materiales eliminados, como Listar
+	ListarPapelera(ctx context.Context, orden []listado.CampoOrden, v listado.Ventana) ([]Material, listado.Resultado, error)
+	// Restaurar saca un material de la papelera y devuelve el material resultante
+	Restaurar(ctx context.Context, id string, actor string) (Material, error)
+	// Purgar borra definitivamente, con sus revisiones, los materiales
+	// eliminados antes de limite y devuelve cuántos borró
+	Purgar(ctx context.Context, limite time.Time) (int64, error)
+
+	// Revisiones devuelve las revisiones de un material en orden, con el
+	// material completo de cada una
+	Revisiones(ctx context.Context, id string) ([]RevisionMaterial, error)
+	Revision(ctx context.Context, id string, numero int) (RevisionMaterial, error)
+
+	// Las claves de idempotencia se guardan junto a los materiales para que
+	// los reintentos funcionen entre instancias
+	idempotencia.Almacen
+}
+
+// OperacionLote es una escritura de un lote: crear, actualizar o eliminar un
+// material. Material lleva lo que se crea o actualiza; para eliminar basta
+// ID. Version es la versión que se espera modificar, como en Actualizar
+type OperacionLote struct {
+	Tipo     TipoOperacion
+	Material *Material
+	ID       string
+	Version  int
+}
+
+// TipoOperacion es la escritura que hace una operación de un lote
+type TipoOperacion string
+
+const (
+	OperacionCrear      TipoOperacion = "crear"
+	OperacionActualizar TipoOperacion = "actualizar"
+	OperacionEliminar   TipoOperacion = "eliminar"
+)
+
+// ErrorOperacion es el error de la operación Indice de un lote
+type ErrorOperacion struct {
+	Indice int
+	Err    error
+}
+
+func (e ErrorOperacion) Error() string {
+	return fmt.Sprintf("operación %d: %v", e.Indice, e.Err)
+}
+
+func (e ErrorOperacion) Unwrap() error {
+	return e.Err
+}
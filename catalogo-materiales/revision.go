@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"catalogo-comun/condicional"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrRevisionNoEncontrada se devuelve cuando el material no tiene la revisión solicitada
+var ErrRevisionNoEncontrada = errors.New("revisión no encontrada")
+
+const (
+	// actorAnonimo es el actor de las escrituras sin cabecera X-Usuario
+	actorAnonimo = "anónimo"
+	// actorSistema es el actor de los materiales iniciales
+	actorSistema = "sistema"
+)
+
+// camposSinRevision no se comparan entre revisiones: cambian en cada
+// escritura o no forman parte del contenido del material
+var camposSinRevision = map[string]bool{
+	"version":      true,
+	"eliminado_en": true,
+}
+
+// CambioCampo es la diferencia de un campo entre dos revisiones. Los campos
+// anidados se nombran con puntos, p. ej. caracteristicas.color
+type CambioCampo struct {
+	Campo    string `json:"campo"`
+	Anterior any    `json:"anterior"`
+	Nuevo    any    `json:"nuevo"`
+}
+
+// RevisionMaterial es una versión inmutable de un material. Numero coincide
+// con Material.Version tras la escritura y Material guarda el material
+// completo para poder compararlo con cualquier otra revisión o revertirlo
+type RevisionMaterial struct {
+	MaterialID string          `gorm:"primaryKey" json:"material_id"`
+	Numero     int             `gorm:"primaryKey" json:"numero"`
+	Actor      string          `json:"actor"`
+	Fecha      time.Time       `json:"fecha"`
+	Cambios    []CambioCampo   `gorm:"serializer:json" json:"cambios"`
+	Material   json.RawMessage `gorm:"serializer:json" json:"material,omitempty"`
+}
+
+func (RevisionMaterial) TableName() string {
+	return "material_revisiones"
+}
+
+// actorDe devuelve quién hace la petición según la cabecera X-Usuario
+func actorDe(c *gin.Context) string {
+	if actor := strings.TrimSpace(c.GetHeader("X-Usuario")); actor != "" {
+		return actor
+	}
+	return actorAnonimo
+}
+
+// nuevaRevision construye la revisión de una escritura. anterior es nil al
+// crear el material
+func nuevaRevision(anterior *Material, m Material, actor string) RevisionMaterial {
+	// Material no tiene valores que no se puedan serializar
+	despues, _ := json.Marshal(m)
+	var antes json.RawMessage
+	if anterior != nil {
+		antes, _ = json.Marshal(anterior)
+	}
+	return RevisionMaterial{
+		MaterialID: m.ID,
+		Numero:     m.Version,
+		Actor:      actor,
+		Fecha:      time.Now().UTC(),
+		Cambios:    diferencias(antes, despues),
+		Material:   despues,
+	}
+}
+
+// diferencias compara dos documentos JSON campo a campo; un documento vacío
+// equivale a no tener campos
+func diferencias(antes, despues json.RawMessage) []CambioCampo {
+	a, d := aplanarJSON(antes), aplanarJSON(despues)
+	campos := make(map[string]bool)
+	for k := range a {
+		campos[k] = true
+	}
+	for k := range d {
+		campos[k] = true
+	}
+	cambios := []CambioCampo{}
+	for campo := range campos {
+		if camposSinRevision[strings.SplitN(campo, ".", 2)[0]] {
+			continue
+		}
+		if !reflect.DeepEqual(a[campo], d[campo]) {
+			cambios = append(cambios, CambioCampo{Campo: campo, Anterior: a[campo], Nuevo: d[campo]})
+		}
+	}
+	slices.SortFunc(cambios, func(x, y CambioCampo) int { return strings.Compare(x.Campo, y.Campo) })
+	return cambios
+}
+
+// aplanarJSON convierte un objeto JSON en un mapa de rutas con puntos a
+// valores. Solo recibe documentos generados por nuevaRevision
+func aplanarJSON(doc json.RawMessage) map[string]any {
+	plano := make(map[string]any)
+	var raiz map[string]any
+	if len(doc) == 0 || json.Unmarshal(doc, &raiz) != nil {
+		return plano
+	}
+	var recorrer func(prefijo string, obj map[string]any)
+	recorrer = func(prefijo string, obj map[string]any) {
+		for k, v := range obj {
+			if hijo, ok := v.(map[string]any); ok {
+				recorrer(prefijo+k+".", hijo)
+				continue
+			}
+			plano[prefijo+k] = v
+		}
+	}
+	recorrer("", raiz)
+	return plano
+}
+
+// getMaterialRevisions lista las revisiones de un material sin el material completo
+func getMaterialRevisions(c *gin.Context) {
+	revisiones, err := repo.Revisiones(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	for i := range revisiones {
+		revisiones[i].Material = nil
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": revisiones,
+	})
+}
+
+// getMaterialRevision devuelve una revisión con el material completo
+func getMaterialRevision(c *gin.Context) {
+	numero, err := strconv.Atoi(c.Param("rev"))
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "rev debe ser un número de revisión"))
+		return
+	}
+	revision, err := repo.Revision(c.Request.Context(), c.Param("id"), numero)
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": revision,
+	})
+}
+
+// getMaterialRevisionsDiff compara dos revisiones (?desde= y ?hasta=). Sin
+// hasta se usa la última y sin desde la anterior a hasta
+func getMaterialRevisionsDiff(c *gin.Context) {
+	revisiones, err := repo.Revisiones(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	if len(revisiones) == 0 {
+		responderError(c, ErrRevisionNoEncontrada)
+		return
+	}
+
+	buscar := func(param string, porDefecto int) (int, error) {
+		s := c.Query(param)
+		if s == "" {
+			return porDefecto, nil
+		}
+		numero, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, errors.New(param + " debe ser un número de revisión")
+		}
+		i := slices.IndexFunc(revisiones, func(r RevisionMaterial) bool { return r.Numero == numero })
+		if i < 0 {
+			return 0, ErrRevisionNoEncontrada
+		}
+		return i, nil
+	}
+	fallo := func(err error) {
+		if errors.Is(err, ErrRevisionNoEncontrada) {
+			responderError(c, err)
+			return
+		}
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+	}
+	hasta, err := buscar("hasta", len(revisiones)-1)
+	if err != nil {
+		fallo(err)
+		return
+	}
+	desde, err := buscar("desde", hasta-1)
+	if err != nil {
+		fallo(err)
+		return
+	}
+
+	var antes json.RawMessage
+	numeroDesde := 0
+	if desde >= 0 {
+		antes, numeroDesde = revisiones[desde].Material, revisiones[desde].Numero
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"desde":   numeroDesde,
+			"hasta":   revisiones[hasta].Numero,
+			"cambios": diferencias(antes, revisiones[hasta].Material),
+		},
+	})
+}
+
+// revertMaterialRevision guarda como nueva revisión el contenido del
+// material en la revisión indicada. Admite If-Match como PUT
+func revertMaterialRevision(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+	numero, err := strconv.Atoi(c.Param("rev"))
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "rev debe ser un número de revisión"))
+		return
+	}
+
+	revision, err := repo.Revision(c.Request.Context(), c.Param("id"), numero)
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	var material Material
+	if err := json.Unmarshal(revision.Material, &material); err != nil {
+		responderError(c, err)
+		return
+	}
+
+	material.ID = c.Param("id")
+	completarMoneda(&material)
+	if err := validarMaterial(material); err != nil {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, err.Error()))
+		return
+	}
+	if err := repo.Actualizar(c.Request.Context(), &material, version, actorDe(c)); err != nil {
+		responderError(c, err)
+		return
+	}
+	c.Header("ETag", condicional.ETag(material.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": material,
+	})
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	dominio "catalogo-dominio"
+	"catalogo-productos/almacenamiento"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrAdjuntoNoEncontrado se devuelve cuando el producto no tiene el adjunto solicitado
+	ErrAdjuntoNoEncontrado = errors.New("adjunto no encontrado")
+	// ErrAdjuntoDuplicado se devuelve al crear un adjunto con el mismo
+	// contenido que otro del mismo producto
+	ErrAdjuntoDuplicado = errors.New("adjunto duplicado")
+
+	errIncluirDesconocido = errors.New("incluir admite relaciones y adjuntos")
+)
+
+// almacenAdjuntos guarda el contenido de los adjuntos, con su SHA-256 como clave
+var almacenAdjuntos almacenamiento.Almacen
+
+// antiguedadMinimaBarrido protege de la recolección los archivos recién
+// guardados cuyo adjunto aún se está creando
+const antiguedadMinimaBarrido = time.Hour
+
+// tiposAdjunto son los tipos de contenido admitidos, según los detecta
+// http.DetectContentType, y si el navegador puede mostrarlos en línea
+var tiposAdjunto = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      false,
+	"application/zip": false,
+}
+
+// Adjunto es un archivo asociado a un producto: fotos de muestras impresas,
+// instrucciones de montaje en PDF, licencias... Orden es su posición en la
+// galería del producto, empezando en 1
+type Adjunto struct {
+	ID            string    `gorm:"primaryKey" json:"id"`
+	ProductoID    string    `json:"producto_id"`
+	NombreArchivo string    `json:"nombre_archivo"`
+	Titulo        string    `json:"titulo"`
+	TipoContenido string    `json:"tipo_contenido" enums:"image/jpeg,image/png,image/gif,image/webp,application/pdf,text/plain,application/zip"`
+	Tamano        int64     `json:"tamano"`
+	SHA256        string    `gorm:"column:sha256" json:"sha256"`
+	Orden         int       `json:"orden"`
+	SubidoEn      time.Time `json:"subido_en"`
+}
+
+func (Adjunto) TableName() string {
+	return "producto_adjuntos"
+}
+
+// OrdenAdjuntos son los IDs de todos los adjuntos de un producto en el orden
+// de la galería
+type OrdenAdjuntos struct {
+	IDs []string `json:"ids"`
+}
+
+// nuevoAlmacenAdjuntos crea el almacén de ADJUNTOS_ALMACEN ("local" o
+// "memoria") en ADJUNTOS_DIR, por defecto ./adjuntos. Con el repositorio en
+// memoria el almacén predeterminado también es la memoria
+func nuevoAlmacenAdjuntos() (almacenamiento.Almacen, error) {
+	tipo := os.Getenv("ADJUNTOS_ALMACEN")
+	if tipo == "" {
+		tipo = "local"
+		if os.Getenv("REPOSITORIO") == "memoria" {
+			tipo = "memoria"
+		}
+	}
+	dir := os.Getenv("ADJUNTOS_DIR")
+	if dir == "" {
+		dir = "adjuntos"
+	}
+	return almacenamiento.Nuevo(tipo, dir)
+}
+
+// tamanoMaximoAdjunto lee ADJUNTO_TAMANO_MAXIMO_MB; por defecto 20 MB
+func tamanoMaximoAdjunto() int64 {
+	if mb, err := strconv.Atoi(os.Getenv("ADJUNTO_TAMANO_MAXIMO_MB")); err == nil && mb > 0 {
+		return int64(mb) << 20
+	}
+	return 20 << 20
+}
+
+// tipoContenido detecta el tipo del archivo por su contenido, sin
+// parámetros como charset; el nombre y la cabecera del cliente no se usan
+func tipoContenido(datos []byte) string {
+	tipo, _, err := mime.ParseMediaType(http.DetectContentType(datos))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return tipo
+}
+
+// productoIncluido es la respuesta de getProduct con ?incluir=; cada lista
+// solo aparece si se pidió
+type productoIncluido struct {
+	Producto
+	Relaciones *[]Relacion `json:"relaciones,omitempty"`
+	Adjuntos   *[]Adjunto  `json:"adjuntos,omitempty"`
+}
+
+// incluirEnProducto añade a la respuesta de getProduct las listas de
+// ?incluir=relaciones,adjuntos
+func incluirEnProducto(ctx context.Context, producto Producto, incluir string) (productoIncluido, error) {
+	res := productoIncluido{Producto: producto}
+	for _, parte := range strings.Split(incluir, ",") {
+		var err error
+		switch strings.TrimSpace(parte) {
+		case "relaciones":
+			var relaciones []Relacion
+			relaciones, err = repo.ListarRelaciones(ctx, producto.ID)
+			res.Relaciones = &relaciones
+		case "adjuntos":
+			var adjuntos []Adjunto
+			adjuntos, err = repo.ListarAdjuntos(ctx, producto.ID)
+			res.Adjuntos = &adjuntos
+		default:
+			err = fmt.Errorf("%w: %s", errIncluirDesconocido, parte)
+		}
+		if err != nil {
+			return productoIncluido{}, err
+		}
+	}
+	return res, nil
+}
+
+// Adjuntos de un producto
+// @Summary Listar los adjuntos de un producto
+// @Description Lista los adjuntos del producto en el orden de su galería
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Success 200 {object} dominio.Respuesta{data=[]Adjunto}
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /productos/{id}/adjuntos [get]
+func getProductAttachments(c *gin.Context) {
+	adjuntos, err := repo.ListarAdjuntos(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": adjuntos,
+	})
+}
+
+// Subir un adjunto
+// @Summary Subir un adjunto a un producto
+// @Description Recibe un archivo en el campo multipart "archivo", con un título opcional en "titulo". El tipo se detecta por el contenido: se admiten imágenes JPEG, PNG, GIF y WebP, PDF, texto y ZIP. El adjunto se añade al final de la galería. Si el producto ya tiene un adjunto con el mismo contenido se devuelve ese adjunto con 200
+// @Tags productos
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param archivo formData file true "Archivo a adjuntar"
+// @Param titulo formData string false "Título para la galería"
+// @Param Idempotency-Key header string false "Clave única de la operación; un reintento con la misma clave y la misma petición devuelve la respuesta guardada"
+// @Success 201 {object} dominio.Respuesta{data=Adjunto}
+// @Success 200 {object} dominio.Respuesta{data=Adjunto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 413 {object} dominio.RespuestaError
+// @Failure 415 {object} dominio.RespuestaError
+// @Router /productos/{id}/adjuntos [post]
+func uploadProductAttachment(c *gin.Context) {
+	ctx := c.Request.Context()
+	producto, err := repo.Obtener(ctx, c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, tamanoMaximoAdjunto())
+	nombre, datos, err := leerArchivoAdjunto(c)
+	if err != nil {
+		var demasiadoGrande *http.MaxBytesError
+		if errors.As(err, &demasiadoGrande) {
+			responderMensaje(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("El adjunto supera el tamaño máximo de %d MB", demasiadoGrande.Limit>>20))
+			return
+		}
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	tipo := tipoContenido(datos)
+	if _, ok := tiposAdjunto[tipo]; !ok {
+		responderMensaje(c, http.StatusUnsupportedMediaType, fmt.Sprintf("Tipo de archivo no admitido: %s", tipo))
+		return
+	}
+
+	suma := sha256.Sum256(datos)
+	adjunto := Adjunto{
+		ID:            uuid.New().String(),
+		ProductoID:    producto.ID,
+		NombreArchivo: nombre,
+		Titulo:        strings.TrimSpace(c.PostForm("titulo")),
+		TipoContenido: tipo,
+		Tamano:        int64(len(datos)),
+		SHA256:        hex.EncodeToString(suma[:]),
+		SubidoEn:      time.Now().UTC(),
+	}
+	if err := almacenAdjuntos.Guardar(ctx, adjunto.SHA256, bytes.NewReader(datos)); err != nil {
+		log.Printf("No se pudo guardar el adjunto del producto %s: %v", producto.ID, err)
+		responderMensaje(c, http.StatusInternalServerError, traducir(c, "Error al guardar el adjunto"))
+		return
+	}
+	err = repo.CrearAdjunto(ctx, &adjunto)
+	if errors.Is(err, ErrAdjuntoDuplicado) {
+		// El mismo archivo ya está en la galería: se devuelve el existente
+		existente, err := adjuntoPorSHA(ctx, producto.ID, adjunto.SHA256)
+		if err != nil {
+			responderError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": existente,
+		})
+		return
+	}
+	if err != nil {
+		barrerArchivo(ctx, adjunto.SHA256)
+		responderError(c, err)
+		return
+	}
+
+	log.Printf("Adjunto %s (%s, %d bytes) guardado para el producto %s", adjunto.ID, tipo, adjunto.Tamano, producto.ID)
+	c.JSON(http.StatusCreated, gin.H{
+		"data": adjunto,
+	})
+}
+
+// Descargar un adjunto
+// @Summary Descargar un adjunto
+// @Description Devuelve el contenido del adjunto. El contenido de un adjunto no cambia, así que se sirve con ETag (su SHA-256) y caché inmutable de un año; admite If-None-Match y peticiones Range. Las imágenes y los PDF se muestran en línea y el resto se descarga
+// @Tags productos
+// @Produce application/octet-stream
+// @Param id path string true "ID del producto"
+// @Param adjunto path string true "ID del adjunto"
+// @Success 200 {file} file
+// @Success 304
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /productos/{id}/adjuntos/{adjunto} [get]
+func getProductAttachment(c *gin.Context) {
+	ctx := c.Request.Context()
+	adjunto, err := repo.ObtenerAdjunto(ctx, c.Param("id"), c.Param("adjunto"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	contenido, err := almacenAdjuntos.Abrir(ctx, adjunto.SHA256)
+	if err != nil {
+		log.Printf("No se pudo abrir el archivo del adjunto %s: %v", adjunto.ID, err)
+		responderMensaje(c, http.StatusInternalServerError, traducir(c, "Error al leer el adjunto"))
+		return
+	}
+	defer contenido.Close()
+
+	disposicion := "attachment"
+	if tiposAdjunto[adjunto.TipoContenido] {
+		disposicion = "inline"
+	}
+	if adjunto.NombreArchivo != "" {
+		disposicion = mime.FormatMediaType(disposicion, map[string]string{"filename": adjunto.NombreArchivo})
+	}
+	c.Header("Content-Type", adjunto.TipoContenido)
+	c.Header("Content-Disposition", disposicion)
+	c.Header("ETag", `"`+adjunto.SHA256+`"`)
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("X-Content-Type-Options", "nosniff")
+	http.ServeContent(c.Writer, c.Request, "", adjunto.SubidoEn, contenido)
+}
+
+// Eliminar un adjunto
+// @Summary Eliminar un adjunto de un producto
+// @Description Quita el adjunto de la galería. El archivo se borra del almacén si ningún otro adjunto lo usa y no se acaba de volver a subir
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param adjunto path string true "ID del adjunto"
+// @Success 200 {object} dominio.Mensaje
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /productos/{id}/adjuntos/{adjunto} [delete]
+func deleteProductAttachment(c *gin.Context) {
+	ctx := c.Request.Context()
+	adjunto, err := repo.ObtenerAdjunto(ctx, c.Param("id"), c.Param("adjunto"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	if err := repo.EliminarAdjunto(ctx, adjunto.ProductoID, adjunto.ID); err != nil {
+		responderError(c, err)
+		return
+	}
+	barrerArchivo(ctx, adjunto.SHA256)
+	c.JSON(http.StatusOK, gin.H{
+		"message": traducir(c, "Adjunto eliminado"),
+	})
+}
+
+// Ordenar la galería
+// @Summary Ordenar los adjuntos de un producto
+// @Description Fija el orden de la galería. ids debe contener exactamente los IDs de todos los adjuntos del producto, en el orden deseado
+// @Tags productos
+// @Accept json
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param orden body OrdenAdjuntos true "IDs de los adjuntos en orden"
+// @Success 200 {object} dominio.Respuesta{data=[]Adjunto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /productos/{id}/adjuntos/orden [put]
+func sortProductAttachments(c *gin.Context) {
+	var cuerpo OrdenAdjuntos
+	if err := c.ShouldBindJSON(&cuerpo); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	adjuntos, err := repo.OrdenarAdjuntos(c.Request.Context(), c.Param("id"), cuerpo.IDs)
+	var errs dominio.ErroresValidacion
+	if errors.As(err, &errs) {
+		responderValidacion(c, "Orden de adjuntos inválido", errs)
+		return
+	}
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": adjuntos,
+	})
+}
+
+// validarOrdenAdjuntos comprueba que ids es una permutación de los adjuntos
+func validarOrdenAdjuntos(adjuntos []Adjunto, ids []string) dominio.ErroresValidacion {
+	var errs dominio.ErroresValidacion
+	if len(ids) != len(adjuntos) {
+		errs.Agregar("ids", fmt.Sprintf("debe incluir los %d adjuntos del producto", len(adjuntos)))
+		return errs
+	}
+	vistos := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		campo := fmt.Sprintf("ids.%d", i)
+		switch {
+		case vistos[id]:
+			errs.Agregar(campo, "está repetido")
+		case !slices.ContainsFunc(adjuntos, func(a Adjunto) bool { return a.ID == id }):
+			errs.Agregar(campo, "no es un adjunto del producto")
+		}
+		vistos[id] = true
+	}
+	return errs
+}
+
+// leerArchivoAdjunto obtiene el archivo del campo multipart "archivo"
+func leerArchivoAdjunto(c *gin.Context) (string, []byte, error) {
+	fh, err := c.FormFile("archivo")
+	if err != nil {
+		return "", nil, err
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	datos, err := io.ReadAll(f)
+	if err == nil && len(datos) == 0 {
+		err = errors.New("el archivo está vacío")
+	}
+	return fh.Filename, datos, err
+}
+
+func adjuntoPorSHA(ctx context.Context, productoID, sha string) (Adjunto, error) {
+	adjuntos, err := repo.ListarAdjuntos(ctx, productoID)
+	if err != nil {
+		return Adjunto{}, err
+	}
+	i := slices.IndexFunc(adjuntos, func(a Adjunto) bool { return a.SHA256 == sha })
+	if i < 0 {
+		return Adjunto{}, ErrAdjuntoNoEncontrado
+	}
+	return adjuntos[i], nil
+}
+
+// barrerArchivo borra del almacén el archivo con ese SHA-256 si ya no lo usa
+// ningún adjunto y se guardó hace más de antiguedadMinimaBarrido; si es más
+// reciente, p. ej. porque otra subida del mismo contenido acaba de
+// guardarlo, lo deja para el barrido periódico. Los errores solo se
+// registran: un archivo huérfano lo recoge el siguiente barrido
+func barrerArchivo(ctx context.Context, sha string) {
+	if _, err := barrerObjeto(ctx, sha, time.Now().Add(-antiguedadMinimaBarrido)); err != nil {
+		log.Printf("No se pudo borrar el archivo %s del almacén: %v", sha, err)
+	}
+}
+
+// barrerAlmacen borra los archivos que ya no usa ningún adjunto, p. ej. los
+// de los productos purgados, salvo los guardados hace menos de
+// antiguedadMinimaBarrido. Devuelve cuántos borró
+func barrerAlmacen(ctx context.Context) (int, error) {
+	objetos, err := almacenAdjuntos.Listar(ctx)
+	if err != nil {
+		return 0, err
+	}
+	limite := time.Now().Add(-antiguedadMinimaBarrido)
+	n := 0
+	for _, o := range objetos {
+		if o.ModificadoEn.After(limite) {
+			continue
+		}
+		borrado, err := barrerObjeto(ctx, o.Clave, limite)
+		if err != nil {
+			return n, err
+		}
+		if borrado {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// barrerObjeto borra el archivo si ningún adjunto lo usa y no se modificó
+// después de limite. La fecha se consulta justo antes de borrar, después de
+// comprobar que no está en uso: una subida que lo vuelve a guardar mientras
+// tanto actualiza la fecha antes de crear su adjunto, y así lo conserva
+func barrerObjeto(ctx context.Context, clave string, limite time.Time) (bool, error) {
+	enUso, err := repo.AdjuntoEnUso(ctx, clave)
+	if err != nil || enUso {
+		return false, err
+	}
+	o, err := almacenAdjuntos.Describir(ctx, clave)
+	if errors.Is(err, almacenamiento.ErrNoEncontrado) {
+		return false, nil
+	}
+	if err != nil || o.ModificadoEn.After(limite) {
+		return false, err
+	}
+	err = almacenAdjuntos.Eliminar(ctx, clave)
+	if errors.Is(err, almacenamiento.ErrNoEncontrado) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// purgarConAdjuntos purga la papelera y después barre del almacén los
+// archivos que ya no usa ningún adjunto: los de los productos purgados y los
+// que barrerArchivo dejó por ser demasiado recientes
+func purgarConAdjuntos(ctx context.Context, limite time.Time) (int64, error) {
+	n, err := repo.Purgar(ctx, limite)
+	if err != nil {
+		return n, err
+	}
+	if barridos, err := barrerAlmacen(ctx); err != nil {
+		log.Printf("Error al barrer el almacén de adjuntos: %v", err)
+	} else if barridos > 0 {
+		log.Printf("Almacén de adjuntos: %d archivos sin usar borrados", barridos)
+	}
+	return n, nil
+}
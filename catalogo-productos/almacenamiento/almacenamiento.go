@@ -0,0 +1,252 @@
+// Package almacenamiento guarda archivos binarios por clave. Los adjuntos de
+// los productos usan como clave el SHA-256 de su contenido, de modo que un
+// mismo archivo se guarda una sola vez aunque lo usen varios productos.
+//
+// Almacen es la interfaz que implementa cada backend: por ahora el sistema
+// de archivos local y la memoria; un backend compatible con S3 solo tiene que
+// implementar los mismos métodos.
+package almacenamiento
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoEncontrado se devuelve al abrir o eliminar una clave que no existe
+	ErrNoEncontrado = errors.New("archivo no encontrado en el almacén")
+	// ErrClaveInvalida se devuelve con claves que no son identificadores
+	// hexadecimales, para que ninguna clave pueda salir del almacén
+	ErrClaveInvalida = errors.New("clave de almacén inválida")
+)
+
+// Almacen guarda y recupera archivos por clave. Guardar sobre una clave que
+// ya existe no cambia su contenido, pero sí actualiza su fecha de
+// modificación, para que el barrido de archivos sin usar no se lleve uno que
+// se acaba de volver a subir
+type Almacen interface {
+	Guardar(ctx context.Context, clave string, r io.Reader) error
+	Abrir(ctx context.Context, clave string) (io.ReadSeekCloser, error)
+	Eliminar(ctx context.Context, clave string) error
+	// Describir devuelve el tamaño y la fecha de modificación de un archivo
+	Describir(ctx context.Context, clave string) (Objeto, error)
+	// Listar devuelve todos los archivos guardados, para recolectar los que
+	// ya no usa ningún adjunto
+	Listar(ctx context.Context) ([]Objeto, error)
+}
+
+// Objeto describe un archivo guardado
+type Objeto struct {
+	Clave        string
+	Tamano       int64
+	ModificadoEn time.Time
+}
+
+// Nuevo crea el almacén indicado por tipo: "local" guarda los archivos bajo
+// dir y "memoria" no los conserva entre reinicios
+func Nuevo(tipo, dir string) (Almacen, error) {
+	switch tipo {
+	case "local":
+		return NuevoLocal(dir)
+	case "memoria":
+		return NuevoMemoria(), nil
+	case "s3":
+		return nil, errors.New("el almacén s3 aún no está disponible")
+	}
+	return nil, fmt.Errorf("almacén desconocido: %s", tipo)
+}
+
+var patronClave = regexp.MustCompile(`^[0-9a-f]{8,128}$`)
+
+func validarClave(clave string) error {
+	if !patronClave.MatchString(clave) {
+		return ErrClaveInvalida
+	}
+	return nil
+}
+
+// Local guarda cada archivo en dir/<2 primeros caracteres>/<clave>
+type Local struct {
+	dir string
+}
+
+// NuevoLocal crea el directorio del almacén si no existe
+func NuevoLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Local{dir: dir}, nil
+}
+
+func (l *Local) ruta(clave string) string {
+	return filepath.Join(l.dir, clave[:2], clave)
+}
+
+// Guardar escribe en un archivo temporal y lo renombra para que una lectura
+// concurrente nunca vea un archivo a medias. Si el archivo ya existe solo
+// actualiza su fecha de modificación
+func (l *Local) Guardar(ctx context.Context, clave string, r io.Reader) error {
+	if err := validarClave(clave); err != nil {
+		return err
+	}
+	ruta := l.ruta(clave)
+	ahora := time.Now()
+	if err := os.Chtimes(ruta, ahora, ahora); !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ruta), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(ruta), ".subida-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ruta)
+}
+
+func (l *Local) Abrir(ctx context.Context, clave string) (io.ReadSeekCloser, error) {
+	if err := validarClave(clave); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(l.ruta(clave))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoEncontrado
+	}
+	return f, err
+}
+
+func (l *Local) Eliminar(ctx context.Context, clave string) error {
+	if err := validarClave(clave); err != nil {
+		return err
+	}
+	err := os.Remove(l.ruta(clave))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNoEncontrado
+	}
+	return err
+}
+
+func (l *Local) Describir(ctx context.Context, clave string) (Objeto, error) {
+	if err := validarClave(clave); err != nil {
+		return Objeto{}, err
+	}
+	info, err := os.Stat(l.ruta(clave))
+	if errors.Is(err, os.ErrNotExist) {
+		return Objeto{}, ErrNoEncontrado
+	}
+	if err != nil {
+		return Objeto{}, err
+	}
+	return Objeto{Clave: clave, Tamano: info.Size(), ModificadoEn: info.ModTime()}, nil
+}
+
+func (l *Local) Listar(ctx context.Context) ([]Objeto, error) {
+	var objetos []Objeto
+	err := filepath.WalkDir(l.dir, func(ruta string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !patronClave.MatchString(d.Name()) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objetos = append(objetos, Objeto{Clave: d.Name(), Tamano: info.Size(), ModificadoEn: info.ModTime()})
+		return nil
+	})
+	return objetos, err
+}
+
+// Memoria guarda los archivos en memoria; se usa con el repositorio en
+// memoria y en desarrollo local. Es seguro para uso concurrente
+type Memoria struct {
+	mu       sync.RWMutex
+	archivos map[string]archivoMemoria
+}
+
+type archivoMemoria struct {
+	datos        []byte
+	modificadoEn time.Time
+}
+
+func NuevoMemoria() *Memoria {
+	return &Memoria{archivos: make(map[string]archivoMemoria)}
+}
+
+func (m *Memoria) Guardar(ctx context.Context, clave string, r io.Reader) error {
+	if err := validarClave(clave); err != nil {
+		return err
+	}
+	datos, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a, ok := m.archivos[clave]; ok {
+		datos = a.datos
+	}
+	m.archivos[clave] = archivoMemoria{datos: datos, modificadoEn: time.Now()}
+	return nil
+}
+
+func (m *Memoria) Abrir(ctx context.Context, clave string) (io.ReadSeekCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.archivos[clave]
+	if !ok {
+		return nil, ErrNoEncontrado
+	}
+	return nopCloser{bytes.NewReader(a.datos)}, nil
+}
+
+func (m *Memoria) Eliminar(ctx context.Context, clave string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.archivos[clave]; !ok {
+		return ErrNoEncontrado
+	}
+	delete(m.archivos, clave)
+	return nil
+}
+
+func (m *Memoria) Describir(ctx context.Context, clave string) (Objeto, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.archivos[clave]
+	if !ok {
+		return Objeto{}, ErrNoEncontrado
+	}
+	return Objeto{Clave: clave, Tamano: int64(len(a.datos)), ModificadoEn: a.modificadoEn}, nil
+}
+
+func (m *Memoria) Listar(ctx context.Context) ([]Objeto, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	objetos := make([]Objeto, 0, len(m.archivos))
+	for clave, a := range m.archivos {
+		objetos = append(objetos, Objeto{Clave: clave, Tamano: int64(len(a.datos)), ModificadoEn: a.modificadoEn})
+	}
+	return objetos, nil
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
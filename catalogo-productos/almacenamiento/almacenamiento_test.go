@@ -0,0 +1,79 @@
+package almacenamiento
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const clavePrueba = "0123456789abcdef"
+
+// almacenPrueba es un almacén con una forma de retrasar la fecha de
+// modificación de una clave
+type almacenPrueba struct {
+	Almacen
+	envejecer func(antes time.Time)
+}
+
+// TestGuardarExistente comprueba que guardar una clave que ya existe no
+// cambia su contenido pero sí su fecha de modificación
+func TestGuardarExistente(t *testing.T) {
+	local, err := NuevoLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	memoria := NuevoMemoria()
+	almacenes := map[string]almacenPrueba{
+		"local": {local, func(antes time.Time) {
+			if err := os.Chtimes(local.ruta(clavePrueba), antes, antes); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		"memoria": {memoria, func(antes time.Time) {
+			a := memoria.archivos[clavePrueba]
+			a.modificadoEn = antes
+			memoria.archivos[clavePrueba] = a
+		}},
+	}
+
+	ctx := context.Background()
+	for nombre, a := range almacenes {
+		t.Run(nombre, func(t *testing.T) {
+			if err := a.Guardar(ctx, clavePrueba, strings.NewReader("original")); err != nil {
+				t.Fatal(err)
+			}
+			antes := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+			a.envejecer(antes)
+			if o, err := a.Describir(ctx, clavePrueba); err != nil || !o.ModificadoEn.Equal(antes) {
+				t.Fatalf("Describir = %+v, %v; se esperaba modificado en %v", o, err, antes)
+			}
+
+			if err := a.Guardar(ctx, clavePrueba, strings.NewReader("otro")); err != nil {
+				t.Fatal(err)
+			}
+			o, err := a.Describir(ctx, clavePrueba)
+			if err != nil || time.Since(o.ModificadoEn) > time.Minute || o.Tamano != int64(len("original")) {
+				t.Errorf("Describir tras volver a guardar = %+v, %v", o, err)
+			}
+			f, err := a.Abrir(ctx, clavePrueba)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if datos, err := io.ReadAll(f); err != nil || string(datos) != "original" {
+				t.Errorf("contenido %q, %v; se esperaba el original", datos, err)
+			}
+
+			if err := a.Eliminar(ctx, clavePrueba); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.Describir(ctx, clavePrueba); !errors.Is(err, ErrNoEncontrado) {
+				t.Errorf("Describir de una clave eliminada: %v", err)
+			}
+		})
+	}
+}
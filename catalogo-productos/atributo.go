@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"catalogo-comun/condicional"
+	"catalogo-comun/listado"
+	dominio "catalogo-dominio"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrAtributoNoEncontrado se devuelve cuando no existe un atributo con el código solicitado
+	ErrAtributoNoEncontrado = errors.New("atributo no encontrado")
+	// ErrAtributoDuplicado se devuelve al crear un atributo con un código ya usado
+	ErrAtributoDuplicado = errors.New("el código de atributo ya está en uso")
+	// ErrAtributoEnUso se devuelve al eliminar un atributo que algún producto tiene
+	ErrAtributoEnUso = errors.New("el atributo tiene valores en algún producto")
+)
+
+// TipoAtributo es el tipo de los valores de un atributo
+type TipoAtributo string
+
+const (
+	AtributoTexto    TipoAtributo = "texto"
+	AtributoNumero   TipoAtributo = "numero"
+	AtributoEnum     TipoAtributo = "enum"
+	AtributoBooleano TipoAtributo = "booleano"
+)
+
+// longitudMaximaEtiqueta limita cada etiqueta libre de un producto
+const longitudMaximaEtiqueta = 50
+
+// patronCodigoAtributo es el formato del código de un atributo; se usa en
+// los filtros atributo.<codigo>, así que no admite puntos
+var patronCodigoAtributo = regexp.MustCompile(`^[a-z0-9]+(_[a-z0-9]+)*$`)
+
+// DefinicionAtributo describe un dato de producto que Producto no tiene como
+// campo propio, p. ej. la rosca o la carga máxima. Los productos guardan su
+// valor en Producto.Atributos bajo Codigo.
+//
+// Categorias limita el atributo a los productos de esas categorías y sus
+// subcategorías; vacío significa todas. Unidad solo se usa con números y
+// Opciones solo con enumerados. El código y el tipo no cambian una vez creado
+type DefinicionAtributo struct {
+	Codigo     string       `gorm:"primaryKey" json:"codigo"`
+	Nombre     string       `json:"nombre"`
+	Tipo       TipoAtributo `json:"tipo" enums:"texto,numero,enum,booleano"`
+	Unidad     string       `json:"unidad,omitempty"`
+	Opciones   []string     `gorm:"serializer:json" json:"opciones,omitempty"`
+	Categorias []string     `gorm:"serializer:json" json:"categorias"`
+	Version    int          `json:"version"`
+}
+
+func (DefinicionAtributo) TableName() string {
+	return "atributos"
+}
+
+var ordenablesAtributos = map[string]func(DefinicionAtributo) any{
+	// id es el desempate que agrega listado.Parse; el código identifica al atributo
+	"id":     func(d DefinicionAtributo) any { return d.Codigo },
+	"codigo": func(d DefinicionAtributo) any { return d.Codigo },
+	"nombre": func(d DefinicionAtributo) any { return d.Nombre },
+	"tipo":   func(d DefinicionAtributo) any { return string(d.Tipo) },
+}
+
+var camposAtributos = map[string]bool{
+	"codigo":     true,
+	"nombre":     true,
+	"tipo":       true,
+	"unidad":     true,
+	"opciones":   true,
+	"categorias": true,
+	"version":    true,
+}
+
+// aplicaA indica si el atributo se puede usar en una categoría, dada la
+// cadena de la categoría y sus ancestros
+func (d DefinicionAtributo) aplicaA(ancestros []string) bool {
+	if len(d.Categorias) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(d.Categorias, func(id string) bool { return slices.Contains(ancestros, id) })
+}
+
+// validarValor comprueba que un valor sea del tipo del atributo y devuelve
+// por qué no lo es
+func (d DefinicionAtributo) validarValor(valor any) string {
+	switch d.Tipo {
+	case AtributoTexto:
+		if s, ok := valor.(string); !ok || strings.TrimSpace(s) == "" {
+			return "debe ser un texto no vacío"
+		}
+	case AtributoNumero:
+		if _, ok := valor.(float64); !ok {
+			return "debe ser un número"
+		}
+	case AtributoEnum:
+		if s, ok := valor.(string); !ok || !slices.Contains(d.Opciones, s) {
+			return "debe ser uno de: " + strings.Join(d.Opciones, ", ")
+		}
+	case AtributoBooleano:
+		if _, ok := valor.(bool); !ok {
+			return "debe ser true o false"
+		}
+	}
+	return ""
+}
+
+// interpretarValor convierte el texto de un filtro al tipo del atributo
+func (d DefinicionAtributo) interpretarValor(texto string) (any, error) {
+	switch d.Tipo {
+	case AtributoNumero:
+		f, err := strconv.ParseFloat(texto, 64)
+		if err != nil {
+			return nil, fmt.Errorf("atributo.%s debe ser un número", d.Codigo)
+		}
+		return f, nil
+	case AtributoBooleano:
+		b, err := strconv.ParseBool(texto)
+		if err != nil {
+			return nil, fmt.Errorf("atributo.%s debe ser true o false", d.Codigo)
+		}
+		return b, nil
+	}
+	return texto, nil
+}
+
+// validarDefinicionAtributo aplica las reglas de una definición frente a las
+// categorías existentes
+func validarDefinicionAtributo(d DefinicionAtributo, categorias []Categoria) error {
+	var errs dominio.ErroresValidacion
+
+	if !patronCodigoAtributo.MatchString(d.Codigo) {
+		errs.Agregar("codigo", "solo admite minúsculas, números y guiones bajos")
+	}
+	if strings.TrimSpace(d.Nombre) == "" {
+		errs.Agregar("nombre", "es obligatorio")
+	}
+	switch d.Tipo {
+	case AtributoTexto, AtributoNumero, AtributoEnum, AtributoBooleano:
+	default:
+		errs.Agregar("tipo", fmt.Sprintf("debe ser %q, %q, %q o %q", AtributoTexto, AtributoNumero, AtributoEnum, AtributoBooleano))
+	}
+	if d.Unidad != "" && d.Tipo != AtributoNumero {
+		errs.Agregar("unidad", "solo se admite en atributos numéricos")
+	}
+	if d.Tipo == AtributoEnum && len(d.Opciones) == 0 {
+		errs.Agregar("opciones", "es obligatorio en los enumerados")
+	}
+	if d.Tipo != AtributoEnum && len(d.Opciones) > 0 {
+		errs.Agregar("opciones", "solo se admite en enumerados")
+	}
+	for _, id := range d.Categorias {
+		if !slices.ContainsFunc(categorias, func(c Categoria) bool { return c.ID == id }) {
+			errs.Agregar("categorias", "no existe la categoría "+id)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ancestros devuelve el ID de la categoría y los de todas sus ancestras
+func ancestros(todas []Categoria, id string) []string {
+	padres := make(map[string]string, len(todas))
+	for _, c := range todas {
+		if c.PadreID != nil {
+			padres[c.ID] = *c.PadreID
+		}
+	}
+	cadena := []string{id}
+	for padre, ok := padres[id]; ok && !slices.Contains(cadena, padre); padre, ok = padres[padre] {
+		cadena = append(cadena, padre)
+	}
+	return cadena
+}
+
+// normalizarEtiquetas deja las etiquetas en minúsculas, sin espacios en los
+// extremos, sin repetir y ordenadas. Una lista nula se conserva para que un
+// PUT sin etiquetas mantenga las existentes
+func normalizarEtiquetas(etiquetas []string) []string {
+	if etiquetas == nil {
+		return nil
+	}
+	normalizadas := []string{}
+	for _, e := range etiquetas {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			normalizadas = append(normalizadas, e)
+		}
+	}
+	slices.Sort(normalizadas)
+	return slices.Compact(normalizadas)
+}
+
+// validarEtiquetas comprueba las etiquetas de un producto; las comas no se
+// admiten porque separan etiquetas en los filtros
+func validarEtiquetas(etiquetas []string, errs *dominio.ErroresValidacion) {
+	for _, e := range etiquetas {
+		if len(e) > longitudMaximaEtiqueta || strings.Contains(e, ",") {
+			errs.Agregar("etiquetas", fmt.Sprintf("cada etiqueta debe tener como máximo %d caracteres y ninguna coma", longitudMaximaEtiqueta))
+			return
+		}
+	}
+}
+
+// comprobarAtributos valida los atributos de un producto contra el registro:
+// cada uno debe estar definido, aplicar a la categoría del producto y tener
+// un valor de su tipo
+func comprobarAtributos(ctx context.Context, p Producto) error {
+	if len(p.Atributos) == 0 {
+		return nil
+	}
+	definiciones, err := repo.ListarAtributos(ctx)
+	if err != nil {
+		return err
+	}
+	categorias, err := repo.ListarCategorias(ctx)
+	if err != nil {
+		return err
+	}
+	if errs := validarAtributos(p, definiciones, categorias); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validarAtributos(p Producto, definiciones []DefinicionAtributo, categorias []Categoria) dominio.ErroresValidacion {
+	var errs dominio.ErroresValidacion
+	cadena := ancestros(categorias, p.CategoriaID)
+	for _, codigo := range slices.Sorted(maps.Keys(p.Atributos)) {
+		campo := "atributos." + codigo
+		i := slices.IndexFunc(definiciones, func(d DefinicionAtributo) bool { return d.Codigo == codigo })
+		switch {
+		case i < 0:
+			errs.Agregar(campo, "no existe")
+		case !definiciones[i].aplicaA(cadena):
+			errs.Agregar(campo, "no aplica a la categoría del producto")
+		default:
+			if msg := definiciones[i].validarValor(p.Atributos[codigo]); msg != "" {
+				errs.Agregar(campo, msg)
+			}
+		}
+	}
+	return errs
+}
+
+// FiltroAtributo filtra productos por el valor de un atributo: Valor exige
+// igualdad y Min y Max acotan los atributos numéricos. Los productos sin el
+// atributo no cumplen ningún filtro
+type FiltroAtributo struct {
+	Codigo string
+	Valor  any
+	Min    *float64
+	Max    *float64
+}
+
+// cumple indica si el producto tiene el atributo con un valor admitido por el filtro
+func (f FiltroAtributo) cumple(p Producto) bool {
+	valor, ok := p.Atributos[f.Codigo]
+	if !ok {
+		return false
+	}
+	if f.Valor != nil && valor != f.Valor {
+		return false
+	}
+	if f.Min != nil || f.Max != nil {
+		n, ok := valor.(float64)
+		if !ok || f.Min != nil && n < *f.Min || f.Max != nil && n > *f.Max {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFiltrosProducto lee los filtros ?etiqueta= (repetible o separado por
+// comas; el producto debe tener todas) y ?atributo.<codigo>=, con
+// atributo.<codigo>.min y .max para los numéricos
+func parseFiltrosProducto(ctx context.Context, query url.Values) ([]string, []FiltroAtributo, error) {
+	var etiquetas []string
+	for _, valor := range query["etiqueta"] {
+		etiquetas = append(etiquetas, strings.Split(valor, ",")...)
+	}
+	etiquetas = normalizarEtiquetas(etiquetas)
+
+	var claves []string
+	for clave := range query {
+		if strings.HasPrefix(clave, "atributo.") {
+			claves = append(claves, clave)
+		}
+	}
+	if len(claves) == 0 {
+		return etiquetas, nil, nil
+	}
+	definiciones, err := repo.ListarAtributos(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filtros := make(map[string]*FiltroAtributo)
+	var orden []string
+	slices.Sort(claves)
+	for _, clave := range claves {
+		codigo, limite, _ := strings.Cut(strings.TrimPrefix(clave, "atributo."), ".")
+		i := slices.IndexFunc(definiciones, func(d DefinicionAtributo) bool { return d.Codigo == codigo })
+		if i < 0 {
+			return nil, nil, fmt.Errorf("atributo desconocido: %s", codigo)
+		}
+		def := definiciones[i]
+		f, ok := filtros[codigo]
+		if !ok {
+			f = &FiltroAtributo{Codigo: codigo}
+			filtros[codigo] = f
+			orden = append(orden, codigo)
+		}
+
+		texto := query.Get(clave)
+		switch limite {
+		case "":
+			if f.Valor, err = def.interpretarValor(texto); err != nil {
+				return nil, nil, err
+			}
+		case "min", "max":
+			if def.Tipo != AtributoNumero {
+				return nil, nil, fmt.Errorf("%s solo se admite en atributos numéricos", clave)
+			}
+			n, err := strconv.ParseFloat(texto, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s debe ser un número", clave)
+			}
+			if limite == "min" {
+				f.Min = &n
+			} else {
+				f.Max = &n
+			}
+		default:
+			return nil, nil, fmt.Errorf("filtro no soportado: %s", clave)
+		}
+	}
+
+	atributos := make([]FiltroAtributo, len(orden))
+	for i, codigo := range orden {
+		atributos[i] = *filtros[codigo]
+	}
+	return etiquetas, atributos, nil
+}
+
+// Obtener atributos
+// @Summary Listar las definiciones de atributos
+// @Description Obtiene una página de atributos ordenados por código. Con categoria_id solo devuelve los que se pueden usar en los productos de esa categoría, incluidos los heredados de sus ancestras y los generales
+// @Tags atributos
+// @Produce json
+// @Param categoria_id query string false "ID de la categoría"
+// @Param page query int false "Número de página, empieza en 1"
+// @Param page_size query int false "Tamaño de página (máximo 100)"
+// @Param cursor query string false "Cursor devuelto en meta.next_cursor o meta.prev_cursor"
+// @Param sort query string false "Campos de orden separados por coma, con - para descendente"
+// @Param fields query string false "Campos a incluir separados por coma"
+// @Success 200 {object} dominio.Pagina{data=[]DefinicionAtributo}
+// @Failure 400 {object} dominio.RespuestaError
+// @Router /atributos [get]
+func getAtributos(c *gin.Context) {
+	params, err := listado.ParseOrdenado(c, "codigo", ordenablesAtributos, camposAtributos)
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	definiciones, err := repo.ListarAtributos(c.Request.Context())
+	if err != nil {
+		responderErrorAtributo(c, err)
+		return
+	}
+	if id := c.Query("categoria_id"); id != "" {
+		categorias, err := repo.ListarCategorias(c.Request.Context())
+		if err != nil {
+			responderErrorAtributo(c, err)
+			return
+		}
+		cadena := ancestros(categorias, id)
+		definiciones = slices.DeleteFunc(definiciones, func(d DefinicionAtributo) bool { return !d.aplicaA(cadena) })
+	}
+
+	pagina, res := listado.EnMemoria(definiciones, params.Orden, params.Ventana, ordenablesAtributos)
+	responderPagina(c, pagina, res, params.Ventana, params.Campos)
+}
+
+// Obtener un atributo
+// @Summary Obtener la definición de un atributo
+// @Tags atributos
+// @Produce json
+// @Param codigo path string true "Código del atributo"
+// @Success 200 {object} dominio.Respuesta{data=DefinicionAtributo}
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /atributos/{codigo} [get]
+func getAtributo(c *gin.Context) {
+	def, err := repo.ObtenerAtributo(c.Request.Context(), c.Param("codigo"))
+	if err != nil {
+		responderErrorAtributo(c, err)
+		return
+	}
+
+	c.Header("ETag", condicional.ETag(def.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": def,
+	})
+}
+
+// Crear un atributo
+// @Summary Crear la definición de un atributo
+// @Description Registra un atributo tipado (texto, numero con unidad, enum con opciones o booleano), opcionalmente limitado a unas categorías y sus subcategorías
+// @Tags atributos
+// @Accept json
+// @Produce json
+// @Param atributo body DefinicionAtributo true "Atributo a crear"
+// @Param Idempotency-Key header string false "Clave única de la operación; un reintento con la misma clave y la misma petición devuelve la respuesta guardada"
+// @Success 201 {object} dominio.Respuesta{data=DefinicionAtributo}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 409 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /atributos [post]
+func createAtributo(c *gin.Context) {
+	var def DefinicionAtributo
+	if err := c.ShouldBindJSON(&def); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if def.Categorias == nil {
+		def.Categorias = []string{}
+	}
+	categorias, err := repo.ListarCategorias(c.Request.Context())
+	if err != nil {
+		responderErrorAtributo(c, err)
+		return
+	}
+	if err := validarDefinicionAtributo(def, categorias); err != nil {
+		responderErrorAtributo(c, err)
+		return
+	}
+
+	if err := repo.CrearAtributo(c.Request.Context(), &def); err != nil {
+		responderErrorAtributo(c, err)
+		return
+	}
+
+	c.Header("ETag", condicional.ETag(def.Version))
+	c.JSON(http.StatusCreated, gin.H{
+		"data": def,
+	})
+}
+
+// Actualizar un atributo
+// @Summary Actualizar la definición de un atributo
+// @Description Reemplaza nombre, unidad, opciones y categorías. El código y el tipo no se pueden cambiar; los valores que dejen de ser válidos se rechazan en la siguiente escritura del producto
+// @Tags atributos
+// @Accept json
+// @Produce json
+// @Param codigo path string true "Código del atributo"
+// @Param If-Match header string false "ETag obtenido al leer el atributo"
+// @Param atributo body DefinicionAtributo true "Atributo actualizado"
+// @Success 200 {object} dominio.Respuesta{data=DefinicionAtributo}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /atributos/{codigo} [put]
+func updateAtributo(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderErrorAtributo(c, ErrConflictoVersion)
+		return
+	}
+
+	var def DefinicionAtributo
+	if err := c.ShouldBindJSON(&def); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	def.Codigo = c.Param("codigo")
+
+	actual, err := repo.ObtenerAtributo(c.Request.Context(), def.Codigo)
+	if err != nil {
+		responderErrorAtributo(c, err)
+		return
+	}
+	if def.Tipo == "" {
+		def.Tipo = actual.Tipo
+	}
+	if def.Tipo != actual.Tipo {
+		responderErrorAtributo(c, dominio.ErroresValidacion{{Campo: "tipo", Mensaje: "no se puede cambiar"}})
+		return
+	}
+	if def.Categorias == nil {
+		def.Categorias = []string{}
+	}
+	categorias, err := repo.ListarCategorias(c.Request.Context())
+	if err != nil {
+		responderErrorAtributo(c, err)
+		return
+	}
+	if err := validarDefinicionAtributo(def, categorias); err != nil {
+		responderErrorAtributo(c, err)
+		return
+	}
+
+	if err := repo.ActualizarAtributo(c.Request.Context(), &def, version); err != nil {
+		responderErrorAtributo(c, err)
+		return
+	}
+
+	c.Header("ETag", condicional.ETag(def.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": def,
+	})
+}
+
+// Eliminar un atributo
+// @Summary Eliminar la definición de un atributo
+// @Description Elimina un atributo que ningún producto, tampoco en la papelera, tiene
+// @Tags atributos
+// @Produce json
+// @Param codigo path string true "Código del atributo"
+// @Param If-Match header string false "ETag obtenido al leer el atributo"
+// @Success 200 {object} dominio.Mensaje
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 409 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Router /atributos/{codigo} [delete]
+func deleteAtributo(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderErrorAtributo(c, ErrConflictoVersion)
+		return
+	}
+
+	if err := repo.EliminarAtributo(c.Request.Context(), c.Param("codigo"), version); err != nil {
+		responderErrorAtributo(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": traducir(c, "Atributo eliminado"),
+	})
+}
+
+// responderErrorAtributo es responderError con el mensaje de validación
+// propio de los atributos
+func responderErrorAtributo(c *gin.Context, err error) {
+	var errsValidacion dominio.ErroresValidacion
+	if errors.As(err, &errsValidacion) {
+		responderValidacion(c, "Atributo inválido", errsValidacion)
+		return
+	}
+	responderError(c, err)
+}
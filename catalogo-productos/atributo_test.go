@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	dominio "catalogo-dominio"
+)
+
+// crearAtributoPrueba define un atributo y falla el test si no se crea
+func crearAtributoPrueba(t *testing.T, srv *httptest.Server, def map[string]any) {
+	t.Helper()
+	if estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/atributos", def); estado != http.StatusCreated {
+		t.Fatalf("crear atributo %v: %d %s", def["codigo"], estado, cuerpo)
+	}
+}
+
+// nombresProductos devuelve los nombres de los productos listados con esa consulta
+func nombresProductos(t *testing.T, srv *httptest.Server, consulta string) (int, []string) {
+	t.Helper()
+	estado, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/productos?"+consulta, nil)
+	if estado != http.StatusOK {
+		return estado, nil
+	}
+	var nombres []string
+	for _, p := range datos[[]Producto](t, cuerpo) {
+		nombres = append(nombres, p.Nombre)
+	}
+	slices.Sort(nombres)
+	return estado, nombres
+}
+
+// camposConError devuelve los campos con error de una respuesta 422
+func camposConError(t *testing.T, cuerpo []byte) []string {
+	t.Helper()
+	var r dominio.RespuestaError
+	if err := json.Unmarshal(cuerpo, &r); err != nil {
+		t.Errorf("respuesta inválida %s: %v", cuerpo, err)
+	}
+	var campos []string
+	for _, e := range r.Errores {
+		campos = append(campos, e.Campo)
+	}
+	return campos
+}
+
+func TestDefinicionesAtributos(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	iluminacion := crearCategoriaPrueba(t, srv, "Iluminación")
+	decoracion := crearCategoriaPrueba(t, srv, "Decoración")
+	crearAtributoPrueba(t, srv, map[string]any{"codigo": "rosca", "nombre": "Rosca", "tipo": "enum", "opciones": []string{"E27", "E14"}, "categorias": []string{iluminacion}})
+	crearAtributoPrueba(t, srv, map[string]any{"codigo": "potencia", "nombre": "Potencia", "tipo": "numero", "unidad": "W"})
+
+	for nombre, caso := range map[string]struct {
+		def    map[string]any
+		estado int
+		campo  string
+	}{
+		"código con punto":      {map[string]any{"codigo": "rosca.x", "nombre": "Rosca", "tipo": "texto"}, http.StatusUnprocessableEntity, "codigo"},
+		"tipo desconocido":      {map[string]any{"codigo": "color", "nombre": "Color", "tipo": "fecha"}, http.StatusUnprocessableEntity, "tipo"},
+		"enum sin opciones":     {map[string]any{"codigo": "color", "nombre": "Color", "tipo": "enum"}, http.StatusUnprocessableEntity, "opciones"},
+		"unidad en un texto":    {map[string]any{"codigo": "color", "nombre": "Color", "tipo": "texto", "unidad": "cm"}, http.StatusUnprocessableEntity, "unidad"},
+		"categoría inexistente": {map[string]any{"codigo": "color", "nombre": "Color", "tipo": "texto", "categorias": []string{"no-existe"}}, http.StatusUnprocessableEntity, "categorias"},
+		"código ya usado":       {map[string]any{"codigo": "rosca", "nombre": "Rosca", "tipo": "texto"}, http.StatusConflict, ""},
+	} {
+		estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/atributos", caso.def)
+		if estado != caso.estado || caso.campo != "" && !slices.Equal(camposConError(t, cuerpo), []string{caso.campo}) {
+			t.Errorf("%s: %d %s", nombre, estado, cuerpo)
+		}
+	}
+
+	// El tipo no se puede cambiar una vez creado
+	estado, _, cuerpo := peticion(t, srv, http.MethodPut, "/api/v1/atributos/potencia", map[string]any{"nombre": "Potencia", "tipo": "texto"})
+	if estado != http.StatusUnprocessableEntity || !slices.Equal(camposConError(t, cuerpo), []string{"tipo"}) {
+		t.Errorf("cambiar el tipo: %d %s", estado, cuerpo)
+	}
+
+	// categoria_id devuelve los generales y los de la categoría o sus ancestras
+	estado, _, cuerpo = peticion(t, srv, http.MethodPost, "/api/v1/categorias", map[string]any{"nombre": "Lámparas", "padre_id": iluminacion})
+	if estado != http.StatusCreated {
+		t.Fatalf("crear subcategoría: %d %s", estado, cuerpo)
+	}
+	lamparas := datos[Categoria](t, cuerpo).ID
+	for categoria, esperados := range map[string][]string{
+		lamparas:   {"potencia", "rosca"},
+		decoracion: {"potencia"},
+	} {
+		_, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/atributos?categoria_id="+categoria, nil)
+		var codigos []string
+		for _, d := range datos[[]DefinicionAtributo](t, cuerpo) {
+			codigos = append(codigos, d.Codigo)
+		}
+		if !slices.Equal(codigos, esperados) {
+			t.Errorf("atributos de %s: %v, se esperaba %v", categoria, codigos, esperados)
+		}
+	}
+}
+
+// TestValoresAtributos comprueba que los valores de los productos se validan
+// contra su definición y se pueden filtrar, junto con las etiquetas
+func TestValoresAtributos(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	iluminacion := crearCategoriaPrueba(t, srv, "Iluminación")
+	decoracion := crearCategoriaPrueba(t, srv, "Decoración")
+	crearAtributoPrueba(t, srv, map[string]any{"codigo": "rosca", "nombre": "Rosca", "tipo": "enum", "opciones": []string{"E27", "E14"}, "categorias": []string{iluminacion}})
+	crearAtributoPrueba(t, srv, map[string]any{"codigo": "potencia", "nombre": "Potencia", "tipo": "numero", "unidad": "W"})
+	crearAtributoPrueba(t, srv, map[string]any{"codigo": "regulable", "nombre": "Regulable", "tipo": "booleano"})
+
+	crear := func(nombre, categoria string, atributos map[string]any, etiquetas ...string) (int, []byte) {
+		p := productoPrueba(nombre, categoria)
+		p["atributos"] = atributos
+		p["etiquetas"] = etiquetas
+		estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/productos", p)
+		return estado, cuerpo
+	}
+
+	estado, cuerpo := crear("Lámpara de mesa", iluminacion, map[string]any{"rosca": "E27", "potencia": 40, "regulable": true}, " Regalo", "MESA", "regalo")
+	if estado != http.StatusCreated {
+		t.Fatalf("crear producto: %d %s", estado, cuerpo)
+	}
+	if p := datos[Producto](t, cuerpo); !slices.Equal(p.Etiquetas, []string{"mesa", "regalo"}) {
+		t.Errorf("etiquetas normalizadas: %v", p.Etiquetas)
+	}
+	if estado, cuerpo := crear("Lámpara de pie", iluminacion, map[string]any{"rosca": "E14", "potencia": 60}, "mesa"); estado != http.StatusCreated {
+		t.Fatalf("crear producto: %d %s", estado, cuerpo)
+	}
+	if estado, cuerpo := crear("Jarrón", decoracion, map[string]any{"regulable": false}); estado != http.StatusCreated {
+		t.Fatalf("crear producto: %d %s", estado, cuerpo)
+	}
+
+	for nombre, caso := range map[string]struct {
+		categoria string
+		atributos map[string]any
+		campos    []string
+	}{
+		"fuera de su categoría": {decoracion, map[string]any{"rosca": "E27"}, []string{"atributos.rosca"}},
+		"no definido":           {decoracion, map[string]any{"color": "azul"}, []string{"atributos.color"}},
+		"opción inexistente":    {iluminacion, map[string]any{"rosca": "GU10"}, []string{"atributos.rosca"}},
+		"tipos incorrectos":     {iluminacion, map[string]any{"potencia": "40", "regulable": "sí"}, []string{"atributos.potencia", "atributos.regulable"}},
+	} {
+		estado, cuerpo := crear("Inválido", caso.categoria, caso.atributos)
+		if estado != http.StatusUnprocessableEntity || !slices.Equal(camposConError(t, cuerpo), caso.campos) {
+			t.Errorf("%s: %d %s", nombre, estado, cuerpo)
+		}
+	}
+
+	for consulta, esperados := range map[string][]string{
+		"atributo.rosca=E27":                                {"Lámpara de mesa"},
+		"atributo.potencia.min=50":                          {"Lámpara de pie"},
+		"atributo.potencia.min=30&atributo.potencia.max=50": {"Lámpara de mesa"},
+		"atributo.potencia=60":                              {"Lámpara de pie"},
+		"atributo.regulable=false":                          {"Jarrón"},
+		"etiqueta=mesa":                                     {"Lámpara de mesa", "Lámpara de pie"},
+		"etiqueta=mesa,regalo":                              {"Lámpara de mesa"},
+		"etiqueta=MESA&etiqueta=regalo":                     {"Lámpara de mesa"},
+		"etiqueta=mesa&atributo.rosca=E14":                  {"Lámpara de pie"},
+		"etiqueta=oferta":                                   nil,
+	} {
+		q, _ := url.ParseQuery(consulta)
+		if estado, nombres := nombresProductos(t, srv, q.Encode()); estado != http.StatusOK || !slices.Equal(nombres, esperados) {
+			t.Errorf("%s: %d %v, se esperaba %v", consulta, estado, nombres, esperados)
+		}
+	}
+	for _, consulta := range []string{
+		"atributo.color=azul",
+		"atributo.rosca.min=1",
+		"atributo.potencia=mucha",
+		"atributo.potencia.desde=10",
+		"atributo.regulable=quizas",
+	} {
+		if estado, _ := nombresProductos(t, srv, consulta); estado != http.StatusBadRequest {
+			t.Errorf("%s: %d, se esperaba 400", consulta, estado)
+		}
+	}
+
+	// Un atributo con valores no se puede eliminar
+	if estado, _, cuerpo := peticion(t, srv, http.MethodDelete, "/api/v1/atributos/rosca", nil); estado != http.StatusConflict {
+		t.Errorf("eliminar un atributo en uso: %d %s", estado, cuerpo)
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"catalogo-comun/condicional"
+	"catalogo-comun/listado"
+	"catalogo-comun/parche"
+	dominio "catalogo-dominio"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ErrCategoriaNoEncontrada se devuelve cuando no existe una categoría con el ID solicitado
+var ErrCategoriaNoEncontrada = errors.New("categoría no encontrada")
+
+// ErrSlugDuplicado se devuelve cuando otra categoría ya usa el slug
+var ErrSlugDuplicado = errors.New("el slug ya está en uso")
+
+// ErrCategoriaEnUso se devuelve al eliminar una categoría que tiene
+// subcategorías o productos
+var ErrCategoriaEnUso = errors.New("la categoría tiene subcategorías o productos")
+
+// Categoria agrupa productos. Las categorías forman un árbol a través de
+// PadreID, nulo en las categorías raíz; Orden fija la posición entre hermanas
+type Categoria struct {
+	ID          string  `gorm:"primaryKey" json:"id" readonly:"true"`
+	Nombre      string  `json:"nombre" validate:"required"`
+	Slug        string  `json:"slug"`
+	Descripcion string  `json:"descripcion"`
+	PadreID     *string `json:"padre_id"`
+	Orden       int     `json:"orden"`
+	Version     int     `json:"version"`
+	// Traducciones guarda nombre y descripción en otros idiomas
+	Traducciones Traducciones `gorm:"serializer:json" json:"traducciones"`
+}
+
+// CategoriaArbol es una categoría con sus subcategorías anidadas
+type CategoriaArbol struct {
+	Categoria
+	Subcategorias []CategoriaArbol `json:"subcategorias"`
+}
+
+var ordenablesCategorias = map[string]func(Categoria) any{
+	"id":     func(c Categoria) any { return c.ID },
+	"nombre": func(c Categoria) any { return c.Nombre },
+	"slug":   func(c Categoria) any { return c.Slug },
+	"orden":  func(c Categoria) any { return float64(c.Orden) },
+}
+
+var camposCategorias = map[string]bool{
+	"id":           true,
+	"nombre":       true,
+	"slug":         true,
+	"descripcion":  true,
+	"padre_id":     true,
+	"orden":        true,
+	"version":      true,
+	"traducciones": true,
+}
+
+var (
+	patronSlug      = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	separadoresSlug = regexp.MustCompile(`[^a-z0-9]+`)
+	sinTildes       = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n")
+)
+
+// slugificar genera un slug a partir de un nombre: minúsculas, sin tildes y
+// con guiones en lugar de espacios y signos. Es la misma regla que aplica la
+// migración 9 a las categorías de texto libre
+func slugificar(nombre string) string {
+	s := sinTildes.Replace(strings.ToLower(strings.TrimSpace(nombre)))
+	return strings.Trim(separadoresSlug.ReplaceAllString(s, "-"), "-")
+}
+
+// descendientes devuelve el ID de la categoría y los de todas sus
+// subcategorías, a cualquier profundidad
+func descendientes(todas []Categoria, id string) []string {
+	hijas := make(map[string][]string)
+	for _, c := range todas {
+		if c.PadreID != nil {
+			hijas[*c.PadreID] = append(hijas[*c.PadreID], c.ID)
+		}
+	}
+	ids := []string{id}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, hijas[ids[i]]...)
+	}
+	return ids
+}
+
+// construirArbol anida las categorías bajo sus padres, ordenadas por Orden y
+// Nombre en cada nivel
+func construirArbol(todas []Categoria) []CategoriaArbol {
+	hijas := make(map[string][]Categoria)
+	for _, c := range todas {
+		padre := ""
+		if c.PadreID != nil {
+			padre = *c.PadreID
+		}
+		hijas[padre] = append(hijas[padre], c)
+	}
+
+	var construir func(padre string) []CategoriaArbol
+	construir = func(padre string) []CategoriaArbol {
+		nivel := hijas[padre]
+		slices.SortFunc(nivel, func(a, b Categoria) int {
+			if a.Orden != b.Orden {
+				return a.Orden - b.Orden
+			}
+			return strings.Compare(a.Nombre, b.Nombre)
+		})
+		arbol := make([]CategoriaArbol, len(nivel))
+		for i, c := range nivel {
+			arbol[i] = CategoriaArbol{Categoria: c, Subcategorias: construir(c.ID)}
+		}
+		return arbol
+	}
+	return construir("")
+}
+
+// mensajeCicloCategoria es el error de un padre_id que cerraría un ciclo
+const mensajeCicloCategoria = "no puede ser la propia categoría ni una de sus subcategorías"
+
+// errCicloCategoria es el error de validación que devuelven los repositorios
+// cuando, al escribir, el nuevo padre ya es descendiente de la categoría:
+// validarCategoria lo comprueba antes, pero otra petición puede haber movido
+// el padre entretanto
+func errCicloCategoria() error {
+	return dominio.ErroresValidacion{{Campo: "padre_id", Mensaje: mensajeCicloCategoria}}
+}
+
+// validarCategoria aplica las reglas de una categoría frente al resto del
+// árbol: el padre debe existir y no puede ser la propia categoría ni una de
+// sus descendientes, y el slug debe ser único
+func validarCategoria(cat Categoria, todas []Categoria) error {
+	var errs dominio.ErroresValidacion
+
+	if strings.TrimSpace(cat.Nombre) == "" {
+		errs.Agregar("nombre", "es obligatorio")
+	}
+	if !patronSlug.MatchString(cat.Slug) {
+		errs.Agregar("slug", "solo admite minúsculas, números y guiones")
+	}
+	for _, otra := range todas {
+		if otra.ID != cat.ID && otra.Slug == cat.Slug {
+			errs.Agregar("slug", "ya está en uso")
+			break
+		}
+	}
+	if cat.PadreID != nil {
+		existe := slices.ContainsFunc(todas, func(c Categoria) bool { return c.ID == *cat.PadreID })
+		switch {
+		case !existe:
+			errs.Agregar("padre_id", "no existe")
+		case slices.Contains(descendientes(todas, cat.ID), *cat.PadreID):
+			errs.Agregar("padre_id", mensajeCicloCategoria)
+		}
+	}
+	cat.Traducciones.validar(&errs)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Obtener categorías
+// @Summary Obtener categorías
+// @Description Obtiene una página de categorías ordenadas por orden y nombre. Con ?arbol=true devuelve todas las categorías anidadas bajo sus padres, sin paginar
+// @Tags categorias
+// @Produce json
+// @Param padre_id query string false "Solo las subcategorías directas de esta categoría; vacío para las categorías raíz"
+// @Param arbol query bool false "Devolver el árbol completo"
+// @Param page query int false "Número de página, empieza en 1"
+// @Param page_size query int false "Tamaño de página (máximo 100)"
+// @Param cursor query string false "Cursor devuelto en meta.next_cursor o meta.prev_cursor"
+// @Param sort query string false "Campos de orden separados por coma, con - para descendente"
+// @Param fields query string false "Campos a incluir separados por coma"
+// @Param Accept-Language header string false "Idiomas preferidos para nombre y descripción, p. ej. en-US,en;q=0.9"
+// @Success 200 {object} dominio.Pagina{data=[]Categoria}
+// @Failure 400 {object} dominio.RespuestaError
+// @Router /categorias [get]
+func getCategorias(c *gin.Context) {
+	todas, err := repo.ListarCategorias(c.Request.Context())
+	if err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+	localizarCategorias(c, todas)
+
+	if arbol, _ := strconv.ParseBool(c.Query("arbol")); arbol {
+		c.JSON(http.StatusOK, gin.H{
+			"data": construirArbol(todas),
+		})
+		return
+	}
+
+	params, err := listado.ParseOrdenado(c, "orden,nombre", ordenablesCategorias, camposCategorias)
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if padre, ok := c.GetQuery("padre_id"); ok {
+		todas = slices.DeleteFunc(todas, func(cat Categoria) bool {
+			if cat.PadreID == nil {
+				return padre != ""
+			}
+			return *cat.PadreID != padre
+		})
+	}
+
+	pagina, res := listado.EnMemoria(todas, params.Orden, params.Ventana, ordenablesCategorias)
+	responderPagina(c, pagina, res, params.Ventana, params.Campos)
+}
+
+// Obtener una categoría por ID
+// @Summary Obtener una categoría por ID
+// @Tags categorias
+// @Produce json
+// @Param id path string true "ID de la categoría"
+// @Param Accept-Language header string false "Idiomas preferidos para nombre y descripción, p. ej. en-US,en;q=0.9"
+// @Success 200 {object} dominio.Respuesta{data=Categoria}
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /categorias/{id} [get]
+func getCategoria(c *gin.Context) {
+	cat, err := repo.ObtenerCategoria(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+	cat.Traducciones.localizar(idiomaDe(c), &cat.Nombre, &cat.Descripcion)
+
+	c.Header("ETag", condicional.ETag(cat.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": cat,
+	})
+}
+
+// Productos de una categoría
+// @Summary Obtener los productos de una categoría
+// @Description Obtiene una página de productos de la categoría. Con ?subcategorias=true incluye los productos de todas sus subcategorías
+// @Tags categorias
+// @Produce json
+// @Param id path string true "ID de la categoría"
+// @Param subcategorias query bool false "Incluir los productos de las subcategorías"
+// @Param etiqueta query string false "Etiquetas que deben tener todos los productos, repetible o separadas por coma"
+// @Param atributo.{codigo} query string false "Valor exacto de un atributo; los numéricos admiten atributo.{codigo}.min y atributo.{codigo}.max"
+// @Param componente query string false "ID de un producto; devuelve los kits que lo incluyen"
+// @Param dimensiones.{eje}.min query string false "Dimensión mínima (eje ancho, alto o profundo), en ?unidad= o con sufijo de unidad, p. ej. 2in"
+// @Param dimensiones.{eje}.max query string false "Dimensión máxima, como dimensiones.{eje}.min"
+// @Param page query int false "Número de página, empieza en 1"
+// @Param page_size query int false "Tamaño de página (máximo 100)"
+// @Param cursor query string false "Cursor devuelto en meta.next_cursor o meta.prev_cursor"
+// @Param sort query string false "Campos de orden separados por coma, con - para descendente"
+// @Param fields query string false "Campos a incluir separados por coma"
+// @Param moneda query string false "Código ISO 4217 al que convertir los precios con la tasa vigente, p. ej. EUR"
+// @Param unidad query string false "Unidad de las dimensiones: mm (por defecto), cm o in"
+// @Param Accept-Language header string false "Idiomas preferidos para nombre y descripción, p. ej. en-US,en;q=0.9"
+// @Success 200 {object} dominio.Pagina{data=[]Producto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /categorias/{id}/productos [get]
+func getCategoriaProductos(c *gin.Context) {
+	if _, err := repo.ObtenerCategoria(c.Request.Context(), c.Param("id")); err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+	listarProductos(c, c.Param("id"), estadosPublicos)
+}
+
+// Crear una categoría
+// @Summary Crear una categoría
+// @Description Crea una categoría. Si no se indica slug se genera a partir del nombre
+// @Tags categorias
+// @Accept json
+// @Produce json
+// @Param categoria body Categoria true "Categoría a crear"
+// @Param Idempotency-Key header string false "Clave única de la operación; un reintento con la misma clave y la misma petición devuelve la respuesta guardada"
+// @Success 201 {object} dominio.Respuesta{data=Categoria}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 409 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /categorias [post]
+func createCategoria(c *gin.Context) {
+	var cat Categoria
+	if err := c.ShouldBindJSON(&cat); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cat.ID = uuid.New().String()
+	if cat.Slug == "" {
+		cat.Slug = slugificar(cat.Nombre)
+	}
+	todas, err := repo.ListarCategorias(c.Request.Context())
+	if err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+	if err := validarCategoria(cat, todas); err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+
+	if err := repo.CrearCategoria(c.Request.Context(), &cat); err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+
+	c.Header("ETag", condicional.ETag(cat.Version))
+	c.JSON(http.StatusCreated, gin.H{
+		"data": cat,
+	})
+}
+
+// Actualizar una categoría
+// @Summary Actualizar una categoría
+// @Description Reemplaza una categoría. Cambiar padre_id mueve la categoría con todas sus subcategorías
+// @Tags categorias
+// @Accept json
+// @Produce json
+// @Param id path string true "ID de la categoría"
+// @Param If-Match header string false "ETag obtenido al leer la categoría"
+// @Param categoria body Categoria true "Categoría actualizada"
+// @Success 200 {object} dominio.Respuesta{data=Categoria}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 409 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /categorias/{id} [put]
+func updateCategoria(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderErrorCategoria(c, ErrConflictoVersion)
+		return
+	}
+
+	var cat Categoria
+	if err := c.ShouldBindJSON(&cat); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	cat.ID = c.Param("id")
+	if cat.Slug == "" {
+		cat.Slug = slugificar(cat.Nombre)
+	}
+	guardarCategoria(c, &cat, version)
+}
+
+// Actualizar parcialmente una categoría
+// @Summary Actualizar parcialmente una categoría
+// @Description Aplica un JSON Merge Patch (RFC 7386) o un JSON Patch (RFC 6902) a la categoría
+// @Tags categorias
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Param id path string true "ID de la categoría"
+// @Param If-Match header string false "ETag obtenido al leer la categoría"
+// @Success 200 {object} dominio.Respuesta{data=Categoria}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 409 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Failure 415 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /categorias/{id} [patch]
+func patchCategoria(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderErrorCategoria(c, ErrConflictoVersion)
+		return
+	}
+
+	actual, err := repo.ObtenerCategoria(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+	if version != 0 && version != actual.Version {
+		responderErrorCategoria(c, ErrConflictoVersion)
+		return
+	}
+
+	var cat Categoria
+	if err := parche.Aplicar(c, actual, &cat); err != nil {
+		responderMensaje(c, parche.Estado(err), err.Error())
+		return
+	}
+	if cat.ID != actual.ID {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "El ID de la categoría no se puede modificar"))
+		return
+	}
+	guardarCategoria(c, &cat, actual.Version)
+}
+
+// guardarCategoria valida la categoría contra el árbol actual y la guarda
+func guardarCategoria(c *gin.Context, cat *Categoria, version int) {
+	todas, err := repo.ListarCategorias(c.Request.Context())
+	if err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+	i := slices.IndexFunc(todas, func(o Categoria) bool { return o.ID == cat.ID })
+	if i < 0 {
+		responderErrorCategoria(c, ErrCategoriaNoEncontrada)
+		return
+	}
+	// Un PUT sin traducciones conserva las que hubiera; {} las elimina
+	if cat.Traducciones == nil {
+		cat.Traducciones = todas[i].Traducciones
+	}
+	if err := validarCategoria(*cat, todas); err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+
+	if err := repo.ActualizarCategoria(c.Request.Context(), cat, version); err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+
+	c.Header("ETag", condicional.ETag(cat.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": cat,
+	})
+}
+
+// Eliminar una categoría
+// @Summary Eliminar una categoría
+// @Description Elimina una categoría sin subcategorías ni productos
+// @Tags categorias
+// @Produce json
+// @Param id path string true "ID de la categoría"
+// @Param If-Match header string false "ETag obtenido al leer la categoría"
+// @Success 200 {object} dominio.Mensaje
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 409 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Router /categorias/{id} [delete]
+func deleteCategoria(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderErrorCategoria(c, ErrConflictoVersion)
+		return
+	}
+
+	if err := repo.EliminarCategoria(c.Request.Context(), c.Param("id"), version); err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": traducir(c, "Categoría eliminada correctamente"),
+	})
+}
+
+// responderErrorCategoria es responderError con el mensaje de validación propio
+// de las categorías
+func responderErrorCategoria(c *gin.Context, err error) {
+	c.JSON(respuestaErrorCategoria(c, err))
+}
+
+// respuestaErrorCategoria es respuestaError con el mensaje de validación
+// propio de las categorías
+func respuestaErrorCategoria(c *gin.Context, err error) (int, dominio.RespuestaError) {
+	var errsValidacion dominio.ErroresValidacion
+	if errors.As(err, &errsValidacion) {
+		return respuestaValidacion(c, "Categoría inválida", errsValidacion)
+	}
+	return respuestaError(c, err)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	dominio "catalogo-dominio"
+)
+
+// TestRepositorioCompruebaCiclos mueve dos categorías una bajo la otra
+// escribiendo directamente en el repositorio, como dos peticiones que
+// validaron el árbol a la vez: la segunda escritura debe rechazarse
+func TestRepositorioCompruebaCiclos(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	ctx := context.Background()
+	x, err := repo.ObtenerCategoria(ctx, crearCategoriaPrueba(t, srv, "X"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	y, err := repo.ObtenerCategoria(ctx, crearCategoriaPrueba(t, srv, "Y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xBajoY := x
+	xBajoY.PadreID = &y.ID
+	yBajoX := y
+	yBajoX.PadreID = &x.ID
+	if err := repo.ActualizarCategoria(ctx, &xBajoY, x.Version); err != nil {
+		t.Fatal(err)
+	}
+	err = repo.ActualizarCategoria(ctx, &yBajoX, y.Version)
+	var errs dominio.ErroresValidacion
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Campo != "padre_id" {
+		t.Fatalf("mover Y bajo X con X bajo Y: %v", err)
+	}
+	if actual, err := repo.ObtenerCategoria(ctx, y.ID); err != nil || actual.PadreID != nil {
+		t.Errorf("Y cambió: %+v, %v", actual, err)
+	}
+
+	// Una categoría tampoco puede ser su propio padre
+	propia := x
+	propia.PadreID = &x.ID
+	if err := repo.ActualizarCategoria(ctx, &propia, 0); !errors.As(err, &errs) {
+		t.Errorf("categoría como su propio padre: %v", err)
+	}
+}
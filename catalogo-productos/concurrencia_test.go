@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	dominio "catalogo-dominio"
+)
+
+// TestConcurrenciaProductos lanza lecturas y escrituras simultáneas contra
+// el servicio. Con go test -race detecta accesos sin sincronizar al
+// repositorio en memoria; además comprueba que no se pierde ninguna
+// actualización que el servicio dio por buena
+func TestConcurrenciaProductos(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+
+	const compartidos, trabajadores, vueltas = 4, 8, 20
+	var productos [compartidos]Producto
+	var actualizados [compartidos]atomic.Int64
+	for i := range productos {
+		productos[i] = crearProductoPrueba(t, srv, fmt.Sprintf("Compartido %d", i), categoriaID)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < trabajadores; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := 0; v < vueltas; v++ {
+				i := (w + v) % compartidos
+				compartido := productos[i]
+
+				if estado, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/productos?page_size=100&sort=-nombre", nil); estado != http.StatusOK {
+					t.Errorf("listar: %d %s", estado, cuerpo)
+					return
+				}
+				estado, cabecera, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/productos/"+compartido.ID, nil)
+				if estado != http.StatusOK {
+					t.Errorf("leer: %d %s", estado, cuerpo)
+					return
+				}
+
+				// Solo una de las actualizaciones con la misma versión puede ganar
+				cambio := productoPrueba(fmt.Sprintf("Compartido %d-%d-%d", i, w, v), categoriaID)
+				estado, _, cuerpo = peticion(t, srv, http.MethodPut, "/api/v1/productos/"+compartido.ID, cambio, "If-Match", cabecera.Get("ETag"))
+				switch estado {
+				case http.StatusOK:
+					actualizados[i].Add(1)
+				case http.StatusPreconditionFailed:
+				default:
+					t.Errorf("actualizar: %d %s", estado, cuerpo)
+					return
+				}
+
+				estado, _, cuerpo = peticion(t, srv, http.MethodPost, "/api/v1/productos", productoPrueba(fmt.Sprintf("Propio %d-%d", w, v), categoriaID))
+				if estado != http.StatusCreated {
+					t.Errorf("crear: %d %s", estado, cuerpo)
+					return
+				}
+				propio := datos[Producto](t, cuerpo)
+				if estado, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/categorias/"+categoriaID+"/productos", nil); estado != http.StatusOK {
+					t.Errorf("listar por categoría: %d %s", estado, cuerpo)
+					return
+				}
+				if estado, _, cuerpo := peticion(t, srv, http.MethodDelete, "/api/v1/productos/"+propio.ID, nil); estado != http.StatusOK {
+					t.Errorf("eliminar: %d %s", estado, cuerpo)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	for i, p := range productos {
+		estado, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/productos/"+p.ID, nil)
+		if estado != http.StatusOK {
+			t.Fatalf("leer: %d %s", estado, cuerpo)
+		}
+		final := datos[Producto](t, cuerpo)
+		if want := p.Version + int(actualizados[i].Load()); final.Version != want {
+			t.Errorf("producto %d: versión %d, se esperaba %d", i, final.Version, want)
+		}
+	}
+
+	_, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/productos", nil)
+	if total := leerPagina(t, cuerpo).Meta.Total; total != compartidos {
+		t.Errorf("quedaron %d productos publicados, se esperaban %d", total, compartidos)
+	}
+	_, _, cuerpo = peticion(t, srv, http.MethodGet, "/api/v1/productos/papelera", nil)
+	if total := leerPagina(t, cuerpo).Meta.Total; total != trabajadores*vueltas {
+		t.Errorf("hay %d productos en la papelera, se esperaban %d", total, trabajadores*vueltas)
+	}
+}
+
+func leerPagina(t testing.TB, cuerpo []byte) dominio.Pagina {
+	t.Helper()
+	var p dominio.Pagina
+	if err := json.Unmarshal(cuerpo, &p); err != nil {
+		t.Fatalf("página inválida %s: %v", cuerpo, err)
+	}
+	return p
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+
+	dominio "catalogo-dominio"
+
+	"github.com/gin-gonic/gin"
+)
+
+// convertirDimensiones aplica ?unidad= a las dimensiones de los productos
+// leídos. Si la unidad no es válida responde el error y devuelve false
+func convertirDimensiones(c *gin.Context, productos []Producto) bool {
+	unidad, err := dominio.ParseUnidad(c.Query("unidad"))
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return false
+	}
+	for i := range productos {
+		productos[i].Dimensiones = productos[i].Dimensiones.En(unidad)
+	}
+	return true
+}
@@ -0,0 +1,26 @@
+// Package docs registra en swag la especificación OpenAPI de la API para que
+// la sirva /swagger. swagger.json se genera con go generate a partir de las
+// anotaciones de los handlers y de los tipos que usan, los de este servicio
+// y los de catalogo-dominio; no se edita a mano
+package docs
+
+//go:generate go run ./generar
+
+import (
+	_ "embed"
+
+	"github.com/swaggo/swag"
+)
+
+//go:embed swagger.json
+var especificacion string
+
+// SwaggerInfo es la especificación que sirve gin-swagger
+var SwaggerInfo = &swag.Spec{
+	InfoInstanceName: swag.Name,
+	SwaggerTemplate:  especificacion,
+}
+
+func init() {
+	swag.Register(SwaggerInfo.InstanceName(), SwaggerInfo)
+}
@@ -0,0 +1,69 @@
+// Command generar escribe docs/swagger.json a partir de las anotaciones de
+// los handlers de catalogo-productos y de los tipos a los que se refieren,
+// incluidos los de catalogo-dominio. Lo ejecuta go generate desde docs:
+//
+//	go generate ./docs
+//
+// Con -comprobar no escribe nada y termina con error si swagger.json no
+// coincide con el código, para detectar una especificación desactualizada
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/swaggo/swag"
+)
+
+func main() {
+	dir := flag.String("dir", "..", "directorio raíz del servicio, con main.go")
+	salida := flag.String("salida", "swagger.json", "fichero de la especificación")
+	comprobar := flag.Bool("comprobar", false, "solo comprueba que la especificación está al día")
+	flag.Parse()
+
+	especificacion, err := generar(*dir)
+	if err != nil {
+		log.Fatalf("Error al generar la especificación: %v", err)
+	}
+
+	if *comprobar {
+		actual, err := os.ReadFile(*salida)
+		if err != nil {
+			log.Fatalf("Error al leer la especificación: %v", err)
+		}
+		if !bytes.Equal(actual, especificacion) {
+			log.Fatalf("%s está desactualizado; ejecute go generate ./docs", *salida)
+		}
+		return
+	}
+	if err := os.WriteFile(*salida, especificacion, 0o644); err != nil {
+		log.Fatalf("Error al escribir la especificación: %v", err)
+	}
+	log.Printf("Especificación escrita en %s", *salida)
+}
+
+// generar analiza el servicio en dir y devuelve la especificación en JSON.
+// Se analizan también las dependencias para resolver los tipos de
+// catalogo-dominio; docs se excluye para no leer la especificación anterior
+func generar(dir string) ([]byte, error) {
+	raiz, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	parser := swag.New(
+		swag.SetParseDependency(true),
+		swag.SetExcludedDirsAndFiles(filepath.Join(raiz, "docs")),
+	)
+	if err := parser.ParseAPIMultiSearchDir([]string{raiz}, "main.go", 100); err != nil {
+		return nil, err
+	}
+	especificacion, err := json.MarshalIndent(parser.GetSwagger(), "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(especificacion, '\n'), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+
+	dominio "catalogo-dominio"
+)
+
+// validarImprimibilidad impide que un producto pase a disponible si tiene un
+// modelo 3D que no supera el análisis de integridad. desde es el estado
+// guardado; si ya estaba disponible no hay transición y no se comprueba. Al
+// sustituir el modelo se pasa desde vacío para exigirlo también a los
+// productos disponibles
+func validarImprimibilidad(desde dominio.EstadoProducto, p Producto) error {
+	if p.Estado != dominio.EstadoDisponible || desde == dominio.EstadoDisponible {
+		return nil
+	}
+	if p.Geometria.Formato == "" || p.Imprimibilidad.Imprimible {
+		return nil
+	}
+	return dominio.ErroresValidacion{{
+		Campo:   "estado",
+		Mensaje: "el modelo 3D no es imprimible; revise el informe de imprimibilidad",
+	}}
+}
+
+// TransicionEstado registra un cambio de estado de un producto. Desde está
+// vacío en la transición con la que se crea el producto
+type TransicionEstado struct {
+	ID         uint                   `gorm:"primaryKey" json:"-"`
+	ProductoID string                 `json:"producto_id"`
+	Desde      dominio.EstadoProducto `json:"desde" enums:"borrador,disponible,agotado,descontinuado"`
+	Hacia      dominio.EstadoProducto `json:"hacia" enums:"borrador,disponible,agotado,descontinuado"`
+	Fecha      time.Time              `json:"fecha"`
+}
+
+func (TransicionEstado) TableName() string {
+	return "producto_transiciones"
+}
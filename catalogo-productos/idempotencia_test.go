@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"catalogo-comun/idempotencia"
+	dominio "catalogo-dominio"
+)
+
+// totalProductos devuelve cuántos productos publicados hay
+func totalProductos(t *testing.T, srv *httptest.Server) int64 {
+	t.Helper()
+	estado, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/productos", nil)
+	if estado != http.StatusOK {
+		t.Fatalf("listar productos: %d %s", estado, cuerpo)
+	}
+	return leerPagina(t, cuerpo).Meta.Total
+}
+
+// codigoError decodifica el código de una respuesta de error
+func codigoError(t *testing.T, cuerpo []byte) dominio.Codigo {
+	t.Helper()
+	var r dominio.RespuestaError
+	if err := json.Unmarshal(cuerpo, &r); err != nil {
+		t.Fatalf("error inválido %s: %v", cuerpo, err)
+	}
+	return r.Codigo
+}
+
+func TestIdempotenciaRepite(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	cuerpo := productoPrueba("Jarrón", categoriaID)
+
+	estado, cabeceras, original := peticion(t, srv, http.MethodPost, "/api/v1/productos", cuerpo, idempotencia.Cabecera, "clave-1")
+	if estado != http.StatusCreated {
+		t.Fatalf("primera petición: %d %s", estado, original)
+	}
+	if cabeceras.Get("Idempotent-Replayed") != "" {
+		t.Error("la primera petición aparece como repetida")
+	}
+
+	estado, repetida, respuesta := peticion(t, srv, http.MethodPost, "/api/v1/productos", cuerpo, idempotencia.Cabecera, "clave-1")
+	if estado != http.StatusCreated {
+		t.Fatalf("reintento: %d %s", estado, respuesta)
+	}
+	if repetida.Get("Idempotent-Replayed") != "true" {
+		t.Error("falta Idempotent-Replayed: true en el reintento")
+	}
+	if !bytes.Equal(respuesta, original) {
+		t.Errorf("el reintento respondió %s, se esperaba %s", respuesta, original)
+	}
+	if repetida.Get("ETag") != cabeceras.Get("ETag") || repetida.Get("Content-Type") != cabeceras.Get("Content-Type") {
+		t.Errorf("el reintento tiene ETag %q y Content-Type %q, se esperaba %q y %q",
+			repetida.Get("ETag"), repetida.Get("Content-Type"), cabeceras.Get("ETag"), cabeceras.Get("Content-Type"))
+	}
+	if n := totalProductos(t, srv); n != 1 {
+		t.Errorf("hay %d productos tras el reintento, se esperaba 1", n)
+	}
+
+	// Otra clave es otra petición
+	if estado, _, _ := peticion(t, srv, http.MethodPost, "/api/v1/productos", cuerpo, idempotencia.Cabecera, "clave-2"); estado != http.StatusCreated {
+		t.Errorf("con otra clave: %d", estado)
+	}
+	if n := totalProductos(t, srv); n != 2 {
+		t.Errorf("hay %d productos con dos claves, se esperaban 2", n)
+	}
+}
+
+func TestIdempotenciaOtraPeticion(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+
+	if estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/productos", productoPrueba("Jarrón", categoriaID), idempotencia.Cabecera, "clave"); estado != http.StatusCreated {
+		t.Fatalf("primera petición: %d %s", estado, cuerpo)
+	}
+
+	// Mismo recurso con otro cuerpo, y mismo cuerpo en otra ruta
+	otras := []struct {
+		ruta   string
+		cuerpo any
+	}{
+		{"/api/v1/productos", productoPrueba("Lámpara", categoriaID)},
+		{"/api/v1/categorias", productoPrueba("Jarrón", categoriaID)},
+	}
+	for _, o := range otras {
+		estado, cabeceras, cuerpo := peticion(t, srv, http.MethodPost, o.ruta, o.cuerpo, idempotencia.Cabecera, "clave")
+		if estado != http.StatusUnprocessableEntity || codigoError(t, cuerpo) != dominio.CodigoIdempotenciaReutilizada {
+			t.Errorf("POST %s con la clave usada: %d %s, se esperaba 422", o.ruta, estado, cuerpo)
+		}
+		if cabeceras.Get("Idempotent-Replayed") != "" {
+			t.Errorf("POST %s con la clave usada aparece como repetida", o.ruta)
+		}
+	}
+	if n := totalProductos(t, srv); n != 1 {
+		t.Errorf("hay %d productos, se esperaba 1", n)
+	}
+}
+
+func TestIdempotenciaEnCurso(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	ahora := time.Now().UTC()
+	if _, ok, err := repo.ReservarIdempotencia(context.Background(), "en-curso", ahora, ahora.Add(time.Minute)); err != nil || !ok {
+		t.Fatalf("reservar la clave: %v, %v", ok, err)
+	}
+
+	estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/productos", productoPrueba("Jarrón", categoriaID), idempotencia.Cabecera, "en-curso")
+	if estado != http.StatusConflict || codigoError(t, cuerpo) != dominio.CodigoIdempotenciaEnCurso {
+		t.Errorf("con la clave en curso: %d %s, se esperaba 409", estado, cuerpo)
+	}
+	if n := totalProductos(t, srv); n != 0 {
+		t.Errorf("hay %d productos, se esperaba 0", n)
+	}
+}
+
+// TestIdempotenciaCaducada comprueba que una clave caducada vuelve a ejecutar
+// la petición, aunque el cuerpo sea otro
+func TestIdempotenciaCaducada(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	caducado := idempotencia.Registro{
+		Clave:    "caducada",
+		Huella:   "otra",
+		Estado:   http.StatusCreated,
+		Cuerpo:   []byte(`{}`),
+		CaducaEn: time.Now().UTC().Add(-time.Minute),
+	}
+	if err := repo.CompletarIdempotencia(context.Background(), caducado); err != nil {
+		t.Fatal(err)
+	}
+
+	estado, cabeceras, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/productos", productoPrueba("Jarrón", categoriaID), idempotencia.Cabecera, "caducada")
+	if estado != http.StatusCreated || cabeceras.Get("Idempotent-Replayed") != "" {
+		t.Errorf("con la clave caducada: %d %s", estado, cuerpo)
+	}
+	if n := totalProductos(t, srv); n != 1 {
+		t.Errorf("hay %d productos, se esperaba 1", n)
+	}
+}
+
+// TestIdempotenciaSimultanea lanza a la vez la misma petición con la misma
+// clave: solo una crea el producto y las demás reciben la respuesta guardada
+// o 409 si llegan mientras sigue en curso
+func TestIdempotenciaSimultanea(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	cuerpo := productoPrueba("Jarrón", categoriaID)
+
+	const intentos = 16
+	estados := make([]int, intentos)
+	repetidas := make([]bool, intentos)
+	var wg sync.WaitGroup
+	for i := range intentos {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			estado, cabeceras, _ := peticion(t, srv, http.MethodPost, "/api/v1/productos", cuerpo, idempotencia.Cabecera, "simultanea")
+			estados[i] = estado
+			repetidas[i] = cabeceras.Get("Idempotent-Replayed") == "true"
+		}()
+	}
+	wg.Wait()
+
+	ejecutadas := 0
+	for i, estado := range estados {
+		switch {
+		case estado == http.StatusCreated && !repetidas[i]:
+			ejecutadas++
+		case estado == http.StatusCreated, estado == http.StatusConflict:
+		default:
+			t.Errorf("intento %d: estado %d", i, estado)
+		}
+	}
+	if ejecutadas != 1 {
+		t.Errorf("%d intentos ejecutaron la petición, se esperaba 1", ejecutadas)
+	}
+	if n := totalProductos(t, srv); n != 1 {
+		t.Errorf("hay %d productos, se esperaba 1", n)
+	}
+}
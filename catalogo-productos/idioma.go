@@ -0,0 +1,501 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"catalogo-comun/condicional"
+	dominio "catalogo-dominio"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrTraduccionNoEncontrada se devuelve cuando el recurso no tiene traducción
+// al idioma solicitado
+var ErrTraduccionNoEncontrada = errors.New("traducción no encontrada")
+
+// claveIdioma guarda en el contexto de gin el idioma negociado
+const claveIdioma = "idioma"
+
+// idiomaPredeterminado es el idioma de Nombre y Descripcion y el que se usa
+// cuando Accept-Language no pide ninguno admitido. Se configura con
+// IDIOMA_PREDETERMINADO; por defecto es
+func idiomaPredeterminado() string {
+	if idioma := strings.ToLower(strings.TrimSpace(os.Getenv("IDIOMA_PREDETERMINADO"))); idioma != "" {
+		return idioma
+	}
+	return "es"
+}
+
+// idiomasAdmitidos son los idiomas a los que se puede traducir el contenido,
+// configurados con IDIOMAS (por defecto es,en). Siempre incluye el
+// predeterminado
+func idiomasAdmitidos() []string {
+	valor := os.Getenv("IDIOMAS")
+	if strings.TrimSpace(valor) == "" {
+		valor = "es,en"
+	}
+	idiomas := []string{idiomaPredeterminado()}
+	for _, idioma := range strings.Split(valor, ",") {
+		idioma = strings.ToLower(strings.TrimSpace(idioma))
+		if idioma != "" && !slices.Contains(idiomas, idioma) {
+			idiomas = append(idiomas, idioma)
+		}
+	}
+	return idiomas
+}
+
+// elegirIdioma devuelve el idioma admitido preferido según una cabecera
+// Accept-Language, p. ej. "en-US,en;q=0.9,es;q=0.5". Una variante regional
+// equivale a su idioma base; sin coincidencias se usa el predeterminado
+func elegirIdioma(cabecera string) string {
+	type preferencia struct {
+		idioma string
+		peso   float64
+	}
+	var preferencias []preferencia
+	for _, parte := range strings.Split(cabecera, ",") {
+		etiqueta, params, _ := strings.Cut(parte, ";")
+		p := preferencia{idioma: strings.ToLower(strings.TrimSpace(etiqueta)), peso: 1}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			peso, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			p.peso = peso
+		}
+		if p.idioma != "" && p.peso > 0 {
+			preferencias = append(preferencias, p)
+		}
+	}
+	slices.SortStableFunc(preferencias, func(a, b preferencia) int { return cmp.Compare(b.peso, a.peso) })
+
+	admitidos := idiomasAdmitidos()
+	for _, p := range preferencias {
+		if p.idioma == "*" {
+			break
+		}
+		base, _, _ := strings.Cut(p.idioma, "-")
+		for _, candidato := range []string{p.idioma, base} {
+			if slices.Contains(admitidos, candidato) {
+				return candidato
+			}
+		}
+	}
+	return idiomaPredeterminado()
+}
+
+// negociarIdioma elige el idioma de la respuesta a partir de Accept-Language
+// y lo indica en Content-Language
+func negociarIdioma(c *gin.Context) {
+	idioma := elegirIdioma(c.GetHeader("Accept-Language"))
+	c.Set(claveIdioma, idioma)
+	c.Header("Content-Language", idioma)
+	c.Header("Vary", "Accept-Language")
+	c.Next()
+}
+
+func idiomaDe(c *gin.Context) string {
+	if idioma := c.GetString(claveIdioma); idioma != "" {
+		return idioma
+	}
+	return idiomaPredeterminado()
+}
+
+// traducir devuelve un mensaje de la API en el idioma de la petición. Los
+// mensajes se escriben en español; los que no están en el catálogo se
+// devuelven tal cual
+func traducir(c *gin.Context, mensaje string) string {
+	if traduccion, ok := mensajes[idiomaDe(c)][mensaje]; ok {
+		return traduccion
+	}
+	return mensaje
+}
+
+// traducirErrores traduce los mensajes de los errores de campo
+func traducirErrores(c *gin.Context, errs dominio.ErroresValidacion) dominio.ErroresValidacion {
+	traducidos := make(dominio.ErroresValidacion, len(errs))
+	for i, e := range errs {
+		traducidos[i] = dominio.ErrorCampo{Campo: e.Campo, Mensaje: traducir(c, e.Mensaje)}
+	}
+	return traducidos
+}
+
+// mensajes es el catálogo de mensajes de la API por idioma
+var mensajes = map[string]map[string]string{
+	"en": {
+		"Producto no encontrado":                                              "Product not found",
+		"Producto inválido":                                                   "Invalid product",
+		"Producto eliminado":                                                  "Product deleted",
+		"El producto fue modificado por otro usuario":                         "The product was modified by another user",
+		"El id del producto no se puede modificar":                            "The product id cannot be changed",
+		"El producto no tiene modelo 3D":                                      "The product has no 3D model",
+		"El modelo 3D no es imprimible; retire el producto de la venta":       "The 3D model is not printable; take the product off sale",
+		"Error al obtener productos":                                          "Error fetching products",
+		"Error al crear el producto":                                          "Error creating the product",
+		"Error al obtener la papelera":                                        "Error fetching the trash",
+		"Error al leer el modelo 3D":                                          "Error reading the 3D model",
+		"Error al generar la miniatura":                                       "Error generating the thumbnail",
+		"Error al serializar la respuesta":                                    "Error serializing the response",
+		"Error interno del servidor":                                          "Internal server error",
+		"Revisión no encontrada":                                              "Revision not found",
+		"rev debe ser un número de revisión":                                  "rev must be a revision number",
+		"Categoría no encontrada":                                             "Category not found",
+		"Categoría inválida":                                                  "Invalid category",
+		"Categoría eliminada correctamente":                                   "Category deleted",
+		"El ID de la categoría no se puede modificar":                         "The category id cannot be changed",
+		"Ya existe una categoría con ese slug":                                "A category with that slug already exists",
+		"La categoría tiene subcategorías o productos":                        "The category has subcategories or products",
+		"Material no encontrado":                                              "Material not found",
+		"No se pudo consultar el material":                                    "Could not fetch the material",
+		"Tasa de cambio no encontrada":                                        "Exchange rate not found",
+		"Tasa de cambio inválida":                                             "Invalid exchange rate",
+		"Tasa de cambio eliminada":                                            "Exchange rate deleted",
+		"moneda debe ser un código ISO 4217 admitido":                         "moneda must be a supported ISO 4217 code",
+		"No se puede convertir el precio":                                     "Cannot convert the price",
+		"Atributo no encontrado":                                              "Attribute not found",
+		"Atributo inválido":                                                   "Invalid attribute",
+		"Atributo eliminado":                                                  "Attribute deleted",
+		"Ya existe un atributo con ese código":                                "An attribute with that code already exists",
+		"El atributo tiene valores en algún producto":                         "Some product has a value for the attribute",
+		"Relación no encontrada":                                              "Relationship not found",
+		"Relación inválida":                                                   "Invalid relationship",
+		"Relación eliminada":                                                  "Relationship deleted",
+		"Ya existe esa relación entre los productos":                          "That relationship between the products already exists",
+		"Adjunto no encontrado":                                               "Attachment not found",
+		"Adjunto eliminado":                                                   "Attachment deleted",
+		"El producto ya tiene un adjunto con ese contenido":                   "The product already has an attachment with that content",
+		"Idempotency-Key admite como máximo 255 caracteres":                   "Idempotency-Key allows at most 255 characters",
+		"Hay otra petición en curso con la misma Idempotency-Key":             "Another request with the same Idempotency-Key is in progress",
+		"La Idempotency-Key ya se usó con otra petición":                      "The Idempotency-Key was already used with a different request",
+		"El lote debe tener entre 1 y 200 operaciones":                        "A batch must have between 1 and 200 operations",
+		"No se aplicó ninguna operación del lote porque una falló":            "No operation in the batch was applied because one failed",
+		"No se aplicó porque falló otra operación del lote":                   "Not applied because another operation in the batch failed",
+		"op debe ser crear, actualizar o eliminar":                            "op must be crear, actualizar or eliminar",
+		"recurso debe ser productos o categorias":                             "recurso must be productos or categorias",
+		"ref solo se admite al crear y debe ser única y alfanumérica":         "ref is only allowed when creating and must be unique and alphanumeric",
+		"id es obligatorio al actualizar o eliminar":                          "id is required to update or delete",
+		"El id no se puede modificar":                                         "The id cannot be changed",
+		"Orden de adjuntos inválido":                                          "Invalid attachment order",
+		"Error al guardar el adjunto":                                         "Error saving the attachment",
+		"Error al leer el adjunto":                                            "Error reading the attachment",
+		"Traducción no encontrada":                                            "Translation not found",
+		"El idioma predeterminado no se puede eliminar":                       "The default language cannot be deleted",
+		"El archivo tiene filas con errores; no se importó ningún producto":   "The file has rows with errors; no product was imported",
+		"Ya existe una tasa para esas monedas con la misma fecha de vigencia": "An exchange rate for those currencies with the same effective date already exists",
+		"El producto es componente de algún kit; quítelo de los kits antes de eliminarlo": "The product is a component of some kit; remove it from the kits before deleting it",
+
+		"no se puede cambiar":                                          "cannot be changed",
+		"no aplica a la categoría del producto":                        "does not apply to the product category",
+		"debe ser un número":                                           "must be a number",
+		"debe ser true o false":                                        "must be true or false",
+		"debe ser un texto no vacío":                                   "must be a non-empty text",
+		"solo admite minúsculas, números y guiones bajos":              "only allows lowercase letters, digits and underscores",
+		"solo se admite en atributos numéricos":                        "is only allowed in numeric attributes",
+		"es obligatorio en los enumerados":                             "is required in enums",
+		"solo se admite en enumerados":                                 "is only allowed in enums",
+		"es obligatorio":                                               "is required",
+		"es obligatoria":                                               "is required",
+		"es obligatorio para importar":                                 "is required to import",
+		"debe ser mayor que 0":                                         "must be greater than 0",
+		"no puede ser negativo":                                        "cannot be negative",
+		"no existe":                                                    "does not exist",
+		"ya está en uso":                                               "is already in use",
+		"idioma no admitido":                                           "language not supported",
+		"debe ser un código ISO 4217 admitido":                         "must be a supported ISO 4217 code",
+		"debe ser distinta de desde":                                   "must be different from desde",
+		"solo admite minúsculas, números y guiones":                    "only allows lowercase letters, digits and hyphens",
+		"no puede ser la propia categoría ni una de sus subcategorías": "cannot be the category itself or one of its subcategories",
+		"no puede ser el propio producto":                              "cannot be the product itself",
+		"un kit no puede incluirse a sí mismo":                         "a kit cannot include itself",
+		"está repetido":                                                "is repeated",
+		"no puede ser otro kit":                                        "cannot be another kit",
+		"el producto es componente de otro kit":                        "the product is a component of another kit",
+		"debe estar entre 0 y 100":                                     "must be between 0 and 100",
+		"admite como máximo 2 decimales":                               "allows at most 2 decimals",
+		"solo se aplica a los kits":                                    "only applies to kits",
+		"no es un adjunto del producto":                                "is not an attachment of the product",
+		"solo se aplica a los borradores":                              "only applies to drafts",
+		"es obligatorio con precio_desde":                              "is required with precio_desde",
+		"es obligatoria con precio_programado":                         "is required with precio_programado",
+		"no se aplica a los kits, su precio se calcula a partir de los componentes": "does not apply to kits, their price is calculated from their components",
+	},
+}
+
+// Traduccion es el texto de un producto o una categoría en un idioma
+// distinto del predeterminado. Los campos vacíos usan el texto predeterminado
+type Traduccion struct {
+	Nombre      string `json:"nombre,omitempty"`
+	Descripcion string `json:"descripcion,omitempty"`
+}
+
+// Traducciones guarda las traducciones por código de idioma
+type Traducciones map[string]Traduccion
+
+// validar comprueba que cada traducción sea de un idioma admitido distinto
+// del predeterminado
+func (t Traducciones) validar(errs *dominio.ErroresValidacion) {
+	admitidos := idiomasAdmitidos()
+	for _, idioma := range slices.Sorted(maps.Keys(t)) {
+		if idioma == idiomaPredeterminado() || !slices.Contains(admitidos, idioma) {
+			errs.Agregar("traducciones."+idioma, "idioma no admitido")
+		}
+	}
+}
+
+// localizar sustituye nombre y descripción por su traducción al idioma, si la hay
+func (t Traducciones) localizar(idioma string, nombre, descripcion *string) {
+	traduccion := t[idioma]
+	if traduccion.Nombre != "" {
+		*nombre = traduccion.Nombre
+	}
+	if traduccion.Descripcion != "" {
+		*descripcion = traduccion.Descripcion
+	}
+}
+
+// conTraduccion devuelve una copia de las traducciones con la del idioma
+// cambiada o, si traduccion es nil, eliminada
+func (t Traducciones) conTraduccion(idioma string, traduccion *Traduccion) Traducciones {
+	copia := maps.Clone(t)
+	if copia == nil {
+		copia = Traducciones{}
+	}
+	if traduccion == nil {
+		delete(copia, idioma)
+	} else {
+		copia[idioma] = *traduccion
+	}
+	return copia
+}
+
+// todasLasTraducciones incluye el texto predeterminado bajo su idioma
+func todasLasTraducciones(t Traducciones, nombre, descripcion string) Traducciones {
+	todas := maps.Clone(t)
+	if todas == nil {
+		todas = Traducciones{}
+	}
+	todas[idiomaPredeterminado()] = Traduccion{Nombre: nombre, Descripcion: descripcion}
+	return todas
+}
+
+func localizarProductos(c *gin.Context, productos []Producto) {
+	idioma := idiomaDe(c)
+	for i := range productos {
+		productos[i].Traducciones.localizar(idioma, &productos[i].Nombre, &productos[i].Descripcion)
+	}
+}
+
+func localizarCategorias(c *gin.Context, categorias []Categoria) {
+	idioma := idiomaDe(c)
+	for i := range categorias {
+		categorias[i].Traducciones.localizar(idioma, &categorias[i].Nombre, &categorias[i].Descripcion)
+	}
+}
+
+// Traducciones de un producto
+// @Summary Listar las traducciones de un producto
+// @Description Devuelve el nombre y la descripción del producto en cada idioma, incluido el predeterminado
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Success 200 {object} dominio.Respuesta{data=Traducciones}
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /productos/{id}/traducciones [get]
+func getProductTranslations(c *gin.Context) {
+	producto, err := repo.Obtener(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+
+	c.Header("ETag", condicional.ETag(producto.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": todasLasTraducciones(producto.Traducciones, producto.Nombre, producto.Descripcion),
+	})
+}
+
+// Traducir un producto
+// @Summary Guardar la traducción de un producto
+// @Description Guarda el nombre y la descripción del producto en un idioma. En el idioma predeterminado cambia los propios nombre y descripción del producto
+// @Tags productos
+// @Accept json
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param idioma path string true "Código de idioma, p. ej. en"
+// @Param If-Match header string false "ETag obtenido al leer el producto"
+// @Param traduccion body Traduccion true "Traducción"
+// @Success 200 {object} dominio.Respuesta{data=Producto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /productos/{id}/traducciones/{idioma} [put]
+func putProductTranslation(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+	var traduccion Traduccion
+	if err := c.ShouldBindJSON(&traduccion); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	producto, err := repo.Obtener(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	if idioma := strings.ToLower(c.Param("idioma")); idioma == idiomaPredeterminado() {
+		producto.Nombre, producto.Descripcion = traduccion.Nombre, traduccion.Descripcion
+	} else {
+		producto.Traducciones = producto.Traducciones.conTraduccion(idioma, &traduccion)
+	}
+	guardarProducto(c, &producto, version)
+}
+
+// Eliminar una traducción de un producto
+// @Summary Eliminar la traducción de un producto
+// @Description Elimina la traducción a un idioma; las respuestas en ese idioma vuelven a usar el texto predeterminado
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param idioma path string true "Código de idioma"
+// @Param If-Match header string false "ETag obtenido al leer el producto"
+// @Success 200 {object} dominio.Respuesta{data=Producto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Router /productos/{id}/traducciones/{idioma} [delete]
+func deleteProductTranslation(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+	idioma := strings.ToLower(c.Param("idioma"))
+	if idioma == idiomaPredeterminado() {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "El idioma predeterminado no se puede eliminar"))
+		return
+	}
+
+	producto, err := repo.Obtener(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	if _, ok := producto.Traducciones[idioma]; !ok {
+		responderError(c, ErrTraduccionNoEncontrada)
+		return
+	}
+	producto.Traducciones = producto.Traducciones.conTraduccion(idioma, nil)
+	guardarProducto(c, &producto, version)
+}
+
+// Traducciones de una categoría
+// @Summary Listar las traducciones de una categoría
+// @Description Devuelve el nombre y la descripción de la categoría en cada idioma, incluido el predeterminado
+// @Tags categorias
+// @Produce json
+// @Param id path string true "ID de la categoría"
+// @Success 200 {object} dominio.Respuesta{data=Traducciones}
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /categorias/{id}/traducciones [get]
+func getCategoriaTranslations(c *gin.Context) {
+	cat, err := repo.ObtenerCategoria(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+
+	c.Header("ETag", condicional.ETag(cat.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": todasLasTraducciones(cat.Traducciones, cat.Nombre, cat.Descripcion),
+	})
+}
+
+// Traducir una categoría
+// @Summary Guardar la traducción de una categoría
+// @Description Guarda el nombre y la descripción de la categoría en un idioma. En el idioma predeterminado cambia los propios nombre y descripción; el slug no cambia
+// @Tags categorias
+// @Accept json
+// @Produce json
+// @Param id path string true "ID de la categoría"
+// @Param idioma path string true "Código de idioma, p. ej. en"
+// @Param If-Match header string false "ETag obtenido al leer la categoría"
+// @Param traduccion body Traduccion true "Traducción"
+// @Success 200 {object} dominio.Respuesta{data=Categoria}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /categorias/{id}/traducciones/{idioma} [put]
+func putCategoriaTranslation(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderErrorCategoria(c, ErrConflictoVersion)
+		return
+	}
+	var traduccion Traduccion
+	if err := c.ShouldBindJSON(&traduccion); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cat, err := repo.ObtenerCategoria(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+	if idioma := strings.ToLower(c.Param("idioma")); idioma == idiomaPredeterminado() {
+		cat.Nombre, cat.Descripcion = traduccion.Nombre, traduccion.Descripcion
+	} else {
+		cat.Traducciones = cat.Traducciones.conTraduccion(idioma, &traduccion)
+	}
+	guardarCategoria(c, &cat, version)
+}
+
+// Eliminar una traducción de una categoría
+// @Summary Eliminar la traducción de una categoría
+// @Tags categorias
+// @Produce json
+// @Param id path string true "ID de la categoría"
+// @Param idioma path string true "Código de idioma"
+// @Param If-Match header string false "ETag obtenido al leer la categoría"
+// @Success 200 {object} dominio.Respuesta{data=Categoria}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Router /categorias/{id}/traducciones/{idioma} [delete]
+func deleteCategoriaTranslation(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderErrorCategoria(c, ErrConflictoVersion)
+		return
+	}
+	idioma := strings.ToLower(c.Param("idioma"))
+	if idioma == idiomaPredeterminado() {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "El idioma predeterminado no se puede eliminar"))
+		return
+	}
+
+	cat, err := repo.ObtenerCategoria(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderErrorCategoria(c, err)
+		return
+	}
+	if _, ok := cat.Traducciones[idioma]; !ok {
+		responderErrorCategoria(c, ErrTraduccionNoEncontrada)
+		return
+	}
+	cat.Traducciones = cat.Traducciones.conTraduccion(idioma, nil)
+	guardarCategoria(c, &cat, version)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestElegirIdioma(t *testing.T) {
+	t.Setenv("IDIOMAS", "")
+	t.Setenv("IDIOMA_PREDETERMINADO", "")
+	for cabecera, want := range map[string]string{
+		"":                      "es",
+		"en":                    "en",
+		"EN-gb":                 "en",
+		"en-US,en;q=0.9":        "en",
+		"es;q=0.2, en;q=0.8":    "en",
+		"fr,en;q=0.5,es;q=0.4":  "en",
+		"de":                    "es",
+		"en;q=0":                "es",
+		"en;q=mucho":            "es",
+		"*":                     "es",
+		"fr;q=0.9, *, en;q=0.5": "es",
+	} {
+		if got := elegirIdioma(cabecera); got != want {
+			t.Errorf("elegirIdioma(%q) = %s, se esperaba %s", cabecera, got, want)
+		}
+	}
+
+	// El predeterminado siempre se admite, aunque no esté en IDIOMAS
+	t.Setenv("IDIOMA_PREDETERMINADO", "en")
+	t.Setenv("IDIOMAS", "fr")
+	for cabecera, want := range map[string]string{
+		"es":    "en",
+		"fr-CA": "fr",
+		"":      "en",
+	} {
+		if got := elegirIdioma(cabecera); got != want {
+			t.Errorf("con en y fr, elegirIdioma(%q) = %s, se esperaba %s", cabecera, got, want)
+		}
+	}
+}
+
+// TestLocalizarProductos comprueba que nombre y descripción salen en el
+// idioma de Accept-Language y caen al predeterminado si no hay traducción
+func TestLocalizarProductos(t *testing.T) {
+	t.Setenv("IDIOMAS", "")
+	t.Setenv("IDIOMA_PREDETERMINADO", "")
+	srv := nuevoServidorPrueba(t)
+	p := crearProductoPrueba(t, srv, "Jarrón", crearCategoriaPrueba(t, srv, "Decoración"))
+	ruta := "/api/v1/productos/" + p.ID
+	if estado, _, cuerpo := peticion(t, srv, http.MethodPut, ruta+"/traducciones/en", map[string]any{"nombre": "Vase"}); estado != http.StatusOK {
+		t.Fatalf("traducir: %d %s", estado, cuerpo)
+	}
+	if estado, _, cuerpo := peticion(t, srv, http.MethodPut, ruta+"/traducciones/de", map[string]any{"nombre": "Vase"}); estado != http.StatusUnprocessableEntity {
+		t.Errorf("idioma no admitido: %d %s", estado, cuerpo)
+	}
+
+	for idioma, want := range map[string]struct{ idioma, nombre string }{
+		"en-GB,en;q=0.8": {"en", "Vase"},
+		"fr":             {"es", "Jarrón"},
+		"":               {"es", "Jarrón"},
+	} {
+		estado, cabeceras, cuerpo := peticion(t, srv, http.MethodGet, ruta, nil, "Accept-Language", idioma)
+		producto := datos[Producto](t, cuerpo)
+		if estado != http.StatusOK || cabeceras.Get("Content-Language") != want.idioma || producto.Nombre != want.nombre {
+			t.Errorf("Accept-Language %q: %d %s, nombre %q", idioma, estado, cabeceras.Get("Content-Language"), producto.Nombre)
+		}
+		// Sin descripción traducida se usa la predeterminada
+		if producto.Descripcion != "Producto de prueba" {
+			t.Errorf("Accept-Language %q: descripción %q", idioma, producto.Descripcion)
+		}
+		if !strings.Contains(cabeceras.Get("Vary"), "Accept-Language") {
+			t.Errorf("Accept-Language %q: Vary %q", idioma, cabeceras.Get("Vary"))
+		}
+	}
+
+	_, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/productos", nil, "Accept-Language", "en")
+	if lista := datos[[]Producto](t, cuerpo); len(lista) != 1 || lista[0].Nombre != "Vase" {
+		t.Errorf("listado en inglés: %+v", lista)
+	}
+	_, _, cuerpo = peticion(t, srv, http.MethodGet, ruta+"/traducciones", nil)
+	if todas := datos[Traducciones](t, cuerpo); len(todas) != 2 || todas["es"].Nombre != "Jarrón" || todas["en"].Nombre != "Vase" {
+		t.Errorf("traducciones: %+v", todas)
+	}
+	_, _, cuerpo = peticion(t, srv, http.MethodGet, "/api/v1/productos/no-existe", nil, "Accept-Language", "en")
+	var r struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(cuerpo, &r); err != nil || r.Error != "Product not found" {
+		t.Errorf("mensaje de error en inglés: %s", cuerpo)
+	}
+}
+
+// TestTraducirErroresMoneda comprueba que los errores de ?moneda= se traducen
+// con el resto de mensajes
+func TestTraducirErroresMoneda(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	crearProductoPrueba(t, srv, "Jarrón", crearCategoriaPrueba(t, srv, "Decoración"))
+
+	for consulta, esperado := range map[string]string{
+		"?moneda=XXX": "moneda must be a supported ISO 4217 code: ",
+		"?moneda=EUR": "Cannot convert the price: ",
+	} {
+		estado, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/productos"+consulta, nil, "Accept-Language", "en")
+		var r struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(cuerpo, &r); err != nil || estado < 400 || !strings.HasPrefix(r.Error, esperado) {
+			t.Errorf("%s: %d %s, se esperaba %q", consulta, estado, cuerpo, esperado)
+		}
+	}
+}
@@ -0,0 +1,743 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"catalogo-comun/listado"
+	dominio "catalogo-dominio"
+	"catalogo-dominio/dinero"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	formatoCSV    = "csv"
+	formatoNDJSON = "ndjson"
+
+	// tamanoLoteExportacion es el número de productos que se leen del
+	// repositorio en cada página al exportar
+	tamanoLoteExportacion = 100
+)
+
+var errFormatoImportacion = errors.New("formato no soportado, use csv o ndjson (?formato= o Content-Type text/csv / application/x-ndjson)")
+
+// columnasCSV son las columnas de la exportación en CSV. Al importar se admite
+// cualquier subconjunto que incluya sku; la columna id se ignora porque el
+// producto se identifica por su SKU
+var columnasCSV = []string{
+	"id",
+	"sku",
+	"nombre",
+	"descripcion",
+	"precio_base.importe",
+	"precio_base.moneda",
+	"dimensiones.ancho",
+	"dimensiones.alto",
+	"dimensiones.profundo",
+	"dimensiones.unidad",
+	"categoria_id",
+	"estado",
+	"publicar_en",
+	"precio_programado.importe",
+	"precio_programado.moneda",
+	"precio_desde",
+	"etiquetas",
+	"atributos",
+}
+
+// camposCSV leen y escriben cada columna de columnasCSV, y las columnas
+// antiguas que aún se aceptan al importar
+var camposCSV = map[string]struct {
+	leer     func(p Producto) string
+	escribir func(p *Producto, v string) error
+}{
+	"id": {
+		leer:     func(p Producto) string { return p.ID },
+		escribir: func(p *Producto, v string) error { return nil },
+	},
+	"sku": {
+		leer:     func(p Producto) string { return p.SKU },
+		escribir: func(p *Producto, v string) error { p.SKU = v; return nil },
+	},
+	"nombre": {
+		leer:     func(p Producto) string { return p.Nombre },
+		escribir: func(p *Producto, v string) error { p.Nombre = v; return nil },
+	},
+	"descripcion": {
+		leer:     func(p Producto) string { return p.Descripcion },
+		escribir: func(p *Producto, v string) error { p.Descripcion = v; return nil },
+	},
+	"precio_base.importe": {
+		leer:     func(p Producto) string { return p.PrecioBase.Importe.String() },
+		escribir: func(p *Producto, v string) error { return leerDecimal(v, &p.PrecioBase.Importe) },
+	},
+	"precio_base.moneda": {
+		leer:     func(p Producto) string { return p.PrecioBase.Moneda },
+		escribir: func(p *Producto, v string) error { p.PrecioBase.Moneda = strings.TrimSpace(v); return nil },
+	},
+	// precio_base es la columna de los archivos anteriores a las monedas;
+	// solo se admite al importar y equivale a precio_base.importe
+	"precio_base": {
+		leer:     func(p Producto) string { return p.PrecioBase.Importe.String() },
+		escribir: func(p *Producto, v string) error { return leerDecimal(v, &p.PrecioBase.Importe) },
+	},
+	"dimensiones.ancho": {
+		leer:     func(p Producto) string { return formatearNumero(p.Dimensiones.Ancho) },
+		escribir: func(p *Producto, v string) error { return leerNumero(v, &p.Dimensiones.Ancho) },
+	},
+	"dimensiones.alto": {
+		leer:     func(p Producto) string { return formatearNumero(p.Dimensiones.Alto) },
+		escribir: func(p *Producto, v string) error { return leerNumero(v, &p.Dimensiones.Alto) },
+	},
+	"dimensiones.profundo": {
+		leer:     func(p Producto) string { return formatearNumero(p.Dimensiones.Profundo) },
+		escribir: func(p *Producto, v string) error { return leerNumero(v, &p.Dimensiones.Profundo) },
+	},
+	"dimensiones.unidad": {
+		leer: func(p Producto) string { return string(p.Dimensiones.Unidad) },
+		escribir: func(p *Producto, v string) error {
+			p.Dimensiones.Unidad = dominio.UnidadLongitud(strings.TrimSpace(v))
+			return nil
+		},
+	},
+	"categoria_id": {
+		leer:     func(p Producto) string { return p.CategoriaID },
+		escribir: func(p *Producto, v string) error { p.CategoriaID = v; return nil },
+	},
+	"estado": {
+		leer:     func(p Producto) string { return string(p.Estado) },
+		escribir: func(p *Producto, v string) error { p.Estado = dominio.EstadoProducto(v); return nil },
+	},
+	// Las fechas programadas van en RFC 3339; vacías no programan nada, como
+	// un importe programado vacío. Sin moneda el precio programado toma la
+	// del precio base
+	"publicar_en": {
+		leer:     func(p Producto) string { return formatearFecha(p.PublicarEn) },
+		escribir: func(p *Producto, v string) error { return leerFecha(v, &p.PublicarEn) },
+	},
+	"precio_programado.importe": {
+		leer: func(p Producto) string {
+			if p.PrecioProgramado == nil {
+				return ""
+			}
+			return p.PrecioProgramado.Importe.String()
+		},
+		escribir: func(p *Producto, v string) error {
+			if strings.TrimSpace(v) == "" {
+				p.PrecioProgramado = nil
+				return nil
+			}
+			var precio dinero.Dinero
+			if p.PrecioProgramado != nil {
+				precio = *p.PrecioProgramado
+			}
+			if err := leerDecimal(v, &precio.Importe); err != nil {
+				return err
+			}
+			p.PrecioProgramado = &precio
+			return nil
+		},
+	},
+	"precio_programado.moneda": {
+		leer: func(p Producto) string {
+			if p.PrecioProgramado == nil {
+				return ""
+			}
+			return p.PrecioProgramado.Moneda
+		},
+		escribir: func(p *Producto, v string) error {
+			if v = strings.TrimSpace(v); v == "" {
+				return nil
+			}
+			var precio dinero.Dinero
+			if p.PrecioProgramado != nil {
+				precio = *p.PrecioProgramado
+			}
+			precio.Moneda = v
+			p.PrecioProgramado = &precio
+			return nil
+		},
+	},
+	"precio_desde": {
+		leer:     func(p Producto) string { return formatearFecha(p.PrecioDesde) },
+		escribir: func(p *Producto, v string) error { return leerFecha(v, &p.PrecioDesde) },
+	},
+	// Las etiquetas se separan con | y los atributos son un objeto JSON
+	"etiquetas": {
+		leer: func(p Producto) string { return strings.Join(p.Etiquetas, "|") },
+		escribir: func(p *Producto, v string) error {
+			p.Etiquetas = []string{}
+			if v != "" {
+				p.Etiquetas = strings.Split(v, "|")
+			}
+			return nil
+		},
+	},
+	"atributos": {
+		leer: func(p Producto) string {
+			if len(p.Atributos) == 0 {
+				return ""
+			}
+			b, _ := json.Marshal(p.Atributos)
+			return string(b)
+		},
+		escribir: func(p *Producto, v string) error {
+			p.Atributos = map[string]any{}
+			if v == "" {
+				return nil
+			}
+			if err := json.Unmarshal([]byte(v), &p.Atributos); err != nil {
+				return errors.New("debe ser un objeto JSON")
+			}
+			return nil
+		},
+	},
+}
+
+func formatearNumero(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func leerNumero(v string, destino *float64) error {
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return errors.New("debe ser un número")
+	}
+	*destino = f
+	return nil
+}
+
+func formatearFecha(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func leerFecha(v string, destino **time.Time) error {
+	if strings.TrimSpace(v) == "" {
+		*destino = nil
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(v))
+	if err != nil {
+		return errors.New("debe ser una fecha RFC 3339, p. ej. 2026-01-31T09:00:00Z")
+	}
+	*destino = &t
+	return nil
+}
+
+func leerDecimal(v string, destino *dinero.Decimal) error {
+	d, err := dinero.ParseDecimal(v)
+	if err != nil {
+		return err
+	}
+	*destino = d
+	return nil
+}
+
+// filaImportacion es una fila ya separada del archivo. aplicar copia sus
+// valores sobre el producto; las columnas o campos ausentes conservan el
+// valor actual del producto
+type filaImportacion struct {
+	numero  int
+	sku     string
+	errores dominio.ErroresValidacion
+	aplicar func(p *Producto) dominio.ErroresValidacion
+}
+
+// ResultadoFila es el resultado de validar o importar una fila
+type ResultadoFila struct {
+	Fila    int                       `json:"fila"`
+	SKU     string                    `json:"sku"`
+	Accion  string                    `json:"accion,omitempty"`
+	ID      string                    `json:"id,omitempty"`
+	Errores dominio.ErroresValidacion `json:"errores,omitempty"`
+}
+
+// InformeImportacion resume una importación
+type InformeImportacion struct {
+	DryRun       bool            `json:"dry_run"`
+	Importado    bool            `json:"importado"`
+	Filas        int             `json:"filas"`
+	Creados      int             `json:"creados"`
+	Actualizados int             `json:"actualizados"`
+	Errores      int             `json:"con_errores"`
+	Detalle      []ResultadoFila `json:"detalle"`
+}
+
+// tamanoMaximoImportacion lee IMPORTACION_TAMANO_MAXIMO_MB; por defecto 10 MB
+func tamanoMaximoImportacion() int64 {
+	if mb, err := strconv.Atoi(os.Getenv("IMPORTACION_TAMANO_MAXIMO_MB")); err == nil && mb > 0 {
+		return int64(mb) << 20
+	}
+	return 10 << 20
+}
+
+// formatoPeticion elige el formato con ?formato= o, si no se indica, con el
+// Content-Type (importación) o el Accept (exportación)
+func formatoPeticion(c *gin.Context, cabecera string) (string, error) {
+	if f := c.Query("formato"); f != "" {
+		switch f {
+		case formatoCSV, formatoNDJSON:
+			return f, nil
+		}
+		return "", errFormatoImportacion
+	}
+	tipo, _, _ := mime.ParseMediaType(c.GetHeader(cabecera))
+	switch tipo {
+	case "text/csv":
+		return formatoCSV, nil
+	case "application/x-ndjson", "application/jsonl", "application/json-lines":
+		return formatoNDJSON, nil
+	case "", "*/*":
+		if cabecera == "Accept" {
+			return formatoNDJSON, nil
+		}
+	}
+	return "", errFormatoImportacion
+}
+
+// Importar productos
+// @Summary Importar productos en lote
+// @Description Crea o actualiza productos a partir de un archivo CSV o NDJSON. Cada fila se identifica por su sku: si ya existe un producto con ese SKU se actualiza y si no se crea. Los campos ausentes conservan su valor. Con ?dry_run=true solo se valida y se devuelve el informe por fila. Sin dry_run el lote se aplica completo o no se aplica: si alguna fila tiene errores no se escribe nada
+// @Tags productos
+// @Accept text/csv
+// @Accept application/x-ndjson
+// @Produce json
+// @Param formato query string false "csv o ndjson; por defecto se deduce del Content-Type"
+// @Param dry_run query bool false "Validar sin guardar"
+// @Param unidad query string false "Unidad de las dimensiones de las filas que no la indican: mm (por defecto), cm o in"
+// @Param Idempotency-Key header string false "Clave única de la operación; un reintento con la misma clave y la misma petición devuelve la respuesta guardada"
+// @Success 200 {object} dominio.Respuesta{data=InformeImportacion}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Failure 413 {object} dominio.RespuestaError
+// @Failure 415 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError{data=InformeImportacion}
+// @Router /productos/import [post]
+func importProducts(c *gin.Context) {
+	formato, err := formatoPeticion(c, "Content-Type")
+	if err != nil {
+		responderMensaje(c, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	unidad, err := dominio.ParseUnidad(c.Query("unidad"))
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	datos, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, tamanoMaximoImportacion()))
+	if err != nil {
+		var demasiadoGrande *http.MaxBytesError
+		if errors.As(err, &demasiadoGrande) {
+			responderMensaje(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("El archivo supera el tamaño máximo de %d MB", demasiadoGrande.Limit>>20))
+			return
+		}
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var filas []filaImportacion
+	if formato == formatoCSV {
+		filas, err = leerFilasCSV(datos)
+	} else {
+		filas, err = leerFilasNDJSON(datos)
+	}
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	informe, crear, actualizar, err := prepararImportacion(c, filas, unidad)
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	informe.DryRun = dryRun
+
+	switch {
+	case informe.Errores > 0 && !dryRun:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":  traducir(c, "El archivo tiene filas con errores; no se importó ningún producto"),
+			"codigo": dominio.CodigoValidacion,
+			"data":   informe,
+		})
+		return
+	case dryRun:
+		c.JSON(http.StatusOK, gin.H{
+			"data": informe,
+		})
+		return
+	}
+
+	if err := repo.Importar(c.Request.Context(), crear, actualizar); err != nil {
+		responderError(c, err)
+		return
+	}
+	informe.Importado = true
+	log.Printf("Importación: %d productos creados y %d actualizados", informe.Creados, informe.Actualizados)
+	c.JSON(http.StatusOK, gin.H{
+		"data": informe,
+	})
+}
+
+// prepararImportacion valida cada fila contra el catálogo actual y separa los
+// productos a crear de los que se actualizan. Las dimensiones de las filas
+// sin unidad se entienden en unidad
+func prepararImportacion(c *gin.Context, filas []filaImportacion, unidad dominio.UnidadLongitud) (InformeImportacion, []*Producto, []ActualizacionProducto, error) {
+	informe := InformeImportacion{Filas: len(filas), Detalle: make([]ResultadoFila, 0, len(filas))}
+
+	skus := make([]string, 0, len(filas))
+	for _, f := range filas {
+		if f.sku != "" {
+			skus = append(skus, f.sku)
+		}
+	}
+	existentes, err := repo.BuscarPorSKU(c.Request.Context(), skus)
+	if err != nil {
+		return informe, nil, nil, err
+	}
+	todas, err := repo.ListarCategorias(c.Request.Context())
+	if err != nil {
+		return informe, nil, nil, err
+	}
+	categorias := make(map[string]bool, len(todas))
+	for _, cat := range todas {
+		categorias[cat.ID] = true
+	}
+	definiciones, err := repo.ListarAtributos(c.Request.Context())
+	if err != nil {
+		return informe, nil, nil, err
+	}
+
+	var (
+		crear      []*Producto
+		actualizar []ActualizacionProducto
+		vistos     = make(map[string]int)
+	)
+	for _, f := range filas {
+		res := ResultadoFila{Fila: f.numero, SKU: f.sku, Errores: f.errores}
+		if f.sku == "" {
+			res.Errores.Agregar("sku", "es obligatorio para importar")
+		} else if anterior, ok := vistos[f.sku]; ok {
+			res.Errores.Agregar("sku", fmt.Sprintf("repetido, ya aparece en la fila %d", anterior))
+		}
+		vistos[f.sku] = f.numero
+
+		if len(res.Errores) == 0 {
+			existente, ok := existentes[f.sku]
+			producto := existente
+			// El JSON se decodifica sobre el producto actual; los mapas y listas
+			// se copian para no modificar los del repositorio
+			producto.Traducciones = maps.Clone(existente.Traducciones)
+			producto.Atributos = maps.Clone(existente.Atributos)
+			producto.Etiquetas = slices.Clone(existente.Etiquetas)
+			producto.Componentes = slices.Clone(existente.Componentes)
+			// Las dimensiones que la fila no trae se expresan en la unidad del
+			// archivo para que no se mezclen con las que sí trae
+			producto.Dimensiones = existente.Dimensiones.En(unidad)
+			res.Errores = append(res.Errores, f.aplicar(&producto)...)
+			// La fila no puede cambiar la identidad ni los datos derivados del modelo 3D
+			producto.ID, producto.SKU, producto.Version = existente.ID, f.sku, existente.Version
+			producto.Geometria, producto.Imprimibilidad = existente.Geometria, existente.Imprimibilidad
+			completarMoneda(&producto)
+			producto.Dimensiones.Normalizar()
+			producto.Etiquetas = normalizarEtiquetas(producto.Etiquetas)
+			if err := prepararKit(c.Request.Context(), &producto); err != nil {
+				var errsKit dominio.ErroresValidacion
+				if !errors.As(err, &errsKit) {
+					return informe, nil, nil, err
+				}
+				res.Errores = append(res.Errores, errsKit...)
+			}
+
+			if ok {
+				res.Accion, res.ID = "actualizar", producto.ID
+				res.Errores = append(res.Errores, erroresCampo(validarProducto(producto))...)
+				res.Errores = append(res.Errores, erroresCampo(dominio.ValidarTransicion(existente.Estado, producto.Estado))...)
+				res.Errores = append(res.Errores, erroresCampo(validarImprimibilidad(existente.Estado, producto))...)
+			} else {
+				if producto.Estado == "" {
+					producto.Estado = dominio.EstadoBorrador
+				}
+				producto.ID = uuid.New().String()
+				res.Accion, res.ID = "crear", producto.ID
+				res.Errores = append(res.Errores, erroresCampo(validarProductoNuevo(producto))...)
+			}
+			if producto.CategoriaID != "" && !categorias[producto.CategoriaID] {
+				res.Errores.Agregar("categoria_id", "no existe")
+			}
+			res.Errores = append(res.Errores, validarAtributos(producto, definiciones, todas)...)
+
+			if len(res.Errores) == 0 {
+				if ok {
+					actualizar = append(actualizar, ActualizacionProducto{Producto: &producto, Version: existente.Version})
+					informe.Actualizados++
+				} else {
+					crear = append(crear, &producto)
+					informe.Creados++
+				}
+			}
+		}
+
+		if len(res.Errores) > 0 {
+			res.Accion, res.ID = "", ""
+			informe.Errores++
+		}
+		informe.Detalle = append(informe.Detalle, res)
+	}
+	return informe, crear, actualizar, nil
+}
+
+// erroresCampo extrae los errores de campo de un error de validación
+func erroresCampo(err error) dominio.ErroresValidacion {
+	var errs dominio.ErroresValidacion
+	errors.As(err, &errs)
+	return errs
+}
+
+// leerFilasCSV separa un CSV con cabecera. Las columnas desconocidas hacen
+// fallar todo el archivo; los valores mal formados se informan en su fila
+func leerFilasCSV(datos []byte) ([]filaImportacion, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(datos, []byte("\ufeff"))))
+	cabecera, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("el archivo está vacío")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("csv: %w", err)
+	}
+	for i, col := range cabecera {
+		cabecera[i] = strings.ToLower(strings.TrimSpace(col))
+		if _, ok := camposCSV[cabecera[i]]; !ok {
+			return nil, fmt.Errorf("csv: columna desconocida %q", col)
+		}
+	}
+	columnaSKU := -1
+	for i, col := range cabecera {
+		if col == "sku" {
+			columnaSKU = i
+		}
+	}
+	if columnaSKU < 0 {
+		return nil, errors.New("csv: falta la columna sku")
+	}
+	r.FieldsPerRecord = len(cabecera)
+
+	var filas []filaImportacion
+	for {
+		registro, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv: %w", err)
+		}
+		linea, _ := r.FieldPos(0)
+		filas = append(filas, filaImportacion{
+			numero: linea,
+			sku:    strings.TrimSpace(registro[columnaSKU]),
+			aplicar: func(p *Producto) dominio.ErroresValidacion {
+				var errs dominio.ErroresValidacion
+				for i, col := range cabecera {
+					if err := camposCSV[col].escribir(p, registro[i]); err != nil {
+						errs.Agregar(col, err.Error())
+					}
+				}
+				return errs
+			},
+		})
+	}
+	return filas, nil
+}
+
+// leerFilasNDJSON separa un archivo con un producto JSON por línea, con los
+// mismos campos que la API. Las líneas vacías se ignoran
+func leerFilasNDJSON(datos []byte) ([]filaImportacion, error) {
+	sc := bufio.NewScanner(bytes.NewReader(datos))
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	var filas []filaImportacion
+	linea := 0
+	for sc.Scan() {
+		linea++
+		contenido := bytes.TrimSpace(sc.Bytes())
+		if len(contenido) == 0 {
+			continue
+		}
+		contenido = bytes.Clone(contenido)
+
+		fila := filaImportacion{numero: linea}
+		var clave struct {
+			SKU string `json:"sku"`
+		}
+		if err := json.Unmarshal(contenido, &clave); err != nil {
+			fila.errores.Agregar("", "JSON mal formado: "+err.Error())
+		}
+		fila.sku = strings.TrimSpace(clave.SKU)
+		fila.aplicar = func(p *Producto) dominio.ErroresValidacion {
+			dec := json.NewDecoder(bytes.NewReader(contenido))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(p); err != nil {
+				return dominio.ErroresValidacion{{Mensaje: "JSON inválido: " + err.Error()}}
+			}
+			return nil
+		}
+		filas = append(filas, fila)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("ndjson: %w", err)
+	}
+	return filas, nil
+}
+
+// Exportar productos
+// @Summary Exportar el catálogo
+// @Description Descarga todos los productos en CSV o NDJSON, opcionalmente filtrados por categoría, etiquetas, atributos y dimensiones. La respuesta se envía por partes a medida que se leen los productos. El CSV aplana el precio y las dimensiones en columnas precio_base.importe, precio_base.moneda, dimensiones.ancho, dimensiones.alto, dimensiones.profundo y dimensiones.unidad
+// @Tags productos
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Param formato query string false "csv o ndjson; por defecto se deduce del Accept"
+// @Param categoria_id query string false "ID de la categoría de los productos"
+// @Param subcategorias query bool false "Con categoria_id, incluir también sus subcategorías"
+// @Param etiqueta query string false "Etiquetas que deben tener todos los productos, repetible o separadas por coma"
+// @Param atributo.{codigo} query string false "Valor exacto de un atributo; los numéricos admiten atributo.{codigo}.min y atributo.{codigo}.max"
+// @Param componente query string false "ID de un producto; devuelve los kits que lo incluyen"
+// @Param dimensiones.{eje}.min query string false "Dimensión mínima (eje ancho, alto o profundo), en ?unidad= o con sufijo de unidad, p. ej. 2in"
+// @Param dimensiones.{eje}.max query string false "Dimensión máxima, como dimensiones.{eje}.min"
+// @Param unidad query string false "Unidad de las dimensiones exportadas y de los filtros sin sufijo: mm (por defecto), cm o in"
+// @Success 200 {file} file
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 406 {object} dominio.RespuestaError
+// @Router /productos/export [get]
+func exportProducts(c *gin.Context) {
+	formato, err := formatoPeticion(c, "Accept")
+	if err != nil {
+		responderMensaje(c, http.StatusNotAcceptable, err.Error())
+		return
+	}
+
+	etiquetas, atributos, err := parseFiltrosProducto(c.Request.Context(), c.Request.URL.Query())
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dimensiones, err := dominio.ParseFiltrosDimensiones(c.Request.URL.Query())
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	unidad, err := dominio.ParseUnidad(c.Query("unidad"))
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	consulta := ConsultaProductos{
+		Etiquetas:   etiquetas,
+		Atributos:   atributos,
+		Dimensiones: dimensiones,
+		Componente:  c.Query("componente"),
+		Orden:       []listado.CampoOrden{{Campo: "id"}},
+		Ventana:     listado.Ventana{Pagina: 1, Tamano: tamanoLoteExportacion},
+	}
+	if id := c.Query("categoria_id"); id != "" {
+		consulta.Categorias = []string{id}
+		if sub, _ := strconv.ParseBool(c.Query("subcategorias")); sub {
+			todas, err := repo.ListarCategorias(c.Request.Context())
+			if err != nil {
+				responderError(c, err)
+				return
+			}
+			consulta.Categorias = descendientes(todas, id)
+		}
+	}
+
+	// La primera página se lee antes de escribir la cabecera para poder
+	// responder un error si el repositorio falla
+	productos, res, err := repo.Listar(c.Request.Context(), consulta)
+	if err == nil {
+		err = completarKits(c.Request.Context(), productos)
+	}
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+
+	var escribir func(p Producto) error
+	var terminar func() error
+	if formato == formatoCSV {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		if err := w.Write(columnasCSV); err != nil {
+			return
+		}
+		fila := make([]string, len(columnasCSV))
+		escribir = func(p Producto) error {
+			for i, col := range columnasCSV {
+				fila[i] = camposCSV[col].leer(p)
+			}
+			return w.Write(fila)
+		}
+		terminar = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(c.Writer)
+		escribir = func(p Producto) error { return enc.Encode(p) }
+		terminar = func() error { return nil }
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "productos."+formato))
+	c.Status(http.StatusOK)
+
+	for {
+		for _, p := range productos {
+			p.Dimensiones = p.Dimensiones.En(unidad)
+			if err := escribir(p); err != nil {
+				log.Printf("Exportación interrumpida: %v", err)
+				return
+			}
+		}
+		if err := terminar(); err != nil {
+			log.Printf("Exportación interrumpida: %v", err)
+			return
+		}
+		c.Writer.Flush()
+
+		if res.Siguiente == nil {
+			return
+		}
+		consulta.Ventana.Cursor = res.Siguiente
+		productos, res, err = repo.Listar(c.Request.Context(), consulta)
+		if err == nil {
+			err = completarKits(c.Request.Context(), productos)
+		}
+		if err != nil {
+			// Ya se envió parte de la respuesta, así que solo queda registrarlo
+			log.Printf("Exportación interrumpida: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// importar sube un archivo a POST /productos/import con el Content-Type del
+// formato y devuelve el estado y el informe, también el de las respuestas 422
+func importar(t *testing.T, srv *httptest.Server, consulta, tipo, archivo string) (int, InformeImportacion) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/v1/productos/import"+consulta, strings.NewReader(archivo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", tipo)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	cuerpo, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r struct {
+		Data InformeImportacion `json:"data"`
+	}
+	if err := json.Unmarshal(cuerpo, &r); err != nil {
+		t.Fatalf("respuesta inválida %s: %v", cuerpo, err)
+	}
+	return resp.StatusCode, r.Data
+}
+
+// ndjson une las filas, cada una codificada en JSON, en un archivo NDJSON
+func ndjson(t *testing.T, filas ...map[string]any) string {
+	t.Helper()
+	var b strings.Builder
+	for _, f := range filas {
+		linea, err := json.Marshal(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Write(linea)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// filaPrueba es un producto publicado válido con SKU para importar
+func filaPrueba(sku, nombre, categoriaID string) map[string]any {
+	f := productoPrueba(nombre, categoriaID)
+	f["sku"] = sku
+	return f
+}
+
+// productosPorSKU lista el catálogo completo indexado por SKU
+func productosPorSKU(t *testing.T) map[string]Producto {
+	t.Helper()
+	productos := make(map[string]Producto)
+	for _, p := range repo.(*repositorioMemoria).porID {
+		productos[p.SKU] = p
+	}
+	return productos
+}
+
+func TestImportarCSV(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	archivo := "sku,nombre,descripcion,precio_base.importe,precio_base.moneda,dimensiones.ancho,dimensiones.alto,dimensiones.profundo,categoria_id,estado,etiquetas\n" +
+		"JAR-1,Jarrón,Jarrón de prueba,10.50,USD,1,2,3," + categoriaID + ",disponible,azul|grande\n" +
+		"LAM-1,Lámpara,Lámpara de prueba,20,EUR,10,20,30," + categoriaID + ",borrador,\n"
+
+	// Con dry_run se valida sin guardar
+	estado, informe := importar(t, srv, "?dry_run=true&unidad=cm", "text/csv", archivo)
+	if estado != http.StatusOK || !informe.DryRun || informe.Importado || informe.Creados != 2 || informe.Errores != 0 {
+		t.Fatalf("dry_run: %d %+v", estado, informe)
+	}
+	if n := len(productosPorSKU(t)); n != 0 {
+		t.Fatalf("dry_run guardó %d productos", n)
+	}
+
+	estado, informe = importar(t, srv, "?unidad=cm", "text/csv", archivo)
+	if estado != http.StatusOK || !informe.Importado || informe.Creados != 2 {
+		t.Fatalf("importar: %d %+v", estado, informe)
+	}
+	productos := productosPorSKU(t)
+	jarron := productos["JAR-1"]
+	if jarron.Nombre != "Jarrón" || jarron.PrecioBase.Importe.String() != "10.5" || jarron.Dimensiones.Ancho != 10 ||
+		strings.Join(jarron.Etiquetas, ",") != "azul,grande" || jarron.Version != 1 {
+		t.Errorf("jarrón importado: %+v", jarron)
+	}
+	if lampara := productos["LAM-1"]; lampara.PrecioBase.Moneda != "EUR" || lampara.Dimensiones.Profundo != 300 {
+		t.Errorf("lámpara importada: %+v", lampara)
+	}
+
+	// El mismo SKU actualiza el producto en lugar de crear otro
+	estado, informe = importar(t, srv, "", "text/csv", "sku,nombre\nJAR-1,Jarrón azul\n")
+	if estado != http.StatusOK || informe.Creados != 0 || informe.Actualizados != 1 || informe.Detalle[0].ID != jarron.ID {
+		t.Fatalf("actualizar por SKU: %d %+v", estado, informe)
+	}
+	if actual := productosPorSKU(t)["JAR-1"]; actual.Nombre != "Jarrón azul" || actual.Version != 2 || actual.Dimensiones.Ancho != 10 {
+		t.Errorf("jarrón actualizado: %+v", actual)
+	}
+}
+
+func TestImportarArchivoInvalido(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	for nombre, archivo := range map[string]string{
+		"columna desconocida": "sku,color\nJAR-1,rojo\n",
+		"sin sku":             "nombre\nJarrón\n",
+		"vacío":               "",
+	} {
+		if estado, _ := importar(t, srv, "", "text/csv", archivo); estado != http.StatusBadRequest {
+			t.Errorf("%s: %d, se esperaba 400", nombre, estado)
+		}
+	}
+	if estado, _ := importar(t, srv, "?unidad=ft", "text/csv", "sku\nJAR-1\n"); estado != http.StatusBadRequest {
+		t.Errorf("unidad desconocida: %d, se esperaba 400", estado)
+	}
+	if estado, _ := importar(t, srv, "", "application/xml", "<productos/>"); estado != http.StatusUnsupportedMediaType {
+		t.Errorf("formato no soportado: %d, se esperaba 415", estado)
+	}
+}
+
+// TestImportarFilasConErrores comprueba que una fila inválida deja el archivo
+// entero sin importar e informa cada fila con su número de línea
+func TestImportarFilasConErrores(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	sinNombre := filaPrueba("LAM-1", "", categoriaID)
+	archivo := ndjson(t,
+		filaPrueba("JAR-1", "Jarrón", categoriaID),
+		sinNombre,
+		filaPrueba("JAR-1", "Jarrón repetido", categoriaID),
+	) + "{no es json\n"
+
+	estado, informe := importar(t, srv, "", "application/x-ndjson", archivo)
+	if estado != http.StatusUnprocessableEntity || informe.Importado || informe.Filas != 4 || informe.Errores != 3 {
+		t.Fatalf("importar: %d %+v", estado, informe)
+	}
+	if d := informe.Detalle[0]; d.Fila != 1 || d.Accion != "crear" || len(d.Errores) != 0 {
+		t.Errorf("fila 1: %+v", d)
+	}
+	if d := informe.Detalle[1]; d.Fila != 2 || d.Accion != "" || len(d.Errores) == 0 || d.Errores[0].Campo != "nombre" {
+		t.Errorf("fila 2: %+v", d)
+	}
+	if d := informe.Detalle[2]; len(d.Errores) != 1 || d.Errores[0].Campo != "sku" {
+		t.Errorf("fila 3 con el SKU repetido: %+v", d)
+	}
+	if d := informe.Detalle[3]; d.Fila != 4 || len(d.Errores) == 0 {
+		t.Errorf("fila 4 con JSON mal formado: %+v", d)
+	}
+	if n := len(productosPorSKU(t)); n != 0 {
+		t.Errorf("se guardaron %d productos de un archivo con errores", n)
+	}
+}
+
+// TestImportarFallaAlGuardar valida un archivo cuyas filas son correctas por
+// separado pero no juntas: la segunda convierte en kit un producto que la
+// primera usa como componente. La escritura falla y no debe quedar nada
+func TestImportarFallaAlGuardar(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	if estado, informe := importar(t, srv, "", "application/x-ndjson", ndjson(t,
+		filaPrueba("JAR-1", "Jarrón", categoriaID),
+		filaPrueba("LAM-1", "Lámpara", categoriaID),
+	)); estado != http.StatusOK {
+		t.Fatalf("importar los componentes: %d %+v", estado, informe)
+	}
+	antes := productosPorSKU(t)
+	revisiones := len(repo.(*repositorioMemoria).revisiones)
+	jarron, lampara := antes["JAR-1"], antes["LAM-1"]
+
+	kit := filaPrueba("KIT-1", "Kit", categoriaID)
+	kit["componentes"] = []map[string]any{{"producto_id": jarron.ID, "cantidad": 1}}
+	jarronKit := map[string]any{"sku": "JAR-1", "componentes": []map[string]any{{"producto_id": lampara.ID, "cantidad": 2}}}
+	estado, informe := importar(t, srv, "", "application/x-ndjson", ndjson(t, kit, jarronKit))
+	if estado != http.StatusUnprocessableEntity || informe.Importado {
+		t.Fatalf("importar: %d %+v, se esperaba 422", estado, informe)
+	}
+
+	despues := productosPorSKU(t)
+	if _, ok := despues["KIT-1"]; ok || len(despues) != len(antes) {
+		t.Errorf("la importación fallida creó productos: %v", despues)
+	}
+	if actual := despues["JAR-1"]; actual.esKit() || actual.Version != jarron.Version {
+		t.Errorf("la importación fallida cambió el jarrón: %+v", actual)
+	}
+	if n := len(repo.(*repositorioMemoria).revisiones); n != revisiones {
+		t.Errorf("quedaron revisiones de la importación fallida: %d, antes %d", n, revisiones)
+	}
+}
+
+func TestExportar(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	jarron := filaPrueba("JAR-1", "Jarrón", categoriaID)
+	jarron["etiquetas"] = []string{"azul"}
+	if estado, informe := importar(t, srv, "", "application/x-ndjson", ndjson(t, jarron)); estado != http.StatusOK {
+		t.Fatalf("importar: %d %+v", estado, informe)
+	}
+
+	estado, cabeceras, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/productos/export?unidad=cm", nil, "Accept", "text/csv")
+	if estado != http.StatusOK || !strings.HasPrefix(cabeceras.Get("Content-Type"), "text/csv") {
+		t.Fatalf("exportar CSV: %d %s", estado, cuerpo)
+	}
+	lineas := strings.Split(strings.TrimSpace(string(cuerpo)), "\n")
+	if len(lineas) != 2 || lineas[0] != strings.Join(columnasCSV, ",") {
+		t.Fatalf("CSV exportado:\n%s", cuerpo)
+	}
+	if !strings.Contains(lineas[1], ",JAR-1,Jarrón,") || !strings.Contains(lineas[1], ",10.5,USD,1,2,3,cm,") || !strings.Contains(lineas[1], ",azul,") {
+		t.Errorf("fila exportada: %s", lineas[1])
+	}
+
+	// El CSV exportado se puede volver a importar sin cambios
+	if estado, informe := importar(t, srv, "", "text/csv", string(cuerpo)); estado != http.StatusOK || informe.Actualizados != 1 {
+		t.Errorf("reimportar el CSV exportado: %d %+v", estado, informe)
+	}
+	if p := productosPorSKU(t)["JAR-1"]; p.Dimensiones.Ancho != 10 || p.Dimensiones.Profundo != 30 {
+		t.Errorf("dimensiones tras reimportar: %+v", p.Dimensiones)
+	}
+
+	estado, _, cuerpo = peticion(t, srv, http.MethodGet, "/api/v1/productos/export?formato=ndjson", nil)
+	if estado != http.StatusOK {
+		t.Fatalf("exportar NDJSON: %d %s", estado, cuerpo)
+	}
+	var exportado Producto
+	if err := json.Unmarshal(cuerpo, &exportado); err != nil || exportado.SKU != "JAR-1" || exportado.Dimensiones.Ancho != 10 {
+		t.Errorf("NDJSON exportado %s: %v", cuerpo, err)
+	}
+
+	if estado, _, _ := peticion(t, srv, http.MethodGet, "/api/v1/productos/export?formato=csv&unidad=ft", nil); estado != http.StatusBadRequest {
+		t.Errorf("exportar con una unidad desconocida: %d, se esperaba 400", estado)
+	}
+}
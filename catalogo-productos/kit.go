@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"catalogo-comun/listado"
+	dominio "catalogo-dominio"
+	"catalogo-dominio/dinero"
+)
+
+// ErrProductoEnKit se devuelve al eliminar un producto que es componente de
+// algún kit, también de uno en la papelera
+var ErrProductoEnKit = errors.New("el producto forma parte de un kit")
+
+// maxComponentesKit limita los componentes distintos de un kit
+const maxComponentesKit = 100
+
+// centesima es 0.01, para aplicar porcentajes
+const centesima = dinero.Uno / 100
+
+// Componente es un producto incluido en un kit con las unidades que lleva
+type Componente struct {
+	ProductoID string `json:"producto_id"`
+	Cantidad   int    `json:"cantidad" example:"2"`
+}
+
+// esKit indica si el producto es un kit, es decir, si tiene componentes
+func (p Producto) esKit() bool {
+	return len(p.Componentes) > 0
+}
+
+// validarComponentes aplica las reglas de un kit que no dependen de otros
+// productos; prepararKit comprueba que los componentes existen
+func validarComponentes(p Producto, errs *dominio.ErroresValidacion) {
+	if len(p.Componentes) > maxComponentesKit {
+		errs.Agregar("componentes", fmt.Sprintf("admite como máximo %d componentes", maxComponentesKit))
+	}
+	vistos := make(map[string]bool, len(p.Componentes))
+	for i, comp := range p.Componentes {
+		campo := fmt.Sprintf("componentes.%d.", i)
+		switch {
+		case comp.ProductoID == "":
+			errs.Agregar(campo+"producto_id", "es obligatorio")
+		case comp.ProductoID == p.ID:
+			errs.Agregar(campo+"producto_id", "un kit no puede incluirse a sí mismo")
+		case vistos[comp.ProductoID]:
+			errs.Agregar(campo+"producto_id", "está repetido")
+		}
+		vistos[comp.ProductoID] = true
+		if comp.Cantidad < 1 {
+			errs.Agregar(campo+"cantidad", "debe ser mayor que 0")
+		}
+	}
+	switch {
+	case p.DescuentoKit < 0 || p.DescuentoKit >= 100*dinero.Uno:
+		errs.Agregar("descuento_kit", "debe estar entre 0 y 100")
+	case p.DescuentoKit.Decimales() > 2:
+		errs.Agregar("descuento_kit", "admite como máximo 2 decimales")
+	case p.DescuentoKit != 0 && !p.esKit():
+		errs.Agregar("descuento_kit", "solo se aplica a los kits")
+	}
+}
+
+// prepararKit deja un producto listo para guardarse: descarta la
+// disponibilidad, que es de solo lectura, y en los kits comprueba los
+// componentes y calcula el precio en la moneda del kit. Un kit no puede
+// incluir otros kits ni ser componente de otro
+func prepararKit(ctx context.Context, p *Producto) error {
+	p.Disponible = false
+	if !p.esKit() {
+		return nil
+	}
+
+	var errs dominio.ErroresValidacion
+	validarComponentes(*p, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	componentes, err := repo.BuscarPorID(ctx, idsComponentes([]Producto{*p}))
+	if err != nil {
+		return err
+	}
+	enKit := false
+	if p.ID != "" {
+		kits, _, err := repo.Listar(ctx, ConsultaProductos{
+			Componente: p.ID,
+			Orden:      []listado.CampoOrden{{Campo: "id"}},
+			Ventana:    listado.Ventana{Pagina: 1, Tamano: 1},
+		})
+		if err != nil {
+			return err
+		}
+		enKit = len(kits) > 0
+	}
+	if err := comprobarComponentes(*p, componentes, enKit); err != nil {
+		return err
+	}
+
+	tasas, err := repo.ListarTasas(ctx)
+	if err != nil {
+		return err
+	}
+	precio, err := precioKit(*p, componentes, tasas, time.Now())
+	if errors.Is(err, dinero.ErrSinTasa) {
+		return dominio.ErroresValidacion{{Campo: "precio_base.moneda", Mensaje: err.Error()}}
+	}
+	if errors.Is(err, dinero.ErrDesbordamiento) {
+		return dominio.ErroresValidacion{{Campo: "componentes", Mensaje: "el precio del kit " + err.Error()}}
+	}
+	if err != nil {
+		return err
+	}
+	p.PrecioBase = precio
+	return nil
+}
+
+// comprobarComponentes aplica las reglas de un kit que dependen de otros
+// productos: sus componentes, buscados por ID en componentes, deben existir y
+// no ser kits, y el kit no puede ser componente de otro, lo que indica enKit.
+// prepararKit las comprueba para responder con todos los errores; los
+// repositorios las vuelven a comprobar al escribir, porque entre tanto otra
+// petición puede haber borrado un componente o convertido uno en kit
+func comprobarComponentes(p Producto, componentes map[string]Producto, enKit bool) error {
+	if !p.esKit() {
+		return nil
+	}
+	var errs dominio.ErroresValidacion
+	for i, comp := range p.Componentes {
+		campo := fmt.Sprintf("componentes.%d.producto_id", i)
+		c, ok := componentes[comp.ProductoID]
+		switch {
+		case !ok:
+			errs.Agregar(campo, "no existe")
+		case c.esKit():
+			errs.Agregar(campo, "no puede ser otro kit")
+		}
+	}
+	if enKit {
+		errs.Agregar("componentes", "el producto es componente de otro kit")
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// precioKit suma los precios de los componentes por su cantidad, convertidos
+// a la moneda del kit, y aplica el descuento del kit
+func precioKit(kit Producto, componentes map[string]Producto, tasas []dinero.TasaCambio, en time.Time) (dinero.Dinero, error) {
+	moneda := kit.PrecioBase.Moneda
+	var total dinero.Decimal
+	for _, comp := range kit.Componentes {
+		precio, err := dinero.Convertir(componentes[comp.ProductoID].PrecioBase, moneda, tasas, en)
+		if err != nil {
+			return dinero.Dinero{}, err
+		}
+		subtotal, err := precio.Importe.MultiplicarEntero(int64(comp.Cantidad))
+		if err != nil {
+			return dinero.Dinero{}, err
+		}
+		if total, err = total.Sumar(subtotal); err != nil {
+			return dinero.Dinero{}, err
+		}
+	}
+	if kit.DescuentoKit != 0 {
+		// El descuento está validado entre 0 y 100, así que ni él ni el
+		// total con el descuento aplicado pueden desbordar
+		descuento, _ := kit.DescuentoKit.Multiplicar(centesima)
+		total, _ = total.Multiplicar(dinero.Uno - descuento)
+	}
+	total, err := total.Redondear(dinero.Decimales(moneda))
+	if err != nil {
+		return dinero.Dinero{}, err
+	}
+	return dinero.Dinero{Importe: total, Moneda: moneda}, nil
+}
+
+// completarKits calcula la disponibilidad de los productos leídos y el precio
+// de los kits con los precios actuales de sus componentes. Un kit solo está
+// disponible si lo están él y todos sus componentes; si falta alguno o su
+// precio no se puede convertir, conserva el precio calculado al guardarlo
+func completarKits(ctx context.Context, productos []Producto) error {
+	var componentes map[string]Producto
+	var tasas []dinero.TasaCambio
+	if ids := idsComponentes(productos); len(ids) > 0 {
+		var err error
+		if componentes, err = repo.BuscarPorID(ctx, ids); err != nil {
+			return err
+		}
+		if tasas, err = repo.ListarTasas(ctx); err != nil {
+			return err
+		}
+	}
+
+	ahora := time.Now()
+	for i := range productos {
+		p := &productos[i]
+		p.Disponible = p.Estado == dominio.EstadoDisponible && !p.EliminadoEn.Valid
+		completo := true
+		for _, comp := range p.Componentes {
+			c, ok := componentes[comp.ProductoID]
+			if !ok || c.Estado != dominio.EstadoDisponible {
+				p.Disponible = false
+			}
+			completo = completo && ok
+		}
+		if p.esKit() && completo {
+			if precio, err := precioKit(*p, componentes, tasas, ahora); err == nil {
+				p.PrecioBase = precio
+			}
+		}
+	}
+	return nil
+}
+
+// idsComponentes devuelve los IDs de los componentes de los kits, sin repetir
+func idsComponentes(productos []Producto) []string {
+	var ids []string
+	vistos := make(map[string]bool)
+	for _, p := range productos {
+		for _, comp := range p.Componentes {
+			if !vistos[comp.ProductoID] {
+				vistos[comp.ProductoID] = true
+				ids = append(ids, comp.ProductoID)
+			}
+		}
+	}
+	return ids
+}
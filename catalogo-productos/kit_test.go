@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	dominio "catalogo-dominio"
+)
+
+// TestRepositorioCompruebaKits escribe directamente en el repositorio, sin
+// pasar por prepararKit, como una petición que comprobó los componentes antes
+// de que otra los cambiara: el repositorio debe rechazar la escritura
+func TestRepositorioCompruebaKits(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	ctx := context.Background()
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	jarron := crearProductoPrueba(t, srv, "Jarrón", categoriaID)
+	lampara := crearProductoPrueba(t, srv, "Lámpara", categoriaID)
+	borrado := crearProductoPrueba(t, srv, "Borrado", categoriaID)
+	if err := repo.Eliminar(ctx, borrado.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	kit := func(id string, componentes ...string) Producto {
+		p := jarron
+		p.ID, p.SKU, p.Nombre = id, "", "Kit "+id
+		p.Componentes = nil
+		for _, c := range componentes {
+			p.Componentes = append(p.Componentes, Componente{ProductoID: c, Cantidad: 1})
+		}
+		return p
+	}
+	campoError := func(err error) string {
+		var errs dominio.ErroresValidacion
+		if !errors.As(err, &errs) || len(errs) != 1 {
+			return ""
+		}
+		return errs[0].Campo
+	}
+
+	// Un componente que se borró mientras tanto
+	p := kit("con-borrado", jarron.ID, borrado.ID)
+	if err := repo.Crear(ctx, &p); campoError(err) != "componentes.1.producto_id" {
+		t.Errorf("crear un kit con un componente borrado: %v", err)
+	}
+
+	// Un componente que se convirtió en kit mientras tanto
+	interior := kit("interior", lampara.ID)
+	if err := repo.Crear(ctx, &interior); err != nil {
+		t.Fatal(err)
+	}
+	p = kit("con-kit", jarron.ID, interior.ID)
+	if err := repo.Crear(ctx, &p); campoError(err) != "componentes.1.producto_id" {
+		t.Errorf("crear un kit con otro kit como componente: %v", err)
+	}
+
+	// Un componente de un kit que se intenta convertir en kit
+	exterior := kit("exterior", jarron.ID)
+	if err := repo.Crear(ctx, &exterior); err != nil {
+		t.Fatal(err)
+	}
+	cambiado := jarron
+	cambiado.Componentes = []Componente{{ProductoID: lampara.ID, Cantidad: 2}}
+	if err := repo.Actualizar(ctx, &cambiado, jarron.Version); campoError(err) != "componentes" {
+		t.Errorf("convertir en kit un componente de otro kit: %v", err)
+	}
+	if actual, err := repo.Obtener(ctx, jarron.ID); err != nil || actual.esKit() || actual.Version != jarron.Version {
+		t.Errorf("el componente cambió: %+v, %v", actual, err)
+	}
+
+	// Y un componente en uso no se puede borrar
+	if err := repo.Eliminar(ctx, jarron.ID, 0); !errors.Is(err, ErrProductoEnKit) {
+		t.Errorf("borrar un componente en uso: %v", err)
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"catalogo-comun/parche"
+	dominio "catalogo-dominio"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxOperacionesLote limita las operaciones de un lote
+const maxOperacionesLote = 200
+
+// Errores de una operación mal formada; se responden con 400
+var (
+	errOperacionLote   = errors.New("op debe ser crear, actualizar o eliminar")
+	errRecursoLote     = errors.New("recurso debe ser productos o categorias")
+	errRefLote         = errors.New("ref solo se admite al crear y debe ser única y alfanumérica")
+	errIDLote          = errors.New("id es obligatorio al actualizar o eliminar")
+	errIDInmutableLote = errors.New("El id no se puede modificar")
+	errDatosLote       = errors.New("datos inválidos")
+)
+
+var patronRefLote = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// peticionLote es el cuerpo de POST /batch
+type peticionLote struct {
+	Operaciones []operacionPeticion `json:"operaciones"`
+}
+
+// operacionPeticion es una operación de un lote tal como la envía el
+// cliente. Datos es el recurso completo al crear y un JSON Merge Patch al
+// actualizar. Ref da nombre al recurso creado para que las operaciones
+// posteriores lo usen como "$ref" en id o en cualquier valor de datos
+type operacionPeticion struct {
+	Op      TipoOperacion   `json:"op" enums:"crear,actualizar,eliminar"`
+	Recurso RecursoLote     `json:"recurso" enums:"productos,categorias"`
+	Ref     string          `json:"ref" example:"nueva"`
+	ID      string          `json:"id"`
+	Version int             `json:"version"`
+	Datos   json.RawMessage `json:"datos" swaggertype:"object"`
+}
+
+// ResultadoOperacion es el resultado de una operación de un lote. Estado es
+// el código HTTP que habría tenido la operación sola; las que no se aplicaron
+// porque falló otra tienen 424
+type ResultadoOperacion struct {
+	Indice  int                       `json:"indice"`
+	Op      TipoOperacion             `json:"op"`
+	Recurso RecursoLote               `json:"recurso"`
+	Ref     string                    `json:"ref,omitempty"`
+	ID      string                    `json:"id,omitempty"`
+	Estado  int                       `json:"estado" example:"201"`
+	Data    any                       `json:"data,omitempty"`
+	Error   string                    `json:"error,omitempty"`
+	Codigo  dominio.Codigo            `json:"codigo,omitempty"`
+	Errores dominio.ErroresValidacion `json:"errores,omitempty"`
+}
+
+// InformeLote resume un lote: si se aplicó y el resultado de cada operación
+type InformeLote struct {
+	Aplicado   bool                 `json:"aplicado"`
+	Resultados []ResultadoOperacion `json:"resultados"`
+}
+
+// preparacionLote es el estado del catálogo tal como lo dejan las operaciones
+// ya preparadas del lote, antes de escribir nada
+type preparacionLote struct {
+	ctx context.Context
+	// refs traduce cada ref al ID del recurso creado
+	refs map[string]string
+	// productos guarda los productos creados o modificados por el lote y
+	// eliminados los que borra
+	productos  map[string]Producto
+	eliminados map[string]bool
+	// categorias es el árbol completo con las operaciones del lote aplicadas;
+	// se lee la primera vez que hace falta
+	categorias []Categoria
+	leidas     bool
+}
+
+// Aplicar un lote de operaciones
+// @Summary Aplicar un lote de operaciones
+// @Description Aplica en orden una lista de operaciones de creación, actualización y eliminación de productos y categorías, todas o ninguna. Al crear, datos es el recurso completo; al actualizar, un JSON Merge Patch sobre el estado que dejan las operaciones anteriores. Una operación de creación con ref permite a las posteriores usar "$ref" en id o en cualquier valor de datos para referirse al ID creado. version funciona como If-Match. Si una operación falla no se aplica ninguna: la que falló lleva su error y el resto estado 424. Los componentes de un kit deben existir antes del lote
+// @Tags lotes
+// @Accept json
+// @Produce json
+// @Param lote body peticionLote true "Operaciones del lote, como máximo 200"
+// @Param Idempotency-Key header string false "Clave única de la operación; un reintento con la misma clave y la misma petición devuelve la respuesta guardada"
+// @Success 200 {object} dominio.Respuesta{data=InformeLote}
+// @Failure 400 {object} dominio.RespuestaError{data=InformeLote}
+// @Failure 404 {object} dominio.RespuestaError{data=InformeLote}
+// @Failure 409 {object} dominio.RespuestaError{data=InformeLote}
+// @Failure 412 {object} dominio.RespuestaError{data=InformeLote}
+// @Failure 422 {object} dominio.RespuestaError{data=InformeLote}
+// @Router /batch [post]
+func postLote(c *gin.Context) {
+	var peticion peticionLote
+	if err := c.ShouldBindJSON(&peticion); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(peticion.Operaciones) == 0 || len(peticion.Operaciones) > maxOperacionesLote {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "El lote debe tener entre 1 y 200 operaciones"))
+		return
+	}
+
+	prep := &preparacionLote{
+		ctx:        c.Request.Context(),
+		refs:       make(map[string]string),
+		productos:  make(map[string]Producto),
+		eliminados: make(map[string]bool),
+	}
+	informe := InformeLote{Resultados: make([]ResultadoOperacion, len(peticion.Operaciones))}
+	for i, op := range peticion.Operaciones {
+		informe.Resultados[i] = ResultadoOperacion{Indice: i, Op: op.Op, Recurso: op.Recurso, Ref: op.Ref}
+	}
+	ops := make([]OperacionLote, len(peticion.Operaciones))
+	for i, op := range peticion.Operaciones {
+		var err error
+		if ops[i], err = prep.preparar(op); err != nil {
+			responderFalloLote(c, informe, i, err)
+			return
+		}
+	}
+
+	if err := repo.AplicarLote(c.Request.Context(), ops); err != nil {
+		var errOp ErrorOperacion
+		if errors.As(err, &errOp) {
+			responderFalloLote(c, informe, errOp.Indice, errOp.Err)
+			return
+		}
+		responderError(c, err)
+		return
+	}
+
+	// Los kits se completan con su disponibilidad como en el resto de
+	// respuestas de productos
+	var productos []Producto
+	for _, op := range ops {
+		if op.Producto != nil {
+			productos = append(productos, *op.Producto)
+		}
+	}
+	if err := completarKits(c.Request.Context(), productos); err != nil {
+		responderError(c, err)
+		return
+	}
+	informe.Aplicado = true
+	for i, op := range ops {
+		res := &informe.Resultados[i]
+		res.ID = op.ID
+		res.Estado = http.StatusOK
+		if op.Tipo == OperacionCrear {
+			res.Estado = http.StatusCreated
+		}
+		switch {
+		case op.Producto != nil:
+			res.ID = op.Producto.ID
+			res.Data, productos = productos[0], productos[1:]
+		case op.Categoria != nil:
+			res.ID = op.Categoria.ID
+			res.Data = *op.Categoria
+		}
+	}
+	log.Printf("Lote: %d operaciones aplicadas", len(ops))
+	c.JSON(http.StatusOK, gin.H{
+		"data": informe,
+	})
+}
+
+// responderFalloLote responde a un lote que no se aplicó porque falló la
+// operación indice. La respuesta lleva el estado de esa operación y el resto
+// de operaciones, 424
+func responderFalloLote(c *gin.Context, informe InformeLote, indice int, err error) {
+	for i := range informe.Resultados {
+		informe.Resultados[i].Estado = http.StatusFailedDependency
+		informe.Resultados[i].Error = traducir(c, "No se aplicó porque falló otra operación del lote")
+		informe.Resultados[i].Codigo = dominio.CodigoDependenciaFallida
+	}
+	res := &informe.Resultados[indice]
+	estado, cuerpo := respuestaOperacionLote(c, res.Recurso, err)
+	res.Estado = estado
+	res.Error, res.Codigo, res.Errores = cuerpo.Error, cuerpo.Codigo, cuerpo.Errores
+	c.JSON(estado, gin.H{
+		"error":  traducir(c, "No se aplicó ninguna operación del lote porque una falló"),
+		"codigo": cuerpo.Codigo,
+		"data":   informe,
+	})
+}
+
+// respuestaOperacionLote devuelve el estado y el cuerpo con los que se
+// respondería al error de una operación hecha por separado
+func respuestaOperacionLote(c *gin.Context, recurso RecursoLote, err error) (int, dominio.RespuestaError) {
+	switch {
+	case errors.Is(err, errDatosLote):
+		return respuestaMensaje(http.StatusBadRequest, err.Error())
+	case errors.Is(err, errOperacionLote), errors.Is(err, errRecursoLote), errors.Is(err, errRefLote),
+		errors.Is(err, errIDLote), errors.Is(err, errIDInmutableLote):
+		return respuestaMensaje(http.StatusBadRequest, traducir(c, err.Error()))
+	case recurso == RecursoCategorias:
+		return respuestaErrorCategoria(c, err)
+	}
+	return respuestaError(c, err)
+}
+
+// preparar valida una operación frente al estado que dejan las anteriores y
+// la convierte en la escritura que se pasará al repositorio
+func (p *preparacionLote) preparar(op operacionPeticion) (OperacionLote, error) {
+	if !slices.Contains([]TipoOperacion{OperacionCrear, OperacionActualizar, OperacionEliminar}, op.Op) {
+		return OperacionLote{}, errOperacionLote
+	}
+	if op.Recurso != RecursoProductos && op.Recurso != RecursoCategorias {
+		return OperacionLote{}, errRecursoLote
+	}
+	if op.Ref != "" {
+		if _, repetida := p.refs[op.Ref]; repetida || op.Op != OperacionCrear || !patronRefLote.MatchString(op.Ref) {
+			return OperacionLote{}, errRefLote
+		}
+	}
+	if op.Op != OperacionCrear {
+		op.ID = p.resolverRef(op.ID)
+		if op.ID == "" {
+			return OperacionLote{}, errIDLote
+		}
+	}
+	datos, err := p.resolverRefs(op.Datos)
+	if err != nil {
+		return OperacionLote{}, err
+	}
+
+	res := OperacionLote{Tipo: op.Op, Recurso: op.Recurso, ID: op.ID, Version: op.Version}
+	if op.Recurso == RecursoProductos {
+		err = p.prepararProducto(&res, datos)
+	} else {
+		err = p.prepararCategoria(&res, datos)
+	}
+	if err != nil {
+		return OperacionLote{}, err
+	}
+	if op.Ref != "" {
+		p.refs[op.Ref] = res.ID
+	}
+	return res, nil
+}
+
+func (p *preparacionLote) prepararProducto(op *OperacionLote, datos []byte) error {
+	switch op.Tipo {
+	case OperacionCrear:
+		var producto Producto
+		if err := json.Unmarshal(datos, &producto); err != nil {
+			return fmt.Errorf("%w: %v", errDatosLote, err)
+		}
+		if err := prepararProductoNuevo(p.ctx, &producto); err != nil {
+			return err
+		}
+		op.ID = producto.ID
+		op.Producto = &producto
+		creado := producto
+		creado.Version = 1
+		p.productos[producto.ID] = creado
+		return nil
+	}
+
+	actual, err := p.producto(op.ID)
+	if err != nil {
+		return err
+	}
+	if op.Version != 0 && op.Version != actual.Version {
+		return ErrConflictoVersion
+	}
+	// El repositorio comprueba la versión que se leyó, o la que deja la
+	// operación anterior del lote sobre el mismo producto
+	op.Version = actual.Version
+	if op.Tipo == OperacionEliminar {
+		delete(p.productos, op.ID)
+		p.eliminados[op.ID] = true
+		return nil
+	}
+
+	var producto Producto
+	if err := parche.Fusionar(actual, datos, &producto); err != nil {
+		return fmt.Errorf("%w: %v", errDatosLote, err)
+	}
+	if producto.ID != actual.ID {
+		return errIDInmutableLote
+	}
+	if err := prepararActualizacion(p.ctx, &producto, actual); err != nil {
+		return err
+	}
+	op.Producto = &producto
+	siguiente := producto
+	siguiente.Version = actual.Version + 1
+	p.productos[producto.ID] = siguiente
+	return nil
+}
+
+// producto devuelve un producto tal como lo dejan las operaciones anteriores
+func (p *preparacionLote) producto(id string) (Producto, error) {
+	if p.eliminados[id] {
+		return Producto{}, ErrProductoNoEncontrado
+	}
+	if producto, ok := p.productos[id]; ok {
+		return producto, nil
+	}
+	return repo.Obtener(p.ctx, id)
+}
+
+func (p *preparacionLote) prepararCategoria(op *OperacionLote, datos []byte) error {
+	if !p.leidas {
+		todas, err := repo.ListarCategorias(p.ctx)
+		if err != nil {
+			return err
+		}
+		p.categorias, p.leidas = todas, true
+	}
+
+	if op.Tipo == OperacionCrear {
+		var cat Categoria
+		if err := json.Unmarshal(datos, &cat); err != nil {
+			return fmt.Errorf("%w: %v", errDatosLote, err)
+		}
+		cat.ID = uuid.New().String()
+		if cat.Slug == "" {
+			cat.Slug = slugificar(cat.Nombre)
+		}
+		if err := validarCategoria(cat, p.categorias); err != nil {
+			return err
+		}
+		op.ID = cat.ID
+		op.Categoria = &cat
+		creada := cat
+		creada.Version = 1
+		p.categorias = append(p.categorias, creada)
+		return nil
+	}
+
+	i := slices.IndexFunc(p.categorias, func(c Categoria) bool { return c.ID == op.ID })
+	if i < 0 {
+		return ErrCategoriaNoEncontrada
+	}
+	actual := p.categorias[i]
+	if op.Version != 0 && op.Version != actual.Version {
+		return ErrConflictoVersion
+	}
+	op.Version = actual.Version
+	if op.Tipo == OperacionEliminar {
+		p.categorias = slices.Delete(p.categorias, i, i+1)
+		return nil
+	}
+
+	var cat Categoria
+	if err := parche.Fusionar(actual, datos, &cat); err != nil {
+		return fmt.Errorf("%w: %v", errDatosLote, err)
+	}
+	if cat.ID != actual.ID {
+		return errIDInmutableLote
+	}
+	if err := validarCategoria(cat, p.categorias); err != nil {
+		return err
+	}
+	op.Categoria = &cat
+	siguiente := cat
+	siguiente.Version = actual.Version + 1
+	p.categorias[i] = siguiente
+	return nil
+}
+
+// resolverRef devuelve el ID creado con la ref si s es "$ref" y la ref ya
+// se definió en el lote; si no, devuelve s
+func (p *preparacionLote) resolverRef(s string) string {
+	if ref, ok := strings.CutPrefix(s, "$"); ok {
+		if id, ok := p.refs[ref]; ok {
+			return id
+		}
+	}
+	return s
+}
+
+// resolverRefs sustituye en datos las cadenas "$ref" por sus IDs. Los números
+// se conservan tal como vienen
+func (p *preparacionLote) resolverRefs(datos json.RawMessage) ([]byte, error) {
+	if len(datos) == 0 {
+		return []byte("{}"), nil
+	}
+	if len(p.refs) == 0 {
+		return datos, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(datos))
+	dec.UseNumber()
+	var valor any
+	if err := dec.Decode(&valor); err != nil {
+		return nil, fmt.Errorf("%w: %v", errDatosLote, err)
+	}
+	var resolver func(v any) any
+	resolver = func(v any) any {
+		switch v := v.(type) {
+		case string:
+			return p.resolverRef(v)
+		case []any:
+			for i := range v {
+				v[i] = resolver(v[i])
+			}
+		case map[string]any:
+			for k := range v {
+				v[k] = resolver(v[k])
+			}
+		}
+		return v
+	}
+	return json.Marshal(resolver(valor))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"catalogo-comun/listado"
+)
+
+// informeLote decodifica la respuesta de POST /batch, correcta o no
+func informeLote(t *testing.T, cuerpo []byte) InformeLote {
+	t.Helper()
+	var r struct {
+		Data InformeLote `json:"data"`
+	}
+	if err := json.Unmarshal(cuerpo, &r); err != nil {
+		t.Fatalf("respuesta inválida %s: %v", cuerpo, err)
+	}
+	return r.Data
+}
+
+func TestLoteAplicaTodo(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	existente := crearProductoPrueba(t, srv, "Jarrón", categoriaID)
+
+	lote := map[string]any{"operaciones": []map[string]any{
+		{"op": "crear", "recurso": "categorias", "ref": "cat", "datos": map[string]any{"nombre": "Jardín"}},
+		{"op": "crear", "recurso": "productos", "ref": "maceta", "datos": productoPrueba("Maceta", "$cat")},
+		{"op": "actualizar", "recurso": "productos", "id": "$maceta", "datos": map[string]any{"nombre": "Maceta grande"}},
+		{"op": "eliminar", "recurso": "productos", "id": existente.ID, "version": existente.Version},
+	}}
+	estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/batch", lote)
+	if estado != http.StatusOK {
+		t.Fatalf("lote: %d %s", estado, cuerpo)
+	}
+	informe := informeLote(t, cuerpo)
+	if !informe.Aplicado {
+		t.Fatalf("el lote no se aplicó: %s", cuerpo)
+	}
+	for i, want := range []int{http.StatusCreated, http.StatusCreated, http.StatusOK, http.StatusOK} {
+		if informe.Resultados[i].Estado != want {
+			t.Errorf("operación %d: estado %d, se esperaba %d", i, informe.Resultados[i].Estado, want)
+		}
+	}
+
+	macetaID := informe.Resultados[1].ID
+	_, _, cuerpo = peticion(t, srv, http.MethodGet, "/api/v1/productos/"+macetaID, nil)
+	maceta := datos[Producto](t, cuerpo)
+	if maceta.Nombre != "Maceta grande" || maceta.Version != 2 || maceta.CategoriaID != informe.Resultados[0].ID {
+		t.Errorf("maceta tras el lote: %+v", maceta)
+	}
+	if estado, _, _ := peticion(t, srv, http.MethodGet, "/api/v1/productos/"+existente.ID, nil); estado != http.StatusNotFound {
+		t.Errorf("el producto eliminado en el lote responde %d", estado)
+	}
+}
+
+// TestLoteNoAplicaNada comprueba que cuando falla la última operación no
+// queda nada de las anteriores
+func TestLoteNoAplicaNada(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	jarron := crearProductoPrueba(t, srv, "Jarrón", categoriaID)
+	lampara := crearProductoPrueba(t, srv, "Lámpara", categoriaID)
+
+	lote := map[string]any{"operaciones": []map[string]any{
+		{"op": "crear", "recurso": "categorias", "ref": "cat", "datos": map[string]any{"nombre": "Jardín"}},
+		{"op": "crear", "recurso": "productos", "ref": "maceta", "datos": productoPrueba("Maceta", "$cat")},
+		{"op": "actualizar", "recurso": "productos", "id": jarron.ID, "datos": map[string]any{"nombre": "Jarrón azul"}},
+		{"op": "eliminar", "recurso": "productos", "id": lampara.ID},
+		{"op": "actualizar", "recurso": "productos", "id": "$maceta", "datos": map[string]any{"nombre": ""}},
+	}}
+	estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/batch", lote)
+	if estado != http.StatusUnprocessableEntity {
+		t.Fatalf("lote: %d %s, se esperaba 422", estado, cuerpo)
+	}
+	informe := informeLote(t, cuerpo)
+	if informe.Aplicado {
+		t.Error("el lote aparece como aplicado")
+	}
+	for i, res := range informe.Resultados {
+		want := http.StatusFailedDependency
+		if i == 4 {
+			want = http.StatusUnprocessableEntity
+		}
+		if res.Estado != want {
+			t.Errorf("operación %d: estado %d, se esperaba %d", i, res.Estado, want)
+		}
+	}
+
+	comprobarSinCambios(t, categoriaID, jarron, lampara)
+}
+
+// TestAplicarLoteRevierte provoca el fallo en el propio repositorio, después
+// de escribir las operaciones anteriores, y comprueba que se deshacen todas
+func TestAplicarLoteRevierte(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	jarron := crearProductoPrueba(t, srv, "Jarrón", categoriaID)
+	lampara := crearProductoPrueba(t, srv, "Lámpara", categoriaID)
+
+	nuevo := jarron
+	nuevo.ID, nuevo.SKU, nuevo.Nombre = "nuevo", "NUEVO-1", "Maceta"
+	cambiado := jarron
+	cambiado.Nombre = "Jarrón azul"
+	ops := []OperacionLote{
+		{Tipo: OperacionCrear, Recurso: RecursoProductos, Producto: &nuevo, ID: nuevo.ID},
+		{Tipo: OperacionActualizar, Recurso: RecursoProductos, Producto: &cambiado, ID: jarron.ID, Version: jarron.Version},
+		{Tipo: OperacionEliminar, Recurso: RecursoProductos, ID: lampara.ID, Version: lampara.Version},
+		{Tipo: OperacionCrear, Recurso: RecursoCategorias, Categoria: &Categoria{ID: "jardin", Nombre: "Jardín", Slug: "jardin"}, ID: "jardin"},
+		// La versión ya no es la del jarrón tras la operación 1
+		{Tipo: OperacionEliminar, Recurso: RecursoProductos, ID: jarron.ID, Version: jarron.Version},
+	}
+	err := repo.AplicarLote(context.Background(), ops)
+	var errOp ErrorOperacion
+	if !errors.As(err, &errOp) || errOp.Indice != 4 || !errors.Is(err, ErrConflictoVersion) {
+		t.Fatalf("AplicarLote = %v, se esperaba un conflicto de versión en la operación 4", err)
+	}
+
+	comprobarSinCambios(t, categoriaID, jarron, lampara)
+	if _, err := repo.Obtener(context.Background(), nuevo.ID); !errors.Is(err, ErrProductoNoEncontrado) {
+		t.Errorf("el producto creado en el lote sigue existiendo: %v", err)
+	}
+	if encontrados, err := repo.BuscarPorSKU(context.Background(), []string{nuevo.SKU}); err != nil || len(encontrados) != 0 {
+		t.Errorf("el SKU del producto creado en el lote sigue indexado: %v, %v", encontrados, err)
+	}
+	if _, err := repo.ObtenerCategoria(context.Background(), "jardin"); !errors.Is(err, ErrCategoriaNoEncontrada) {
+		t.Errorf("la categoría creada en el lote sigue existiendo: %v", err)
+	}
+}
+
+// comprobarSinCambios comprueba que el catálogo sigue con la categoría y
+// los dos productos tal como se crearon
+func comprobarSinCambios(t *testing.T, categoriaID string, productos ...Producto) {
+	t.Helper()
+	ctx := context.Background()
+	for _, p := range productos {
+		actual, err := repo.Obtener(ctx, p.ID)
+		if err != nil {
+			t.Errorf("%s: %v", p.Nombre, err)
+			continue
+		}
+		if actual.Nombre != p.Nombre || actual.Version != p.Version || actual.CategoriaID != p.CategoriaID {
+			t.Errorf("%s cambió: %+v", p.Nombre, actual)
+		}
+		if revisiones, err := repo.Revisiones(ctx, p.ID); err != nil || len(revisiones) != 1 {
+			t.Errorf("%s tiene %d revisiones (%v), se esperaba 1", p.Nombre, len(revisiones), err)
+		}
+	}
+
+	ventana := listado.Ventana{Pagina: 1, Tamano: listado.TamanoMaximo}
+	orden := []listado.CampoOrden{{Campo: "id"}}
+	enCategoria, _, err := repo.Listar(ctx, ConsultaProductos{Categorias: []string{categoriaID}, Orden: orden, Ventana: ventana})
+	if err != nil || len(enCategoria) != len(productos) {
+		t.Errorf("la categoría tiene %d productos (%v), se esperaban %d", len(enCategoria), err, len(productos))
+	}
+	todos, _, err := repo.Listar(ctx, ConsultaProductos{Orden: orden, Ventana: ventana})
+	if err != nil || len(todos) != len(productos) {
+		t.Errorf("hay %d productos (%v), se esperaban %d", len(todos), err, len(productos))
+	}
+	papelera, _, err := repo.ListarPapelera(ctx, ConsultaProductos{Orden: orden, Ventana: ventana})
+	if err != nil || len(papelera) != 0 {
+		t.Errorf("hay %d productos en la papelera (%v)", len(papelera), err)
+	}
+	categorias, err := repo.ListarCategorias(ctx)
+	if err != nil || len(categorias) != 1 {
+		t.Errorf("hay %d categorías (%v), se esperaba 1", len(categorias), err)
+	}
+}
@@ -1,19 +1,32 @@
 package main
 
 import (
-	"catalogo-productos/docs"
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"catalogo-comun/basedatos"
+	"catalogo-comun/condicional"
+	"catalogo-comun/idempotencia"
+	"catalogo-comun/listado"
+	"catalogo-comun/papelera"
+	"catalogo-comun/parche"
+	dominio "catalogo-dominio"
+	"catalogo-dominio/dinero"
+	_ "catalogo-productos/docs"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/swaggo/files"
+	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
 )
 
-// Por ahora usamos almacenamiento en memoria
-// TODO: Implementar base de datos PostgreSQL
-
 // @title API de Catálogo de Productos
 // @version 1.0
 // @description API REST para gestión de productos 3D
@@ -38,10 +51,23 @@ func main() {
 		log.Fatalf("Error al configurar: %v", err)
 	}
 
+	r := nuevoRouter()
+
+	papelera.IniciarPurga(purgarConAdjuntos)
+	iniciarProgramador()
+	idempotencia.IniciarPurga(repo)
+
+	log.Printf("Iniciando servicio de productos en :8081")
+	r.Run(":8081")
+}
+
+// nuevoRouter registra las rutas del servicio sobre el repositorio ya
+// configurado en repo
+func nuevoRouter() *gin.Engine {
 	r := gin.Default()
 
 	// Documentación Swagger
-	r.GET("/swagger/*any", ginSwagger.WrapHandler(files.Handler))
+	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
@@ -53,166 +79,631 @@ func main() {
 	})
 
 	// Rutas API
-	api := r.Group("/api/v1")
+	api := r.Group("/api/v1", identificarActor, negociarIdioma, idempotencia.Middleware(repo, traducir))
 	{
 		api.GET("/productos", getProducts)
+		api.GET("/productos/export", exportProducts)
+		api.GET("/productos/papelera", getProductosPapelera)
+		api.GET("/productos/borradores", getProductosBorradores)
+		api.POST("/productos/import", importProducts)
 		api.GET("/productos/:id", getProduct)
 		api.POST("/productos", createProduct)
 		api.PUT("/productos/:id", updateProduct)
+		api.PATCH("/productos/:id", patchProduct)
 		api.DELETE("/productos/:id", deleteProduct)
-	}
+		api.POST("/productos/:id/restaurar", restoreProduct)
+		api.GET("/productos/:id/historial-estados", getProductHistory)
+		api.GET("/productos/:id/revisiones", getProductRevisions)
+		api.GET("/productos/:id/revisiones/diff", getProductRevisionsDiff)
+		api.GET("/productos/:id/revisiones/:rev", getProductRevision)
+		api.POST("/productos/:id/revisiones/:rev/revertir", revertProductRevision)
+		api.POST("/productos/:id/modelo", uploadProductModel)
+		api.GET("/productos/:id/modelo", getProductModel)
+		api.POST("/productos/:id/modelo/analisis", analyzeProductModel)
+		api.GET("/productos/:id/thumbnail", getProductThumbnail)
+		api.GET("/productos/:id/traducciones", getProductTranslations)
+		api.PUT("/productos/:id/traducciones/:idioma", putProductTranslation)
+		api.DELETE("/productos/:id/traducciones/:idioma", deleteProductTranslation)
+		api.GET("/productos/:id/relaciones", getProductRelations)
+		api.POST("/productos/:id/relaciones", createProductRelation)
+		api.DELETE("/productos/:id/relaciones/:relacion", deleteProductRelation)
+		api.GET("/productos/:id/adjuntos", getProductAttachments)
+		api.POST("/productos/:id/adjuntos", uploadProductAttachment)
+		api.PUT("/productos/:id/adjuntos/orden", sortProductAttachments)
+		api.GET("/productos/:id/adjuntos/:adjunto", getProductAttachment)
+		api.DELETE("/productos/:id/adjuntos/:adjunto", deleteProductAttachment)
 
-	log.Printf("Iniciando servicio de productos en :8081")
-	r.Run(":8081")
-}
+		api.GET("/tasas-cambio", getTasasCambio)
+		api.POST("/tasas-cambio", createTasaCambio)
+		api.DELETE("/tasas-cambio/:id", deleteTasaCambio)
+
+		api.GET("/atributos", getAtributos)
+		api.GET("/atributos/:codigo", getAtributo)
+		api.POST("/atributos", createAtributo)
+		api.PUT("/atributos/:codigo", updateAtributo)
+		api.DELETE("/atributos/:codigo", deleteAtributo)
+
+		api.GET("/categorias", getCategorias)
+		api.GET("/categorias/:id", getCategoria)
+		api.GET("/categorias/:id/productos", getCategoriaProductos)
+		api.POST("/categorias", createCategoria)
+		api.PUT("/categorias/:id", updateCategoria)
+		api.PATCH("/categorias/:id", patchCategoria)
+		api.DELETE("/categorias/:id", deleteCategoria)
+		api.GET("/categorias/:id/traducciones", getCategoriaTranslations)
+		api.PUT("/categorias/:id/traducciones/:idioma", putCategoriaTranslation)
+		api.DELETE("/categorias/:id/traducciones/:idioma", deleteCategoriaTranslation)
 
-type Dimensiones struct {
-	Ancho    float64 `json:"ancho"`
-	Alto     float64 `json:"alto"`
-	Profundo float64 `json:"profundo"`
+		api.POST("/batch", postLote)
+	}
+
+	return r
 }
 
+// Producto es un producto del catálogo. Los campos marcados con
+// validate:"required" son los obligatorios que comprueba validarProducto; la
+// especificación OpenAPI se genera a partir de este tipo
 type Producto struct {
-	ID          string      `json:"id"`
-	Nombre      string      `json:"nombre"`
-	Descripcion string      `json:"descripcion"`
-	PrecioBase  float64     `json:"precio_base"`
-	Dimensiones Dimensiones `json:"dimensiones"`
-	Categoria   string      `json:"categoria"`
-	Estado      string      `json:"estado"`
+	ID string `gorm:"primaryKey" json:"id" readonly:"true"`
+	// Código de referencia único, opcional; identifica el producto al importar
+	SKU            string                 `gorm:"column:sku" json:"sku"`
+	Nombre         string                 `json:"nombre" validate:"required"`
+	Descripcion    string                 `json:"descripcion" validate:"required"`
+	PrecioBase     dinero.Dinero          `gorm:"embedded;embeddedPrefix:precio_base_" json:"precio_base" validate:"required"`
+	Dimensiones    dominio.Dimensiones    `gorm:"embedded;embeddedPrefix:dimensiones_" json:"dimensiones" validate:"required"`
+	CategoriaID    string                 `json:"categoria_id" validate:"required"`
+	Estado         dominio.EstadoProducto `json:"estado" validate:"required" enums:"borrador,disponible,agotado,descontinuado"`
+	Geometria      Geometria              `gorm:"embedded;embeddedPrefix:geometria_" json:"geometria" readonly:"true"`
+	Imprimibilidad InformeImprimibilidad  `gorm:"embedded;embeddedPrefix:imprimibilidad_" json:"imprimibilidad" readonly:"true"`
+	Version        int                    `json:"version"`
+	// EliminadoEn marca los productos en la papelera; GORM los excluye de
+	// las consultas mientras no se restauren
+	EliminadoEn gorm.DeletedAt `gorm:"column:eliminado_en" json:"eliminado_en" swaggertype:"string" format:"date-time" readonly:"true"`
+	// Traducciones guarda nombre y descripción en otros idiomas; Nombre y
+	// Descripcion están en el idioma predeterminado
+	Traducciones Traducciones `gorm:"serializer:json" json:"traducciones"`
+	// Atributos guarda los valores de los atributos definidos en el registro,
+	// por código; Etiquetas son etiquetas libres en minúsculas
+	Atributos map[string]any `gorm:"serializer:json" json:"atributos"`
+	Etiquetas []string       `gorm:"serializer:json" json:"etiquetas" example:"decoracion,regalo"`
+	// Componentes convierte el producto en un kit: su precio es la suma de
+	// los de sus componentes menos DescuentoKit, un porcentaje
+	Componentes  []Componente   `gorm:"serializer:json" json:"componentes"`
+	DescuentoKit dinero.Decimal `json:"descuento_kit" swaggertype:"string" example:"10"`
+	// Disponible se calcula al leer, ver completarKits
+	Disponible bool `gorm:"-" json:"disponible" readonly:"true"`
+	// PublicarEn programa la publicación de un borrador y PrecioDesde la
+	// fecha a partir de la cual PrecioProgramado sustituye a PrecioBase; ver
+	// iniciarProgramador
+	PublicarEn       *time.Time     `json:"publicar_en" example:"2026-03-01T09:00:00Z"`
+	PrecioProgramado *dinero.Dinero `gorm:"serializer:json" json:"precio_programado"`
+	PrecioDesde      *time.Time     `json:"precio_desde" example:"2026-03-01T00:00:00Z"`
 }
 
-var productos = []Producto{}
+var repo RepositorioProductos
 
 func setup() error {
-	// Inicializar productos de ejemplo
-	productos = []Producto{
-		{
-			ID:          "p001",
-			Nombre:      "Pieza de Soporte",
-			Descripcion: "Soporte para impresión 3D",
-			PrecioBase:  15.99,
-			Dimensiones: Dimensiones{
-				Ancho:    10.0,
-				Alto:     5.0,
-				Profundo: 3.0,
-			},
-			Categoria: "Soportes",
-			Estado:    "disponible",
-		},
+	var err error
+	if almacenAdjuntos, err = nuevoAlmacenAdjuntos(); err != nil {
+		return err
+	}
+
+	// REPOSITORIO=memoria permite levantar el servicio sin base de datos
+	if os.Getenv("REPOSITORIO") == "memoria" {
+		log.Printf("Usando repositorio en memoria, los datos no se conservarán")
+		repo = newRepositorioMemoria()
+		return nil
+	}
+
+	db, err := gorm.Open(postgres.Open(basedatos.DSN("productos")), &gorm.Config{TranslateError: true})
+	if err != nil {
+		return err
+	}
+
+	if err := migrar(db); err != nil {
+		return err
 	}
+
+	repo = newRepositorioPostgres(db)
 	return nil
 }
 
 // Obtener todos los productos
 // @Summary Obtener todos los productos
-// @Description Obtiene la lista completa de productos
+// @Description Obtiene una página de productos publicados, sin los borradores, opcionalmente filtrada por categoría y sus subcategorías, etiquetas y atributos. Admite paginación por número de página o por cursor, orden y selección de campos
 // @Tags productos
 // @Accept json
 // @Produce json
-// @Success 200 {object} docs.productosResponse
+// @Param categoria_id query string false "ID de la categoría de los productos"
+// @Param subcategorias query bool false "Con categoria_id, incluir también los productos de sus subcategorías"
+// @Param etiqueta query string false "Etiquetas que deben tener todos los productos, repetible o separadas por coma"
+// @Param atributo.{codigo} query string false "Valor exacto de un atributo; los numéricos admiten atributo.{codigo}.min y atributo.{codigo}.max"
+// @Param componente query string false "ID de un producto; devuelve los kits que lo incluyen"
+// @Param dimensiones.{eje}.min query string false "Dimensión mínima (eje ancho, alto o profundo), en ?unidad= o con sufijo de unidad, p. ej. 2in"
+// @Param dimensiones.{eje}.max query string false "Dimensión máxima, como dimensiones.{eje}.min"
+// @Param page query int false "Número de página, empieza en 1"
+// @Param page_size query int false "Tamaño de página (máximo 100)"
+// @Param cursor query string false "Cursor devuelto en meta.next_cursor o meta.prev_cursor"
+// @Param sort query string false "Campos de orden separados por coma, con - para descendente, p. ej. precio_base,-nombre"
+// @Param fields query string false "Campos a incluir separados por coma, p. ej. id,nombre,precio_base"
+// @Param moneda query string false "Código ISO 4217 al que convertir los precios con la tasa vigente, p. ej. EUR"
+// @Param unidad query string false "Unidad de las dimensiones: mm (por defecto), cm o in"
+// @Param Accept-Language header string false "Idiomas preferidos para nombre y descripción, p. ej. en-US,en;q=0.9"
+// @Success 200 {object} dominio.Pagina{data=[]Producto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
 // @Router /productos [get]
 func getProducts(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"data": productos,
-	})
+	listarProductos(c, c.Query("categoria_id"), estadosPublicos)
+}
+
+// listarProductos responde una página de los productos en alguno de estados.
+// Si categoriaID no está vacío filtra por esa categoría y, con
+// ?subcategorias=true, por todas sus descendientes
+func listarProductos(c *gin.Context, categoriaID string, estados []dominio.EstadoProducto) {
+	params, err := listado.Parse(c, ordenablesProductos, camposProductos)
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	etiquetas, atributos, err := parseFiltrosProducto(c.Request.Context(), c.Request.URL.Query())
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dimensiones, err := dominio.ParseFiltrosDimensiones(c.Request.URL.Query())
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	consulta := ConsultaProductos{Estados: estados, Etiquetas: etiquetas, Atributos: atributos, Dimensiones: dimensiones, Componente: c.Query("componente"), Orden: params.Orden, Ventana: params.Ventana}
+	if categoriaID != "" {
+		consulta.Categorias = []string{categoriaID}
+		if sub, _ := strconv.ParseBool(c.Query("subcategorias")); sub {
+			todas, err := repo.ListarCategorias(c.Request.Context())
+			if err != nil {
+				responderError(c, err)
+				return
+			}
+			consulta.Categorias = descendientes(todas, categoriaID)
+		}
+	}
+
+	productos, res, err := repo.Listar(c.Request.Context(), consulta)
+	if err != nil {
+		responderMensaje(c, http.StatusInternalServerError, traducir(c, "Error al obtener productos"))
+		return
+	}
+	if err := completarKits(c.Request.Context(), productos); err != nil {
+		responderError(c, err)
+		return
+	}
+	if !convertirPrecios(c, productos) || !convertirDimensiones(c, productos) {
+		return
+	}
+	localizarProductos(c, productos)
+
+	responderPagina(c, productos, res, params.Ventana, params.Campos)
 }
 
 // Obtener un producto por ID
 // @Summary Obtener un producto por ID
-// @Description Obtiene un producto específico por su ID
+// @Description Obtiene un producto específico por su ID. Con ?incluir=relaciones,adjuntos la respuesta incluye sus relaciones con otros productos y los adjuntos de su galería
 // @Tags productos
 // @Accept json
 // @Produce json
 // @Param id path string true "ID del producto"
-// @Success 200 {object} docs.productoResponse
-// @Failure 404 {object} docs.errorResponse
+// @Param moneda query string false "Código ISO 4217 al que convertir los precios con la tasa vigente, p. ej. EUR"
+// @Param unidad query string false "Unidad de las dimensiones: mm (por defecto), cm o in"
+// @Param incluir query string false "Listas a incluir separadas por coma: relaciones, adjuntos"
+// @Param Accept-Language header string false "Idiomas preferidos para nombre y descripción, p. ej. en-US,en;q=0.9"
+// @Success 200 {object} dominio.Respuesta{data=Producto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
 // @Router /productos/{id} [get]
 func getProduct(c *gin.Context) {
-	id := c.Param("id")
-	for _, p := range productos {
-		if p.ID == id {
-			c.JSON(http.StatusOK, gin.H{
-				"data": p,
-			})
+	producto, err := repo.Obtener(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	lista := []Producto{producto}
+	if err := completarKits(c.Request.Context(), lista); err != nil {
+		responderError(c, err)
+		return
+	}
+	if !convertirPrecios(c, lista) || !convertirDimensiones(c, lista) {
+		return
+	}
+	localizarProductos(c, lista)
+	producto = lista[0]
+
+	c.Header("ETag", condicional.ETag(producto.Version))
+	if incluir := c.Query("incluir"); incluir != "" {
+		res, err := incluirEnProducto(c.Request.Context(), producto, incluir)
+		if errors.Is(err, errIncluirDesconocido) {
+			responderMensaje(c, http.StatusBadRequest, err.Error())
 			return
 		}
+		if err != nil {
+			responderError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": res,
+		})
+		return
 	}
-	c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado"})
+	c.JSON(http.StatusOK, gin.H{
+		"data": producto,
+	})
 }
 
 // Crear un nuevo producto
 // @Summary Crear un nuevo producto
-// @Description Crea un nuevo producto en el catálogo
+// @Description Crea un nuevo producto en el catálogo. Si no se indica estado se crea como borrador. Con componentes el producto es un kit y su precio se calcula a partir de ellos
 // @Tags productos
 // @Accept json
 // @Produce json
-// @Param producto body docs.Producto true "Producto a crear"
-// @Success 201 {object} docs.productoResponse
-// @Failure 400 {object} docs.errorResponse
+// @Param producto body Producto true "Producto a crear"
+// @Param Idempotency-Key header string false "Clave única de la operación; un reintento con la misma clave y la misma petición devuelve la respuesta guardada"
+// @Success 201 {object} dominio.Respuesta{data=Producto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
 // @Router /productos [post]
 func createProduct(c *gin.Context) {
-	var producto docs.Producto
+	var producto Producto
 	if err := c.ShouldBindJSON(&producto); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		responderMensaje(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	producto.ID = uuid.New().String()
-	productos = append(productos, producto)
+	if err := prepararProductoNuevo(c.Request.Context(), &producto); err != nil {
+		responderError(c, err)
+		return
+	}
+	if err := repo.Crear(c.Request.Context(), &producto); err != nil {
+		var errsValidacion dominio.ErroresValidacion
+		if errors.As(err, &errsValidacion) {
+			responderError(c, err)
+			return
+		}
+		responderMensaje(c, http.StatusInternalServerError, traducir(c, "Error al crear el producto"))
+		return
+	}
+	responderProductoGuardado(c, http.StatusCreated, producto)
+}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"data": producto,
-	})
+// prepararProductoNuevo completa y valida un producto que se va a crear y
+// le asigna un ID nuevo. Si no se indica estado se crea como borrador
+func prepararProductoNuevo(ctx context.Context, producto *Producto) error {
+	if producto.Estado == "" {
+		producto.Estado = dominio.EstadoBorrador
+	}
+	completarMoneda(producto)
+	producto.Dimensiones.Normalizar()
+	producto.Etiquetas = normalizarEtiquetas(producto.Etiquetas)
+	// El precio de un kit se calcula antes de validarlo
+	if err := prepararKit(ctx, producto); err != nil {
+		return err
+	}
+	if err := validarProductoNuevo(*producto); err != nil {
+		return err
+	}
+	if err := comprobarAtributos(ctx, *producto); err != nil {
+		return err
+	}
+
+	// La geometría solo se obtiene al subir el modelo 3D
+	producto.Geometria = Geometria{}
+	producto.Imprimibilidad = InformeImprimibilidad{}
+	producto.ID = uuid.New().String()
+	return nil
 }
 
 // Actualizar un producto
 // @Summary Actualizar un producto
-// @Description Actualiza un producto existente
+// @Description Actualiza un producto existente. Si se envía If-Match solo se actualiza cuando coincide con la versión actual
 // @Tags productos
 // @Accept json
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param If-Match header string false "ETag obtenido al leer el producto"
+// @Param producto body Producto true "Producto actualizado"
+// @Success 200 {object} dominio.Respuesta{data=Producto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /productos/{id} [put]
 func updateProduct(c *gin.Context) {
-	id := c.Param("id")
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+
 	var producto Producto
 	if err := c.ShouldBindJSON(&producto); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		responderMensaje(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Buscar y actualizar el producto en el array
-	for i, p := range productos {
-		if p.ID == id {
-			producto.ID = id // Mantener el ID original
-			productos[i] = producto
-			c.JSON(http.StatusOK, gin.H{
-				"data": producto,
-			})
-			return
-		}
+	producto.ID = c.Param("id") // Mantener el ID original
+	guardarProducto(c, &producto, version)
+}
+
+// Actualizar parcialmente un producto
+// @Summary Actualizar parcialmente un producto
+// @Description Aplica un JSON Merge Patch (RFC 7386) o un JSON Patch (RFC 6902) sobre un producto existente. El resultado se valida antes de guardarlo. Las dimensiones del documento al que se aplica el parche están en ?unidad=
+// @Tags productos
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param unidad query string false "Unidad de las dimensiones del parche: mm (por defecto), cm o in"
+// @Param If-Match header string false "ETag obtenido al leer el producto"
+// @Success 200 {object} dominio.Respuesta{data=Producto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 409 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Failure 415 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /productos/{id} [patch]
+func patchProduct(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+
+	actual, err := repo.Obtener(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	if version != 0 && actual.Version != version {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+
+	// El parche se aplica sobre las dimensiones expresadas en ?unidad=
+	unidad, err := dominio.ParseUnidad(c.Query("unidad"))
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	actual.Dimensiones = actual.Dimensiones.En(unidad)
+
+	var producto Producto
+	if err := parche.Aplicar(c, actual, &producto); err != nil {
+		responderMensaje(c, parche.Estado(err), err.Error())
+		return
+	}
+	if producto.ID != actual.ID {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "El id del producto no se puede modificar"))
+		return
+	}
+
+	guardarProducto(c, &producto, actual.Version)
+}
+
+// guardarProducto valida un producto completo, incluida la transición de
+// estado respecto al almacenado, lo guarda y responde con el resultado. Si
+// version es 0 se exige la versión leída para no pisar una escritura
+// concurrente entre la lectura y la actualización
+func guardarProducto(c *gin.Context, producto *Producto, version int) {
+	actual, err := repo.Obtener(c.Request.Context(), producto.ID)
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	if version == 0 {
+		version = actual.Version
+	}
+	if err := prepararActualizacion(c.Request.Context(), producto, actual); err != nil {
+		responderError(c, err)
+		return
+	}
+
+	if err := repo.Actualizar(c.Request.Context(), producto, version); err != nil {
+		responderError(c, err)
+		return
+	}
+	responderProductoGuardado(c, http.StatusOK, *producto)
+}
+
+// prepararActualizacion completa y valida el producto que va a reemplazar a
+// actual: conserva lo que no se escribe por esta vía y comprueba la
+// transición de estado
+func prepararActualizacion(ctx context.Context, producto *Producto, actual Producto) error {
+	producto.Geometria = actual.Geometria
+	producto.Imprimibilidad = actual.Imprimibilidad
+	// Un PUT sin traducciones, atributos, etiquetas o componentes conserva
+	// los que hubiera; {} o [] los eliminan. Los componentes se conservan
+	// junto con el descuento del kit
+	if producto.Traducciones == nil {
+		producto.Traducciones = actual.Traducciones
+	}
+	if producto.Atributos == nil {
+		producto.Atributos = actual.Atributos
+	}
+	if producto.Etiquetas == nil {
+		producto.Etiquetas = actual.Etiquetas
+	}
+	if producto.Componentes == nil {
+		producto.Componentes = actual.Componentes
+		producto.DescuentoKit = actual.DescuentoKit
+	}
+	completarMoneda(producto)
+	producto.Dimensiones.Normalizar()
+	producto.Etiquetas = normalizarEtiquetas(producto.Etiquetas)
+
+	if err := prepararKit(ctx, producto); err != nil {
+		return err
+	}
+	if err := validarProducto(*producto); err != nil {
+		return err
 	}
+	if err := comprobarAtributos(ctx, *producto); err != nil {
+		return err
+	}
+	if err := dominio.ValidarTransicion(actual.Estado, producto.Estado); err != nil {
+		return err
+	}
+	return validarImprimibilidad(actual.Estado, *producto)
+}
 
-	c.JSON(http.StatusNotFound, gin.H{
-		"error": "Producto no encontrado",
+// responderProductoGuardado responde con un producto recién escrito y su
+// ETag, con la disponibilidad calculada como en las lecturas
+func responderProductoGuardado(c *gin.Context, estado int, producto Producto) {
+	lista := []Producto{producto}
+	if err := completarKits(c.Request.Context(), lista); err != nil {
+		responderError(c, err)
+		return
+	}
+	c.Header("ETag", condicional.ETag(producto.Version))
+	c.JSON(estado, gin.H{
+		"data": lista[0],
 	})
 }
 
+// Historial de estados de un producto
+// @Summary Historial de estados de un producto
+// @Description Lista los cambios de estado del producto en orden cronológico
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Success 200 {object} dominio.Respuesta{data=[]TransicionEstado}
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /productos/{id}/historial-estados [get]
+func getProductHistory(c *gin.Context) {
+	historial, err := repo.Historial(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": historial,
+	})
+}
+
+// Eliminar un producto
+// @Summary Eliminar un producto
+// @Description Mueve un producto a la papelera, de donde se puede restaurar hasta que se purga. No se puede eliminar un producto que es componente de algún kit. Si se envía If-Match solo se elimina cuando coincide con la versión actual
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param If-Match header string false "ETag obtenido al leer el producto"
+// @Success 200 {object} dominio.Mensaje
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 409 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Router /productos/{id} [delete]
 func deleteProduct(c *gin.Context) {
-	id := c.Param("id")
-
-	// Buscar y eliminar el producto del array
-	for i, p := range productos {
-		if p.ID == id {
-			productos = append(productos[:i], productos[i+1:]...)
-			c.JSON(http.StatusOK, gin.H{
-				"message": "Producto eliminado",
-			})
-			return
-		}
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
 	}
 
-	c.JSON(http.StatusNotFound, gin.H{
-		"error": "Producto no encontrado",
+	if err := repo.Eliminar(c.Request.Context(), c.Param("id"), version); err != nil {
+		responderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": traducir(c, "Producto eliminado"),
 	})
 }
+
+// responderError traduce los errores del repositorio a respuestas HTTP
+func responderError(c *gin.Context, err error) {
+	c.JSON(respuestaError(c, err))
+}
+
+// responderMensaje responde un error con el código de su estado
+func responderMensaje(c *gin.Context, estado int, mensaje string) {
+	c.JSON(respuestaMensaje(estado, mensaje))
+}
+
+// responderPagina escribe el sobre {"data", "meta", "links"} de un listado paginado
+func responderPagina[T any](c *gin.Context, elementos []T, res listado.Resultado, v listado.Ventana, campos []string) {
+	if err := listado.Responder(c, elementos, res, v, campos); err != nil {
+		responderMensaje(c, http.StatusInternalServerError, traducir(c, "Error al serializar la respuesta"))
+	}
+}
+
+// responderValidacion responde 422 con el detalle de los errores de campo,
+// traducidos como mensaje
+func responderValidacion(c *gin.Context, mensaje string, errs dominio.ErroresValidacion) {
+	c.JSON(respuestaValidacion(c, mensaje, errs))
+}
+
+func respuestaMensaje(estado int, mensaje string) (int, dominio.RespuestaError) {
+	return estado, dominio.NuevoError(estado, mensaje)
+}
+
+func respuestaValidacion(c *gin.Context, mensaje string, errs dominio.ErroresValidacion) (int, dominio.RespuestaError) {
+	return http.StatusUnprocessableEntity, dominio.RespuestaError{
+		Error:   traducir(c, mensaje),
+		Codigo:  dominio.CodigoValidacion,
+		Errores: traducirErrores(c, errs),
+	}
+}
+
+// respuestaError devuelve el estado y el cuerpo con los que responderError
+// responde a err
+func respuestaError(c *gin.Context, err error) (int, dominio.RespuestaError) {
+	var errsValidacion dominio.ErroresValidacion
+	switch {
+	case errors.As(err, &errsValidacion):
+		return respuestaValidacion(c, "Producto inválido", errsValidacion)
+	case errors.Is(err, ErrProductoNoEncontrado):
+		return respuestaMensaje(http.StatusNotFound, traducir(c, "Producto no encontrado"))
+	case errors.Is(err, ErrConflictoVersion):
+		return respuestaMensaje(http.StatusPreconditionFailed, traducir(c, "El producto fue modificado por otro usuario"))
+	case errors.Is(err, ErrProductoEnKit):
+		return respuestaMensaje(http.StatusConflict, traducir(c, "El producto es componente de algún kit; quítelo de los kits antes de eliminarlo"))
+	case errors.Is(err, ErrRelacionNoEncontrada):
+		return respuestaMensaje(http.StatusNotFound, traducir(c, "Relación no encontrada"))
+	case errors.Is(err, ErrRelacionDuplicada):
+		return respuestaMensaje(http.StatusConflict, traducir(c, "Ya existe esa relación entre los productos"))
+	case errors.Is(err, ErrAdjuntoNoEncontrado):
+		return respuestaMensaje(http.StatusNotFound, traducir(c, "Adjunto no encontrado"))
+	case errors.Is(err, ErrAdjuntoDuplicado):
+		return respuestaMensaje(http.StatusConflict, traducir(c, "El producto ya tiene un adjunto con ese contenido"))
+	case errors.Is(err, ErrTraduccionNoEncontrada):
+		return respuestaMensaje(http.StatusNotFound, traducir(c, "Traducción no encontrada"))
+	case errors.Is(err, ErrAtributoNoEncontrado):
+		return respuestaMensaje(http.StatusNotFound, traducir(c, "Atributo no encontrado"))
+	case errors.Is(err, ErrAtributoDuplicado):
+		return respuestaMensaje(http.StatusConflict, traducir(c, "Ya existe un atributo con ese código"))
+	case errors.Is(err, ErrAtributoEnUso):
+		return respuestaMensaje(http.StatusConflict, traducir(c, "El atributo tiene valores en algún producto"))
+	case errors.Is(err, ErrRevisionNoEncontrada):
+		return respuestaMensaje(http.StatusNotFound, traducir(c, "Revisión no encontrada"))
+	case errors.Is(err, ErrTasaNoEncontrada):
+		return respuestaMensaje(http.StatusNotFound, traducir(c, "Tasa de cambio no encontrada"))
+	case errors.Is(err, ErrTasaDuplicada):
+		return respuestaMensaje(http.StatusConflict, traducir(c, "Ya existe una tasa para esas monedas con la misma fecha de vigencia"))
+	case errors.Is(err, ErrModeloNoEncontrado):
+		return respuestaMensaje(http.StatusNotFound, traducir(c, "El producto no tiene modelo 3D"))
+	case errors.Is(err, ErrModeloNoImprimible):
+		return respuestaMensaje(http.StatusConflict, traducir(c, "El modelo 3D no es imprimible; retire el producto de la venta"))
+	case errors.Is(err, ErrCategoriaNoEncontrada):
+		return respuestaMensaje(http.StatusNotFound, traducir(c, "Categoría no encontrada"))
+	case errors.Is(err, ErrSlugDuplicado):
+		return respuestaMensaje(http.StatusConflict, traducir(c, "Ya existe una categoría con ese slug"))
+	case errors.Is(err, ErrCategoriaEnUso):
+		return respuestaMensaje(http.StatusConflict, traducir(c, "La categoría tiene subcategorías o productos"))
+	}
+	log.Printf("Error de repositorio: %v", err)
+	return respuestaMensaje(http.StatusInternalServerError, traducir(c, "Error interno del servidor"))
+}
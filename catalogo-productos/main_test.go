@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// nuevoServidorPrueba levanta el servicio sobre un repositorio en memoria
+// vacío. repo es global, así que los tests que lo usan no corren en paralelo
+func nuevoServidorPrueba(t *testing.T) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	repo = newRepositorioMemoria()
+	srv := httptest.NewServer(nuevoRouter())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// peticion envía cuerpo como JSON, si no es nil, y devuelve el estado, las
+// cabeceras y el cuerpo de la respuesta. cabeceras alterna nombre y valor.
+// Si la petición no llega a hacerse marca el test como fallido y devuelve
+// estado 0, así que se puede usar desde otras goroutines
+func peticion(t testing.TB, srv *httptest.Server, metodo, ruta string, cuerpo any, cabeceras ...string) (int, http.Header, []byte) {
+	t.Helper()
+	var r io.Reader
+	if cuerpo != nil {
+		b, err := json.Marshal(cuerpo)
+		if err != nil {
+			t.Error(err)
+			return 0, nil, nil
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(metodo, srv.URL+ruta, r)
+	if err != nil {
+		t.Error(err)
+		return 0, nil, nil
+	}
+	if cuerpo != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(cabeceras); i += 2 {
+		req.Header.Set(cabeceras[i], cabeceras[i+1])
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Error(err)
+		return 0, nil, nil
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Error(err)
+		return 0, nil, nil
+	}
+	return resp.StatusCode, resp.Header, b
+}
+
+// datos decodifica el campo data de una respuesta
+func datos[T any](t testing.TB, cuerpo []byte) T {
+	t.Helper()
+	var r struct {
+		Data T `json:"data"`
+	}
+	if err := json.Unmarshal(cuerpo, &r); err != nil {
+		t.Errorf("respuesta inválida %s: %v", cuerpo, err)
+	}
+	return r.Data
+}
+
+// crearCategoriaPrueba crea una categoría raíz y devuelve su ID
+func crearCategoriaPrueba(t testing.TB, srv *httptest.Server, nombre string) string {
+	t.Helper()
+	estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/categorias", map[string]any{"nombre": nombre})
+	if estado != http.StatusCreated {
+		t.Fatalf("crear categoría: %d %s", estado, cuerpo)
+	}
+	return datos[Categoria](t, cuerpo).ID
+}
+
+// productoPrueba devuelve el cuerpo de un producto publicado válido
+func productoPrueba(nombre, categoriaID string) map[string]any {
+	return map[string]any{
+		"nombre":       nombre,
+		"descripcion":  "Producto de prueba",
+		"precio_base":  map[string]any{"importe": "10.50", "moneda": "USD"},
+		"dimensiones":  map[string]any{"ancho": 10, "alto": 20, "profundo": 30},
+		"categoria_id": categoriaID,
+		"estado":       "disponible",
+	}
+}
+
+// crearProductoPrueba crea un producto publicado y lo devuelve
+func crearProductoPrueba(t testing.TB, srv *httptest.Server, nombre, categoriaID string) Producto {
+	t.Helper()
+	estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/productos", productoPrueba(nombre, categoriaID))
+	if estado != http.StatusCreated {
+		t.Fatalf("crear producto: %d %s", estado, cuerpo)
+	}
+	return datos[Producto](t, cuerpo)
+}
@@ -0,0 +1,333 @@
+package malla
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// toleranciaSoldadura es la distancia en mm por debajo de la cual dos
+	// vértices se consideran el mismo
+	toleranciaSoldadura = 1e-5
+	// areaMinima es el área en mm² por debajo de la cual un triángulo es degenerado
+	areaMinima = 1e-10
+	// epsilonInterseccion descarta los contactos en bordes y vértices al
+	// buscar autointersecciones
+	epsilonInterseccion = 1e-9
+	// maximoAutointersecciones acota el trabajo en mallas muy dañadas; al
+	// alcanzarlo se deja de contar
+	maximoAutointersecciones = 1000
+)
+
+// Informe recoge los defectos que impiden imprimir una malla
+type Informe struct {
+	// AristasNoManifold son las aristas compartidas por más de dos triángulos
+	AristasNoManifold int
+	// AristasAbiertas son las aristas que pertenecen a un solo triángulo
+	AristasAbiertas int
+	// Agujeros es el número de contornos abiertos que forman esas aristas
+	Agujeros int
+	// NormalesInvertidas son los triángulos orientados al revés que sus vecinos
+	// o, en un cuerpo cerrado orientado de forma consistente, hacia el interior
+	NormalesInvertidas int
+	// TriangulosDegenerados son los triángulos sin área o con vértices repetidos
+	TriangulosDegenerados int
+	// Cuerpos es el número de piezas desconectadas de la malla
+	Cuerpos int
+	// Autointersecciones son los pares de triángulos no adyacentes que se
+	// cortan, hasta maximoAutointersecciones
+	Autointersecciones int
+}
+
+// Imprimible indica si la malla es un único cuerpo cerrado, manifold, bien
+// orientado y sin triángulos degenerados ni autointersecciones
+func (i Informe) Imprimible() bool {
+	return i.AristasNoManifold == 0 &&
+		i.AristasAbiertas == 0 &&
+		i.NormalesInvertidas == 0 &&
+		i.TriangulosDegenerados == 0 &&
+		i.Cuerpos == 1 &&
+		i.Autointersecciones == 0
+}
+
+// arista es una arista sin dirección entre dos vértices soldados, con a < b
+type arista struct{ a, b int }
+
+// usoArista es un triángulo que contiene la arista; directo indica si la
+// recorre de a hacia b
+type usoArista struct {
+	cara    int
+	directo bool
+}
+
+// vecino es un triángulo adyacente por una arista manifold; mismoSentido
+// indica que ambos recorren la arista en la misma dirección, es decir, que
+// sus orientaciones no son consistentes
+type vecino struct {
+	cara         int
+	mismoSentido bool
+}
+
+// Analizar comprueba la integridad de la malla. Los vértices se sueldan con
+// toleranciaSoldadura antes de construir la topología
+func (m *Malla) Analizar() Informe {
+	var inf Informe
+
+	// Soldar vértices y descartar de la topología los triángulos que
+	// colapsan en una arista o un punto
+	indices := make(map[[3]int64]int)
+	var posiciones []Vector
+	soldar := func(v Vector) int {
+		clave := [3]int64{
+			int64(math.Round(v.X / toleranciaSoldadura)),
+			int64(math.Round(v.Y / toleranciaSoldadura)),
+			int64(math.Round(v.Z / toleranciaSoldadura)),
+		}
+		i, ok := indices[clave]
+		if !ok {
+			i = len(posiciones)
+			indices[clave] = i
+			posiciones = append(posiciones, v)
+		}
+		return i
+	}
+
+	var caras [][3]int
+	var triangulos []Triangulo
+	for _, t := range m.Triangulos {
+		c := [3]int{soldar(t[0]), soldar(t[1]), soldar(t[2])}
+		if c[0] == c[1] || c[1] == c[2] || c[0] == c[2] {
+			inf.TriangulosDegenerados++
+			continue
+		}
+		if t.Area() < areaMinima {
+			inf.TriangulosDegenerados++
+		}
+		caras = append(caras, c)
+		triangulos = append(triangulos, t)
+	}
+
+	usos := make(map[arista][]usoArista)
+	for i, c := range caras {
+		for k := 0; k < 3; k++ {
+			u, v := c[k], c[(k+1)%3]
+			a := arista{u, v}
+			if u > v {
+				a = arista{v, u}
+			}
+			usos[a] = append(usos[a], usoArista{cara: i, directo: u < v})
+		}
+	}
+
+	cuerpos := newConjuntos(len(caras))
+	contornos := newConjuntos(len(posiciones))
+	verticesBorde := make(map[int]bool)
+	vecinos := make([][]vecino, len(caras))
+	for a, us := range usos {
+		for _, u := range us[1:] {
+			cuerpos.unir(us[0].cara, u.cara)
+		}
+		switch {
+		case len(us) == 1:
+			inf.AristasAbiertas++
+			contornos.unir(a.a, a.b)
+			verticesBorde[a.a] = true
+			verticesBorde[a.b] = true
+		case len(us) > 2:
+			inf.AristasNoManifold++
+		default:
+			mismo := us[0].directo == us[1].directo
+			vecinos[us[0].cara] = append(vecinos[us[0].cara], vecino{us[1].cara, mismo})
+			vecinos[us[1].cara] = append(vecinos[us[1].cara], vecino{us[0].cara, mismo})
+		}
+	}
+
+	raices := make(map[int]bool)
+	for i := range caras {
+		raices[cuerpos.raiz(i)] = true
+	}
+	inf.Cuerpos = len(raices)
+
+	raices = make(map[int]bool)
+	for v := range verticesBorde {
+		raices[contornos.raiz(v)] = true
+	}
+	inf.Agujeros = len(raices)
+
+	inf.NormalesInvertidas = contarInvertidas(triangulos, vecinos)
+	inf.Autointersecciones = contarAutointersecciones(triangulos, caras)
+	return inf
+}
+
+// contarInvertidas propaga la orientación desde un triángulo de cada región
+// conexa por aristas manifold. Los triángulos que quedan con paridad distinta
+// a la mayoritaria están invertidos; si la región encierra volumen se toma
+// como correcta la orientación que da volumen positivo, de modo que un cuerpo
+// con todas las normales hacia dentro cuenta todos sus triángulos
+func contarInvertidas(triangulos []Triangulo, vecinos [][]vecino) int {
+	paridad := make([]int8, len(triangulos))
+	for i := range paridad {
+		paridad[i] = -1
+	}
+
+	total := 0
+	for inicio := range triangulos {
+		if paridad[inicio] >= 0 {
+			continue
+		}
+		paridad[inicio] = 0
+		pila := []int{inicio}
+		var (
+			porParidad [2]int
+			volumen    float64
+			escala     float64
+		)
+		for len(pila) > 0 {
+			i := pila[len(pila)-1]
+			pila = pila[:len(pila)-1]
+			porParidad[paridad[i]]++
+
+			t := triangulos[i]
+			v := t[0].Dot(t[1].Cross(t[2])) / 6
+			if paridad[i] == 1 {
+				v = -v
+			}
+			volumen += v
+			escala += math.Abs(v)
+
+			for _, n := range vecinos[i] {
+				if paridad[n.cara] >= 0 {
+					continue
+				}
+				p := paridad[i]
+				if n.mismoSentido {
+					p = 1 - p
+				}
+				paridad[n.cara] = p
+				pila = append(pila, n.cara)
+			}
+		}
+
+		switch {
+		case escala > 0 && math.Abs(volumen) > escala*1e-9:
+			// Con volumen positivo la paridad 0 es la correcta
+			if volumen > 0 {
+				total += porParidad[1]
+			} else {
+				total += porParidad[0]
+			}
+		default:
+			total += min(porParidad[0], porParidad[1])
+		}
+	}
+	return total
+}
+
+// contarAutointersecciones busca pares de triángulos que se cortan. Se
+// ordenan por la coordenada X mínima y solo se comparan los que solapan en
+// las tres coordenadas; los pares que comparten un vértice se consideran
+// adyacentes y no se comprueban
+func contarAutointersecciones(triangulos []Triangulo, caras [][3]int) int {
+	type caja struct {
+		min, max Vector
+		i        int
+	}
+	cajas := make([]caja, len(triangulos))
+	for i, t := range triangulos {
+		m := Malla{Triangulos: []Triangulo{t}}
+		mn, mx := m.Limites()
+		cajas[i] = caja{mn, mx, i}
+	}
+	sort.Slice(cajas, func(a, b int) bool { return cajas[a].min.X < cajas[b].min.X })
+
+	total := 0
+	for a := range cajas {
+		ca := cajas[a]
+		for b := a + 1; b < len(cajas) && cajas[b].min.X <= ca.max.X; b++ {
+			cb := cajas[b]
+			if cb.min.Y > ca.max.Y || cb.max.Y < ca.min.Y || cb.min.Z > ca.max.Z || cb.max.Z < ca.min.Z {
+				continue
+			}
+			if compartenVertice(caras[ca.i], caras[cb.i]) {
+				continue
+			}
+			if seCortan(triangulos[ca.i], triangulos[cb.i]) {
+				total++
+				if total >= maximoAutointersecciones {
+					return total
+				}
+			}
+		}
+	}
+	return total
+}
+
+func compartenVertice(a, b [3]int) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// seCortan indica si algún lado de un triángulo atraviesa el interior del
+// otro. Los triángulos coplanares no se consideran intersecciones
+func seCortan(t1, t2 Triangulo) bool {
+	for k := 0; k < 3; k++ {
+		if segmentoCorta(t1[k], t1[(k+1)%3], t2) || segmentoCorta(t2[k], t2[(k+1)%3], t1) {
+			return true
+		}
+	}
+	return false
+}
+
+// segmentoCorta aplica Möller-Trumbore al segmento p-q contra el triángulo t
+func segmentoCorta(p, q Vector, t Triangulo) bool {
+	dir := q.Sub(p)
+	e1 := t[1].Sub(t[0])
+	e2 := t[2].Sub(t[0])
+	h := dir.Cross(e2)
+	det := e1.Dot(h)
+	if math.Abs(det) < epsilonInterseccion*e1.Len()*h.Len() {
+		return false // paralelo o coplanar
+	}
+	f := 1 / det
+	s := p.Sub(t[0])
+	u := f * s.Dot(h)
+	if u <= epsilonInterseccion || u >= 1-epsilonInterseccion {
+		return false
+	}
+	qv := s.Cross(e1)
+	v := f * dir.Dot(qv)
+	if v <= epsilonInterseccion || u+v >= 1-epsilonInterseccion {
+		return false
+	}
+	d := f * e2.Dot(qv)
+	return d > epsilonInterseccion && d < 1-epsilonInterseccion
+}
+
+// conjuntos es una estructura union-find sobre los enteros 0..n-1
+type conjuntos []int
+
+func newConjuntos(n int) conjuntos {
+	c := make(conjuntos, n)
+	for i := range c {
+		c[i] = i
+	}
+	return c
+}
+
+func (c conjuntos) raiz(i int) int {
+	for c[i] != i {
+		c[i] = c[c[i]]
+		i = c[i]
+	}
+	return i
+}
+
+func (c conjuntos) unir(a, b int) {
+	c[c.raiz(a)] = c.raiz(b)
+}
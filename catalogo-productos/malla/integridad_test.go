@@ -0,0 +1,171 @@
+package malla
+
+import (
+	"slices"
+	"testing"
+)
+
+// invertir devuelve el triángulo con la orientación contraria
+func invertir(t Triangulo) Triangulo {
+	return Triangulo{t[0], t[2], t[1]}
+}
+
+// unir devuelve una malla con los triángulos de todas
+func unir(mallas ...*Malla) *Malla {
+	m := &Malla{}
+	for _, o := range mallas {
+		m.Triangulos = append(m.Triangulos, o.Triangulos...)
+	}
+	return m
+}
+
+func TestAnalizar(t *testing.T) {
+	casos := []struct {
+		nombre     string
+		malla      func() *Malla
+		want       Informe
+		imprimible bool
+	}{
+		{
+			nombre:     "cubo cerrado",
+			malla:      func() *Malla { return cubo(10, Vector{}) },
+			want:       Informe{Cuerpos: 1},
+			imprimible: true,
+		},
+		{
+			// Los vértices a menos de toleranciaSoldadura se sueldan
+			nombre: "vértices casi iguales",
+			malla: func() *Malla {
+				m := cubo(10, Vector{})
+				m.Triangulos[0][0] = m.Triangulos[0][0].Add(Vector{1e-7, 0, 0})
+				return m
+			},
+			want:       Informe{Cuerpos: 1},
+			imprimible: true,
+		},
+		{
+			nombre: "un agujero",
+			malla: func() *Malla {
+				m := cubo(10, Vector{})
+				m.Triangulos = m.Triangulos[1:]
+				return m
+			},
+			want: Informe{AristasAbiertas: 3, Agujeros: 1, Cuerpos: 1},
+		},
+		{
+			// Sin las dos tapas quedan dos contornos: arriba y abajo
+			nombre: "tubo abierto",
+			malla: func() *Malla {
+				m := cubo(10, Vector{})
+				m.Triangulos = m.Triangulos[4:]
+				return m
+			},
+			want: Informe{AristasAbiertas: 8, Agujeros: 2, Cuerpos: 1},
+		},
+		{
+			nombre: "una normal invertida",
+			malla: func() *Malla {
+				m := cubo(10, Vector{})
+				m.Triangulos[5] = invertir(m.Triangulos[5])
+				return m
+			},
+			want: Informe{NormalesInvertidas: 1, Cuerpos: 1},
+		},
+		{
+			// Orientado de forma consistente pero hacia dentro
+			nombre: "todas las normales hacia dentro",
+			malla: func() *Malla {
+				m := cubo(10, Vector{})
+				for i, tri := range m.Triangulos {
+					m.Triangulos[i] = invertir(tri)
+				}
+				return m
+			},
+			want: Informe{NormalesInvertidas: 12, Cuerpos: 1},
+		},
+		{
+			nombre: "triángulo con vértices repetidos",
+			malla: func() *Malla {
+				m := cubo(10, Vector{})
+				v := m.Triangulos[0][0]
+				m.Triangulos = append(m.Triangulos, Triangulo{v, v, m.Triangulos[0][1]})
+				return m
+			},
+			want: Informe{TriangulosDegenerados: 1, Cuerpos: 1},
+		},
+		{
+			// Un triángulo duplicado comparte sus tres aristas con otros dos
+			nombre: "aristas no manifold",
+			malla: func() *Malla {
+				m := cubo(10, Vector{})
+				m.Triangulos = append(m.Triangulos, m.Triangulos[0])
+				return m
+			},
+			want: Informe{AristasNoManifold: 3, Cuerpos: 1},
+		},
+		{
+			nombre: "dos cuerpos separados",
+			malla: func() *Malla {
+				return unir(cubo(10, Vector{}), cubo(5, Vector{20, 0, 0}))
+			},
+			want: Informe{Cuerpos: 2},
+		},
+		{
+			// Dos cubos que se solapan: las caras de uno atraviesan las del
+			// otro. El desplazamiento evita que los cortes caigan justo sobre
+			// una arista, donde no se cuentan
+			nombre: "autointersección",
+			malla: func() *Malla {
+				return unir(cubo(10, Vector{}), cubo(10, Vector{5, 3.3, 4.1}))
+			},
+			want: Informe{Cuerpos: 2, Autointersecciones: -1},
+		},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			got := c.malla().Analizar()
+			// -1 indica que solo importa que haya alguna autointersección
+			if c.want.Autointersecciones < 0 {
+				if got.Autointersecciones == 0 {
+					t.Errorf("no se detectaron autointersecciones: %+v", got)
+				}
+				c.want.Autointersecciones = got.Autointersecciones
+			}
+			if got != c.want {
+				t.Errorf("Analizar() = %+v, se esperaba %+v", got, c.want)
+			}
+			if got.Imprimible() != c.imprimible {
+				t.Errorf("Imprimible() = %v, se esperaba %v", got.Imprimible(), c.imprimible)
+			}
+		})
+	}
+}
+
+// TestAnalizarNoDependeDelOrden comprueba que el informe es el mismo con los
+// triángulos en otro orden, ya que la propagación de la orientación parte
+// del primer triángulo de cada región
+func TestAnalizarNoDependeDelOrden(t *testing.T) {
+	m := cubo(10, Vector{})
+	m.Triangulos[0] = invertir(m.Triangulos[0])
+	want := m.Analizar()
+
+	invertido := &Malla{Triangulos: slices.Clone(m.Triangulos)}
+	slices.Reverse(invertido.Triangulos)
+	if got := invertido.Analizar(); got != want {
+		t.Errorf("en orden inverso Analizar() = %+v, se esperaba %+v", got, want)
+	}
+}
+
+func TestAnalizarMallaGrande(t *testing.T) {
+	// Una rejilla de cubos separados: muchos triángulos, ninguna intersección
+	var cubos []*Malla
+	for i := range 10 {
+		for j := range 10 {
+			cubos = append(cubos, cubo(1, Vector{float64(2 * i), float64(2 * j), 0}))
+		}
+	}
+	got := unir(cubos...).Analizar()
+	if want := (Informe{Cuerpos: 100}); got != want {
+		t.Errorf("Analizar() = %+v, se esperaba %+v", got, want)
+	}
+}
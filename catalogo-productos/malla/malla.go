@@ -0,0 +1,177 @@
+// Package malla lee modelos 3D en formato STL, OBJ y 3MF y calcula su
+// geometría: caja envolvente, volumen, área de superficie y número de
+// triángulos. Todas las medidas se expresan en milímetros.
+package malla
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"path"
+	"strings"
+)
+
+// Formato identifica el formato de archivo de un modelo
+type Formato string
+
+const (
+	FormatoSTL Formato = "stl"
+	FormatoOBJ Formato = "obj"
+	Formato3MF Formato = "3mf"
+)
+
+var (
+	// ErrFormatoDesconocido se devuelve cuando no se reconoce el formato del archivo
+	ErrFormatoDesconocido = errors.New("formato de modelo no soportado, se admite STL, OBJ y 3MF")
+	// ErrMallaVacia se devuelve cuando el archivo no contiene ningún triángulo
+	ErrMallaVacia = errors.New("el modelo no contiene triángulos")
+)
+
+// Vector es un punto o una dirección en el espacio
+type Vector struct {
+	X, Y, Z float64
+}
+
+func (a Vector) Sub(b Vector) Vector    { return Vector{a.X - b.X, a.Y - b.Y, a.Z - b.Z} }
+func (a Vector) Add(b Vector) Vector    { return Vector{a.X + b.X, a.Y + b.Y, a.Z + b.Z} }
+func (a Vector) Scale(k float64) Vector { return Vector{a.X * k, a.Y * k, a.Z * k} }
+func (a Vector) Dot(b Vector) float64   { return a.X*b.X + a.Y*b.Y + a.Z*b.Z }
+func (a Vector) Len() float64           { return math.Sqrt(a.Dot(a)) }
+
+func (a Vector) Cross(b Vector) Vector {
+	return Vector{
+		a.Y*b.Z - a.Z*b.Y,
+		a.Z*b.X - a.X*b.Z,
+		a.X*b.Y - a.Y*b.X,
+	}
+}
+
+// Triangulo es una cara de la malla con sus vértices en orden antihorario
+// visto desde fuera, de modo que la normal apunta hacia el exterior
+type Triangulo [3]Vector
+
+// Normal devuelve la normal sin normalizar; su longitud es el doble del área
+func (t Triangulo) Normal() Vector {
+	return t[1].Sub(t[0]).Cross(t[2].Sub(t[0]))
+}
+
+// Area devuelve el área del triángulo
+func (t Triangulo) Area() float64 {
+	return t.Normal().Len() / 2
+}
+
+// Malla es una superficie triangulada
+type Malla struct {
+	Triangulos []Triangulo
+}
+
+// Geometria resume las medidas de una malla
+type Geometria struct {
+	Min        Vector
+	Max        Vector
+	Volumen    float64 // mm³
+	Area       float64 // mm²
+	Triangulos int
+}
+
+// Tamano devuelve las dimensiones de la caja envolvente
+func (g Geometria) Tamano() Vector {
+	return g.Max.Sub(g.Min)
+}
+
+// Limites devuelve las esquinas mínima y máxima de la caja envolvente
+func (m *Malla) Limites() (min, max Vector) {
+	if len(m.Triangulos) == 0 {
+		return Vector{}, Vector{}
+	}
+	min = m.Triangulos[0][0]
+	max = min
+	for _, t := range m.Triangulos {
+		for _, v := range t {
+			min = Vector{math.Min(min.X, v.X), math.Min(min.Y, v.Y), math.Min(min.Z, v.Z)}
+			max = Vector{math.Max(max.X, v.X), math.Max(max.Y, v.Y), math.Max(max.Z, v.Z)}
+		}
+	}
+	return min, max
+}
+
+// Volumen calcula el volumen encerrado por la malla sumando los volúmenes con
+// signo de los tetraedros que forma cada triángulo con el origen. Solo es
+// exacto para mallas cerradas; se devuelve el valor absoluto para que una
+// malla con todas las normales invertidas también dé un volumen positivo
+func (m *Malla) Volumen() float64 {
+	var v float64
+	for _, t := range m.Triangulos {
+		v += t[0].Dot(t[1].Cross(t[2])) / 6
+	}
+	return math.Abs(v)
+}
+
+// Area calcula el área total de la superficie
+func (m *Malla) Area() float64 {
+	var a float64
+	for _, t := range m.Triangulos {
+		a += t.Area()
+	}
+	return a
+}
+
+// Geometria calcula todas las medidas de la malla
+func (m *Malla) Geometria() Geometria {
+	min, max := m.Limites()
+	return Geometria{
+		Min:        min,
+		Max:        max,
+		Volumen:    m.Volumen(),
+		Area:       m.Area(),
+		Triangulos: len(m.Triangulos),
+	}
+}
+
+// DetectarFormato deduce el formato a partir de la extensión del nombre de
+// archivo y, si no la hay, del contenido
+func DetectarFormato(nombre string, datos []byte) (Formato, error) {
+	switch strings.ToLower(path.Ext(nombre)) {
+	case ".stl":
+		return FormatoSTL, nil
+	case ".obj":
+		return FormatoOBJ, nil
+	case ".3mf":
+		return Formato3MF, nil
+	}
+
+	switch {
+	case bytes.HasPrefix(datos, []byte("PK\x03\x04")):
+		return Formato3MF, nil
+	case esSTLBinario(datos) || bytes.HasPrefix(bytes.TrimSpace(datos), []byte("solid")):
+		return FormatoSTL, nil
+	case bytes.Contains(datos, []byte("\nv ")) || bytes.HasPrefix(datos, []byte("v ")):
+		return FormatoOBJ, nil
+	}
+	return "", ErrFormatoDesconocido
+}
+
+// Leer interpreta los datos de un modelo en el formato indicado
+func Leer(formato Formato, datos []byte) (*Malla, error) {
+	var (
+		m   *Malla
+		err error
+	)
+	switch formato {
+	case FormatoSTL:
+		m, err = LeerSTL(datos)
+	case FormatoOBJ:
+		m, err = LeerOBJ(datos)
+	case Formato3MF:
+		m, err = Leer3MF(datos)
+	default:
+		return nil, ErrFormatoDesconocido
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Triangulos) == 0 {
+		return nil, ErrMallaVacia
+	}
+	return m, nil
+}
@@ -0,0 +1,309 @@
+package malla
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// carasCubo son las caras de un cubo sobre las esquinas x + 2y + 4z, en
+// sentido antihorario vistas desde fuera
+var carasCubo = [][4]int{
+	{0, 2, 3, 1}, // z = 0
+	{4, 5, 7, 6}, // z = 1
+	{0, 1, 5, 4}, // y = 0
+	{2, 6, 7, 3}, // y = 1
+	{0, 4, 6, 2}, // x = 0
+	{1, 3, 7, 5}, // x = 1
+}
+
+// esquinasCubo devuelve las esquinas de un cubo de lado dado con la
+// esquina mínima en origen
+func esquinasCubo(lado float64, origen Vector) []Vector {
+	esquinas := make([]Vector, 8)
+	for i := range esquinas {
+		esquinas[i] = origen.Add(Vector{float64(i & 1), float64(i >> 1 & 1), float64(i >> 2 & 1)}.Scale(lado))
+	}
+	return esquinas
+}
+
+// cubo devuelve los 12 triángulos de un cubo cerrado y bien orientado
+func cubo(lado float64, origen Vector) *Malla {
+	e := esquinasCubo(lado, origen)
+	m := &Malla{}
+	for _, c := range carasCubo {
+		m.Triangulos = append(m.Triangulos,
+			Triangulo{e[c[0]], e[c[1]], e[c[2]]},
+			Triangulo{e[c[0]], e[c[2]], e[c[3]]})
+	}
+	return m
+}
+
+func stlBinario(m *Malla) []byte {
+	var b bytes.Buffer
+	b.Write(make([]byte, tamanoCabeceraSTL))
+	binary.Write(&b, binary.LittleEndian, uint32(len(m.Triangulos)))
+	for _, t := range m.Triangulos {
+		// La normal se escribe a cero: el lector la recalcula
+		binary.Write(&b, binary.LittleEndian, [3]float32{})
+		for _, v := range t {
+			binary.Write(&b, binary.LittleEndian, [3]float32{float32(v.X), float32(v.Y), float32(v.Z)})
+		}
+		binary.Write(&b, binary.LittleEndian, uint16(0))
+	}
+	return b.Bytes()
+}
+
+func stlASCII(m *Malla) []byte {
+	var b strings.Builder
+	b.WriteString("solid cubo\n")
+	for _, t := range m.Triangulos {
+		b.WriteString("  facet normal 0 0 0\n    outer loop\n")
+		for _, v := range t {
+			fmt.Fprintf(&b, "      vertex %g %g %g\n", v.X, v.Y, v.Z)
+		}
+		b.WriteString("    endloop\n  endfacet\n")
+	}
+	b.WriteString("endsolid cubo\n")
+	return []byte(b.String())
+}
+
+// objCubo escribe el cubo con caras cuadradas; la mitad de las caras usan
+// índices negativos y referencias v/vt/vn
+func objCubo(lado float64) []byte {
+	var b strings.Builder
+	b.WriteString("# cubo\no cubo\n")
+	for _, v := range esquinasCubo(lado, Vector{}) {
+		fmt.Fprintf(&b, "v %g %g %g\n", v.X, v.Y, v.Z)
+	}
+	b.WriteString("vn 0 0 1\n")
+	for i, c := range carasCubo {
+		b.WriteString("f")
+		for _, v := range c {
+			if i%2 == 0 {
+				fmt.Fprintf(&b, " %d/1/1", v+1)
+			} else {
+				fmt.Fprintf(&b, " %d", v-8)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return []byte(b.String())
+}
+
+// paquete3MF empaqueta un modelo 3MF con el XML indicado
+func paquete3MF(t *testing.T, ruta, modelo string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for nombre, contenido := range map[string]string{
+		"[Content_Types].xml": `<?xml version="1.0" encoding="UTF-8"?><Types/>`,
+		ruta:                  modelo,
+	} {
+		w, err := zw.Create(nombre)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(contenido))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// modelo3MFCubo escribe un cubo unitario como objeto 1 en la unidad dada;
+// extra se añade a resources y build lista los elementos
+func modelo3MFCubo(unidad, extra, build string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>
+<model unit=%q xmlns="http://schemas.microsoft.com/3dmanufacturing/core/2015/02">
+<resources><object id="1" type="model"><mesh><vertices>`, unidad)
+	for _, v := range esquinasCubo(1, Vector{}) {
+		fmt.Fprintf(&b, `<vertex x="%g" y="%g" z="%g"/>`, v.X, v.Y, v.Z)
+	}
+	b.WriteString(`</vertices><triangles>`)
+	for _, c := range carasCubo {
+		fmt.Fprintf(&b, `<triangle v1="%d" v2="%d" v3="%d"/><triangle v1="%d" v2="%d" v3="%d"/>`, c[0], c[1], c[2], c[0], c[2], c[3])
+	}
+	fmt.Fprintf(&b, `</triangles></mesh></object>%s</resources><build>%s</build></model>`, extra, build)
+	return b.String()
+}
+
+func casiIgual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-6*math.Max(1, math.Abs(b))
+}
+
+// comprobarGeometria compara la geometría con la de una caja
+func comprobarGeometria(t *testing.T, g Geometria, min, max Vector, triangulos int) {
+	t.Helper()
+	tam := max.Sub(min)
+	volumen := tam.X * tam.Y * tam.Z
+	area := 2 * (tam.X*tam.Y + tam.Y*tam.Z + tam.X*tam.Z)
+	if g.Triangulos != triangulos {
+		t.Errorf("%d triángulos, se esperaban %d", g.Triangulos, triangulos)
+	}
+	if !casiIgual(g.Min.X, min.X) || !casiIgual(g.Min.Y, min.Y) || !casiIgual(g.Min.Z, min.Z) ||
+		!casiIgual(g.Max.X, max.X) || !casiIgual(g.Max.Y, max.Y) || !casiIgual(g.Max.Z, max.Z) {
+		t.Errorf("caja envolvente %v-%v, se esperaba %v-%v", g.Min, g.Max, min, max)
+	}
+	if !casiIgual(g.Volumen, volumen) {
+		t.Errorf("volumen %g, se esperaba %g", g.Volumen, volumen)
+	}
+	if !casiIgual(g.Area, area) {
+		t.Errorf("área %g, se esperaba %g", g.Area, area)
+	}
+}
+
+func TestLeerFormatos(t *testing.T) {
+	cubo10 := cubo(10, Vector{})
+	casos := []struct {
+		nombre  string
+		formato Formato
+		datos   []byte
+	}{
+		{"STL binario", FormatoSTL, stlBinario(cubo10)},
+		{"STL ASCII", FormatoSTL, stlASCII(cubo10)},
+		{"OBJ", FormatoOBJ, objCubo(10)},
+		{"3MF", Formato3MF, paquete3MF(t, "3D/3dmodel.model", modelo3MFCubo("centimeter", "", `<item objectid="1"/>`))},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			formato, err := DetectarFormato("", c.datos)
+			if err != nil || formato != c.formato {
+				t.Fatalf("DetectarFormato = %q, %v; se esperaba %q", formato, err, c.formato)
+			}
+			m, err := Leer(c.formato, c.datos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			comprobarGeometria(t, m.Geometria(), Vector{}, Vector{10, 10, 10}, 12)
+		})
+	}
+}
+
+func TestSTLBinarioQueEmpiezaPorSolid(t *testing.T) {
+	datos := stlBinario(cubo(2, Vector{}))
+	copy(datos, "solid exportado como binario")
+	m, err := LeerSTL(datos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comprobarGeometria(t, m.Geometria(), Vector{}, Vector{2, 2, 2}, 12)
+}
+
+func TestSTLInvalido(t *testing.T) {
+	casos := map[string]string{
+		"sin cabecera":       "no es un stl",
+		"vértice incompleto": "solid x\nfacet normal 0 0 1\nouter loop\nvertex 0 0\nendloop\nendfacet\nendsolid x\n",
+		"coordenada":         "solid x\nfacet normal 0 0 1\nouter loop\nvertex 0 0 a\nendloop\nendfacet\nendsolid x\n",
+		"cuatro vértices":    "solid x\nouter loop\nvertex 0 0 0\nvertex 1 0 0\nvertex 1 1 0\nvertex 0 1 0\nendloop\nendsolid x\n",
+	}
+	for nombre, datos := range casos {
+		if _, err := LeerSTL([]byte(datos)); err == nil {
+			t.Errorf("%s: se esperaba un error", nombre)
+		}
+	}
+	// Un STL ASCII bien formado pero sin caras es una malla vacía
+	if _, err := Leer(FormatoSTL, []byte("solid vacio\nendsolid vacio\n")); !errors.Is(err, ErrMallaVacia) {
+		t.Errorf("STL sin caras: %v", err)
+	}
+}
+
+func TestOBJ(t *testing.T) {
+	// Un pentágono se triangula en abanico en tres triángulos
+	pentagono := "v 0 0 0\nv 2 0 0\nv 3 1 0\nv 1 2 0\nv -1 1 0\nf 1 2 3 4 5\n"
+	m, err := LeerOBJ([]byte(pentagono))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Triangulos) != 3 {
+		t.Errorf("%d triángulos, se esperaban 3", len(m.Triangulos))
+	}
+
+	invalidos := map[string]string{
+		"índice fuera de rango": "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 4\n",
+		"índice negativo":       "v 0 0 0\nv 1 0 0\nv 0 1 0\nf -1 -2 -4\n",
+		"índice cero":           "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 0 1 2\n",
+		"índice no numérico":    "v 0 0 0\nv 1 0 0\nv 0 1 0\nf a 1 2\n",
+		"cara de dos vértices":  "v 0 0 0\nv 1 0 0\nf 1 2\n",
+		"vértice incompleto":    "v 0 0\n",
+	}
+	for nombre, datos := range invalidos {
+		if _, err := LeerOBJ([]byte(datos)); err == nil {
+			t.Errorf("%s: se esperaba un error", nombre)
+		}
+	}
+}
+
+func Test3MF(t *testing.T) {
+	// El elemento se traslada 5 unidades en X y se escala a milímetros
+	datos := paquete3MF(t, "3D/3dmodel.model", modelo3MFCubo("inch", "", `<item objectid="1" transform="1 0 0 0 1 0 0 0 1 5 0 0"/>`))
+	m, err := Leer3MF(datos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comprobarGeometria(t, m.Geometria(), Vector{5 * 25.4, 0, 0}, Vector{6 * 25.4, 25.4, 25.4}, 12)
+
+	// Un objeto compuesto por dos cubos, uno escalado al doble y desplazado
+	compuesto := `<object id="2" type="model"><components>
+		<component objectid="1"/>
+		<component objectid="1" transform="2 0 0 0 2 0 0 0 2 0 0 3"/>
+	</components></object>`
+	datos = paquete3MF(t, "3D/otro.model", modelo3MFCubo("millimeter", compuesto, `<item objectid="2"/>`))
+	if m, err = Leer3MF(datos); err != nil {
+		t.Fatal(err)
+	}
+	g := m.Geometria()
+	if g.Triangulos != 24 || !casiIgual(g.Volumen, 1+8) || g.Max != (Vector{2, 2, 5}) {
+		t.Errorf("objeto compuesto: %+v", g)
+	}
+
+	invalidos := map[string][]byte{
+		"no es zip":             []byte("PK\x03\x04 roto"),
+		"sin modelo":            paquete3MF(t, "Metadata/thumbnail.png", "png"),
+		"unidad desconocida":    paquete3MF(t, "3D/3dmodel.model", modelo3MFCubo("parsec", "", `<item objectid="1"/>`)),
+		"objeto inexistente":    paquete3MF(t, "3D/3dmodel.model", modelo3MFCubo("", "", `<item objectid="9"/>`)),
+		"transformación":        paquete3MF(t, "3D/3dmodel.model", modelo3MFCubo("", "", `<item objectid="1" transform="1 0 0"/>`)),
+		"componentes cíclicos":  paquete3MF(t, "3D/3dmodel.model", modelo3MFCubo("", `<object id="3"><components><component objectid="3"/></components></object>`, `<item objectid="3"/>`)),
+		"índice fuera de rango": paquete3MF(t, "3D/3dmodel.model", strings.Replace(modelo3MFCubo("", "", `<item objectid="1"/>`), `v3="3"`, `v3="8"`, 1)),
+		"XML mal formado":       paquete3MF(t, "3D/3dmodel.model", "<model><resources>"),
+	}
+	for nombre, datos := range invalidos {
+		if _, err := Leer3MF(datos); err == nil {
+			t.Errorf("%s: se esperaba un error", nombre)
+		}
+	}
+}
+
+func TestDetectarFormato(t *testing.T) {
+	casos := []struct {
+		nombre string
+		datos  string
+		want   Formato
+	}{
+		{"pieza.STL", "", FormatoSTL},
+		{"pieza.obj", "", FormatoOBJ},
+		{"pieza.3mf", "", Formato3MF},
+		{"", "PK\x03\x04resto", Formato3MF},
+		{"", "  solid pieza\n", FormatoSTL},
+		{"", "# comentario\nv 0 0 0\n", FormatoOBJ},
+	}
+	for _, c := range casos {
+		got, err := DetectarFormato(c.nombre, []byte(c.datos))
+		if err != nil || got != c.want {
+			t.Errorf("DetectarFormato(%q, %q) = %q, %v; se esperaba %q", c.nombre, c.datos, got, err, c.want)
+		}
+	}
+	if _, err := DetectarFormato("pieza.step", []byte("ISO-10303-21;")); !errors.Is(err, ErrFormatoDesconocido) {
+		t.Errorf("formato desconocido: %v", err)
+	}
+	if _, err := Leer("step", nil); !errors.Is(err, ErrFormatoDesconocido) {
+		t.Errorf("Leer con formato desconocido: %v", err)
+	}
+}
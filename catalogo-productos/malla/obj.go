@@ -0,0 +1,75 @@
+package malla
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LeerOBJ interpreta un archivo Wavefront OBJ. Solo se usan los vértices
+// (v) y las caras (f); las caras con más de tres vértices se triangulan en
+// abanico. Se admiten índices negativos, relativos al último vértice leído
+func LeerOBJ(datos []byte) (*Malla, error) {
+	m := &Malla{}
+	var (
+		vertices []Vector
+		linea    int
+	)
+	sc := bufio.NewScanner(bytes.NewReader(datos))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		linea++
+		campos := strings.Fields(sc.Text())
+		if len(campos) == 0 {
+			continue
+		}
+		switch campos[0] {
+		case "v":
+			if len(campos) < 4 {
+				return nil, fmt.Errorf("obj: línea %d: vértice mal formado", linea)
+			}
+			v, err := parseVector(campos[1:4])
+			if err != nil {
+				return nil, fmt.Errorf("obj: línea %d: %w", linea, err)
+			}
+			vertices = append(vertices, v)
+		case "f":
+			if len(campos) < 4 {
+				return nil, fmt.Errorf("obj: línea %d: una cara necesita al menos 3 vértices", linea)
+			}
+			cara := make([]Vector, 0, len(campos)-1)
+			for _, ref := range campos[1:] {
+				v, err := verticeOBJ(ref, vertices)
+				if err != nil {
+					return nil, fmt.Errorf("obj: línea %d: %w", linea, err)
+				}
+				cara = append(cara, v)
+			}
+			for i := 1; i+1 < len(cara); i++ {
+				m.Triangulos = append(m.Triangulos, Triangulo{cara[0], cara[i], cara[i+1]})
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("obj: %w", err)
+	}
+	return m, nil
+}
+
+// verticeOBJ resuelve una referencia de cara del tipo v, v/vt, v//vn o v/vt/vn
+func verticeOBJ(ref string, vertices []Vector) (Vector, error) {
+	indice, _, _ := strings.Cut(ref, "/")
+	i, err := strconv.Atoi(indice)
+	if err != nil {
+		return Vector{}, fmt.Errorf("índice de vértice inválido %q", ref)
+	}
+	if i < 0 {
+		i = len(vertices) + i + 1
+	}
+	if i < 1 || i > len(vertices) {
+		return Vector{}, fmt.Errorf("índice de vértice fuera de rango %q", ref)
+	}
+	return vertices[i-1], nil
+}
@@ -0,0 +1,159 @@
+package malla
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+const (
+	// superMuestreo es el número de muestras por lado de cada píxel; se
+	// renderiza a mayor resolución y se promedia para suavizar los bordes
+	superMuestreo = 2
+	// margenVista es la fracción del lado menor de la imagen que ocupa la
+	// esfera que envuelve el modelo
+	margenVista = 0.9
+	luzAmbiente = 0.25
+)
+
+// Vista describe la cámara y el aspecto de un render. La cámara es
+// ortográfica y mira al centro del modelo; Azimut se mide en grados desde el
+// eje X alrededor de Z y Elevacion en grados sobre el plano XY
+type Vista struct {
+	Ancho     int
+	Alto      int
+	Azimut    float64
+	Elevacion float64
+	Color     color.RGBA
+	// Fondo puede ser transparente
+	Fondo color.RGBA
+}
+
+// Renderizar dibuja la malla con un rasterizador por software: z-buffer,
+// sombreado plano por cara iluminado desde la cámara y suavizado por
+// supermuestreo. Las caras se iluminan por ambos lados para que las normales
+// invertidas no dejen huecos en la imagen
+func (m *Malla) Renderizar(v Vista) *image.RGBA {
+	ancho, alto := v.Ancho*superMuestreo, v.Alto*superMuestreo
+	lienzo := image.NewRGBA(image.Rect(0, 0, ancho, alto))
+	for i := 0; i < len(lienzo.Pix); i += 4 {
+		lienzo.Pix[i], lienzo.Pix[i+1], lienzo.Pix[i+2], lienzo.Pix[i+3] = v.Fondo.R, v.Fondo.G, v.Fondo.B, v.Fondo.A
+	}
+
+	inf, sup := m.Limites()
+	centro := inf.Add(sup).Scale(0.5)
+	radio := sup.Sub(inf).Len() / 2
+	if radio == 0 {
+		return reducir(lienzo, v.Ancho, v.Alto)
+	}
+
+	// Base de la cámara: hacia apunta del modelo a la cámara
+	az, el := v.Azimut*math.Pi/180, v.Elevacion*math.Pi/180
+	hacia := Vector{math.Cos(el) * math.Cos(az), math.Cos(el) * math.Sin(az), math.Sin(el)}
+	derecha := Vector{-math.Sin(az), math.Cos(az), 0}
+	arriba := hacia.Cross(derecha)
+	luz := normalizar(hacia.Add(arriba.Scale(0.5)).Sub(derecha.Scale(0.3)))
+
+	escala := margenVista * float64(min(ancho, alto)) / 2 / radio
+	proyectar := func(p Vector) Vector {
+		d := p.Sub(centro)
+		return Vector{
+			float64(ancho)/2 + d.Dot(derecha)*escala,
+			float64(alto)/2 - d.Dot(arriba)*escala,
+			d.Dot(hacia),
+		}
+	}
+
+	profundidad := make([]float64, ancho*alto)
+	for i := range profundidad {
+		profundidad[i] = math.Inf(-1)
+	}
+
+	for _, t := range m.Triangulos {
+		n := t.Normal()
+		if n.Len() == 0 {
+			continue
+		}
+		intensidad := luzAmbiente + (1-luzAmbiente)*math.Abs(normalizar(n).Dot(luz))
+		c := color.RGBA{
+			R: uint8(float64(v.Color.R) * intensidad),
+			G: uint8(float64(v.Color.G) * intensidad),
+			B: uint8(float64(v.Color.B) * intensidad),
+			A: 255,
+		}
+		rasterizar(lienzo, profundidad, proyectar(t[0]), proyectar(t[1]), proyectar(t[2]), c)
+	}
+	return reducir(lienzo, v.Ancho, v.Alto)
+}
+
+// rasterizar rellena el triángulo proyectado a, b, c con funciones de arista,
+// conservando en cada píxel la cara más cercana a la cámara
+func rasterizar(img *image.RGBA, profundidad []float64, a, b, c Vector, col color.RGBA) {
+	area := arista2D(a, b, c)
+	if area == 0 {
+		return
+	}
+	ancho, alto := img.Rect.Dx(), img.Rect.Dy()
+	x0 := max(int(math.Floor(math.Min(a.X, math.Min(b.X, c.X)))), 0)
+	x1 := min(int(math.Ceil(math.Max(a.X, math.Max(b.X, c.X)))), ancho-1)
+	y0 := max(int(math.Floor(math.Min(a.Y, math.Min(b.Y, c.Y)))), 0)
+	y1 := min(int(math.Ceil(math.Max(a.Y, math.Max(b.Y, c.Y)))), alto-1)
+
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			p := Vector{float64(x) + 0.5, float64(y) + 0.5, 0}
+			w0 := arista2D(b, c, p) / area
+			w1 := arista2D(c, a, p) / area
+			w2 := arista2D(a, b, p) / area
+			if w0 < 0 || w1 < 0 || w2 < 0 {
+				continue
+			}
+			z := w0*a.Z + w1*b.Z + w2*c.Z
+			i := y*ancho + x
+			if z <= profundidad[i] {
+				continue
+			}
+			profundidad[i] = z
+			o := i * 4
+			img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3] = col.R, col.G, col.B, col.A
+		}
+	}
+}
+
+// arista2D es el doble del área con signo del triángulo a, b, p en pantalla
+func arista2D(a, b, p Vector) float64 {
+	return (b.X-a.X)*(p.Y-a.Y) - (b.Y-a.Y)*(p.X-a.X)
+}
+
+// reducir promedia bloques de superMuestreo×superMuestreo píxeles. Los
+// colores de image.RGBA están premultiplicados, así que el promedio es
+// correcto también en los bordes con fondo transparente
+func reducir(src *image.RGBA, ancho, alto int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, ancho, alto))
+	const muestras = superMuestreo * superMuestreo
+	for y := 0; y < alto; y++ {
+		for x := 0; x < ancho; x++ {
+			var suma [4]int
+			for dy := 0; dy < superMuestreo; dy++ {
+				for dx := 0; dx < superMuestreo; dx++ {
+					o := src.PixOffset(x*superMuestreo+dx, y*superMuestreo+dy)
+					for k := range suma {
+						suma[k] += int(src.Pix[o+k])
+					}
+				}
+			}
+			o := dst.PixOffset(x, y)
+			for k := range suma {
+				dst.Pix[o+k] = uint8(suma[k] / muestras)
+			}
+		}
+	}
+	return dst
+}
+
+func normalizar(v Vector) Vector {
+	if l := v.Len(); l > 0 {
+		return v.Scale(1 / l)
+	}
+	return v
+}
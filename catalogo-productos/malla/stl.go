@@ -0,0 +1,110 @@
+package malla
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	tamanoCabeceraSTL  = 80
+	tamanoTrianguloSTL = 50 // normal, tres vértices y dos bytes de atributos
+)
+
+// esSTLBinario comprueba si el tamaño de los datos coincide con el número de
+// triángulos declarado en la cabecera binaria. Algunos exportadores escriben
+// "solid" al comienzo de archivos binarios, así que el prefijo no basta
+func esSTLBinario(datos []byte) bool {
+	if len(datos) < tamanoCabeceraSTL+4 {
+		return false
+	}
+	n := binary.LittleEndian.Uint32(datos[tamanoCabeceraSTL:])
+	return uint64(len(datos)) == uint64(tamanoCabeceraSTL+4)+uint64(n)*tamanoTrianguloSTL
+}
+
+// LeerSTL interpreta un archivo STL binario o ASCII
+func LeerSTL(datos []byte) (*Malla, error) {
+	if esSTLBinario(datos) {
+		return leerSTLBinario(datos), nil
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(datos), []byte("solid")) {
+		return leerSTLASCII(datos)
+	}
+	return nil, fmt.Errorf("stl: el archivo no es un STL binario ni ASCII válido")
+}
+
+func leerSTLBinario(datos []byte) *Malla {
+	n := int(binary.LittleEndian.Uint32(datos[tamanoCabeceraSTL:]))
+	m := &Malla{Triangulos: make([]Triangulo, 0, n)}
+	off := tamanoCabeceraSTL + 4
+	for i := 0; i < n; i++ {
+		var t Triangulo
+		// Se ignora la normal declarada y se recalcula a partir de los vértices
+		p := off + 12
+		for v := 0; v < 3; v++ {
+			t[v] = Vector{
+				float64(math.Float32frombits(binary.LittleEndian.Uint32(datos[p:]))),
+				float64(math.Float32frombits(binary.LittleEndian.Uint32(datos[p+4:]))),
+				float64(math.Float32frombits(binary.LittleEndian.Uint32(datos[p+8:]))),
+			}
+			p += 12
+		}
+		m.Triangulos = append(m.Triangulos, t)
+		off += tamanoTrianguloSTL
+	}
+	return m
+}
+
+func leerSTLASCII(datos []byte) (*Malla, error) {
+	m := &Malla{}
+	var (
+		vertices []Vector
+		linea    int
+	)
+	sc := bufio.NewScanner(bytes.NewReader(datos))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		linea++
+		campos := strings.Fields(sc.Text())
+		if len(campos) == 0 {
+			continue
+		}
+		switch campos[0] {
+		case "vertex":
+			if len(campos) != 4 {
+				return nil, fmt.Errorf("stl: línea %d: vértice mal formado", linea)
+			}
+			v, err := parseVector(campos[1:])
+			if err != nil {
+				return nil, fmt.Errorf("stl: línea %d: %w", linea, err)
+			}
+			vertices = append(vertices, v)
+		case "endloop":
+			if len(vertices) != 3 {
+				return nil, fmt.Errorf("stl: línea %d: la cara tiene %d vértices, se esperaban 3", linea, len(vertices))
+			}
+			m.Triangulos = append(m.Triangulos, Triangulo{vertices[0], vertices[1], vertices[2]})
+			vertices = vertices[:0]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("stl: %w", err)
+	}
+	return m, nil
+}
+
+func parseVector(campos []string) (Vector, error) {
+	var c [3]float64
+	for i := range c {
+		f, err := strconv.ParseFloat(campos[i], 64)
+		if err != nil {
+			return Vector{}, fmt.Errorf("coordenada inválida %q", campos[i])
+		}
+		c[i] = f
+	}
+	return Vector{c[0], c[1], c[2]}, nil
+}
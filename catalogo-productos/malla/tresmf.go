@@ -0,0 +1,202 @@
+package malla
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	rutaModelo3MF     = "3D/3dmodel.model"
+	tamanoMaximo3MF   = 512 << 20 // límite del modelo descomprimido
+	profundidadMaxima = 16        // niveles de componentes anidados
+)
+
+// escalas3MF convierte cada unidad de 3MF a milímetros
+var escalas3MF = map[string]float64{
+	"":           1,
+	"micron":     0.001,
+	"millimeter": 1,
+	"centimeter": 10,
+	"inch":       25.4,
+	"foot":       304.8,
+	"meter":      1000,
+}
+
+type modelo3MF struct {
+	Unidad  string      `xml:"unit,attr"`
+	Objetos []objeto3MF `xml:"resources>object"`
+	Items   []item3MF   `xml:"build>item"`
+}
+
+type objeto3MF struct {
+	ID          int            `xml:"id,attr"`
+	Vertices    []vertice3MF   `xml:"mesh>vertices>vertex"`
+	Triangulos  []triangulo3MF `xml:"mesh>triangles>triangle"`
+	Componentes []item3MF      `xml:"components>component"`
+}
+
+type vertice3MF struct {
+	X float64 `xml:"x,attr"`
+	Y float64 `xml:"y,attr"`
+	Z float64 `xml:"z,attr"`
+}
+
+type triangulo3MF struct {
+	V1 int `xml:"v1,attr"`
+	V2 int `xml:"v2,attr"`
+	V3 int `xml:"v3,attr"`
+}
+
+type item3MF struct {
+	ObjetoID       int    `xml:"objectid,attr"`
+	Transformacion string `xml:"transform,attr"`
+}
+
+// matriz3MF es una transformación afín 3x4 en el orden de la especificación:
+// m00 m01 m02 m10 m11 m12 m20 m21 m22 m30 m31 m32, aplicada a vectores fila
+type matriz3MF [12]float64
+
+var identidad3MF = matriz3MF{1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0}
+
+func parseMatriz3MF(s string) (matriz3MF, error) {
+	if strings.TrimSpace(s) == "" {
+		return identidad3MF, nil
+	}
+	campos := strings.Fields(s)
+	if len(campos) != 12 {
+		return matriz3MF{}, fmt.Errorf("3mf: transformación con %d valores, se esperaban 12", len(campos))
+	}
+	var m matriz3MF
+	for i, c := range campos {
+		f, err := strconv.ParseFloat(c, 64)
+		if err != nil {
+			return matriz3MF{}, fmt.Errorf("3mf: transformación inválida %q", s)
+		}
+		m[i] = f
+	}
+	return m, nil
+}
+
+func (m matriz3MF) aplicar(v Vector) Vector {
+	return Vector{
+		v.X*m[0] + v.Y*m[3] + v.Z*m[6] + m[9],
+		v.X*m[1] + v.Y*m[4] + v.Z*m[7] + m[10],
+		v.X*m[2] + v.Y*m[5] + v.Z*m[8] + m[11],
+	}
+}
+
+// componer devuelve la transformación que aplica primero m y después o
+func (m matriz3MF) componer(o matriz3MF) matriz3MF {
+	var r matriz3MF
+	for fila := 0; fila < 4; fila++ {
+		for col := 0; col < 3; col++ {
+			var s float64
+			for k := 0; k < 3; k++ {
+				s += m[fila*3+k] * o[k*3+col]
+			}
+			if fila == 3 {
+				s += o[9+col]
+			}
+			r[fila*3+col] = s
+		}
+	}
+	return r
+}
+
+// Leer3MF interpreta un paquete 3MF. Se leen las mallas de los objetos
+// referenciados en build, aplicando las transformaciones de los elementos y
+// de sus componentes, y se convierten las coordenadas a milímetros
+func Leer3MF(datos []byte) (*Malla, error) {
+	zr, err := zip.NewReader(bytes.NewReader(datos), int64(len(datos)))
+	if err != nil {
+		return nil, fmt.Errorf("3mf: el archivo no es un paquete zip válido: %w", err)
+	}
+
+	var archivo *zip.File
+	for _, f := range zr.File {
+		if strings.EqualFold(f.Name, rutaModelo3MF) {
+			archivo = f
+			break
+		}
+		if archivo == nil && strings.EqualFold(path.Ext(f.Name), ".model") {
+			archivo = f
+		}
+	}
+	if archivo == nil {
+		return nil, fmt.Errorf("3mf: el paquete no contiene %s", rutaModelo3MF)
+	}
+
+	rc, err := archivo.Open()
+	if err != nil {
+		return nil, fmt.Errorf("3mf: %w", err)
+	}
+	defer rc.Close()
+
+	var mod modelo3MF
+	if err := xml.NewDecoder(io.LimitReader(rc, tamanoMaximo3MF)).Decode(&mod); err != nil {
+		return nil, fmt.Errorf("3mf: modelo mal formado: %w", err)
+	}
+
+	escala, ok := escalas3MF[mod.Unidad]
+	if !ok {
+		return nil, fmt.Errorf("3mf: unidad desconocida %q", mod.Unidad)
+	}
+	objetos := make(map[int]*objeto3MF, len(mod.Objetos))
+	for i := range mod.Objetos {
+		objetos[mod.Objetos[i].ID] = &mod.Objetos[i]
+	}
+
+	m := &Malla{}
+	base := matriz3MF{escala, 0, 0, 0, escala, 0, 0, 0, escala, 0, 0, 0}
+	for _, item := range mod.Items {
+		t, err := parseMatriz3MF(item.Transformacion)
+		if err != nil {
+			return nil, err
+		}
+		// La transformación del elemento se aplica en unidades del modelo y
+		// después se escala a milímetros
+		if err := agregarObjeto3MF(m, objetos, item.ObjetoID, t.componer(base), 0); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func agregarObjeto3MF(m *Malla, objetos map[int]*objeto3MF, id int, t matriz3MF, profundidad int) error {
+	if profundidad > profundidadMaxima {
+		return fmt.Errorf("3mf: demasiados niveles de componentes anidados")
+	}
+	obj, ok := objetos[id]
+	if !ok {
+		return fmt.Errorf("3mf: referencia a objeto inexistente %d", id)
+	}
+
+	for _, tri := range obj.Triangulos {
+		var nuevo Triangulo
+		for i, idx := range [3]int{tri.V1, tri.V2, tri.V3} {
+			if idx < 0 || idx >= len(obj.Vertices) {
+				return fmt.Errorf("3mf: objeto %d: índice de vértice fuera de rango %d", id, idx)
+			}
+			v := obj.Vertices[idx]
+			nuevo[i] = t.aplicar(Vector{v.X, v.Y, v.Z})
+		}
+		m.Triangulos = append(m.Triangulos, nuevo)
+	}
+
+	for _, comp := range obj.Componentes {
+		ct, err := parseMatriz3MF(comp.Transformacion)
+		if err != nil {
+			return err
+		}
+		if err := agregarObjeto3MF(m, objetos, comp.ObjetoID, ct.componer(t), profundidad+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,883 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"catalogo-comun/idempotencia"
+	"catalogo-comun/listado"
+	dominio "catalogo-dominio"
+	"catalogo-dominio/dinero"
+
+	"gorm.io/gorm"
+)
+
+// repositorioMemoria guarda los productos y las categorías en memoria. Se usa en pruebas y en
+// desarrollo local cuando no hay una base de datos disponible.
+//
+// Es seguro para uso concurrente: las lecturas toman el candado de lectura y
+// las escrituras el de escritura. Los productos se indexan por ID y por
+// categoría y por SKU; orden conserva el orden de inserción para que los listados sean
+// estables. Los productos eliminados pasan a papelera, fuera de los índices,
+// y conservan su historial y su modelo hasta que se purgan.
+type repositorioMemoria struct {
+	mu           sync.RWMutex
+	porID        map[string]Producto
+	porCategoria map[string][]string
+	porSKU       map[string]string
+	orden        []string
+	historial    map[string][]TransicionEstado
+	revisiones   map[string][]Revision
+	modelos      map[string]ModeloProducto
+	categorias   map[string]Categoria
+	papelera     map[string]Producto
+	tasas        []dinero.TasaCambio
+	atributos    map[string]DefinicionAtributo
+	relaciones   []Relacion
+	// adjuntos guarda los adjuntos de cada producto en el orden de la galería
+	adjuntos map[string][]Adjunto
+	*idempotencia.Memoria
+}
+
+func newRepositorioMemoria(iniciales ...Producto) *repositorioMemoria {
+	r := &repositorioMemoria{
+		porID:        make(map[string]Producto),
+		porCategoria: make(map[string][]string),
+		porSKU:       make(map[string]string),
+		historial:    make(map[string][]TransicionEstado),
+		revisiones:   make(map[string][]Revision),
+		modelos:      make(map[string]ModeloProducto),
+		categorias:   make(map[string]Categoria),
+		papelera:     make(map[string]Producto),
+		atributos:    make(map[string]DefinicionAtributo),
+		adjuntos:     make(map[string][]Adjunto),
+		Memoria:      idempotencia.NuevaMemoria(),
+	}
+	for _, p := range iniciales {
+		r.insertar(p)
+		r.asegurarRevisionBase(p)
+	}
+	return r
+}
+
+func (r *repositorioMemoria) Listar(ctx context.Context, consulta ConsultaProductos) ([]Producto, listado.Resultado, error) {
+	r.mu.RLock()
+	ids := r.orden
+	if len(consulta.Categorias) > 0 {
+		ids = nil
+		for _, cat := range consulta.Categorias {
+			ids = append(ids, r.porCategoria[cat]...)
+		}
+	}
+	productos := make([]Producto, 0, len(ids))
+	for _, id := range ids {
+		if p := r.porID[id]; consulta.cumple(p) {
+			productos = append(productos, p)
+		}
+	}
+	r.mu.RUnlock()
+
+	pagina, res := listado.EnMemoria(productos, consulta.Orden, consulta.Ventana, ordenablesProductos)
+	return pagina, res, nil
+}
+
+func (r *repositorioMemoria) Obtener(ctx context.Context, id string) (Producto, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.porID[id]
+	if !ok {
+		return Producto{}, ErrProductoNoEncontrado
+	}
+	return p, nil
+}
+
+func (r *repositorioMemoria) Crear(ctx context.Context, producto *Producto) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.crear(ctx, producto)
+}
+
+// crear agrega un producto nuevo; el llamador debe tener el candado de escritura
+func (r *repositorioMemoria) crear(ctx context.Context, producto *Producto) error {
+	if err := r.comprobarReferencias(*producto); err != nil {
+		return err
+	}
+	producto.Version = 1
+	producto.EliminadoEn = gorm.DeletedAt{}
+	revision, err := nuevaRevision(ctx, nil, *producto)
+	if err != nil {
+		return err
+	}
+	r.insertar(*producto)
+	r.registrarTransicion(producto.ID, "", producto.Estado)
+	r.revisiones[producto.ID] = append(r.revisiones[producto.ID], revision)
+	return nil
+}
+
+func (r *repositorioMemoria) Actualizar(ctx context.Context, producto *Producto, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.actualizar(ctx, producto, version)
+}
+
+// actualizar reemplaza un producto; el llamador debe tener el candado de escritura
+func (r *repositorioMemoria) actualizar(ctx context.Context, producto *Producto, version int) error {
+	anterior, ok := r.porID[producto.ID]
+	if !ok {
+		return ErrProductoNoEncontrado
+	}
+	if version != 0 && anterior.Version != version {
+		return ErrConflictoVersion
+	}
+	if err := r.comprobarReferencias(*producto); err != nil {
+		return err
+	}
+	r.asegurarRevisionBase(anterior)
+	producto.Version = anterior.Version + 1
+	producto.EliminadoEn = gorm.DeletedAt{}
+	revision, err := nuevaRevision(ctx, &anterior, *producto)
+	if err != nil {
+		return err
+	}
+	r.porID[producto.ID] = *producto
+	r.revisiones[producto.ID] = append(r.revisiones[producto.ID], revision)
+	if anterior.CategoriaID != producto.CategoriaID || anterior.SKU != producto.SKU {
+		r.desindexar(anterior)
+		r.indexar(*producto)
+	}
+	if anterior.Estado != producto.Estado {
+		r.registrarTransicion(producto.ID, anterior.Estado, producto.Estado)
+	}
+	return nil
+}
+
+func (r *repositorioMemoria) GuardarModelo(ctx context.Context, producto *Producto, modelo *ModeloProducto, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.actualizar(ctx, producto, version); err != nil {
+		return err
+	}
+	r.modelos[producto.ID] = *modelo
+	return nil
+}
+
+func (r *repositorioMemoria) ObtenerModelo(ctx context.Context, id string) (ModeloProducto, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	modelo, ok := r.modelos[id]
+	if !ok {
+		return ModeloProducto{}, ErrModeloNoEncontrado
+	}
+	return modelo, nil
+}
+
+func (r *repositorioMemoria) Eliminar(ctx context.Context, id string, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.eliminar(id, version)
+}
+
+// eliminar pasa un producto a la papelera; el llamador debe tener el candado
+// de escritura
+func (r *repositorioMemoria) eliminar(id string, version int) error {
+	p, ok := r.porID[id]
+	if !ok {
+		return ErrProductoNoEncontrado
+	}
+	if version != 0 && p.Version != version {
+		return ErrConflictoVersion
+	}
+	// Los kits de la papelera también cuentan: se pueden restaurar
+	enKit := ConsultaProductos{Componente: id}
+	for _, productos := range []map[string]Producto{r.porID, r.papelera} {
+		for _, kit := range productos {
+			if enKit.cumple(kit) {
+				return ErrProductoEnKit
+			}
+		}
+	}
+	r.desindexar(p)
+	delete(r.porID, id)
+	r.orden = quitarID(r.orden, id)
+	p.EliminadoEn = gorm.DeletedAt{Time: time.Now().UTC(), Valid: true}
+	r.papelera[id] = p
+	return nil
+}
+
+func (r *repositorioMemoria) ListarPapelera(ctx context.Context, consulta ConsultaProductos) ([]Producto, listado.Resultado, error) {
+	r.mu.RLock()
+	productos := make([]Producto, 0, len(r.papelera))
+	for _, p := range r.papelera {
+		if (len(consulta.Categorias) == 0 || slices.Contains(consulta.Categorias, p.CategoriaID)) && consulta.cumple(p) {
+			productos = append(productos, p)
+		}
+	}
+	r.mu.RUnlock()
+
+	pagina, res := listado.EnMemoria(productos, consulta.Orden, consulta.Ventana, ordenablesProductos)
+	return pagina, res, nil
+}
+
+func (r *repositorioMemoria) Restaurar(ctx context.Context, id string) (Producto, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.papelera[id]
+	if !ok {
+		return Producto{}, ErrProductoNoEncontrado
+	}
+	if err := r.comprobarReferencias(p); err != nil {
+		return Producto{}, err
+	}
+	r.asegurarRevisionBase(p)
+	p.EliminadoEn = gorm.DeletedAt{}
+	p.Version++
+	// Restaurar no cambia el contenido, pero la revisión deja constancia de quién lo hizo
+	revision, err := nuevaRevision(ctx, &p, p)
+	if err != nil {
+		return Producto{}, err
+	}
+	delete(r.papelera, id)
+	r.insertar(p)
+	r.revisiones[id] = append(r.revisiones[id], revision)
+	return p, nil
+}
+
+func (r *repositorioMemoria) Purgar(ctx context.Context, limite time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for id, p := range r.papelera {
+		if p.EliminadoEn.Time.Before(limite) {
+			delete(r.papelera, id)
+			delete(r.historial, id)
+			delete(r.revisiones, id)
+			delete(r.modelos, id)
+			delete(r.adjuntos, id)
+			r.relaciones = slices.DeleteFunc(r.relaciones, func(rel Relacion) bool {
+				return rel.ProductoID == id || rel.DestinoID == id
+			})
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *repositorioMemoria) Programados(ctx context.Context, hasta time.Time) ([]Producto, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var productos []Producto
+	for _, id := range r.orden {
+		p := r.porID[id]
+		publicar := p.Estado == dominio.EstadoBorrador && p.PublicarEn != nil && !p.PublicarEn.After(hasta)
+		if publicar || p.PrecioDesde != nil && !p.PrecioDesde.After(hasta) {
+			productos = append(productos, p)
+		}
+	}
+	return productos, nil
+}
+
+func (r *repositorioMemoria) Historial(ctx context.Context, id string) ([]TransicionEstado, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.porID[id]; !ok {
+		return nil, ErrProductoNoEncontrado
+	}
+	return append([]TransicionEstado{}, r.historial[id]...), nil
+}
+
+func (r *repositorioMemoria) Revisiones(ctx context.Context, id string) ([]Revision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.porID[id]; !ok {
+		return nil, ErrProductoNoEncontrado
+	}
+	return append([]Revision{}, r.revisiones[id]...), nil
+}
+
+func (r *repositorioMemoria) Revision(ctx context.Context, id string, numero int) (Revision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.porID[id]; !ok {
+		return Revision{}, ErrProductoNoEncontrado
+	}
+	for _, rev := range r.revisiones[id] {
+		if rev.Numero == numero {
+			return rev, nil
+		}
+	}
+	return Revision{}, ErrRevisionNoEncontrada
+}
+
+func (r *repositorioMemoria) BuscarPorSKU(ctx context.Context, skus []string) (map[string]Producto, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	encontrados := make(map[string]Producto)
+	for _, sku := range skus {
+		if id, ok := r.porSKU[sku]; ok {
+			encontrados[sku] = r.porID[id]
+		}
+	}
+	return encontrados, nil
+}
+
+func (r *repositorioMemoria) BuscarPorID(ctx context.Context, ids []string) (map[string]Producto, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	encontrados := make(map[string]Producto)
+	for _, id := range ids {
+		if p, ok := r.porID[id]; ok {
+			encontrados[id] = p
+		}
+	}
+	return encontrados, nil
+}
+
+func (r *repositorioMemoria) Importar(ctx context.Context, crear []*Producto, actualizar []ActualizacionProducto) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Se comprueba todo el lote antes de escribir para que sea atómico
+	skus := make(map[string]bool)
+	for _, p := range crear {
+		if err := r.comprobarReferencias(*p); err != nil {
+			return err
+		}
+		if p.SKU != "" && skus[p.SKU] {
+			return errSKUEnUso()
+		}
+		skus[p.SKU] = true
+	}
+	for _, a := range actualizar {
+		anterior, ok := r.porID[a.Producto.ID]
+		if !ok {
+			return ErrProductoNoEncontrado
+		}
+		if a.Version != 0 && anterior.Version != a.Version {
+			return ErrConflictoVersion
+		}
+		if err := r.comprobarReferencias(*a.Producto); err != nil {
+			return err
+		}
+	}
+
+	// Las actualizaciones vuelven a comprobar las referencias con las altas ya
+	// hechas y pueden fallar; en ese caso se vuelve al estado de antes
+	restaurar := r.instantanea()
+	for _, p := range crear {
+		p.Version = 1
+		p.EliminadoEn = gorm.DeletedAt{}
+		revision, err := nuevaRevision(ctx, nil, *p)
+		if err != nil {
+			restaurar()
+			return err
+		}
+		r.insertar(*p)
+		r.registrarTransicion(p.ID, "", p.Estado)
+		r.revisiones[p.ID] = append(r.revisiones[p.ID], revision)
+	}
+	for _, a := range actualizar {
+		if err := r.actualizar(ctx, a.Producto, a.Version); err != nil {
+			restaurar()
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *repositorioMemoria) AplicarLote(ctx context.Context, ops []OperacionLote) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Las operaciones se aplican una a una, cada una viendo las anteriores;
+	// si una falla se vuelve al estado de antes del lote
+	restaurar := r.instantanea()
+	for i, op := range ops {
+		if err := r.aplicarOperacion(ctx, op); err != nil {
+			restaurar()
+			return ErrorOperacion{Indice: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// aplicarOperacion aplica una operación de un lote; el llamador debe tener el
+// candado de escritura
+func (r *repositorioMemoria) aplicarOperacion(ctx context.Context, op OperacionLote) error {
+	switch op.Recurso {
+	case RecursoProductos:
+		switch op.Tipo {
+		case OperacionCrear:
+			return r.crear(ctx, op.Producto)
+		case OperacionActualizar:
+			return r.actualizar(ctx, op.Producto, op.Version)
+		case OperacionEliminar:
+			return r.eliminar(op.ID, op.Version)
+		}
+	case RecursoCategorias:
+		switch op.Tipo {
+		case OperacionCrear:
+			return r.crearCategoria(op.Categoria)
+		case OperacionActualizar:
+			return r.actualizarCategoria(op.Categoria, op.Version)
+		case OperacionEliminar:
+			return r.eliminarCategoria(op.ID, op.Version)
+		}
+	}
+	return fmt.Errorf("operación de lote no soportada: %s %s", op.Tipo, op.Recurso)
+}
+
+// instantanea copia el estado que puede modificar un lote y devuelve la
+// función que lo restaura; el llamador debe tener el candado de escritura.
+// Las listas de porCategoria y orden se copian porque quitarID las modifica
+// en su sitio; al resto de listas solo se les añaden elementos
+func (r *repositorioMemoria) instantanea() func() {
+	porID := maps.Clone(r.porID)
+	porCategoria := make(map[string][]string, len(r.porCategoria))
+	for cat, ids := range r.porCategoria {
+		porCategoria[cat] = slices.Clone(ids)
+	}
+	porSKU := maps.Clone(r.porSKU)
+	orden := slices.Clone(r.orden)
+	historial := maps.Clone(r.historial)
+	revisiones := maps.Clone(r.revisiones)
+	categorias := maps.Clone(r.categorias)
+	papelera := maps.Clone(r.papelera)
+	return func() {
+		r.porID = porID
+		r.porCategoria = porCategoria
+		r.porSKU = porSKU
+		r.orden = orden
+		r.historial = historial
+		r.revisiones = revisiones
+		r.categorias = categorias
+		r.papelera = papelera
+	}
+}
+
+func (r *repositorioMemoria) ListarRelaciones(ctx context.Context, id string) ([]Relacion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.porID[id]; !ok {
+		return nil, ErrProductoNoEncontrado
+	}
+	relaciones := []Relacion{}
+	for _, rel := range r.relaciones {
+		otro := rel.DestinoID
+		if rel.DestinoID == id {
+			otro = rel.ProductoID
+		}
+		if _, activo := r.porID[otro]; activo && (rel.ProductoID == id || rel.DestinoID == id) {
+			relaciones = append(relaciones, rel)
+		}
+	}
+	return relaciones, nil
+}
+
+func (r *repositorioMemoria) CrearRelacion(ctx context.Context, relacion *Relacion) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.porID[relacion.ProductoID]; !ok {
+		return ErrProductoNoEncontrado
+	}
+	if _, ok := r.porID[relacion.DestinoID]; !ok {
+		return dominio.ErroresValidacion{{Campo: "destino_id", Mensaje: "no existe"}}
+	}
+	for _, rel := range r.relaciones {
+		if rel.ProductoID == relacion.ProductoID && rel.Tipo == relacion.Tipo && rel.DestinoID == relacion.DestinoID {
+			return ErrRelacionDuplicada
+		}
+	}
+	r.relaciones = append(r.relaciones, *relacion)
+	return nil
+}
+
+func (r *repositorioMemoria) EliminarRelacion(ctx context.Context, id, relacionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.porID[id]; !ok {
+		return ErrProductoNoEncontrado
+	}
+	i := slices.IndexFunc(r.relaciones, func(rel Relacion) bool {
+		return rel.ID == relacionID && (rel.ProductoID == id || rel.DestinoID == id)
+	})
+	if i < 0 {
+		return ErrRelacionNoEncontrada
+	}
+	r.relaciones = slices.Delete(r.relaciones, i, i+1)
+	return nil
+}
+
+func (r *repositorioMemoria) ListarAdjuntos(ctx context.Context, id string) ([]Adjunto, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.porID[id]; !ok {
+		return nil, ErrProductoNoEncontrado
+	}
+	return append([]Adjunto{}, r.adjuntos[id]...), nil
+}
+
+func (r *repositorioMemoria) ObtenerAdjunto(ctx context.Context, id, adjuntoID string) (Adjunto, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.porID[id]; !ok {
+		return Adjunto{}, ErrProductoNoEncontrado
+	}
+	i := slices.IndexFunc(r.adjuntos[id], func(a Adjunto) bool { return a.ID == adjuntoID })
+	if i < 0 {
+		return Adjunto{}, ErrAdjuntoNoEncontrado
+	}
+	return r.adjuntos[id][i], nil
+}
+
+func (r *repositorioMemoria) CrearAdjunto(ctx context.Context, adjunto *Adjunto) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.porID[adjunto.ProductoID]; !ok {
+		return ErrProductoNoEncontrado
+	}
+	adjuntos := r.adjuntos[adjunto.ProductoID]
+	if slices.ContainsFunc(adjuntos, func(a Adjunto) bool { return a.SHA256 == adjunto.SHA256 }) {
+		return ErrAdjuntoDuplicado
+	}
+	adjunto.Orden = len(adjuntos) + 1
+	r.adjuntos[adjunto.ProductoID] = append(adjuntos, *adjunto)
+	return nil
+}
+
+func (r *repositorioMemoria) EliminarAdjunto(ctx context.Context, id, adjuntoID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.porID[id]; !ok {
+		return ErrProductoNoEncontrado
+	}
+	adjuntos := r.adjuntos[id]
+	i := slices.IndexFunc(adjuntos, func(a Adjunto) bool { return a.ID == adjuntoID })
+	if i < 0 {
+		return ErrAdjuntoNoEncontrado
+	}
+	adjuntos = slices.Delete(adjuntos, i, i+1)
+	for j := i; j < len(adjuntos); j++ {
+		adjuntos[j].Orden = j + 1
+	}
+	r.adjuntos[id] = adjuntos
+	return nil
+}
+
+func (r *repositorioMemoria) OrdenarAdjuntos(ctx context.Context, id string, ids []string) ([]Adjunto, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.porID[id]; !ok {
+		return nil, ErrProductoNoEncontrado
+	}
+	actuales := r.adjuntos[id]
+	if errs := validarOrdenAdjuntos(actuales, ids); len(errs) > 0 {
+		return nil, errs
+	}
+	adjuntos := make([]Adjunto, len(ids))
+	for i, adjuntoID := range ids {
+		j := slices.IndexFunc(actuales, func(a Adjunto) bool { return a.ID == adjuntoID })
+		adjuntos[i] = actuales[j]
+		adjuntos[i].Orden = i + 1
+	}
+	r.adjuntos[id] = adjuntos
+	return append([]Adjunto{}, adjuntos...), nil
+}
+
+func (r *repositorioMemoria) AdjuntoEnUso(ctx context.Context, sha string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, adjuntos := range r.adjuntos {
+		if slices.ContainsFunc(adjuntos, func(a Adjunto) bool { return a.SHA256 == sha }) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *repositorioMemoria) ListarTasas(ctx context.Context) ([]dinero.TasaCambio, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]dinero.TasaCambio{}, r.tasas...), nil
+}
+
+func (r *repositorioMemoria) CrearTasa(ctx context.Context, tasa *dinero.TasaCambio) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.tasas {
+		if t.Desde == tasa.Desde && t.Hacia == tasa.Hacia && t.VigenteDesde.Equal(tasa.VigenteDesde) {
+			return ErrTasaDuplicada
+		}
+	}
+	r.tasas = append(r.tasas, *tasa)
+	return nil
+}
+
+func (r *repositorioMemoria) EliminarTasa(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := slices.IndexFunc(r.tasas, func(t dinero.TasaCambio) bool { return t.ID == id })
+	if i < 0 {
+		return ErrTasaNoEncontrada
+	}
+	r.tasas = slices.Delete(r.tasas, i, i+1)
+	return nil
+}
+
+func (r *repositorioMemoria) ListarAtributos(ctx context.Context) ([]DefinicionAtributo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	definiciones := make([]DefinicionAtributo, 0, len(r.atributos))
+	return slices.AppendSeq(definiciones, maps.Values(r.atributos)), nil
+}
+
+func (r *repositorioMemoria) ObtenerAtributo(ctx context.Context, codigo string) (DefinicionAtributo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	def, ok := r.atributos[codigo]
+	if !ok {
+		return DefinicionAtributo{}, ErrAtributoNoEncontrado
+	}
+	return def, nil
+}
+
+func (r *repositorioMemoria) CrearAtributo(ctx context.Context, def *DefinicionAtributo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.atributos[def.Codigo]; ok {
+		return ErrAtributoDuplicado
+	}
+	def.Version = 1
+	r.atributos[def.Codigo] = *def
+	return nil
+}
+
+func (r *repositorioMemoria) ActualizarAtributo(ctx context.Context, def *DefinicionAtributo, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	actual, ok := r.atributos[def.Codigo]
+	if !ok {
+		return ErrAtributoNoEncontrado
+	}
+	if version != 0 && actual.Version != version {
+		return ErrConflictoVersion
+	}
+	def.Version = actual.Version + 1
+	r.atributos[def.Codigo] = *def
+	return nil
+}
+
+func (r *repositorioMemoria) EliminarAtributo(ctx context.Context, codigo string, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	actual, ok := r.atributos[codigo]
+	if !ok {
+		return ErrAtributoNoEncontrado
+	}
+	if version != 0 && actual.Version != version {
+		return ErrConflictoVersion
+	}
+	for _, productos := range []map[string]Producto{r.porID, r.papelera} {
+		for _, p := range productos {
+			if _, ok := p.Atributos[codigo]; ok {
+				return ErrAtributoEnUso
+			}
+		}
+	}
+	delete(r.atributos, codigo)
+	return nil
+}
+
+func (r *repositorioMemoria) ListarCategorias(ctx context.Context) ([]Categoria, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	categorias := make([]Categoria, 0, len(r.categorias))
+	for _, c := range r.categorias {
+		categorias = append(categorias, c)
+	}
+	return categorias, nil
+}
+
+func (r *repositorioMemoria) ObtenerCategoria(ctx context.Context, id string) (Categoria, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.categorias[id]
+	if !ok {
+		return Categoria{}, ErrCategoriaNoEncontrada
+	}
+	return c, nil
+}
+
+func (r *repositorioMemoria) CrearCategoria(ctx context.Context, categoria *Categoria) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.crearCategoria(categoria)
+}
+
+func (r *repositorioMemoria) crearCategoria(categoria *Categoria) error {
+	if err := r.comprobarCategoria(*categoria); err != nil {
+		return err
+	}
+	categoria.Version = 1
+	r.categorias[categoria.ID] = *categoria
+	return nil
+}
+
+func (r *repositorioMemoria) ActualizarCategoria(ctx context.Context, categoria *Categoria, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.actualizarCategoria(categoria, version)
+}
+
+func (r *repositorioMemoria) actualizarCategoria(categoria *Categoria, version int) error {
+	anterior, ok := r.categorias[categoria.ID]
+	if !ok {
+		return ErrCategoriaNoEncontrada
+	}
+	if version != 0 && anterior.Version != version {
+		return ErrConflictoVersion
+	}
+	if err := r.comprobarCategoria(*categoria); err != nil {
+		return err
+	}
+	categoria.Version = anterior.Version + 1
+	r.categorias[categoria.ID] = *categoria
+	return nil
+}
+
+func (r *repositorioMemoria) EliminarCategoria(ctx context.Context, id string, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.eliminarCategoria(id, version)
+}
+
+func (r *repositorioMemoria) eliminarCategoria(id string, version int) error {
+	c, ok := r.categorias[id]
+	if !ok {
+		return ErrCategoriaNoEncontrada
+	}
+	if version != 0 && c.Version != version {
+		return ErrConflictoVersion
+	}
+	if len(r.porCategoria[id]) > 0 {
+		return ErrCategoriaEnUso
+	}
+	// Como la clave foránea en PostgreSQL, los productos en la papelera
+	// también impiden borrar su categoría
+	for _, p := range r.papelera {
+		if p.CategoriaID == id {
+			return ErrCategoriaEnUso
+		}
+	}
+	for _, otra := range r.categorias {
+		if otra.PadreID != nil && *otra.PadreID == id {
+			return ErrCategoriaEnUso
+		}
+	}
+	delete(r.categorias, id)
+	return nil
+}
+
+// comprobarCategoria aplica las restricciones que en PostgreSQL garantizan el
+// índice único del slug y la clave foránea del padre
+func (r *repositorioMemoria) comprobarCategoria(c Categoria) error {
+	for _, otra := range r.categorias {
+		if otra.ID != c.ID && otra.Slug == c.Slug {
+			return ErrSlugDuplicado
+		}
+	}
+	if c.PadreID != nil {
+		if _, ok := r.categorias[*c.PadreID]; !ok {
+			return dominio.ErroresValidacion{{Campo: "padre_id", Mensaje: "no existe"}}
+		}
+		todas := slices.Collect(maps.Values(r.categorias))
+		if slices.Contains(descendientes(todas, c.ID), *c.PadreID) {
+			return errCicloCategoria()
+		}
+	}
+	return nil
+}
+
+func (r *repositorioMemoria) registrarTransicion(id string, desde, hacia dominio.EstadoProducto) {
+	r.historial[id] = append(r.historial[id], TransicionEstado{
+		ProductoID: id,
+		Desde:      desde,
+		Hacia:      hacia,
+		Fecha:      time.Now().UTC(),
+	})
+}
+
+// comprobarReferencias aplica las restricciones que en PostgreSQL garantizan
+// la clave foránea de la categoría y el índice único del SKU, y vuelve a
+// comprobar los componentes de un kit con los productos actuales
+func (r *repositorioMemoria) comprobarReferencias(p Producto) error {
+	if _, ok := r.categorias[p.CategoriaID]; !ok {
+		return errCategoriaInexistente()
+	}
+	if id, ok := r.porSKU[p.SKU]; ok && p.SKU != "" && id != p.ID {
+		return errSKUEnUso()
+	}
+	if !p.esKit() {
+		return nil
+	}
+	consulta, enKit := ConsultaProductos{Componente: p.ID}, false
+	for _, kit := range r.porID {
+		if consulta.cumple(kit) {
+			enKit = true
+			break
+		}
+	}
+	return comprobarComponentes(p, r.porID, enKit)
+}
+
+// asegurarRevisionBase guarda la revisión base de un producto sin
+// revisiones, como los cargados al crear el repositorio, para que su estado
+// antes de la siguiente escritura se pueda consultar y revertir; el llamador
+// debe tener el candado de escritura
+func (r *repositorioMemoria) asegurarRevisionBase(p Producto) {
+	if len(r.revisiones[p.ID]) > 0 {
+		return
+	}
+	if revision, err := revisionBase(p); err == nil {
+		r.revisiones[p.ID] = append(r.revisiones[p.ID], revision)
+	}
+}
+
+// insertar agrega un producto nuevo; el llamador debe tener el candado de escritura
+func (r *repositorioMemoria) insertar(p Producto) {
+	r.porID[p.ID] = p
+	r.orden = append(r.orden, p.ID)
+	r.indexar(p)
+}
+
+func (r *repositorioMemoria) indexar(p Producto) {
+	r.porCategoria[p.CategoriaID] = append(r.porCategoria[p.CategoriaID], p.ID)
+	if p.SKU != "" {
+		r.porSKU[p.SKU] = p.ID
+	}
+}
+
+func (r *repositorioMemoria) desindexar(p Producto) {
+	if p.SKU != "" {
+		delete(r.porSKU, p.SKU)
+	}
+	ids := quitarID(r.porCategoria[p.CategoriaID], p.ID)
+	if len(ids) == 0 {
+		delete(r.porCategoria, p.CategoriaID)
+		return
+	}
+	r.porCategoria[p.CategoriaID] = ids
+}
+
+// quitarID elimina id de la lista conservando el orden del resto
+func quitarID(ids []string, id string) []string {
+	for i, oid := range ids {
+		if oid == id {
+			return append(ids[:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// migracion es un cambio de esquema versionado. Las migraciones se aplican en
+// orden y una sola vez; nunca se modifica una migración ya publicada, se
+// agrega una nueva con la siguiente versión
+type migracion struct {
+	version     int
+	descripcion string
+	sql         string
+}
+
+var migraciones = []migracion{
+	{
+		version:     1,
+		descripcion: "crear tabla productos",
+		sql: `CREATE TABLE IF NOT EXISTS productos (
+			id          TEXT PRIMARY KEY,
+			nombre      TEXT NOT NULL,
+			descripcion TEXT NOT NULL DEFAULT '',
+			precio_base NUMERIC(12, 2) NOT NULL DEFAULT 0,
+			categoria   TEXT NOT NULL DEFAULT '',
+			estado      TEXT NOT NULL DEFAULT ''
+		)`,
+	},
+	{
+		version:     2,
+		descripcion: "agregar dimensiones a productos",
+		sql: `ALTER TABLE productos
+			ADD COLUMN IF NOT EXISTS dimensiones_ancho    DOUBLE PRECISION NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS dimensiones_alto     DOUBLE PRECISION NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS dimensiones_profundo DOUBLE PRECISION NOT NULL DEFAULT 0`,
+	},
+	{
+		version:     3,
+		descripcion: "indexar productos por categoria",
+		sql:         `CREATE INDEX IF NOT EXISTS idx_productos_categoria ON productos (categoria)`,
+	},
+	{
+		version:     4,
+		descripcion: "agregar version a productos para control de concurrencia",
+		sql:         `ALTER TABLE productos ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1`,
+	},
+	{
+		version:     5,
+		descripcion: "ciclo de vida de productos e historial de estados",
+		sql: `UPDATE productos SET estado = lower(trim(estado));
+			UPDATE productos SET estado = 'borrador'
+				WHERE estado NOT IN ('borrador', 'disponible', 'agotado', 'descontinuado');
+			ALTER TABLE productos ADD CONSTRAINT productos_estado_valido
+				CHECK (estado IN ('borrador', 'disponible', 'agotado', 'descontinuado'));
+			CREATE TABLE IF NOT EXISTS producto_transiciones (
+				id          BIGSERIAL PRIMARY KEY,
+				producto_id TEXT NOT NULL REFERENCES productos (id) ON DELETE CASCADE,
+				desde       TEXT NOT NULL DEFAULT '',
+				hacia       TEXT NOT NULL,
+				fecha       TIMESTAMPTZ NOT NULL DEFAULT now()
+			);
+			CREATE INDEX IF NOT EXISTS idx_producto_transiciones_producto
+				ON producto_transiciones (producto_id, fecha)`,
+	},
+	{
+		version:     6,
+		descripcion: "modelos 3D de productos y geometría extraída",
+		sql: `ALTER TABLE productos
+				ADD COLUMN IF NOT EXISTS geometria_formato    TEXT NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS geometria_volumen    DOUBLE PRECISION NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS geometria_area       DOUBLE PRECISION NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS geometria_triangulos INTEGER NOT NULL DEFAULT 0;
+			CREATE TABLE IF NOT EXISTS producto_modelos (
+				producto_id    TEXT PRIMARY KEY REFERENCES productos (id) ON DELETE CASCADE,
+				formato        TEXT NOT NULL,
+				nombre_archivo TEXT NOT NULL DEFAULT '',
+				datos          BYTEA NOT NULL,
+				sha256         TEXT NOT NULL,
+				subido_en      TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`,
+	},
+	{
+		version:     7,
+		descripcion: "informe de imprimibilidad del modelo 3D",
+		sql: `ALTER TABLE productos
+				ADD COLUMN IF NOT EXISTS imprimibilidad_imprimible             BOOLEAN NOT NULL DEFAULT false,
+				ADD COLUMN IF NOT EXISTS imprimibilidad_aristas_no_manifold    INTEGER NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS imprimibilidad_aristas_abiertas       INTEGER NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS imprimibilidad_agujeros               INTEGER NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS imprimibilidad_normales_invertidas    INTEGER NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS imprimibilidad_triangulos_degenerados INTEGER NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS imprimibilidad_cuerpos                INTEGER NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS imprimibilidad_autointersecciones     INTEGER NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS imprimibilidad_analizado_en           TIMESTAMPTZ`,
+	},
+	{
+		version:     8,
+		descripcion: "miniatura del modelo 3D",
+		sql:         `ALTER TABLE producto_modelos ADD COLUMN IF NOT EXISTS miniatura BYTEA`,
+	},
+	{
+		// Cada valor distinto de la antigua columna categoria se convierte en
+		// una categoría raíz. Los valores que solo difieren en mayúsculas,
+		// tildes, espacios o signos comparten slug y se fusionan en la misma
+		// categoría; el resto ("Soporte" y "Soportes") se puede reorganizar
+		// después reasignando los productos y eliminando la sobrante
+		version:     9,
+		descripcion: "categorías jerárquicas",
+		sql: `CREATE TABLE IF NOT EXISTS categorias (
+				id          TEXT PRIMARY KEY,
+				nombre      TEXT NOT NULL,
+				slug        TEXT NOT NULL UNIQUE,
+				descripcion TEXT NOT NULL DEFAULT '',
+				padre_id    TEXT REFERENCES categorias (id),
+				orden       INTEGER NOT NULL DEFAULT 0,
+				version     INTEGER NOT NULL DEFAULT 1
+			);
+			CREATE INDEX IF NOT EXISTS idx_categorias_padre ON categorias (padre_id);
+
+			CREATE FUNCTION pg_temp.slug_categoria(nombre TEXT) RETURNS TEXT AS $$
+				SELECT trim(BOTH '-' FROM regexp_replace(
+					translate(lower(trim(nombre)), 'áéíóúüñ', 'aeiouun'),
+					'[^a-z0-9]+', '-', 'g'))
+			$$ LANGUAGE SQL IMMUTABLE;
+
+			INSERT INTO categorias (id, nombre, slug)
+				SELECT gen_random_uuid()::text, min(trim(categoria)), pg_temp.slug_categoria(categoria)
+				FROM productos
+				WHERE pg_temp.slug_categoria(categoria) <> ''
+				GROUP BY pg_temp.slug_categoria(categoria)
+				ON CONFLICT (slug) DO NOTHING;
+
+			ALTER TABLE productos ADD COLUMN IF NOT EXISTS categoria_id TEXT REFERENCES categorias (id);
+			UPDATE productos p SET categoria_id = c.id
+				FROM categorias c
+				WHERE c.slug = pg_temp.slug_categoria(p.categoria);
+
+			DROP INDEX IF EXISTS idx_productos_categoria;
+			ALTER TABLE productos DROP COLUMN categoria;
+			CREATE INDEX IF NOT EXISTS idx_productos_categoria ON productos (categoria_id)`,
+	},
+	{
+		version:     10,
+		descripcion: "SKU de productos como clave natural",
+		sql: `ALTER TABLE productos ADD COLUMN IF NOT EXISTS sku TEXT NOT NULL DEFAULT '';
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_productos_sku ON productos (sku) WHERE sku <> ''`,
+	},
+	{
+		version:     11,
+		descripcion: "papelera de productos",
+		sql: `ALTER TABLE productos ADD COLUMN IF NOT EXISTS eliminado_en TIMESTAMPTZ;
+			CREATE INDEX IF NOT EXISTS idx_productos_eliminado_en ON productos (eliminado_en)
+				WHERE eliminado_en IS NOT NULL;
+			DROP INDEX IF EXISTS idx_productos_sku;
+			CREATE UNIQUE INDEX idx_productos_sku ON productos (sku)
+				WHERE sku <> '' AND eliminado_en IS NULL`,
+	},
+	{
+		version:     12,
+		descripcion: "revisiones de productos",
+		sql: `CREATE TABLE IF NOT EXISTS producto_revisiones (
+			producto_id TEXT NOT NULL REFERENCES productos (id) ON DELETE CASCADE,
+			numero      INTEGER NOT NULL,
+			actor       TEXT NOT NULL,
+			fecha       TIMESTAMPTZ NOT NULL DEFAULT now(),
+			cambios     JSONB NOT NULL,
+			producto    JSONB NOT NULL,
+			PRIMARY KEY (producto_id, numero)
+		)`,
+	},
+	{
+		version:     13,
+		descripcion: "precios con moneda y tasas de cambio",
+		sql: `ALTER TABLE productos RENAME COLUMN precio_base TO precio_base_importe;
+			ALTER TABLE productos ALTER COLUMN precio_base_importe TYPE NUMERIC(15, 3);
+			ALTER TABLE productos ADD COLUMN IF NOT EXISTS precio_base_moneda TEXT NOT NULL DEFAULT 'USD';
+			CREATE TABLE IF NOT EXISTS tasas_cambio (
+				id            TEXT PRIMARY KEY,
+				desde         TEXT NOT NULL,
+				hacia         TEXT NOT NULL,
+				tasa          NUMERIC(20, 8) NOT NULL CHECK (tasa > 0),
+				vigente_desde TIMESTAMPTZ NOT NULL,
+				UNIQUE (desde, hacia, vigente_desde)
+			)`,
+	},
+	{
+		version:     14,
+		descripcion: "traducciones de productos y categorías",
+		sql: `ALTER TABLE productos ADD COLUMN IF NOT EXISTS traducciones JSONB DEFAULT '{}';
+			ALTER TABLE categorias ADD COLUMN IF NOT EXISTS traducciones JSONB DEFAULT '{}'`,
+	},
+	{
+		version:     15,
+		descripcion: "atributos tipados y etiquetas de productos",
+		sql: `CREATE TABLE IF NOT EXISTS atributos (
+				codigo     TEXT PRIMARY KEY,
+				nombre     TEXT NOT NULL,
+				tipo       TEXT NOT NULL CHECK (tipo IN ('texto', 'numero', 'enum', 'booleano')),
+				unidad     TEXT NOT NULL DEFAULT '',
+				opciones   JSONB,
+				categorias JSONB NOT NULL DEFAULT '[]',
+				version    INTEGER NOT NULL DEFAULT 1
+			);
+			ALTER TABLE productos ADD COLUMN IF NOT EXISTS atributos JSONB DEFAULT '{}';
+			ALTER TABLE productos ADD COLUMN IF NOT EXISTS etiquetas JSONB DEFAULT '[]';
+			CREATE INDEX IF NOT EXISTS idx_productos_atributos ON productos USING GIN (atributos);
+			CREATE INDEX IF NOT EXISTS idx_productos_etiquetas ON productos USING GIN (etiquetas)`,
+	},
+	{
+		version:     16,
+		descripcion: "kits de productos",
+		sql: `ALTER TABLE productos ADD COLUMN IF NOT EXISTS componentes JSONB DEFAULT '[]';
+			ALTER TABLE productos ADD COLUMN IF NOT EXISTS descuento_kit NUMERIC(5, 2) NOT NULL DEFAULT 0;
+			CREATE INDEX IF NOT EXISTS idx_productos_componentes ON productos USING GIN (componentes jsonb_path_ops)`,
+	},
+	{
+		version:     17,
+		descripcion: "relaciones entre productos",
+		sql: `CREATE TABLE IF NOT EXISTS producto_relaciones (
+				id          TEXT PRIMARY KEY,
+				producto_id TEXT NOT NULL REFERENCES productos (id) ON DELETE CASCADE,
+				tipo        TEXT NOT NULL CHECK (tipo IN ('compatible_con', 'reemplaza_a', 'accesorio_de', 'alternativa')),
+				destino_id  TEXT NOT NULL REFERENCES productos (id) ON DELETE CASCADE,
+				creada_en   TIMESTAMPTZ NOT NULL DEFAULT now(),
+				UNIQUE (producto_id, tipo, destino_id),
+				CHECK (producto_id <> destino_id)
+			);
+			CREATE INDEX IF NOT EXISTS idx_producto_relaciones_destino ON producto_relaciones (destino_id)`,
+	},
+	{
+		version:     18,
+		descripcion: "adjuntos de productos",
+		sql: `CREATE TABLE IF NOT EXISTS producto_adjuntos (
+				id             TEXT PRIMARY KEY,
+				producto_id    TEXT NOT NULL REFERENCES productos (id) ON DELETE CASCADE,
+				nombre_archivo TEXT NOT NULL DEFAULT '',
+				titulo         TEXT NOT NULL DEFAULT '',
+				tipo_contenido TEXT NOT NULL,
+				tamano         BIGINT NOT NULL,
+				sha256         TEXT NOT NULL,
+				orden          INTEGER NOT NULL,
+				subido_en      TIMESTAMPTZ NOT NULL DEFAULT now(),
+				UNIQUE (producto_id, sha256)
+			);
+			CREATE INDEX IF NOT EXISTS idx_producto_adjuntos_sha256 ON producto_adjuntos (sha256)`,
+	},
+	{
+		version:     19,
+		descripcion: "unidad de las dimensiones",
+		// Las dimensiones existentes se interpretan en milímetros, la unidad
+		// en que se guardan todas
+		sql: `ALTER TABLE productos ADD COLUMN IF NOT EXISTS dimensiones_unidad TEXT NOT NULL DEFAULT 'mm' CHECK (dimensiones_unidad = 'mm')`,
+	},
+	{
+		version:     20,
+		descripcion: "publicación y cambio de precio programados",
+		// Los índices parciales solo cubren los productos con algo programado,
+		// que son los que consulta el programador en cada pasada
+		sql: `ALTER TABLE productos ADD COLUMN IF NOT EXISTS publicar_en TIMESTAMPTZ;
+			ALTER TABLE productos ADD COLUMN IF NOT EXISTS precio_programado JSONB;
+			ALTER TABLE productos ADD COLUMN IF NOT EXISTS precio_desde TIMESTAMPTZ;
+			CREATE INDEX IF NOT EXISTS idx_productos_publicar_en ON productos (publicar_en) WHERE publicar_en IS NOT NULL;
+			CREATE INDEX IF NOT EXISTS idx_productos_precio_desde ON productos (precio_desde) WHERE precio_desde IS NOT NULL`,
+	},
+	{
+		version:     21,
+		descripcion: "claves de idempotencia",
+		sql: `CREATE TABLE IF NOT EXISTS idempotencia (
+			clave          TEXT PRIMARY KEY,
+			huella         TEXT NOT NULL DEFAULT '',
+			estado         INTEGER NOT NULL DEFAULT 0,
+			tipo_contenido TEXT NOT NULL DEFAULT '',
+			etag           TEXT NOT NULL DEFAULT '',
+			cuerpo         BYTEA,
+			caduca_en      TIMESTAMPTZ NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_idempotencia_caduca_en ON idempotencia (caduca_en)`,
+	},
+	{
+		version:     22,
+		descripcion: "vista de la miniatura guardada",
+		// Las miniaturas anteriores quedan sin vista y se vuelven a renderizar
+		sql: `ALTER TABLE producto_modelos ADD COLUMN IF NOT EXISTS vista_miniatura TEXT NOT NULL DEFAULT ''`,
+	},
+}
+
+// migrar aplica las migraciones pendientes registrándolas en schema_migrations
+func migrar(db *gorm.DB) error {
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     INTEGER PRIMARY KEY,
+		descripcion TEXT NOT NULL,
+		aplicada_en TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`).Error; err != nil {
+		return err
+	}
+
+	var aplicadas []int
+	if err := db.Raw("SELECT version FROM schema_migrations").Scan(&aplicadas).Error; err != nil {
+		return err
+	}
+	hechas := make(map[int]bool, len(aplicadas))
+	for _, v := range aplicadas {
+		hechas[v] = true
+	}
+
+	for _, m := range migraciones {
+		if hechas[m.version] {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(m.sql).Error; err != nil {
+				return err
+			}
+			return tx.Exec("INSERT INTO schema_migrations (version, descripcion) VALUES (?, ?)", m.version, m.descripcion).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migración %d (%s): %w", m.version, m.descripcion, err)
+		}
+		log.Printf("Migración %d aplicada: %s", m.version, m.descripcion)
+	}
+	return nil
+}
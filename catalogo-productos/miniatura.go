@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"catalogo-productos/malla"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tamanoMaximoMiniatura = 1024
+	// capacidadCacheMiniaturas es el número de miniaturas renderizadas bajo
+	// demanda que se conservan en memoria
+	capacidadCacheMiniaturas = 256
+)
+
+var (
+	errMaterialNoEncontrado = errors.New("material no encontrado")
+	errColorInvalido        = errors.New("color inválido, use #rrggbb o un nombre de color")
+	errServicioMateriales   = errors.New("respuesta inesperada de catalogo-materiales")
+)
+
+// coloresNombrados traduce los nombres de color que usa catalogo-materiales en
+// CaracteristicasMaterial.Color
+var coloresNombrados = map[string]color.RGBA{
+	"natural":      {0xE8, 0xDC, 0xC2, 0xFF},
+	"transparente": {0xD6, 0xE6, 0xEE, 0xFF},
+	"blanco":       {0xF2, 0xF2, 0xF2, 0xFF},
+	"negro":        {0x30, 0x30, 0x30, 0xFF},
+	"gris":         {0x90, 0x90, 0x90, 0xFF},
+	"plata":        {0xC0, 0xC0, 0xC8, 0xFF},
+	"dorado":       {0xD4, 0xAF, 0x37, 0xFF},
+	"rojo":         {0xD0, 0x30, 0x30, 0xFF},
+	"naranja":      {0xF0, 0x80, 0x20, 0xFF},
+	"amarillo":     {0xF0, 0xD0, 0x30, 0xFF},
+	"verde":        {0x40, 0xA0, 0x50, 0xFF},
+	"azul":         {0x30, 0x60, 0xC0, 0xFF},
+	"morado":       {0x80, 0x40, 0xA0, 0xFF},
+	"rosa":         {0xF0, 0x90, 0xB0, 0xFF},
+	"marron":       {0x80, 0x50, 0x30, 0xFF},
+	"marrón":       {0x80, 0x50, 0x30, 0xFF},
+}
+
+// vistaPredeterminada es la vista de la miniatura que se guarda con el modelo.
+// Se configura con MINIATURA_TAMANO, MINIATURA_AZIMUT, MINIATURA_ELEVACION y
+// MINIATURA_COLOR
+func vistaPredeterminada() malla.Vista {
+	v := malla.Vista{
+		Ancho:     256,
+		Alto:      256,
+		Azimut:    -45,
+		Elevacion: 30,
+		Color:     color.RGBA{0xB0, 0xB0, 0xB0, 0xFF},
+	}
+	if n, err := strconv.Atoi(os.Getenv("MINIATURA_TAMANO")); err == nil && n > 0 && n <= tamanoMaximoMiniatura {
+		v.Ancho, v.Alto = n, n
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("MINIATURA_AZIMUT"), 64); err == nil {
+		v.Azimut = f
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("MINIATURA_ELEVACION"), 64); err == nil {
+		v.Elevacion = f
+	}
+	if c, err := parseColor(os.Getenv("MINIATURA_COLOR")); err == nil {
+		v.Color = c
+	}
+	return v
+}
+
+// claveVista identifica una vista en las claves de la caché y en los ETag
+func claveVista(v malla.Vista) string {
+	return fmt.Sprintf("%d:%d:%g:%g:%02x%02x%02x", v.Ancho, v.Alto, v.Azimut, v.Elevacion, v.Color.R, v.Color.G, v.Color.B)
+}
+
+// parseColor acepta #rrggbb, rrggbb o un nombre de coloresNombrados
+func parseColor(s string) (color.RGBA, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := coloresNombrados[s]; ok {
+		return c, nil
+	}
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return color.RGBA{}, errColorInvalido
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return color.RGBA{}, errColorInvalido
+	}
+	return color.RGBA{b[0], b[1], b[2], 0xFF}, nil
+}
+
+// renderizarMiniatura dibuja la malla y la codifica en PNG
+func renderizarMiniatura(m *malla.Malla, v malla.Vista) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, m.Renderizar(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// cacheMiniaturas guarda las miniaturas renderizadas bajo demanda. La clave
+// incluye el SHA-256 del modelo, así que al cambiar el modelo las entradas
+// antiguas dejan de usarse y acaban desalojadas
+type cacheMiniaturas struct {
+	mu       sync.Mutex
+	entradas map[string][]byte
+	orden    []string
+}
+
+var miniaturas = &cacheMiniaturas{entradas: make(map[string][]byte)}
+
+func (c *cacheMiniaturas) obtener(clave string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	imagen, ok := c.entradas[clave]
+	return imagen, ok
+}
+
+func (c *cacheMiniaturas) guardar(clave string, imagen []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entradas[clave]; ok {
+		return
+	}
+	if len(c.orden) >= capacidadCacheMiniaturas {
+		delete(c.entradas, c.orden[0])
+		c.orden = c.orden[1:]
+	}
+	c.entradas[clave] = imagen
+	c.orden = append(c.orden, clave)
+}
+
+// Miniatura de un producto
+// @Summary Obtener la miniatura de un producto
+// @Description Devuelve un PNG renderizado a partir del modelo 3D. Sin parámetros se sirve la miniatura generada al subir el modelo, o se vuelve a renderizar si la vista MINIATURA_* cambió desde entonces; con ellos se renderiza con la cámara, el tamaño y el color indicados. ?material= toma el color de las características del material en catalogo-materiales
+// @Tags productos
+// @Produce image/png
+// @Param id path string true "ID del producto"
+// @Param tamano query int false "Lado en píxeles (máximo 1024)"
+// @Param ancho query int false "Ancho en píxeles"
+// @Param alto query int false "Alto en píxeles"
+// @Param azimut query number false "Giro de la cámara en grados alrededor del eje Z"
+// @Param elevacion query number false "Elevación de la cámara en grados"
+// @Param color query string false "Color #rrggbb o nombre"
+// @Param material query string false "ID del material cuyo color se usa"
+// @Success 200 {file} file
+// @Success 304
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 502 {object} dominio.RespuestaError
+// @Router /productos/{id}/thumbnail [get]
+func getProductThumbnail(c *gin.Context) {
+	modelo, err := repo.ObtenerModelo(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+
+	vista, err := parseVista(c)
+	if err != nil {
+		switch {
+		case errors.Is(err, errMaterialNoEncontrado):
+			responderMensaje(c, http.StatusBadRequest, traducir(c, "Material no encontrado"))
+		case errors.Is(err, errServicioMateriales):
+			log.Printf("Error consultando el material: %v", err)
+			responderMensaje(c, http.StatusBadGateway, traducir(c, "No se pudo consultar el material"))
+		default:
+			responderMensaje(c, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	clave := modelo.SHA256 + ":" + claveVista(vista)
+	suma := sha256.Sum256([]byte(clave))
+	etiqueta := `"` + hex.EncodeToString(suma[:16]) + `"`
+
+	c.Header("ETag", etiqueta)
+	c.Header("Cache-Control", "no-cache")
+	if c.GetHeader("If-None-Match") == etiqueta {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	// La miniatura guardada solo sirve si se generó con esta misma vista: al
+	// cambiar MINIATURA_* se vuelve a renderizar en vez de servir la antigua
+	imagen := modelo.Miniatura
+	if modelo.VistaMiniatura != claveVista(vista) || len(imagen) == 0 {
+		var ok bool
+		if imagen, ok = miniaturas.obtener(clave); !ok {
+			m, err := malla.Leer(modelo.Formato, modelo.Datos)
+			if err == nil {
+				imagen, err = renderizarMiniatura(m, vista)
+			}
+			if err != nil {
+				log.Printf("No se pudo renderizar la miniatura del producto %s: %v", modelo.ProductoID, err)
+				responderMensaje(c, http.StatusInternalServerError, traducir(c, "Error al generar la miniatura"))
+				return
+			}
+			miniaturas.guardar(clave, imagen)
+		}
+	}
+	c.Data(http.StatusOK, "image/png", imagen)
+}
+
+// parseVista parte de la vista predeterminada y aplica los parámetros de la petición
+func parseVista(c *gin.Context) (malla.Vista, error) {
+	v := vistaPredeterminada()
+
+	lado := func(nombre string, destino ...*int) error {
+		s := c.Query(nombre)
+		if s == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > tamanoMaximoMiniatura {
+			return fmt.Errorf("%s debe ser un entero entre 1 y %d", nombre, tamanoMaximoMiniatura)
+		}
+		for _, d := range destino {
+			*d = n
+		}
+		return nil
+	}
+	angulo := func(nombre string, destino *float64) error {
+		s := c.Query(nombre)
+		if s == "" {
+			return nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%s debe ser un número de grados", nombre)
+		}
+		*destino = f
+		return nil
+	}
+	for _, err := range []error{
+		lado("tamano", &v.Ancho, &v.Alto),
+		lado("ancho", &v.Ancho),
+		lado("alto", &v.Alto),
+		angulo("azimut", &v.Azimut),
+		angulo("elevacion", &v.Elevacion),
+	} {
+		if err != nil {
+			return v, err
+		}
+	}
+
+	if s := c.Query("color"); s != "" {
+		col, err := parseColor(s)
+		if err != nil {
+			return v, err
+		}
+		v.Color = col
+	} else if id := c.Query("material"); id != "" {
+		col, err := colorMaterial(c.Request.Context(), id)
+		if err != nil {
+			return v, err
+		}
+		v.Color = col
+	}
+	return v, nil
+}
+
+var clienteMateriales = &http.Client{Timeout: 3 * time.Second}
+
+// colorMaterial consulta el material en catalogo-materiales (MATERIALES_ENDPOINT,
+// por defecto http://localhost:8082) y devuelve el color de sus características.
+// Si el color no se reconoce se usa el predeterminado
+func colorMaterial(ctx context.Context, id string) (color.RGBA, error) {
+	base := os.Getenv("MATERIALES_ENDPOINT")
+	if base == "" {
+		base = "http://localhost:8082"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimRight(base, "/")+"/api/v1/materiales/"+url.PathEscape(id), nil)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	resp, err := clienteMateriales.Do(req)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("%w: %v", errServicioMateriales, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return color.RGBA{}, errMaterialNoEncontrado
+	default:
+		return color.RGBA{}, fmt.Errorf("%w: estado %d", errServicioMateriales, resp.StatusCode)
+	}
+
+	var cuerpo struct {
+		Data struct {
+			Caracteristicas struct {
+				Color string `json:"color"`
+			} `json:"caracteristicas"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&cuerpo); err != nil {
+		return color.RGBA{}, fmt.Errorf("%w: %v", errServicioMateriales, err)
+	}
+	col, err := parseColor(cuerpo.Data.Caracteristicas.Color)
+	if err != nil {
+		return vistaPredeterminada().Color, nil
+	}
+	return col, nil
+}
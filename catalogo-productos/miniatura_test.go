@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"catalogo-productos/malla"
+)
+
+// TestMiniaturaCambioVista guarda un modelo con su miniatura y cambia después
+// MINIATURA_COLOR: la miniatura sin parámetros debe volver a renderizarse con
+// la nueva vista y cambiar de ETag en vez de servir la guardada
+func TestMiniaturaCambioVista(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	p := crearProductoPrueba(t, srv, "Jarrón", crearCategoriaPrueba(t, srv, "Decoración"))
+	m, err := malla.Leer(malla.FormatoSTL, []byte(trianguloSuelto))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vista := vistaPredeterminada()
+	guardada, err := renderizarMiniatura(m, vista)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelo := ModeloProducto{
+		ProductoID:     p.ID,
+		Formato:        malla.FormatoSTL,
+		Datos:          []byte(trianguloSuelto),
+		SHA256:         "prueba",
+		Miniatura:      guardada,
+		VistaMiniatura: claveVista(vista),
+	}
+	if err := repo.GuardarModelo(context.Background(), &p, &modelo, p.Version); err != nil {
+		t.Fatal(err)
+	}
+
+	ruta := "/api/v1/productos/" + p.ID + "/thumbnail"
+	estado, cabeceras, cuerpo := peticion(t, srv, http.MethodGet, ruta, nil)
+	if estado != http.StatusOK || !bytes.Equal(cuerpo, guardada) {
+		t.Fatalf("miniatura guardada: %d, %d bytes", estado, len(cuerpo))
+	}
+	etiqueta := cabeceras.Get("ETag")
+	if estado, _, _ := peticion(t, srv, http.MethodGet, ruta, nil, "If-None-Match", etiqueta); estado != http.StatusNotModified {
+		t.Errorf("If-None-Match con la misma vista: %d", estado)
+	}
+
+	t.Setenv("MINIATURA_COLOR", "rojo")
+	estado, cabeceras, cuerpo = peticion(t, srv, http.MethodGet, ruta, nil, "If-None-Match", etiqueta)
+	if estado != http.StatusOK {
+		t.Fatalf("If-None-Match tras cambiar la vista: %d", estado)
+	}
+	if cabeceras.Get("ETag") == etiqueta {
+		t.Error("el ETag no cambió con la vista")
+	}
+	if bytes.Equal(cuerpo, guardada) {
+		t.Error("se sirvió la miniatura guardada con otra vista")
+	}
+	if esperada, err := renderizarMiniatura(m, vistaPredeterminada()); err != nil || !bytes.Equal(cuerpo, esperada) {
+		t.Errorf("la miniatura no es la de la nueva vista: %v", err)
+	}
+}
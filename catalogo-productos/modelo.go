@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"catalogo-comun/condicional"
+	dominio "catalogo-dominio"
+	"catalogo-productos/malla"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Geometria resume la malla del modelo 3D de un producto. Formato está vacío
+// si el producto aún no tiene modelo
+type Geometria struct {
+	Formato    malla.Formato `json:"formato,omitempty" enums:"stl,obj,3mf"`
+	Volumen    float64       `json:"volumen"`    // mm³
+	Area       float64       `json:"area"`       // mm²
+	Triangulos int           `json:"triangulos"` // caras de la malla
+}
+
+// InformeImprimibilidad es el resultado del último análisis de integridad del
+// modelo 3D. AnalizadoEn es nulo mientras el producto no tenga modelo
+type InformeImprimibilidad struct {
+	Imprimible            bool       `json:"imprimible"`
+	AristasNoManifold     int        `json:"aristas_no_manifold"`
+	AristasAbiertas       int        `json:"aristas_abiertas"`
+	Agujeros              int        `json:"agujeros"`
+	NormalesInvertidas    int        `json:"normales_invertidas"`
+	TriangulosDegenerados int        `json:"triangulos_degenerados"`
+	Cuerpos               int        `json:"cuerpos"`
+	Autointersecciones    int        `json:"autointersecciones"`
+	AnalizadoEn           *time.Time `json:"analizado_en"`
+}
+
+func nuevoInforme(inf malla.Informe) InformeImprimibilidad {
+	ahora := time.Now().UTC()
+	return InformeImprimibilidad{
+		Imprimible:            inf.Imprimible(),
+		AristasNoManifold:     inf.AristasNoManifold,
+		AristasAbiertas:       inf.AristasAbiertas,
+		Agujeros:              inf.Agujeros,
+		NormalesInvertidas:    inf.NormalesInvertidas,
+		TriangulosDegenerados: inf.TriangulosDegenerados,
+		Cuerpos:               inf.Cuerpos,
+		Autointersecciones:    inf.Autointersecciones,
+		AnalizadoEn:           &ahora,
+	}
+}
+
+// ModeloProducto es el archivo del modelo 3D de un producto tal como se subió
+type ModeloProducto struct {
+	ProductoID    string        `gorm:"primaryKey" json:"producto_id"`
+	Formato       malla.Formato `json:"formato"`
+	NombreArchivo string        `json:"nombre_archivo"`
+	Datos         []byte        `json:"-"`
+	SHA256        string        `gorm:"column:sha256" json:"sha256"`
+	// Miniatura es el PNG con la vista predeterminada, generado al subir el modelo
+	Miniatura []byte `json:"-"`
+	// VistaMiniatura es la clave de la vista con la que se generó Miniatura
+	VistaMiniatura string    `json:"-"`
+	SubidoEn       time.Time `json:"subido_en"`
+}
+
+func (ModeloProducto) TableName() string {
+	return "producto_modelos"
+}
+
+// tiposContenidoModelo es el Content-Type con el que se sirve cada formato
+var tiposContenidoModelo = map[malla.Formato]string{
+	malla.FormatoSTL: "model/stl",
+	malla.FormatoOBJ: "model/obj",
+	malla.Formato3MF: "model/3mf",
+}
+
+// tamanoMaximoModelo lee MODELO_TAMANO_MAXIMO_MB; por defecto 100 MB
+func tamanoMaximoModelo() int64 {
+	if mb, err := strconv.Atoi(os.Getenv("MODELO_TAMANO_MAXIMO_MB")); err == nil && mb > 0 {
+		return int64(mb) << 20
+	}
+	return 100 << 20
+}
+
+// Subir el modelo 3D de un producto
+// @Summary Subir el modelo 3D de un producto
+// @Description Recibe un archivo STL (binario o ASCII), OBJ o 3MF en el campo multipart "archivo", o directamente en el cuerpo indicando ?nombre= o ?formato=. Calcula la caja envolvente, el volumen, el área y los triángulos, analiza la integridad de la malla, genera la miniatura y lo guarda todo en el producto. Un producto disponible solo admite modelos imprimibles
+// @Tags productos
+// @Accept multipart/form-data
+// @Accept application/octet-stream
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param If-Match header string false "ETag obtenido al leer el producto"
+// @Param archivo formData file false "Modelo 3D"
+// @Param nombre query string false "Nombre del archivo cuando se envía en el cuerpo"
+// @Param formato query string false "stl, obj o 3mf; si no se indica se deduce del archivo"
+// @Param Idempotency-Key header string false "Clave única de la operación; un reintento con la misma clave y la misma petición devuelve la respuesta guardada"
+// @Success 200 {object} dominio.Respuesta{data=Producto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Failure 413 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /productos/{id}/modelo [post]
+func uploadProductModel(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+
+	producto, err := repo.Obtener(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+
+	nombre, datos, err := leerArchivoModelo(c)
+	if err != nil {
+		var demasiadoGrande *http.MaxBytesError
+		if errors.As(err, &demasiadoGrande) {
+			responderMensaje(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("El modelo supera el tamaño máximo de %d MB", demasiadoGrande.Limit>>20))
+			return
+		}
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	formato := malla.Formato(c.Query("formato"))
+	if formato == "" {
+		if formato, err = malla.DetectarFormato(nombre, datos); err != nil {
+			responderMensaje(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	m, err := malla.Leer(formato, datos)
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	aplicarGeometria(&producto, formato, m.Geometria())
+	producto.Imprimibilidad = nuevoInforme(m.Analizar())
+	if err := validarProducto(producto); err != nil {
+		responderError(c, err)
+		return
+	}
+	if err := validarImprimibilidad("", producto); err != nil {
+		responderError(c, err)
+		return
+	}
+
+	vista := vistaPredeterminada()
+	miniatura, err := renderizarMiniatura(m, vista)
+	if err != nil {
+		log.Printf("No se pudo renderizar la miniatura del producto %s: %v", producto.ID, err)
+		responderMensaje(c, http.StatusInternalServerError, traducir(c, "Error al generar la miniatura"))
+		return
+	}
+
+	suma := sha256.Sum256(datos)
+	modelo := ModeloProducto{
+		ProductoID:     producto.ID,
+		Formato:        formato,
+		NombreArchivo:  nombre,
+		Datos:          datos,
+		SHA256:         hex.EncodeToString(suma[:]),
+		Miniatura:      miniatura,
+		VistaMiniatura: claveVista(vista),
+		SubidoEn:       time.Now().UTC(),
+	}
+	if version == 0 {
+		version = producto.Version
+	}
+	if err := repo.GuardarModelo(c.Request.Context(), &producto, &modelo, version); err != nil {
+		responderError(c, err)
+		return
+	}
+
+	log.Printf("Modelo %s de %d triángulos guardado para el producto %s", formato, producto.Geometria.Triangulos, producto.ID)
+	c.Header("ETag", condicional.ETag(producto.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": producto,
+	})
+}
+
+// Analizar el modelo 3D de un producto
+// @Summary Analizar la integridad del modelo 3D
+// @Description Vuelve a analizar el modelo 3D guardado: aristas no manifold, agujeros, normales invertidas, triángulos degenerados, cuerpos desconectados y autointersecciones. El informe se guarda en el producto. Si el producto está disponible y el modelo ya no es imprimible responde 409 sin guardar nada: primero hay que pasarlo a agotado o descontinuado
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param If-Match header string false "ETag obtenido al leer el producto"
+// @Param Idempotency-Key header string false "Clave única de la operación; un reintento con la misma clave y la misma petición devuelve la respuesta guardada"
+// @Success 200 {object} dominio.Respuesta{data=InformeImprimibilidad}
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 409 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Router /productos/{id}/modelo/analisis [post]
+func analyzeProductModel(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+
+	producto, err := repo.Obtener(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	modelo, err := repo.ObtenerModelo(c.Request.Context(), producto.ID)
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	m, err := malla.Leer(modelo.Formato, modelo.Datos)
+	if err != nil {
+		log.Printf("No se pudo leer el modelo guardado del producto %s: %v", producto.ID, err)
+		responderMensaje(c, http.StatusInternalServerError, traducir(c, "Error al leer el modelo 3D"))
+		return
+	}
+
+	producto.Imprimibilidad = nuevoInforme(m.Analizar())
+	// Como al subir un modelo, un producto disponible no puede quedar con uno
+	// no imprimible; ninguna transición desde disponible lo deja fuera de la
+	// venta sin decidir entre agotado y descontinuado, así que se rechaza
+	if validarImprimibilidad("", producto) != nil {
+		log.Printf("El modelo del producto disponible %s ya no es imprimible: %+v", producto.ID, producto.Imprimibilidad)
+		responderError(c, ErrModeloNoImprimible)
+		return
+	}
+	if version == 0 {
+		version = producto.Version
+	}
+	if err := repo.Actualizar(c.Request.Context(), &producto, version); err != nil {
+		responderError(c, err)
+		return
+	}
+
+	c.Header("ETag", condicional.ETag(producto.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"data": producto.Imprimibilidad,
+	})
+}
+
+// Descargar el modelo 3D de un producto
+// @Summary Descargar el modelo 3D de un producto
+// @Description Devuelve el archivo del modelo 3D tal como se subió
+// @Tags productos
+// @Produce application/octet-stream
+// @Param id path string true "ID del producto"
+// @Success 200 {file} file
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /productos/{id}/modelo [get]
+func getProductModel(c *gin.Context) {
+	modelo, err := repo.ObtenerModelo(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+
+	nombre := modelo.NombreArchivo
+	if nombre == "" {
+		nombre = modelo.ProductoID + "." + string(modelo.Formato)
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", nombre))
+	c.Header("ETag", `"`+modelo.SHA256+`"`)
+	c.Data(http.StatusOK, tiposContenidoModelo[modelo.Formato], modelo.Datos)
+}
+
+// leerArchivoModelo obtiene el archivo del campo multipart "archivo" o, si la
+// petición no es multipart, del cuerpo completo
+func leerArchivoModelo(c *gin.Context) (string, []byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, tamanoMaximoModelo())
+
+	if c.ContentType() == "multipart/form-data" {
+		fh, err := c.FormFile("archivo")
+		if err != nil {
+			return "", nil, err
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return "", nil, err
+		}
+		defer f.Close()
+		datos, err := io.ReadAll(f)
+		return fh.Filename, datos, err
+	}
+
+	datos, err := io.ReadAll(c.Request.Body)
+	if err == nil && len(datos) == 0 {
+		err = errors.New("no se recibió ningún archivo")
+	}
+	return c.Query("nombre"), datos, err
+}
+
+// aplicarGeometria copia al producto las medidas de la malla. La caja
+// envolvente reemplaza las dimensiones: X es el ancho, Y la profundidad y Z
+// la altura, como en la cama de una impresora
+func aplicarGeometria(p *Producto, formato malla.Formato, g malla.Geometria) {
+	tamano := g.Tamano()
+	p.Dimensiones = dominio.Dimensiones{
+		Ancho:    tamano.X,
+		Alto:     tamano.Z,
+		Profundo: tamano.Y,
+		Unidad:   dominio.UnidadMilimetro,
+	}
+	p.Geometria = Geometria{
+		Formato:    formato,
+		Volumen:    g.Volumen,
+		Area:       g.Area,
+		Triangulos: g.Triangulos,
+	}
+}
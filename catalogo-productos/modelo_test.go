@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	dominio "catalogo-dominio"
+	"catalogo-productos/malla"
+)
+
+// trianguloSuelto es un STL ASCII con un solo triángulo: una malla abierta,
+// no imprimible
+const trianguloSuelto = `solid suelto
+facet normal 0 0 1
+outer loop
+vertex 0 0 0
+vertex 10 0 0
+vertex 0 10 0
+endloop
+endfacet
+endsolid suelto
+`
+
+// TestAnalizarModeloNoImprimible guarda un modelo no imprimible marcado como
+// imprimible, como los analizados con una versión anterior del análisis, y
+// comprueba que el nuevo análisis no deja un producto disponible con él
+func TestAnalizarModeloNoImprimible(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	ctx := context.Background()
+	p := crearProductoPrueba(t, srv, "Jarrón", crearCategoriaPrueba(t, srv, "Decoración"))
+	p.Geometria.Formato = malla.FormatoSTL
+	p.Imprimibilidad = InformeImprimibilidad{Cuerpos: 1, Imprimible: true}
+	modelo := ModeloProducto{ProductoID: p.ID, Formato: malla.FormatoSTL, Datos: []byte(trianguloSuelto)}
+	if err := repo.GuardarModelo(ctx, &p, &modelo, p.Version); err != nil {
+		t.Fatal(err)
+	}
+
+	ruta := "/api/v1/productos/" + p.ID + "/modelo/analisis"
+	if estado, _, cuerpo := peticion(t, srv, http.MethodPost, ruta, nil); estado != http.StatusConflict {
+		t.Fatalf("analizar con el producto disponible: %d %s, se esperaba 409", estado, cuerpo)
+	}
+	if actual, err := repo.Obtener(ctx, p.ID); err != nil || actual.Version != p.Version || !actual.Imprimibilidad.Imprimible {
+		t.Errorf("el análisis rechazado cambió el producto: %+v, %v", actual, err)
+	}
+
+	// Retirado de la venta, el análisis se guarda
+	p.Estado = dominio.EstadoAgotado
+	if err := repo.Actualizar(ctx, &p, p.Version); err != nil {
+		t.Fatal(err)
+	}
+	estado, _, cuerpo := peticion(t, srv, http.MethodPost, ruta, nil)
+	if estado != http.StatusOK {
+		t.Fatalf("analizar con el producto agotado: %d %s", estado, cuerpo)
+	}
+	if informe := datos[InformeImprimibilidad](t, cuerpo); informe.Imprimible || informe.AristasAbiertas != 3 {
+		t.Errorf("informe: %+v", informe)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+
+	"catalogo-comun/listado"
+	dominio "catalogo-dominio"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Papelera de productos
+// @Summary Listar la papelera de productos
+// @Description Obtiene una página de productos eliminados que aún no se han purgado. Admite los mismos filtros, paginación, orden y selección de campos que el listado de productos
+// @Tags productos
+// @Produce json
+// @Param categoria_id query string false "ID de la categoría de los productos"
+// @Param etiqueta query string false "Etiquetas que deben tener todos los productos, repetible o separadas por coma"
+// @Param atributo.{codigo} query string false "Valor exacto de un atributo; los numéricos admiten atributo.{codigo}.min y atributo.{codigo}.max"
+// @Param componente query string false "ID de un producto; devuelve los kits que lo incluyen"
+// @Param dimensiones.{eje}.min query string false "Dimensión mínima (eje ancho, alto o profundo), en ?unidad= o con sufijo de unidad, p. ej. 2in"
+// @Param dimensiones.{eje}.max query string false "Dimensión máxima, como dimensiones.{eje}.min"
+// @Param page query int false "Número de página, empieza en 1"
+// @Param page_size query int false "Tamaño de página (máximo 100)"
+// @Param cursor query string false "Cursor devuelto en meta.next_cursor o meta.prev_cursor"
+// @Param sort query string false "Campos de orden separados por coma, con - para descendente"
+// @Param fields query string false "Campos a incluir separados por coma"
+// @Param moneda query string false "Código ISO 4217 al que convertir los precios con la tasa vigente, p. ej. EUR"
+// @Param unidad query string false "Unidad de las dimensiones: mm (por defecto), cm o in"
+// @Param Accept-Language header string false "Idiomas preferidos para nombre y descripción, p. ej. en-US,en;q=0.9"
+// @Success 200 {object} dominio.Pagina{data=[]Producto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /productos/papelera [get]
+func getProductosPapelera(c *gin.Context) {
+	params, err := listado.Parse(c, ordenablesProductos, camposProductos)
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	etiquetas, atributos, err := parseFiltrosProducto(c.Request.Context(), c.Request.URL.Query())
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dimensiones, err := dominio.ParseFiltrosDimensiones(c.Request.URL.Query())
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	consulta := ConsultaProductos{Etiquetas: etiquetas, Atributos: atributos, Dimensiones: dimensiones, Componente: c.Query("componente"), Orden: params.Orden, Ventana: params.Ventana}
+	if id := c.Query("categoria_id"); id != "" {
+		consulta.Categorias = []string{id}
+	}
+
+	productos, res, err := repo.ListarPapelera(c.Request.Context(), consulta)
+	if err != nil {
+		responderMensaje(c, http.StatusInternalServerError, traducir(c, "Error al obtener la papelera"))
+		return
+	}
+	if err := completarKits(c.Request.Context(), productos); err != nil {
+		responderError(c, err)
+		return
+	}
+	if !convertirPrecios(c, productos) || !convertirDimensiones(c, productos) {
+		return
+	}
+	localizarProductos(c, productos)
+
+	responderPagina(c, productos, res, params.Ventana, params.Campos)
+}
+
+// Restaurar un producto
+// @Summary Restaurar un producto de la papelera
+// @Description Devuelve al catálogo un producto eliminado. Falla con 422 si otro producto usa ya su SKU
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param Idempotency-Key header string false "Clave única de la operación; un reintento con la misma clave y la misma petición devuelve la respuesta guardada"
+// @Success 200 {object} dominio.Respuesta{data=Producto}
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /productos/{id}/restaurar [post]
+func restoreProduct(c *gin.Context) {
+	producto, err := repo.Restaurar(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	responderProductoGuardado(c, http.StatusOK, producto)
+}
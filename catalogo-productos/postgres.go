@@ -0,0 +1,864 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"catalogo-comun/idempotencia"
+	"catalogo-comun/listado"
+	dominio "catalogo-dominio"
+	"catalogo-dominio/dinero"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// repositorioPostgres persiste los productos en PostgreSQL usando GORM. Las
+// claves de idempotencia van en la tabla idempotencia de la misma base
+type repositorioPostgres struct {
+	idempotencia.SQL
+	db *gorm.DB
+}
+
+func newRepositorioPostgres(db *gorm.DB) *repositorioPostgres {
+	return &repositorioPostgres{SQL: idempotencia.SQL{DB: db}, db: db}
+}
+
+func (r *repositorioPostgres) Listar(ctx context.Context, consulta ConsultaProductos) ([]Producto, listado.Resultado, error) {
+	q := r.db.WithContext(ctx).Model(&Producto{})
+	q = filtrarSQL(q, consulta)
+	// La misma consulta base se reutiliza para contar y para paginar
+	q = q.Session(&gorm.Session{})
+
+	var res listado.Resultado
+	if err := q.Count(&res.Total).Error; err != nil {
+		return nil, res, err
+	}
+
+	productos, err := listado.SQL(q, consulta.Orden, consulta.Ventana, ordenablesProductos, columnasOrden, &res)
+	if err != nil {
+		return nil, res, err
+	}
+	return productos, res, nil
+}
+
+func (r *repositorioPostgres) Obtener(ctx context.Context, id string) (Producto, error) {
+	var producto Producto
+	err := r.db.WithContext(ctx).First(&producto, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Producto{}, ErrProductoNoEncontrado
+	}
+	return producto, err
+}
+
+func (r *repositorioPostgres) Crear(ctx context.Context, producto *Producto) error {
+	producto.Version = 1
+	producto.EliminadoEn = gorm.DeletedAt{}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := comprobarKitSQL(tx, *producto); err != nil {
+			return err
+		}
+		if err := tx.Create(producto).Error; err != nil {
+			return traducirErrorProducto(err)
+		}
+		if err := registrarRevision(ctx, tx, nil, *producto); err != nil {
+			return err
+		}
+		return registrarTransicion(tx, producto.ID, "", producto.Estado)
+	})
+}
+
+func (r *repositorioPostgres) Actualizar(ctx context.Context, producto *Producto, version int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return r.actualizar(ctx, tx, producto, version)
+	})
+}
+
+// actualizar escribe el producto dentro de una transacción ya abierta
+func (r *repositorioPostgres) actualizar(ctx context.Context, tx *gorm.DB, producto *Producto, version int) error {
+	// Se lee el producto completo para calcular la revisión, bloqueado para
+	// que comprobarKitSQL vea los kits que lo usan como componente
+	var anterior Producto
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&anterior, "id = ?", producto.ID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrProductoNoEncontrado
+	}
+	if err != nil {
+		return err
+	}
+	if version != 0 && anterior.Version != version {
+		return ErrConflictoVersion
+	}
+	if err := comprobarKitSQL(tx, *producto); err != nil {
+		return err
+	}
+	if err := asegurarRevisionBase(tx, anterior); err != nil {
+		return err
+	}
+
+	// La condición sobre version evita perder escrituras concurrentes
+	// entre la lectura anterior y esta actualización
+	producto.Version = anterior.Version + 1
+	producto.EliminadoEn = gorm.DeletedAt{}
+	res := tx.Model(&Producto{}).
+		Where("id = ? AND version = ?", producto.ID, anterior.Version).
+		Select("*").
+		Updates(producto)
+	if res.Error != nil {
+		return traducirErrorProducto(res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrConflictoVersion
+	}
+	if err := registrarRevision(ctx, tx, &anterior, *producto); err != nil {
+		return err
+	}
+	if anterior.Estado != producto.Estado {
+		return registrarTransicion(tx, producto.ID, anterior.Estado, producto.Estado)
+	}
+	return nil
+}
+
+func (r *repositorioPostgres) GuardarModelo(ctx context.Context, producto *Producto, modelo *ModeloProducto, version int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := r.actualizar(ctx, tx, producto, version); err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(modelo).Error
+	})
+}
+
+func (r *repositorioPostgres) ObtenerModelo(ctx context.Context, id string) (ModeloProducto, error) {
+	var modelo ModeloProducto
+	err := r.db.WithContext(ctx).First(&modelo, "producto_id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ModeloProducto{}, ErrModeloNoEncontrado
+	}
+	return modelo, err
+}
+
+func (r *repositorioPostgres) Eliminar(ctx context.Context, id string, version int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// El bloqueo hace esperar a los kits que se están guardando con este
+		// producto como componente, así que el recuento siguiente los ve
+		actual, err := r.leerActual(tx.Clauses(clause.Locking{Strength: "UPDATE"}), id)
+		if err != nil {
+			return err
+		}
+		if version != 0 && actual.Version != version {
+			return ErrConflictoVersion
+		}
+
+		// Los kits de la papelera también cuentan: se pueden restaurar
+		var enKit int64
+		if err := filtrarSQL(tx.Unscoped().Model(&Producto{}), ConsultaProductos{Componente: id}).Count(&enKit).Error; err != nil {
+			return err
+		}
+		if enKit > 0 {
+			return ErrProductoEnKit
+		}
+
+		// Con gorm.DeletedAt el borrado solo marca eliminado_en
+		res := tx.Delete(&Producto{}, "id = ? AND version = ?", id, actual.Version)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrConflictoVersion
+		}
+		return nil
+	})
+}
+
+func (r *repositorioPostgres) ListarPapelera(ctx context.Context, consulta ConsultaProductos) ([]Producto, listado.Resultado, error) {
+	q := r.db.WithContext(ctx).Unscoped().Model(&Producto{}).Where("eliminado_en IS NOT NULL")
+	q = filtrarSQL(q, consulta)
+	q = q.Session(&gorm.Session{})
+
+	var res listado.Resultado
+	if err := q.Count(&res.Total).Error; err != nil {
+		return nil, res, err
+	}
+
+	productos, err := listado.SQL(q, consulta.Orden, consulta.Ventana, ordenablesProductos, columnasOrden, &res)
+	if err != nil {
+		return nil, res, err
+	}
+	return productos, res, nil
+}
+
+func (r *repositorioPostgres) Restaurar(ctx context.Context, id string) (Producto, error) {
+	var producto Producto
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Unscoped().Model(&Producto{}).
+			Where("id = ? AND eliminado_en IS NOT NULL", id).
+			Updates(map[string]any{"eliminado_en": nil, "version": gorm.Expr("version + 1")})
+		if res.Error != nil {
+			return traducirErrorProducto(res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrProductoNoEncontrado
+		}
+		if err := tx.First(&producto, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := comprobarKitSQL(tx, producto); err != nil {
+			return err
+		}
+		eliminado := producto
+		eliminado.Version--
+		if err := asegurarRevisionBase(tx, eliminado); err != nil {
+			return err
+		}
+		// Restaurar no cambia el contenido, pero la revisión deja constancia de quién lo hizo
+		return registrarRevision(ctx, tx, &producto, producto)
+	})
+	return producto, err
+}
+
+func (r *repositorioPostgres) Purgar(ctx context.Context, limite time.Time) (int64, error) {
+	// El historial, las revisiones, el modelo, las relaciones y los adjuntos se
+	// borran en cascada; sus archivos los recoge el barrido del almacén
+	res := r.db.WithContext(ctx).Unscoped().Where("eliminado_en < ?", limite).Delete(&Producto{})
+	return res.RowsAffected, res.Error
+}
+
+func (r *repositorioPostgres) Programados(ctx context.Context, hasta time.Time) ([]Producto, error) {
+	var productos []Producto
+	err := r.db.WithContext(ctx).
+		Where("(estado = ? AND publicar_en <= ?) OR precio_desde <= ?", dominio.EstadoBorrador, hasta, hasta).
+		Order("id").
+		Find(&productos).Error
+	return productos, err
+}
+
+func (r *repositorioPostgres) Historial(ctx context.Context, id string) ([]TransicionEstado, error) {
+	if _, err := r.leerActual(r.db.WithContext(ctx), id); err != nil {
+		return nil, err
+	}
+	var historial []TransicionEstado
+	err := r.db.WithContext(ctx).Where("producto_id = ?", id).Order("fecha, id").Find(&historial).Error
+	return historial, err
+}
+
+func (r *repositorioPostgres) Revisiones(ctx context.Context, id string) ([]Revision, error) {
+	if _, err := r.leerActual(r.db.WithContext(ctx), id); err != nil {
+		return nil, err
+	}
+	var revisiones []Revision
+	err := r.db.WithContext(ctx).Where("producto_id = ?", id).Order("numero").Find(&revisiones).Error
+	return revisiones, err
+}
+
+func (r *repositorioPostgres) Revision(ctx context.Context, id string, numero int) (Revision, error) {
+	if _, err := r.leerActual(r.db.WithContext(ctx), id); err != nil {
+		return Revision{}, err
+	}
+	var revision Revision
+	err := r.db.WithContext(ctx).First(&revision, "producto_id = ? AND numero = ?", id, numero).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Revision{}, ErrRevisionNoEncontrada
+	}
+	return revision, err
+}
+
+func (r *repositorioPostgres) BuscarPorSKU(ctx context.Context, skus []string) (map[string]Producto, error) {
+	encontrados := make(map[string]Producto)
+	if len(skus) == 0 {
+		return encontrados, nil
+	}
+	var productos []Producto
+	if err := r.db.WithContext(ctx).Where("sku IN ?", skus).Find(&productos).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range productos {
+		encontrados[p.SKU] = p
+	}
+	return encontrados, nil
+}
+
+func (r *repositorioPostgres) BuscarPorID(ctx context.Context, ids []string) (map[string]Producto, error) {
+	encontrados := make(map[string]Producto)
+	if len(ids) == 0 {
+		return encontrados, nil
+	}
+	var productos []Producto
+	if err := r.db.WithContext(ctx).Where("id IN ?", ids).Find(&productos).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range productos {
+		encontrados[p.ID] = p
+	}
+	return encontrados, nil
+}
+
+func (r *repositorioPostgres) Importar(ctx context.Context, crear []*Producto, actualizar []ActualizacionProducto) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, p := range crear {
+			p.Version = 1
+			p.EliminadoEn = gorm.DeletedAt{}
+			if err := comprobarKitSQL(tx, *p); err != nil {
+				return err
+			}
+			if err := tx.Create(p).Error; err != nil {
+				return traducirErrorProducto(err)
+			}
+			if err := registrarRevision(ctx, tx, nil, *p); err != nil {
+				return err
+			}
+			if err := registrarTransicion(tx, p.ID, "", p.Estado); err != nil {
+				return err
+			}
+		}
+		for _, a := range actualizar {
+			if err := r.actualizar(ctx, tx, a.Producto, a.Version); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *repositorioPostgres) AplicarLote(ctx context.Context, ops []OperacionLote) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Dentro de la transacción las transacciones de cada escritura son
+		// puntos de guardado, así que se reutilizan los métodos de siempre
+		enLote := newRepositorioPostgres(tx)
+		for i, op := range ops {
+			if err := enLote.aplicarOperacion(ctx, op); err != nil {
+				return ErrorOperacion{Indice: i, Err: err}
+			}
+		}
+		return nil
+	})
+}
+
+func (r *repositorioPostgres) aplicarOperacion(ctx context.Context, op OperacionLote) error {
+	switch op.Recurso {
+	case RecursoProductos:
+		switch op.Tipo {
+		case OperacionCrear:
+			return r.Crear(ctx, op.Producto)
+		case OperacionActualizar:
+			return r.Actualizar(ctx, op.Producto, op.Version)
+		case OperacionEliminar:
+			return r.Eliminar(ctx, op.ID, op.Version)
+		}
+	case RecursoCategorias:
+		switch op.Tipo {
+		case OperacionCrear:
+			return r.CrearCategoria(ctx, op.Categoria)
+		case OperacionActualizar:
+			return r.ActualizarCategoria(ctx, op.Categoria, op.Version)
+		case OperacionEliminar:
+			return r.EliminarCategoria(ctx, op.ID, op.Version)
+		}
+	}
+	return fmt.Errorf("operación de lote no soportada: %s %s", op.Tipo, op.Recurso)
+}
+
+func (r *repositorioPostgres) ListarRelaciones(ctx context.Context, id string) ([]Relacion, error) {
+	if _, err := r.leerActual(r.db.WithContext(ctx), id); err != nil {
+		return nil, err
+	}
+	// Se descartan las relaciones cuyo otro extremo está en la papelera
+	var relaciones []Relacion
+	err := r.db.WithContext(ctx).
+		Select("producto_relaciones.*").
+		Joins("JOIN productos otro ON otro.id = CASE WHEN producto_relaciones.producto_id = ? THEN producto_relaciones.destino_id ELSE producto_relaciones.producto_id END", id).
+		Where("(producto_relaciones.producto_id = ? OR producto_relaciones.destino_id = ?) AND otro.eliminado_en IS NULL", id, id).
+		Order("producto_relaciones.creada_en, producto_relaciones.id").
+		Find(&relaciones).Error
+	return relaciones, err
+}
+
+func (r *repositorioPostgres) CrearRelacion(ctx context.Context, relacion *Relacion) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := r.leerActual(tx, relacion.ProductoID); err != nil {
+			return err
+		}
+		_, err := r.leerActual(tx, relacion.DestinoID)
+		if errors.Is(err, ErrProductoNoEncontrado) {
+			return dominio.ErroresValidacion{{Campo: "destino_id", Mensaje: "no existe"}}
+		}
+		if err != nil {
+			return err
+		}
+		err = tx.Create(relacion).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrRelacionDuplicada
+		}
+		return err
+	})
+}
+
+func (r *repositorioPostgres) EliminarRelacion(ctx context.Context, id, relacionID string) error {
+	if _, err := r.leerActual(r.db.WithContext(ctx), id); err != nil {
+		return err
+	}
+	res := r.db.WithContext(ctx).Delete(&Relacion{}, "id = ? AND (producto_id = ? OR destino_id = ?)", relacionID, id, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrRelacionNoEncontrada
+	}
+	return nil
+}
+
+func (r *repositorioPostgres) ListarAdjuntos(ctx context.Context, id string) ([]Adjunto, error) {
+	return r.listarAdjuntos(r.db.WithContext(ctx), id)
+}
+
+func (r *repositorioPostgres) listarAdjuntos(tx *gorm.DB, id string) ([]Adjunto, error) {
+	if _, err := r.leerActual(tx, id); err != nil {
+		return nil, err
+	}
+	var adjuntos []Adjunto
+	err := tx.Where("producto_id = ?", id).Order("orden, subido_en, id").Find(&adjuntos).Error
+	return adjuntos, err
+}
+
+func (r *repositorioPostgres) ObtenerAdjunto(ctx context.Context, id, adjuntoID string) (Adjunto, error) {
+	if _, err := r.leerActual(r.db.WithContext(ctx), id); err != nil {
+		return Adjunto{}, err
+	}
+	var adjunto Adjunto
+	err := r.db.WithContext(ctx).First(&adjunto, "id = ? AND producto_id = ?", adjuntoID, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Adjunto{}, ErrAdjuntoNoEncontrado
+	}
+	return adjunto, err
+}
+
+func (r *repositorioPostgres) CrearAdjunto(ctx context.Context, adjunto *Adjunto) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := bloquearProducto(tx, adjunto.ProductoID); err != nil {
+			return err
+		}
+		if err := tx.Model(&Adjunto{}).Where("producto_id = ?", adjunto.ProductoID).
+			Select("COALESCE(MAX(orden), 0) + 1").Scan(&adjunto.Orden).Error; err != nil {
+			return err
+		}
+		err := tx.Create(adjunto).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrAdjuntoDuplicado
+		}
+		return err
+	})
+}
+
+func (r *repositorioPostgres) EliminarAdjunto(ctx context.Context, id, adjuntoID string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := bloquearProducto(tx, id); err != nil {
+			return err
+		}
+		var adjunto Adjunto
+		err := tx.First(&adjunto, "id = ? AND producto_id = ?", adjuntoID, id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAdjuntoNoEncontrado
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&adjunto).Error; err != nil {
+			return err
+		}
+		// Los siguientes adjuntos suben un puesto para que la galería no tenga huecos
+		return tx.Model(&Adjunto{}).Where("producto_id = ? AND orden > ?", id, adjunto.Orden).
+			Update("orden", gorm.Expr("orden - 1")).Error
+	})
+}
+
+func (r *repositorioPostgres) OrdenarAdjuntos(ctx context.Context, id string, ids []string) ([]Adjunto, error) {
+	var adjuntos []Adjunto
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := bloquearProducto(tx, id); err != nil {
+			return err
+		}
+		actuales, err := r.listarAdjuntos(tx, id)
+		if err != nil {
+			return err
+		}
+		if errs := validarOrdenAdjuntos(actuales, ids); len(errs) > 0 {
+			return errs
+		}
+		for i, adjuntoID := range ids {
+			if err := tx.Model(&Adjunto{}).Where("id = ?", adjuntoID).Update("orden", i+1).Error; err != nil {
+				return err
+			}
+		}
+		adjuntos, err = r.listarAdjuntos(tx, id)
+		return err
+	})
+	return adjuntos, err
+}
+
+func (r *repositorioPostgres) AdjuntoEnUso(ctx context.Context, sha string) (bool, error) {
+	var n int64
+	err := r.db.WithContext(ctx).Model(&Adjunto{}).Where("sha256 = ?", sha).Limit(1).Count(&n).Error
+	return n > 0, err
+}
+
+func (r *repositorioPostgres) ListarTasas(ctx context.Context) ([]dinero.TasaCambio, error) {
+	var tasas []dinero.TasaCambio
+	err := r.db.WithContext(ctx).Find(&tasas).Error
+	return tasas, err
+}
+
+func (r *repositorioPostgres) CrearTasa(ctx context.Context, tasa *dinero.TasaCambio) error {
+	err := r.db.WithContext(ctx).Create(tasa).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrTasaDuplicada
+	}
+	return err
+}
+
+func (r *repositorioPostgres) EliminarTasa(ctx context.Context, id string) error {
+	res := r.db.WithContext(ctx).Delete(&dinero.TasaCambio{}, "id = ?", id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrTasaNoEncontrada
+	}
+	return nil
+}
+
+func (r *repositorioPostgres) ListarAtributos(ctx context.Context) ([]DefinicionAtributo, error) {
+	var definiciones []DefinicionAtributo
+	err := r.db.WithContext(ctx).Find(&definiciones).Error
+	return definiciones, err
+}
+
+func (r *repositorioPostgres) ObtenerAtributo(ctx context.Context, codigo string) (DefinicionAtributo, error) {
+	var def DefinicionAtributo
+	err := r.db.WithContext(ctx).First(&def, "codigo = ?", codigo).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return DefinicionAtributo{}, ErrAtributoNoEncontrado
+	}
+	return def, err
+}
+
+func (r *repositorioPostgres) CrearAtributo(ctx context.Context, def *DefinicionAtributo) error {
+	def.Version = 1
+	err := r.db.WithContext(ctx).Create(def).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrAtributoDuplicado
+	}
+	return err
+}
+
+func (r *repositorioPostgres) ActualizarAtributo(ctx context.Context, def *DefinicionAtributo, version int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var anterior DefinicionAtributo
+		err := tx.Select("version").First(&anterior, "codigo = ?", def.Codigo).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAtributoNoEncontrado
+		}
+		if err != nil {
+			return err
+		}
+		if version != 0 && anterior.Version != version {
+			return ErrConflictoVersion
+		}
+
+		def.Version = anterior.Version + 1
+		res := tx.Model(&DefinicionAtributo{}).
+			Where("codigo = ? AND version = ?", def.Codigo, anterior.Version).
+			Select("*").
+			Updates(def)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrConflictoVersion
+		}
+		return nil
+	})
+}
+
+func (r *repositorioPostgres) EliminarAtributo(ctx context.Context, codigo string, version int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var actual DefinicionAtributo
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&actual, "codigo = ?", codigo).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAtributoNoEncontrado
+		}
+		if err != nil {
+			return err
+		}
+		if version != 0 && actual.Version != version {
+			return ErrConflictoVersion
+		}
+
+		// Los productos de la papelera también cuentan: se pueden restaurar
+		var enUso int64
+		if err := tx.Unscoped().Model(&Producto{}).Where("atributos -> ? IS NOT NULL", codigo).Count(&enUso).Error; err != nil {
+			return err
+		}
+		if enUso > 0 {
+			return ErrAtributoEnUso
+		}
+		return tx.Delete(&DefinicionAtributo{}, "codigo = ?", codigo).Error
+	})
+}
+
+func (r *repositorioPostgres) ListarCategorias(ctx context.Context) ([]Categoria, error) {
+	var categorias []Categoria
+	err := r.db.WithContext(ctx).Find(&categorias).Error
+	return categorias, err
+}
+
+func (r *repositorioPostgres) ObtenerCategoria(ctx context.Context, id string) (Categoria, error) {
+	var categoria Categoria
+	err := r.db.WithContext(ctx).First(&categoria, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Categoria{}, ErrCategoriaNoEncontrada
+	}
+	return categoria, err
+}
+
+func (r *repositorioPostgres) CrearCategoria(ctx context.Context, categoria *Categoria) error {
+	categoria.Version = 1
+	return traducirErrorCategoria(r.db.WithContext(ctx).Create(categoria).Error)
+}
+
+func (r *repositorioPostgres) ActualizarCategoria(ctx context.Context, categoria *Categoria, version int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var anterior Categoria
+		err := tx.Select("version").First(&anterior, "id = ?", categoria.ID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCategoriaNoEncontrada
+		}
+		if err != nil {
+			return err
+		}
+		if version != 0 && anterior.Version != version {
+			return ErrConflictoVersion
+		}
+		if err := comprobarCicloSQL(tx, *categoria); err != nil {
+			return err
+		}
+
+		categoria.Version = anterior.Version + 1
+		res := tx.Model(&Categoria{}).
+			Where("id = ? AND version = ?", categoria.ID, anterior.Version).
+			Select("*").
+			Updates(categoria)
+		if res.Error != nil {
+			return traducirErrorCategoria(res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrConflictoVersion
+		}
+		return nil
+	})
+}
+
+// comprobarCicloSQL comprueba dentro de la transacción que el nuevo padre de
+// la categoría no es ella misma ni una de sus descendientes. El bloqueo de la
+// tabla, que no impide leerla, serializa los cambios de categorías hasta el
+// final de la transacción: sin él, dos movimientos simultáneos, X bajo Y e Y
+// bajo X, pasarían la comprobación a la vez y cerrarían un ciclo
+func comprobarCicloSQL(tx *gorm.DB, c Categoria) error {
+	if c.PadreID == nil {
+		return nil
+	}
+	if err := tx.Exec("LOCK TABLE categorias IN SHARE ROW EXCLUSIVE MODE").Error; err != nil {
+		return err
+	}
+	var ciclo bool
+	err := tx.Raw(`WITH RECURSIVE ancestros AS (
+			SELECT id, padre_id FROM categorias WHERE id = ?
+			UNION
+			SELECT c.id, c.padre_id FROM categorias c JOIN ancestros a ON c.id = a.padre_id
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestros WHERE id = ?)`, *c.PadreID, c.ID).Scan(&ciclo).Error
+	if err != nil {
+		return err
+	}
+	if ciclo {
+		return errCicloCategoria()
+	}
+	return nil
+}
+
+func (r *repositorioPostgres) EliminarCategoria(ctx context.Context, id string, version int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var actual Categoria
+		err := tx.Select("version").First(&actual, "id = ?", id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCategoriaNoEncontrada
+		}
+		if err != nil {
+			return err
+		}
+		if version != 0 && actual.Version != version {
+			return ErrConflictoVersion
+		}
+
+		// Las claves foráneas de productos y subcategorías impiden borrarla si está en uso
+		res := tx.Delete(&Categoria{}, "id = ? AND version = ?", id, actual.Version)
+		if errors.Is(res.Error, gorm.ErrForeignKeyViolated) {
+			return ErrCategoriaEnUso
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrConflictoVersion
+		}
+		return nil
+	})
+}
+
+// traducirErrorProducto convierte la violación de la clave foránea de
+// categoria_id y la del índice único del SKU en errores de validación
+func traducirErrorProducto(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return errCategoriaInexistente()
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return errSKUEnUso()
+	}
+	return err
+}
+
+// traducirErrorCategoria convierte las violaciones del índice único del slug
+// y de la clave foránea del padre en los errores del repositorio
+func traducirErrorCategoria(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrSlugDuplicado
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return dominio.ErroresValidacion{{Campo: "padre_id", Mensaje: "no existe"}}
+	}
+	return err
+}
+
+// leerActual lee la versión y el estado almacenados de un producto
+func (r *repositorioPostgres) leerActual(tx *gorm.DB, id string) (Producto, error) {
+	var producto Producto
+	err := tx.Select("version", "estado").First(&producto, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Producto{}, ErrProductoNoEncontrado
+	}
+	return producto, err
+}
+
+// comprobarKitSQL vuelve a comprobar los componentes de un kit dentro de la
+// transacción que lo escribe. Los componentes quedan bloqueados con FOR SHARE
+// hasta el final, para que nadie los borre ni los convierta en kits mientras
+// tanto; Eliminar y actualizar bloquean su producto antes de buscar los kits
+// que lo usan, así que esperan a que el kit se guarde
+func comprobarKitSQL(tx *gorm.DB, p Producto) error {
+	if !p.esKit() {
+		return nil
+	}
+	var encontrados []Producto
+	err := tx.Clauses(clause.Locking{Strength: "SHARE"}).
+		Where("id IN ?", idsComponentes([]Producto{p})).
+		Find(&encontrados).Error
+	if err != nil {
+		return err
+	}
+	componentes := make(map[string]Producto, len(encontrados))
+	for _, c := range encontrados {
+		componentes[c.ID] = c
+	}
+	var enKit int64
+	if err := filtrarSQL(tx.Model(&Producto{}), ConsultaProductos{Componente: p.ID}).Count(&enKit).Error; err != nil {
+		return err
+	}
+	return comprobarComponentes(p, componentes, enKit > 0)
+}
+
+// bloquearProducto bloquea la fila del producto hasta el final de la
+// transacción; serializa los cambios en la galería de adjuntos
+func bloquearProducto(tx *gorm.DB, id string) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&Producto{}, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrProductoNoEncontrado
+	}
+	return err
+}
+
+func registrarTransicion(tx *gorm.DB, id string, desde, hacia dominio.EstadoProducto) error {
+	return tx.Create(&TransicionEstado{
+		ProductoID: id,
+		Desde:      desde,
+		Hacia:      hacia,
+		Fecha:      time.Now().UTC(),
+	}).Error
+}
+
+// asegurarRevisionBase guarda la revisión base del producto si no tiene la de
+// su versión, como los creados antes de la migración 12, para que su estado
+// antes de la escritura que sigue se pueda consultar y revertir. El llamador
+// escribe el producto en la misma transacción y ya lo tiene bloqueado
+func asegurarRevisionBase(tx *gorm.DB, p Producto) error {
+	var n int64
+	if err := tx.Model(&Revision{}).Where("producto_id = ? AND numero = ?", p.ID, p.Version).Count(&n).Error; err != nil || n > 0 {
+		return err
+	}
+	revision, err := revisionBase(p)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&revision).Error
+}
+
+func registrarRevision(ctx context.Context, tx *gorm.DB, anterior *Producto, producto Producto) error {
+	revision, err := nuevaRevision(ctx, anterior, producto)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&revision).Error
+}
+
+// filtrarSQL aplica a la consulta los filtros de estado, categoría, etiquetas y
+// atributos. Las etiquetas y los atributos se comparan por contención JSONB
+// para aprovechar sus índices GIN
+func filtrarSQL(q *gorm.DB, consulta ConsultaProductos) *gorm.DB {
+	if len(consulta.Estados) > 0 {
+		q = q.Where("estado IN ?", consulta.Estados)
+	}
+	if len(consulta.Categorias) > 0 {
+		q = q.Where("categoria_id IN ?", consulta.Categorias)
+	}
+	if len(consulta.Etiquetas) > 0 {
+		etiquetas, _ := json.Marshal(consulta.Etiquetas)
+		q = q.Where("etiquetas @> ?::jsonb", string(etiquetas))
+	}
+	if consulta.Componente != "" {
+		componente, _ := json.Marshal([]map[string]string{{"producto_id": consulta.Componente}})
+		q = q.Where("componentes @> ?::jsonb", string(componente))
+	}
+	for _, f := range consulta.Atributos {
+		q = q.Where("atributos -> ? IS NOT NULL", f.Codigo)
+		if f.Valor != nil {
+			valor, _ := json.Marshal(map[string]any{f.Codigo: f.Valor})
+			q = q.Where("atributos @> ?::jsonb", string(valor))
+		}
+		if f.Min != nil {
+			q = q.Where("(atributos ->> ?)::numeric >= ?", f.Codigo, *f.Min)
+		}
+		if f.Max != nil {
+			q = q.Where("(atributos ->> ?)::numeric <= ?", f.Codigo, *f.Max)
+		}
+	}
+	// Eje viene de dominio.EjesDimensiones, así que se puede usar en el nombre de la columna
+	for _, f := range consulta.Dimensiones {
+		if f.Min != nil {
+			q = q.Where("dimensiones_"+f.Eje+" >= ?", *f.Min)
+		}
+		if f.Max != nil {
+			q = q.Where("dimensiones_"+f.Eje+" <= ?", *f.Max)
+		}
+	}
+	return q
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	dominio "catalogo-dominio"
+	"catalogo-dominio/dinero"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrTasaNoEncontrada se devuelve cuando no existe una tasa de cambio con el ID solicitado
+	ErrTasaNoEncontrada = errors.New("tasa de cambio no encontrada")
+	// ErrTasaDuplicada se devuelve al crear una tasa para un par de monedas
+	// que ya tiene otra con la misma fecha de vigencia
+	ErrTasaDuplicada = errors.New("tasa de cambio duplicada")
+)
+
+// completarMoneda asigna la moneda predeterminada a un precio que no la
+// indica, como los enviados con el formato numérico anterior. El precio
+// programado toma por defecto la moneda del precio base
+func completarMoneda(p *Producto) {
+	if p.PrecioBase.Moneda == "" {
+		p.PrecioBase.Moneda = dinero.MonedaPorDefecto()
+	}
+	if p.PrecioProgramado != nil && p.PrecioProgramado.Moneda == "" {
+		p.PrecioProgramado.Moneda = p.PrecioBase.Moneda
+	}
+}
+
+// convertirPrecios aplica ?moneda= a los productos con la tasa vigente en el
+// momento de la petición, al precio base y al programado, para que la
+// respuesta no mezcle monedas. Si no puede convertir responde el error y
+// devuelve false
+func convertirPrecios(c *gin.Context, productos []Producto) bool {
+	moneda := strings.ToUpper(c.Query("moneda"))
+	if moneda == "" {
+		return true
+	}
+	if !dinero.MonedaValida(moneda) {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "moneda debe ser un código ISO 4217 admitido")+": "+strings.Join(dinero.Monedas(), ", "))
+		return false
+	}
+
+	tasas, err := repo.ListarTasas(c.Request.Context())
+	if err != nil {
+		responderError(c, err)
+		return false
+	}
+	ahora := time.Now()
+	for i := range productos {
+		precio, err := dinero.Convertir(productos[i].PrecioBase, moneda, tasas, ahora)
+		if err != nil {
+			responderMensaje(c, http.StatusUnprocessableEntity, traducir(c, "No se puede convertir el precio")+": "+err.Error())
+			return false
+		}
+		productos[i].PrecioBase = precio
+		if p := productos[i].PrecioProgramado; p != nil {
+			programado, err := dinero.Convertir(*p, moneda, tasas, ahora)
+			if err != nil {
+				responderMensaje(c, http.StatusUnprocessableEntity, traducir(c, "No se puede convertir el precio")+": "+err.Error())
+				return false
+			}
+			productos[i].PrecioProgramado = &programado
+		}
+	}
+	return true
+}
+
+// Tasas de cambio
+// @Summary Listar las tasas de cambio
+// @Description Lista las tasas de cambio, las vigentes y las programadas, ordenadas por par de monedas y fecha de vigencia. Admite filtrar por desde y hacia
+// @Tags tasas-cambio
+// @Produce json
+// @Param desde query string false "Moneda de origen"
+// @Param hacia query string false "Moneda de destino"
+// @Success 200 {object} dominio.Respuesta{data=[]dinero.TasaCambio}
+// @Router /tasas-cambio [get]
+func getTasasCambio(c *gin.Context) {
+	tasas, err := repo.ListarTasas(c.Request.Context())
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+
+	desde, hacia := strings.ToUpper(c.Query("desde")), strings.ToUpper(c.Query("hacia"))
+	tasas = slices.DeleteFunc(tasas, func(t dinero.TasaCambio) bool {
+		return desde != "" && t.Desde != desde || hacia != "" && t.Hacia != hacia
+	})
+	slices.SortFunc(tasas, func(a, b dinero.TasaCambio) int {
+		if r := strings.Compare(a.Desde+a.Hacia, b.Desde+b.Hacia); r != 0 {
+			return r
+		}
+		return a.VigenteDesde.Compare(b.VigenteDesde)
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"data": tasas,
+	})
+}
+
+// Crear una tasa de cambio
+// @Summary Crear una tasa de cambio
+// @Description Registra cuántas unidades de hacia vale una unidad de desde a partir de vigente_desde (por defecto, ahora). La tasa sustituye a la anterior del mismo par desde esa fecha; para el par inverso se usa su inverso
+// @Tags tasas-cambio
+// @Accept json
+// @Produce json
+// @Param tasa body dinero.TasaCambio true "Tasa de cambio"
+// @Param Idempotency-Key header string false "Clave única de la operación; un reintento con la misma clave y la misma petición devuelve la respuesta guardada"
+// @Success 201 {object} dominio.Respuesta{data=dinero.TasaCambio}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 409 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /tasas-cambio [post]
+func createTasaCambio(c *gin.Context) {
+	var tasa dinero.TasaCambio
+	if err := c.ShouldBindJSON(&tasa); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tasa.ID = uuid.New().String()
+	tasa.Desde, tasa.Hacia = strings.ToUpper(tasa.Desde), strings.ToUpper(tasa.Hacia)
+	if tasa.VigenteDesde.IsZero() {
+		tasa.VigenteDesde = time.Now().UTC()
+	}
+	if errs := tasa.Validar(); len(errs) > 0 {
+		var errores dominio.ErroresValidacion
+		for _, campo := range []string{"desde", "hacia", "tasa", "vigente_desde"} {
+			if msg, ok := errs[campo]; ok {
+				errores.Agregar(campo, msg)
+			}
+		}
+		responderValidacion(c, "Tasa de cambio inválida", errores)
+		return
+	}
+
+	if err := repo.CrearTasa(c.Request.Context(), &tasa); err != nil {
+		responderError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"data": tasa,
+	})
+}
+
+// Eliminar una tasa de cambio
+// @Summary Eliminar una tasa de cambio
+// @Description Elimina una tasa; desde ese momento vuelve a aplicarse la anterior del mismo par
+// @Tags tasas-cambio
+// @Produce json
+// @Param id path string true "ID de la tasa"
+// @Success 200 {object} dominio.Mensaje
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /tasas-cambio/{id} [delete]
+func deleteTasaCambio(c *gin.Context) {
+	if err := repo.EliminarTasa(c.Request.Context(), c.Param("id")); err != nil {
+		responderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": traducir(c, "Tasa de cambio eliminada"),
+	})
+}
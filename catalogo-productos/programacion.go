@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	dominio "catalogo-dominio"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// intervaloProgramacion es cada cuánto se aplican las publicaciones y los
+	// cambios de precio programados; se aplican con hasta ese retraso
+	intervaloProgramacion = time.Minute
+	// actorProgramador es el actor de las revisiones que guarda el programador
+	actorProgramador = "programador"
+)
+
+// estadosPublicos son los estados de los productos que aparecen en los
+// listados públicos; los borradores solo se ven en /productos/borradores
+var estadosPublicos = []dominio.EstadoProducto{dominio.EstadoDisponible, dominio.EstadoAgotado, dominio.EstadoDescontinuado}
+
+// validarProgramacion aplica las reglas de la publicación y el cambio de
+// precio programados
+func validarProgramacion(p Producto, errs *dominio.ErroresValidacion) {
+	if p.PublicarEn != nil && p.Estado != dominio.EstadoBorrador {
+		errs.Agregar("publicar_en", "solo se aplica a los borradores")
+	}
+	switch {
+	case p.PrecioProgramado == nil && p.PrecioDesde == nil:
+		return
+	case p.PrecioProgramado == nil:
+		errs.Agregar("precio_programado", "es obligatorio con precio_desde")
+		return
+	case p.PrecioDesde == nil:
+		errs.Agregar("precio_desde", "es obligatoria con precio_programado")
+	}
+	if p.esKit() {
+		errs.Agregar("precio_programado", "no se aplica a los kits, su precio se calcula a partir de los componentes")
+		return
+	}
+	errsPrecio := p.PrecioProgramado.Validar(true)
+	for _, sub := range []string{"importe", "moneda"} {
+		if msg, ok := errsPrecio[sub]; ok {
+			errs.Agregar("precio_programado."+sub, msg)
+		}
+	}
+}
+
+// aplicarProgramacion aplica a un producto lo que tenga programado para
+// antes de ahora y devuelve si cambió. Un borrador que no se puede publicar,
+// porque su modelo 3D no es imprimible, sigue siendo borrador y pierde la
+// publicación programada para no reintentarla en cada pasada
+func aplicarProgramacion(p *Producto, ahora time.Time) bool {
+	cambio := false
+	if p.PrecioDesde != nil && !p.PrecioDesde.After(ahora) && p.PrecioProgramado != nil {
+		p.PrecioBase = *p.PrecioProgramado
+		p.PrecioProgramado, p.PrecioDesde = nil, nil
+		cambio = true
+	}
+	if p.PublicarEn != nil && !p.PublicarEn.After(ahora) && p.Estado == dominio.EstadoBorrador {
+		p.PublicarEn = nil
+		publicado := *p
+		publicado.Estado = dominio.EstadoDisponible
+		if err := validarImprimibilidad(dominio.EstadoBorrador, publicado); err != nil {
+			log.Printf("No se pudo publicar el producto %s: %v", p.ID, err)
+		} else {
+			p.Estado = dominio.EstadoDisponible
+		}
+		cambio = true
+	}
+	return cambio
+}
+
+// aplicarProgramados publica los borradores y cambia los precios cuya fecha
+// ha llegado. Cada producto se guarda con la versión leída: si otro cliente
+// lo modificó entretanto se vuelve a intentar en la siguiente pasada
+func aplicarProgramados(ctx context.Context) {
+	ctx = context.WithValue(ctx, claveActor{}, actorProgramador)
+	ahora := time.Now().UTC()
+	productos, err := repo.Programados(ctx, ahora)
+	if err != nil {
+		log.Printf("Error al leer los productos programados: %v", err)
+		return
+	}
+	for _, p := range productos {
+		if !aplicarProgramacion(&p, ahora) {
+			continue
+		}
+		if err := repo.Actualizar(ctx, &p, p.Version); err != nil {
+			log.Printf("Error al aplicar la programación del producto %s: %v", p.ID, err)
+		}
+	}
+}
+
+// iniciarProgramador aplica lo programado al arrancar y luego cada
+// intervaloProgramacion
+func iniciarProgramador() {
+	go func() {
+		for {
+			aplicarProgramados(context.Background())
+			time.Sleep(intervaloProgramacion)
+		}
+	}()
+}
+
+// Borradores de productos
+// @Summary Listar los borradores
+// @Description Obtiene una página de productos en estado borrador, que no aparecen en los listados públicos, con su publicación programada. Admite los mismos filtros, paginación, orden y selección de campos que el listado de productos
+// @Tags productos
+// @Produce json
+// @Param categoria_id query string false "ID de la categoría de los productos"
+// @Param subcategorias query bool false "Con categoria_id, incluir también los productos de sus subcategorías"
+// @Param etiqueta query string false "Etiquetas que deben tener todos los productos, repetible o separadas por coma"
+// @Param atributo.{codigo} query string false "Valor exacto de un atributo; los numéricos admiten atributo.{codigo}.min y atributo.{codigo}.max"
+// @Param componente query string false "ID de un producto; devuelve los kits que lo incluyen"
+// @Param dimensiones.{eje}.min query string false "Dimensión mínima (eje ancho, alto o profundo), en ?unidad= o con sufijo de unidad, p. ej. 2in"
+// @Param dimensiones.{eje}.max query string false "Dimensión máxima, como dimensiones.{eje}.min"
+// @Param page query int false "Número de página, empieza en 1"
+// @Param page_size query int false "Tamaño de página (máximo 100)"
+// @Param cursor query string false "Cursor devuelto en meta.next_cursor o meta.prev_cursor"
+// @Param sort query string false "Campos de orden separados por coma, con - para descendente"
+// @Param fields query string false "Campos a incluir separados por coma"
+// @Param moneda query string false "Código ISO 4217 al que convertir los precios con la tasa vigente, p. ej. EUR"
+// @Param unidad query string false "Unidad de las dimensiones: mm (por defecto), cm o in"
+// @Param Accept-Language header string false "Idiomas preferidos para nombre y descripción, p. ej. en-US,en;q=0.9"
+// @Success 200 {object} dominio.Pagina{data=[]Producto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /productos/borradores [get]
+func getProductosBorradores(c *gin.Context) {
+	listarProductos(c, c.Query("categoria_id"), []dominio.EstadoProducto{dominio.EstadoBorrador})
+}
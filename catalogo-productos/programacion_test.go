@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	dominio "catalogo-dominio"
+	"catalogo-dominio/dinero"
+	"catalogo-productos/malla"
+)
+
+func TestAplicarProgramacion(t *testing.T) {
+	ahora := time.Now().UTC()
+	antes, despues := ahora.Add(-time.Minute), ahora.Add(time.Minute)
+	precio := func(importe string) dinero.Dinero {
+		d, err := dinero.Nuevo(importe, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	nuevo := precio("8")
+
+	// Un cambio de precio futuro no se aplica todavía
+	p := Producto{Estado: dominio.EstadoDisponible, PrecioBase: precio("10"), PrecioProgramado: &nuevo, PrecioDesde: &despues}
+	if aplicarProgramacion(&p, ahora) || p.PrecioBase.String() != "10 USD" {
+		t.Errorf("precio futuro aplicado: %+v", p)
+	}
+	p.PrecioDesde = &antes
+	if !aplicarProgramacion(&p, ahora) || p.PrecioBase.String() != "8 USD" || p.PrecioProgramado != nil || p.PrecioDesde != nil {
+		t.Errorf("precio vencido: %+v", p)
+	}
+	if aplicarProgramacion(&p, ahora) {
+		t.Error("se volvió a aplicar un precio ya aplicado")
+	}
+
+	// La publicación solo se aplica a los borradores
+	p = Producto{Estado: dominio.EstadoBorrador, PublicarEn: &despues}
+	if aplicarProgramacion(&p, ahora) || p.Estado != dominio.EstadoBorrador {
+		t.Errorf("publicación futura aplicada: %+v", p)
+	}
+	p.PublicarEn = &antes
+	if !aplicarProgramacion(&p, ahora) || p.Estado != dominio.EstadoDisponible || p.PublicarEn != nil {
+		t.Errorf("publicación vencida: %+v", p)
+	}
+
+	// Un borrador con un modelo no imprimible sigue siendo borrador y pierde
+	// la publicación programada
+	p = Producto{Estado: dominio.EstadoBorrador, PublicarEn: &antes, Geometria: Geometria{Formato: malla.FormatoSTL}}
+	if !aplicarProgramacion(&p, ahora) || p.Estado != dominio.EstadoBorrador || p.PublicarEn != nil {
+		t.Errorf("borrador no imprimible: %+v", p)
+	}
+}
+
+// TestBorradoresProgramados comprueba que los borradores no salen en el
+// listado público hasta que el programador los publica
+func TestBorradoresProgramados(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	crear := func(nombre string, campos map[string]any) Producto {
+		cuerpo := productoPrueba(nombre, categoriaID)
+		for k, v := range campos {
+			cuerpo[k] = v
+		}
+		estado, _, respuesta := peticion(t, srv, http.MethodPost, "/api/v1/productos", cuerpo)
+		if estado != http.StatusCreated {
+			t.Fatalf("crear %s: %d %s", nombre, estado, respuesta)
+		}
+		return datos[Producto](t, respuesta)
+	}
+	vencido := time.Now().Add(-time.Minute)
+	publicado := crear("Jarrón", map[string]any{"precio_programado": map[string]any{"importe": "8", "moneda": "USD"}, "precio_desde": vencido})
+	pendiente := crear("Lámpara", map[string]any{"estado": "borrador", "publicar_en": vencido})
+	crear("Maceta", map[string]any{"estado": "borrador", "publicar_en": time.Now().Add(time.Hour)})
+	crear("Cuenco", map[string]any{"estado": "borrador"})
+
+	invalido := productoPrueba("Publicado", categoriaID)
+	invalido["publicar_en"] = vencido
+	if estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/productos", invalido); estado != http.StatusUnprocessableEntity || !slices.Equal(camposConError(t, cuerpo), []string{"publicar_en"}) {
+		t.Errorf("publicar_en en un producto publicado: %d %s", estado, cuerpo)
+	}
+
+	listados := func() (publicos, borradores []string) {
+		_, publicos = nombresProductos(t, srv, "")
+		_, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/productos/borradores?sort=nombre", nil)
+		for _, p := range datos[[]Producto](t, cuerpo) {
+			borradores = append(borradores, p.Nombre)
+		}
+		return publicos, borradores
+	}
+	if publicos, borradores := listados(); !slices.Equal(publicos, []string{"Jarrón"}) || !slices.Equal(borradores, []string{"Cuenco", "Lámpara", "Maceta"}) {
+		t.Errorf("antes de aplicar: públicos %v, borradores %v", publicos, borradores)
+	}
+	if estado, _, _ := peticion(t, srv, http.MethodGet, "/api/v1/productos/"+pendiente.ID, nil); estado != http.StatusOK {
+		t.Errorf("un borrador se puede leer por ID: %d", estado)
+	}
+
+	aplicarProgramados(context.Background())
+	if publicos, borradores := listados(); !slices.Equal(publicos, []string{"Jarrón", "Lámpara"}) || !slices.Equal(borradores, []string{"Cuenco", "Maceta"}) {
+		t.Errorf("después de aplicar: públicos %v, borradores %v", publicos, borradores)
+	}
+	if p, err := repo.Obtener(context.Background(), publicado.ID); err != nil || p.PrecioBase.String() != "8 USD" || p.PrecioDesde != nil {
+		t.Errorf("precio programado: %+v, %v", p, err)
+	}
+	if p, err := repo.Obtener(context.Background(), pendiente.ID); err != nil || p.Estado != dominio.EstadoDisponible || p.PublicarEn != nil {
+		t.Errorf("publicación programada: %+v, %v", p, err)
+	}
+}
+
+// TestConvertirPrecioProgramado comprueba que ?moneda= convierte también el
+// precio programado, para que la respuesta no mezcle monedas
+func TestConvertirPrecioProgramado(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	tasa := map[string]any{"desde": "USD", "hacia": "EUR", "tasa": "0.5", "vigente_desde": time.Now().Add(-time.Hour)}
+	if estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/tasas-cambio", tasa); estado != http.StatusCreated {
+		t.Fatalf("crear tasa: %d %s", estado, cuerpo)
+	}
+	cuerpo := productoPrueba("Jarrón", crearCategoriaPrueba(t, srv, "Decoración"))
+	cuerpo["precio_programado"] = map[string]any{"importe": "20", "moneda": "USD"}
+	cuerpo["precio_desde"] = time.Now().Add(24 * time.Hour)
+	estado, _, respuesta := peticion(t, srv, http.MethodPost, "/api/v1/productos", cuerpo)
+	if estado != http.StatusCreated {
+		t.Fatalf("crear producto: %d %s", estado, respuesta)
+	}
+	id := datos[Producto](t, respuesta).ID
+
+	estado, _, respuesta = peticion(t, srv, http.MethodGet, "/api/v1/productos/"+id+"?moneda=EUR", nil)
+	if estado != http.StatusOK {
+		t.Fatalf("obtener en EUR: %d %s", estado, respuesta)
+	}
+	p := datos[Producto](t, respuesta)
+	if p.PrecioBase.String() != "5.25 EUR" {
+		t.Errorf("precio_base: %s", p.PrecioBase)
+	}
+	if p.PrecioProgramado == nil || p.PrecioProgramado.String() != "10 EUR" {
+		t.Errorf("precio_programado: %v", p.PrecioProgramado)
+	}
+}
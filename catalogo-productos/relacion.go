@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	dominio "catalogo-dominio"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrRelacionNoEncontrada se devuelve cuando el producto no tiene la relación solicitada
+	ErrRelacionNoEncontrada = errors.New("relación no encontrada")
+	// ErrRelacionDuplicada se devuelve al crear una relación que ya existe
+	// con el mismo tipo y los mismos productos
+	ErrRelacionDuplicada = errors.New("relación duplicada")
+)
+
+// TipoRelacion es el significado de una relación entre dos productos
+type TipoRelacion string
+
+const (
+	RelacionCompatible  TipoRelacion = "compatible_con"
+	RelacionReemplaza   TipoRelacion = "reemplaza_a"
+	RelacionAccesorio   TipoRelacion = "accesorio_de"
+	RelacionAlternativa TipoRelacion = "alternativa"
+)
+
+var tiposRelacion = []TipoRelacion{RelacionCompatible, RelacionReemplaza, RelacionAccesorio, RelacionAlternativa}
+
+// Relacion es un vínculo dirigido entre dos productos que se lee como
+// "ProductoID Tipo DestinoID", p. ej. un soporte accesorio_de una lámpara
+type Relacion struct {
+	ID         string       `gorm:"primaryKey" json:"id"`
+	ProductoID string       `json:"producto_id"`
+	Tipo       TipoRelacion `json:"tipo" enums:"compatible_con,reemplaza_a,accesorio_de,alternativa"`
+	DestinoID  string       `json:"destino_id"`
+	CreadaEn   time.Time    `json:"creada_en"`
+}
+
+func (Relacion) TableName() string {
+	return "producto_relaciones"
+}
+
+// validar comprueba el tipo y el destino de una relación nueva; que el
+// destino exista lo comprueba el repositorio
+func (r Relacion) validar() dominio.ErroresValidacion {
+	var errs dominio.ErroresValidacion
+	if !slices.Contains(tiposRelacion, r.Tipo) {
+		errs.Agregar("tipo", fmt.Sprintf("debe ser %q, %q, %q o %q",
+			RelacionCompatible, RelacionReemplaza, RelacionAccesorio, RelacionAlternativa))
+	}
+	switch r.DestinoID {
+	case "":
+		errs.Agregar("destino_id", "es obligatorio")
+	case r.ProductoID:
+		errs.Agregar("destino_id", "no puede ser el propio producto")
+	}
+	return errs
+}
+
+// filtrarRelaciones aplica ?tipo= y ?direccion=salientes|entrantes a las
+// relaciones del producto id
+func filtrarRelaciones(c *gin.Context, id string, relaciones []Relacion) ([]Relacion, error) {
+	tipo := TipoRelacion(c.Query("tipo"))
+	if tipo != "" && !slices.Contains(tiposRelacion, tipo) {
+		return nil, fmt.Errorf("tipo de relación desconocido: %s", tipo)
+	}
+	direccion := c.Query("direccion")
+	if direccion != "" && direccion != "salientes" && direccion != "entrantes" {
+		return nil, errors.New("direccion debe ser salientes o entrantes")
+	}
+	return slices.DeleteFunc(relaciones, func(r Relacion) bool {
+		return tipo != "" && r.Tipo != tipo ||
+			direccion == "salientes" && r.ProductoID != id ||
+			direccion == "entrantes" && r.DestinoID != id
+	}), nil
+}
+
+// Relaciones de un producto
+// @Summary Listar las relaciones de un producto
+// @Description Lista las relaciones en las que participa el producto, tanto las que parten de él como las que lo tienen como destino, en orden de creación. Las relaciones con productos de la papelera no se devuelven
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param tipo query string false "compatible_con, reemplaza_a, accesorio_de o alternativa"
+// @Param direccion query string false "salientes (el producto es el origen) o entrantes (es el destino)"
+// @Success 200 {object} dominio.Respuesta{data=[]Relacion}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /productos/{id}/relaciones [get]
+func getProductRelations(c *gin.Context) {
+	relaciones, err := repo.ListarRelaciones(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	relaciones, err = filtrarRelaciones(c, c.Param("id"), relaciones)
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": relaciones,
+	})
+}
+
+// Crear una relación
+// @Summary Relacionar un producto con otro
+// @Description Crea una relación dirigida desde el producto hacia destino_id, p. ej. {"tipo": "accesorio_de", "destino_id": "..."} indica que el producto es accesorio del destino
+// @Tags productos
+// @Accept json
+// @Produce json
+// @Param id path string true "ID del producto de origen"
+// @Param relacion body Relacion true "Tipo y destino de la relación"
+// @Param Idempotency-Key header string false "Clave única de la operación; un reintento con la misma clave y la misma petición devuelve la respuesta guardada"
+// @Success 201 {object} dominio.Respuesta{data=Relacion}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 409 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /productos/{id}/relaciones [post]
+func createProductRelation(c *gin.Context) {
+	var relacion Relacion
+	if err := c.ShouldBindJSON(&relacion); err != nil {
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	relacion.ID = uuid.New().String()
+	relacion.ProductoID = c.Param("id")
+	relacion.Tipo = TipoRelacion(strings.TrimSpace(string(relacion.Tipo)))
+	relacion.CreadaEn = time.Now().UTC()
+	if errs := relacion.validar(); len(errs) > 0 {
+		responderRelacionInvalida(c, errs)
+		return
+	}
+	if err := repo.CrearRelacion(c.Request.Context(), &relacion); err != nil {
+		var errs dominio.ErroresValidacion
+		if errors.As(err, &errs) {
+			responderRelacionInvalida(c, errs)
+			return
+		}
+		responderError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"data": relacion,
+	})
+}
+
+// Eliminar una relación
+// @Summary Eliminar una relación de un producto
+// @Description Elimina una relación en la que el producto es origen o destino
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param relacion path string true "ID de la relación"
+// @Success 200 {object} dominio.Mensaje
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /productos/{id}/relaciones/{relacion} [delete]
+func deleteProductRelation(c *gin.Context) {
+	if err := repo.EliminarRelacion(c.Request.Context(), c.Param("id"), c.Param("relacion")); err != nil {
+		responderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": traducir(c, "Relación eliminada"),
+	})
+}
+
+func responderRelacionInvalida(c *gin.Context, errs dominio.ErroresValidacion) {
+	responderValidacion(c, "Relación inválida", errs)
+}
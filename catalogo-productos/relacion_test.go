@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// relacionar crea una relación desde origen y devuelve el estado y el cuerpo
+func relacionar(t *testing.T, srv *httptest.Server, origen string, tipo TipoRelacion, destino string) (int, []byte) {
+	t.Helper()
+	estado, _, cuerpo := peticion(t, srv, http.MethodPost, "/api/v1/productos/"+origen+"/relaciones", map[string]any{"tipo": tipo, "destino_id": destino})
+	return estado, cuerpo
+}
+
+func TestRelaciones(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Iluminación")
+	lampara := crearProductoPrueba(t, srv, "Lámpara", categoriaID)
+	soporte := crearProductoPrueba(t, srv, "Soporte", categoriaID)
+	aplique := crearProductoPrueba(t, srv, "Aplique", categoriaID)
+	borrado := crearProductoPrueba(t, srv, "Borrado", categoriaID)
+
+	estado, cuerpo := relacionar(t, srv, soporte.ID, RelacionAccesorio, lampara.ID)
+	if estado != http.StatusCreated {
+		t.Fatalf("crear relación: %d %s", estado, cuerpo)
+	}
+	accesorio := datos[Relacion](t, cuerpo)
+	if accesorio.ProductoID != soporte.ID || accesorio.DestinoID != lampara.ID || accesorio.ID == "" {
+		t.Errorf("relación creada: %+v", accesorio)
+	}
+	for _, r := range []struct {
+		origen, destino string
+		tipo            TipoRelacion
+	}{
+		{aplique.ID, lampara.ID, RelacionAlternativa},
+		{soporte.ID, aplique.ID, RelacionCompatible},
+		{soporte.ID, borrado.ID, RelacionCompatible},
+	} {
+		if estado, cuerpo := relacionar(t, srv, r.origen, r.tipo, r.destino); estado != http.StatusCreated {
+			t.Fatalf("crear relación: %d %s", estado, cuerpo)
+		}
+	}
+
+	for nombre, caso := range map[string]struct {
+		origen, destino string
+		tipo            TipoRelacion
+		estado          int
+		campo           string
+	}{
+		"duplicada":           {soporte.ID, lampara.ID, RelacionAccesorio, http.StatusConflict, ""},
+		"consigo mismo":       {soporte.ID, soporte.ID, RelacionCompatible, http.StatusUnprocessableEntity, "destino_id"},
+		"tipo desconocido":    {soporte.ID, aplique.ID, "pieza_de", http.StatusUnprocessableEntity, "tipo"},
+		"destino inexistente": {soporte.ID, "no-existe", RelacionCompatible, http.StatusUnprocessableEntity, "destino_id"},
+		"origen inexistente":  {"no-existe", lampara.ID, RelacionCompatible, http.StatusNotFound, ""},
+	} {
+		estado, cuerpo := relacionar(t, srv, caso.origen, caso.tipo, caso.destino)
+		if estado != caso.estado || caso.campo != "" && !slices.Equal(camposConError(t, cuerpo), []string{caso.campo}) {
+			t.Errorf("%s: %d %s", nombre, estado, cuerpo)
+		}
+	}
+
+	// Las relaciones con productos de la papelera no se listan
+	if err := repo.Eliminar(context.Background(), borrado.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	listar := func(id, consulta string) (int, []TipoRelacion) {
+		estado, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/productos/"+id+"/relaciones"+consulta, nil)
+		var tipos []TipoRelacion
+		for _, r := range datos[[]Relacion](t, cuerpo) {
+			tipos = append(tipos, r.Tipo)
+		}
+		return estado, tipos
+	}
+	for _, caso := range []struct {
+		id, consulta string
+		tipos        []TipoRelacion
+	}{
+		{soporte.ID, "", []TipoRelacion{RelacionAccesorio, RelacionCompatible}},
+		{lampara.ID, "", []TipoRelacion{RelacionAccesorio, RelacionAlternativa}},
+		{lampara.ID, "?direccion=entrantes", []TipoRelacion{RelacionAccesorio, RelacionAlternativa}},
+		{lampara.ID, "?direccion=salientes", nil},
+		{lampara.ID, "?tipo=alternativa", []TipoRelacion{RelacionAlternativa}},
+		{aplique.ID, "?direccion=salientes", []TipoRelacion{RelacionAlternativa}},
+	} {
+		if estado, tipos := listar(caso.id, caso.consulta); estado != http.StatusOK || !slices.Equal(tipos, caso.tipos) {
+			t.Errorf("relaciones de %s%s: %d %v, se esperaba %v", caso.id, caso.consulta, estado, tipos, caso.tipos)
+		}
+	}
+	for _, consulta := range []string{"?tipo=pieza_de", "?direccion=ambas"} {
+		if estado, _ := listar(lampara.ID, consulta); estado != http.StatusBadRequest {
+			t.Errorf("%s: %d, se esperaba 400", consulta, estado)
+		}
+	}
+	if estado, _ := listar("no-existe", ""); estado != http.StatusNotFound {
+		t.Errorf("relaciones de un producto inexistente: %d", estado)
+	}
+
+	// Se puede eliminar desde el destino, pero no desde un producto ajeno
+	ruta := func(id string) string { return "/api/v1/productos/" + id + "/relaciones/" + accesorio.ID }
+	if estado, _, _ := peticion(t, srv, http.MethodDelete, ruta(aplique.ID), nil); estado != http.StatusNotFound {
+		t.Errorf("eliminar desde un producto ajeno: %d", estado)
+	}
+	if estado, _, cuerpo := peticion(t, srv, http.MethodDelete, ruta(lampara.ID), nil); estado != http.StatusOK {
+		t.Errorf("eliminar desde el destino: %d %s", estado, cuerpo)
+	}
+	if estado, tipos := listar(soporte.ID, ""); estado != http.StatusOK || !slices.Equal(tipos, []TipoRelacion{RelacionCompatible}) {
+		t.Errorf("relaciones tras eliminar: %d %v", estado, tipos)
+	}
+}
+
+// TestIncluirRelaciones comprueba ?incluir= en GET /productos/:id
+func TestIncluirRelaciones(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Iluminación")
+	lampara := crearProductoPrueba(t, srv, "Lámpara", categoriaID)
+	soporte := crearProductoPrueba(t, srv, "Soporte", categoriaID)
+	if estado, cuerpo := relacionar(t, srv, soporte.ID, RelacionAccesorio, lampara.ID); estado != http.StatusCreated {
+		t.Fatalf("crear relación: %d %s", estado, cuerpo)
+	}
+
+	obtener := func(consulta string) (int, map[string]any) {
+		estado, _, cuerpo := peticion(t, srv, http.MethodGet, "/api/v1/productos/"+lampara.ID+consulta, nil)
+		return estado, datos[map[string]any](t, cuerpo)
+	}
+
+	estado, p := obtener("?incluir=relaciones")
+	relaciones, _ := p["relaciones"].([]any)
+	if estado != http.StatusOK || p["nombre"] != "Lámpara" || len(relaciones) != 1 || p["adjuntos"] != nil {
+		t.Errorf("incluir=relaciones: %d %v", estado, p)
+	}
+	estado, p = obtener("?incluir=relaciones,%20adjuntos")
+	if adjuntos, ok := p["adjuntos"].([]any); estado != http.StatusOK || !ok || len(adjuntos) != 0 || p["relaciones"] == nil {
+		t.Errorf("incluir=relaciones,adjuntos: %d %v", estado, p)
+	}
+	if _, p := obtener(""); p["relaciones"] != nil || p["adjuntos"] != nil {
+		t.Errorf("sin incluir: %v", p)
+	}
+	if estado, _ := obtener("?incluir=revisiones"); estado != http.StatusBadRequest {
+		t.Errorf("incluir desconocido: %d", estado)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"catalogo-comun/idempotencia"
+	"catalogo-comun/listado"
+	dominio "catalogo-dominio"
+	"catalogo-dominio/dinero"
+)
+
+// ErrProductoNoEncontrado se devuelve cuando no existe un producto con el ID solicitado
+var ErrProductoNoEncontrado = errors.New("producto no encontrado")
+
+// ErrConflictoVersion se devuelve cuando la versión esperada de un producto no
+// coincide con la almacenada, es decir, otro cliente lo modificó antes
+var ErrConflictoVersion = errors.New("la versión del producto no coincide")
+
+// ErrModeloNoEncontrado se devuelve cuando el producto no tiene un modelo 3D
+var ErrModeloNoEncontrado = errors.New("el producto no tiene modelo 3D")
+
+// ErrModeloNoImprimible se devuelve cuando al volver a analizar el modelo 3D
+// de un producto disponible resulta que ya no es imprimible
+var ErrModeloNoImprimible = errors.New("el modelo 3D de un producto disponible no es imprimible")
+
+// RepositorioProductos abstrae el almacenamiento de productos para que los
+// handlers no dependan de una base de datos concreta.
+//
+// Actualizar y Eliminar reciben la versión que el cliente espera modificar;
+// 0 significa cualquier versión. Cada escritura incrementa Producto.Version.
+// Crear y Actualizar registran en el historial cada cambio de estado y
+// guardan una revisión con el actor de ctx (ver identificarActor).
+//
+// Eliminar mueve el producto a la papelera: deja de aparecer en las demás
+// operaciones hasta que se restaura o se purga. Crear y Actualizar ignoran
+// Producto.EliminadoEn. Eliminar devuelve ErrProductoEnKit si el producto es
+// componente de algún kit, también de uno en la papelera.
+type RepositorioProductos interface {
+	Listar(ctx context.Context, consulta ConsultaProductos) ([]Producto, listado.Resultado, error)
+	Obtener(ctx context.Context, id string) (Producto, error)
+	Crear(ctx context.Context, producto *Producto) error
+	Actualizar(ctx context.Context, producto *Producto, version int) error
+	Eliminar(ctx context.Context, id string, version int) error
+	Historial(ctx context.Context, id string) ([]TransicionEstado, error)
+
+	// Revisiones devuelve las revisiones de un producto en orden, con el
+	// producto completo de cada una
+	Revisiones(ctx context.Context, id string) ([]Revision, error)
+	Revision(ctx context.Context, id string, numero int) (Revision, error)
+
+	// ListarPapelera lista los productos eliminados con los mismos filtros
+	// que Listar
+	ListarPapelera(ctx context.Context, consulta ConsultaProductos) ([]Producto, listado.Resultado, error)
+	// Restaurar saca un producto de la papelera; devuelve un error de campo
+	// en sku si otro producto tomó su SKU mientras estaba eliminado
+	Restaurar(ctx context.Context, id string) (Producto, error)
+	// Purgar borra definitivamente, con su historial y su modelo, los
+	// productos eliminados antes de limite y devuelve cuántos borró
+	Purgar(ctx context.Context, limite time.Time) (int64, error)
+
+	// Programados devuelve los productos con un cambio de precio o, si son
+	// borradores, una publicación programados para hasta o antes
+	Programados(ctx context.Context, hasta time.Time) ([]Producto, error)
+
+	// GuardarModelo reemplaza el modelo 3D de un producto y actualiza el
+	// producto, que lleva la geometría extraída, en una sola operación
+	GuardarModelo(ctx context.Context, producto *Producto, modelo *ModeloProducto, version int) error
+	ObtenerModelo(ctx context.Context, id string) (ModeloProducto, error)
+
+	// BuscarPorSKU devuelve los productos con alguno de los SKU indicados,
+	// indexados por SKU
+	BuscarPorSKU(ctx context.Context, skus []string) (map[string]Producto, error)
+	// BuscarPorID devuelve los productos con alguno de los IDs indicados,
+	// indexados por ID; los que no existen se omiten
+	BuscarPorID(ctx context.Context, ids []string) (map[string]Producto, error)
+	// Importar crea y actualiza un lote de productos de forma atómica: si
+	// alguna escritura falla no se aplica ninguna
+	Importar(ctx context.Context, crear []*Producto, actualizar []ActualizacionProducto) error
+	// AplicarLote aplica en orden las operaciones de un lote de forma
+	// atómica. Si una falla no se aplica ninguna y el error es un
+	// ErrorOperacion con la posición de la que falló
+	AplicarLote(ctx context.Context, ops []OperacionLote) error
+
+	// ListarRelaciones devuelve las relaciones en las que participa el
+	// producto, en orden de creación, salvo las que lo unen a un producto de
+	// la papelera. CrearRelacion devuelve un error de campo en destino_id si
+	// el destino no existe. Las relaciones de un producto se borran al purgarlo
+	ListarRelaciones(ctx context.Context, id string) ([]Relacion, error)
+	CrearRelacion(ctx context.Context, relacion *Relacion) error
+	EliminarRelacion(ctx context.Context, id, relacionID string) error
+
+	// ListarAdjuntos devuelve los adjuntos del producto en el orden de su
+	// galería. CrearAdjunto lo añade al final y devuelve ErrAdjuntoDuplicado
+	// si el producto ya tiene otro con el mismo SHA-256. OrdenarAdjuntos
+	// recibe los IDs de todos los adjuntos en el nuevo orden y devuelve un
+	// error de campo en ids si no coinciden. AdjuntoEnUso indica si algún
+	// adjunto, también de un producto en la papelera, usa ese contenido. Los
+	// adjuntos de un producto se borran al purgarlo; sus archivos no, de eso
+	// se encarga el barrido del almacén
+	ListarAdjuntos(ctx context.Context, id string) ([]Adjunto, error)
+	ObtenerAdjunto(ctx context.Context, id, adjuntoID string) (Adjunto, error)
+	CrearAdjunto(ctx context.Context, adjunto *Adjunto) error
+	EliminarAdjunto(ctx context.Context, id, adjuntoID string) error
+	OrdenarAdjuntos(ctx context.Context, id string, ids []string) ([]Adjunto, error)
+	AdjuntoEnUso(ctx context.Context, sha string) (bool, error)
+
+	// Las claves de idempotencia se guardan junto a los productos para que
+	// los reintentos funcionen entre instancias
+	idempotencia.Almacen
+
+	// ListarTasas devuelve todas las tasas de cambio; CrearTasa devuelve
+	// ErrTasaDuplicada si el par ya tiene una tasa con la misma vigencia
+	ListarTasas(ctx context.Context) ([]dinero.TasaCambio, error)
+	CrearTasa(ctx context.Context, tasa *dinero.TasaCambio) error
+	EliminarTasa(ctx context.Context, id string) error
+
+	// Las definiciones de atributos se guardan junto a los productos;
+	// EliminarAtributo devuelve ErrAtributoEnUso si algún producto, también
+	// en la papelera, tiene valor para el atributo
+	ListarAtributos(ctx context.Context) ([]DefinicionAtributo, error)
+	ObtenerAtributo(ctx context.Context, codigo string) (DefinicionAtributo, error)
+	CrearAtributo(ctx context.Context, def *DefinicionAtributo) error
+	ActualizarAtributo(ctx context.Context, def *DefinicionAtributo, version int) error
+	EliminarAtributo(ctx context.Context, codigo string, version int) error
+
+	// Las categorías se guardan junto a los productos para mantener la
+	// integridad referencial: Crear y Actualizar devuelven un error de campo
+	// en categoria_id si la categoría no existe, y EliminarCategoria devuelve
+	// ErrCategoriaEnUso si tiene subcategorías o productos
+	ListarCategorias(ctx context.Context) ([]Categoria, error)
+	ObtenerCategoria(ctx context.Context, id string) (Categoria, error)
+	CrearCategoria(ctx context.Context, categoria *Categoria) error
+	ActualizarCategoria(ctx context.Context, categoria *Categoria, version int) error
+	EliminarCategoria(ctx context.Context, id string, version int) error
+}
+
+// ActualizacionProducto es un producto a reemplazar junto con la versión que
+// se espera modificar, como en Actualizar
+type ActualizacionProducto struct {
+	Producto *Producto
+	Version  int
+}
+
+// OperacionLote es una escritura de un lote: crear, actualizar o eliminar un
+// producto o una categoría. Producto o Categoria, según Recurso, llevan lo que
+// se crea o actualiza; para eliminar basta ID. Version es la versión que se
+// espera modificar, como en Actualizar
+type OperacionLote struct {
+	Tipo      TipoOperacion
+	Recurso   RecursoLote
+	Producto  *Producto
+	Categoria *Categoria
+	ID        string
+	Version   int
+}
+
+// TipoOperacion es la escritura que hace una operación de un lote
+type TipoOperacion string
+
+const (
+	OperacionCrear      TipoOperacion = "crear"
+	OperacionActualizar TipoOperacion = "actualizar"
+	OperacionEliminar   TipoOperacion = "eliminar"
+)
+
+// RecursoLote es el tipo de recurso sobre el que actúa una operación de un lote
+type RecursoLote string
+
+const (
+	RecursoProductos  RecursoLote = "productos"
+	RecursoCategorias RecursoLote = "categorias"
+)
+
+// ErrorOperacion es el error de la operación Indice de un lote
+type ErrorOperacion struct {
+	Indice int
+	Err    error
+}
+
+func (e ErrorOperacion) Error() string {
+	return fmt.Sprintf("operación %d: %v", e.Indice, e.Err)
+}
+
+func (e ErrorOperacion) Unwrap() error {
+	return e.Err
+}
+
+// errSKUEnUso es el error de validación de un producto cuyo SKU ya tiene otro producto
+func errSKUEnUso() error {
+	return dominio.ErroresValidacion{{Campo: "sku", Mensaje: "ya está en uso"}}
+}
+
+// errCategoriaInexistente es el error de validación de un producto cuya
+// categoría no existe
+func errCategoriaInexistente() error {
+	return dominio.ErroresValidacion{{Campo: "categoria_id", Mensaje: "no existe"}}
+}
+
+// columnasOrden traduce los campos de orden cuyo nombre en la API no
+// coincide con la columna en PostgreSQL
+var columnasOrden = map[string]string{
+	"precio_base": "precio_base_importe",
+}
+
+// ConsultaProductos describe los filtros, el orden y la ventana de un listado
+// de productos. Orden siempre termina en el ID, ver listado.Parse
+type ConsultaProductos struct {
+	// Estados filtra por cualquiera de estos estados; vacío no filtra
+	Estados []dominio.EstadoProducto
+	// Categorias filtra por cualquiera de estas categorías; vacío no filtra
+	Categorias []string
+	// Etiquetas exige todas estas etiquetas y Atributos todos estos filtros
+	Etiquetas []string
+	Atributos []FiltroAtributo
+	// Dimensiones exige todos estos rangos, en milímetros
+	Dimensiones []dominio.FiltroDimension
+	// Componente filtra los kits que incluyen ese producto
+	Componente string
+	Orden      []listado.CampoOrden
+	Ventana    listado.Ventana
+}
+
+// cumple aplica a un producto los filtros de estado, etiquetas, atributos,
+// dimensiones y componente; las categorías las filtra cada repositorio con
+// sus índices
+func (c ConsultaProductos) cumple(p Producto) bool {
+	if len(c.Estados) > 0 && !slices.Contains(c.Estados, p.Estado) {
+		return false
+	}
+	for _, e := range c.Etiquetas {
+		if !slices.Contains(p.Etiquetas, e) {
+			return false
+		}
+	}
+	for _, f := range c.Atributos {
+		if !f.cumple(p) {
+			return false
+		}
+	}
+	for _, f := range c.Dimensiones {
+		if !f.Cumple(p.Dimensiones) {
+			return false
+		}
+	}
+	if c.Componente != "" && !slices.ContainsFunc(p.Componentes, func(comp Componente) bool { return comp.ProductoID == c.Componente }) {
+		return false
+	}
+	return true
+}
+
+// ordenablesProductos son los campos por los que se puede ordenar un listado
+// de productos. Las claves coinciden con las columnas en PostgreSQL salvo las
+// de columnasOrden. precio_base ordena por importe sin convertir la moneda
+var ordenablesProductos = map[string]func(Producto) any{
+	"id":           func(p Producto) any { return p.ID },
+	"sku":          func(p Producto) any { return p.SKU },
+	"nombre":       func(p Producto) any { return p.Nombre },
+	"precio_base":  func(p Producto) any { return p.PrecioBase.Importe.Float64() },
+	"categoria_id": func(p Producto) any { return p.CategoriaID },
+	"estado":       func(p Producto) any { return string(p.Estado) },
+}
+
+// camposProductos son los campos que se pueden pedir con ?fields=
+var camposProductos = map[string]bool{
+	"id":                true,
+	"sku":               true,
+	"nombre":            true,
+	"descripcion":       true,
+	"precio_base":       true,
+	"dimensiones":       true,
+	"categoria_id":      true,
+	"estado":            true,
+	"geometria":         true,
+	"imprimibilidad":    true,
+	"version":           true,
+	"eliminado_en":      true,
+	"traducciones":      true,
+	"atributos":         true,
+	"etiquetas":         true,
+	"componentes":       true,
+	"descuento_kit":     true,
+	"disponible":        true,
+	"publicar_en":       true,
+	"precio_programado": true,
+	"precio_desde":      true,
+}
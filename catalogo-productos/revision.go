@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"catalogo-comun/condicional"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrRevisionNoEncontrada se devuelve cuando el producto no tiene la revisión solicitada
+var ErrRevisionNoEncontrada = errors.New("revisión no encontrada")
+
+const (
+	// actorAnonimo es el actor de las escrituras sin cabecera X-Usuario
+	actorAnonimo = "anónimo"
+	// actorSistema es el actor de las revisiones base
+	actorSistema = "sistema"
+)
+
+// camposSinRevision no se comparan entre revisiones: cambian en cada
+// escritura o no forman parte del contenido del producto
+var camposSinRevision = map[string]bool{
+	"version":      true,
+	"eliminado_en": true,
+}
+
+// CambioCampo es la diferencia de un campo entre dos revisiones. Los campos
+// anidados se nombran con puntos, p. ej. dimensiones.ancho
+type CambioCampo struct {
+	Campo    string `json:"campo"`
+	Anterior any    `json:"anterior"`
+	Nuevo    any    `json:"nuevo"`
+}
+
+// Diferencias son los cambios entre dos revisiones de un producto. Desde es 0
+// al comparar con la creación
+type Diferencias struct {
+	Desde   int           `json:"desde"`
+	Hasta   int           `json:"hasta"`
+	Cambios []CambioCampo `json:"cambios"`
+}
+
+// Revision es una versión inmutable de un producto. Numero coincide con
+// Producto.Version tras la escritura y Producto guarda el producto completo
+// para poder compararlo con cualquier otra revisión o revertirlo
+type Revision struct {
+	ProductoID string          `gorm:"primaryKey" json:"producto_id"`
+	Numero     int             `gorm:"primaryKey" json:"numero"`
+	Actor      string          `json:"actor"`
+	Fecha      time.Time       `json:"fecha"`
+	Cambios    []CambioCampo   `gorm:"serializer:json" json:"cambios"`
+	Producto   json.RawMessage `gorm:"serializer:json" json:"producto,omitempty" swaggertype:"object"`
+}
+
+func (Revision) TableName() string {
+	return "producto_revisiones"
+}
+
+type claveActor struct{}
+
+// identificarActor guarda en el contexto de la petición quién la hace, según
+// la cabecera X-Usuario, para registrarlo en las revisiones
+func identificarActor(c *gin.Context) {
+	if actor := strings.TrimSpace(c.GetHeader("X-Usuario")); actor != "" {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), claveActor{}, actor))
+	}
+	c.Next()
+}
+
+func actorDe(ctx context.Context) string {
+	if actor, ok := ctx.Value(claveActor{}).(string); ok {
+		return actor
+	}
+	return actorAnonimo
+}
+
+// revisionBase es la revisión de partida de un producto que no tiene la de su
+// versión actual, porque se guardó antes de que existieran las revisiones o
+// se cargó sin pasar por una escritura. Refleja el producto tal como está,
+// igual que la revisión de un alta, a nombre de actorSistema
+func revisionBase(p Producto) (Revision, error) {
+	return nuevaRevision(context.WithValue(context.Background(), claveActor{}, actorSistema), nil, p)
+}
+
+// nuevaRevision construye la revisión de una escritura. anterior es nil al
+// crear el producto
+func nuevaRevision(ctx context.Context, anterior *Producto, producto Producto) (Revision, error) {
+	despues, err := json.Marshal(producto)
+	if err != nil {
+		return Revision{}, err
+	}
+	var antes json.RawMessage
+	if anterior != nil {
+		if antes, err = json.Marshal(anterior); err != nil {
+			return Revision{}, err
+		}
+	}
+	cambios, err := diferencias(antes, despues)
+	if err != nil {
+		return Revision{}, err
+	}
+	return Revision{
+		ProductoID: producto.ID,
+		Numero:     producto.Version,
+		Actor:      actorDe(ctx),
+		Fecha:      time.Now().UTC(),
+		Cambios:    cambios,
+		Producto:   despues,
+	}, nil
+}
+
+// diferencias compara dos documentos JSON campo a campo; un documento vacío
+// equivale a no tener campos
+func diferencias(antes, despues json.RawMessage) ([]CambioCampo, error) {
+	a, err := aplanarJSON(antes)
+	if err != nil {
+		return nil, err
+	}
+	d, err := aplanarJSON(despues)
+	if err != nil {
+		return nil, err
+	}
+
+	campos := make(map[string]bool)
+	for k := range a {
+		campos[k] = true
+	}
+	for k := range d {
+		campos[k] = true
+	}
+	cambios := []CambioCampo{}
+	for campo := range campos {
+		if camposSinRevision[strings.SplitN(campo, ".", 2)[0]] {
+			continue
+		}
+		if !reflect.DeepEqual(a[campo], d[campo]) {
+			cambios = append(cambios, CambioCampo{Campo: campo, Anterior: a[campo], Nuevo: d[campo]})
+		}
+	}
+	slices.SortFunc(cambios, func(x, y CambioCampo) int { return strings.Compare(x.Campo, y.Campo) })
+	return cambios, nil
+}
+
+// aplanarJSON convierte un objeto JSON en un mapa de rutas con puntos a
+// valores; las listas se tratan como un único valor
+func aplanarJSON(doc json.RawMessage) (map[string]any, error) {
+	plano := make(map[string]any)
+	if len(doc) == 0 {
+		return plano, nil
+	}
+	var raiz map[string]any
+	if err := json.Unmarshal(doc, &raiz); err != nil {
+		return nil, err
+	}
+	var recorrer func(prefijo string, obj map[string]any)
+	recorrer = func(prefijo string, obj map[string]any) {
+		for k, v := range obj {
+			if hijo, ok := v.(map[string]any); ok {
+				recorrer(prefijo+k+".", hijo)
+				continue
+			}
+			plano[prefijo+k] = v
+		}
+	}
+	recorrer("", raiz)
+	return plano, nil
+}
+
+// Revisiones de un producto
+// @Summary Listar las revisiones de un producto
+// @Description Lista en orden las revisiones del producto, una por escritura, con el actor (cabecera X-Usuario), la fecha y los campos cambiados respecto a la anterior
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Success 200 {object} dominio.Respuesta{data=[]Revision}
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /productos/{id}/revisiones [get]
+func getProductRevisions(c *gin.Context) {
+	revisiones, err := repo.Revisiones(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+
+	// El producto completo de cada revisión se consulta de una en una
+	for i := range revisiones {
+		revisiones[i].Producto = nil
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": revisiones,
+	})
+}
+
+// Revisión de un producto
+// @Summary Obtener una revisión de un producto
+// @Description Devuelve la revisión con el producto completo tal como quedó tras esa escritura
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param rev path int true "Número de revisión"
+// @Success 200 {object} dominio.Respuesta{data=Revision}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /productos/{id}/revisiones/{rev} [get]
+func getProductRevision(c *gin.Context) {
+	numero, err := strconv.Atoi(c.Param("rev"))
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "rev debe ser un número de revisión"))
+		return
+	}
+	revision, err := repo.Revision(c.Request.Context(), c.Param("id"), numero)
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": revision,
+	})
+}
+
+// Diferencias entre revisiones
+// @Summary Comparar dos revisiones de un producto
+// @Description Devuelve los campos que difieren entre dos revisiones. Sin hasta se usa la última revisión y sin desde la anterior a hasta
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param desde query int false "Revisión de partida"
+// @Param hasta query int false "Revisión de llegada"
+// @Success 200 {object} dominio.Respuesta{data=Diferencias}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Router /productos/{id}/revisiones/diff [get]
+func getProductRevisionsDiff(c *gin.Context) {
+	revisiones, err := repo.Revisiones(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	if len(revisiones) == 0 {
+		responderError(c, ErrRevisionNoEncontrada)
+		return
+	}
+
+	buscar := func(param string, porDefecto int) (int, error) {
+		s := c.Query(param)
+		if s == "" {
+			return porDefecto, nil
+		}
+		numero, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, errors.New(param + " debe ser un número de revisión")
+		}
+		i := slices.IndexFunc(revisiones, func(r Revision) bool { return r.Numero == numero })
+		if i < 0 {
+			return 0, ErrRevisionNoEncontrada
+		}
+		return i, nil
+	}
+	fallo := func(err error) {
+		if errors.Is(err, ErrRevisionNoEncontrada) {
+			responderError(c, err)
+			return
+		}
+		responderMensaje(c, http.StatusBadRequest, err.Error())
+	}
+	hasta, err := buscar("hasta", len(revisiones)-1)
+	if err != nil {
+		fallo(err)
+		return
+	}
+	// Sin desde se compara con la revisión previa; la primera se compara con nada
+	desde, err := buscar("desde", hasta-1)
+	if err != nil {
+		fallo(err)
+		return
+	}
+
+	var antes json.RawMessage
+	numeroDesde := 0
+	if desde >= 0 {
+		antes, numeroDesde = revisiones[desde].Producto, revisiones[desde].Numero
+	}
+	cambios, err := diferencias(antes, revisiones[hasta].Producto)
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": Diferencias{Desde: numeroDesde, Hasta: revisiones[hasta].Numero, Cambios: cambios},
+	})
+}
+
+// Revertir un producto
+// @Summary Revertir un producto a una revisión
+// @Description Guarda como nueva revisión el contenido del producto en la revisión indicada. Se valida como cualquier actualización, incluida la transición de estado; la geometría y el modelo 3D no se revierten
+// @Tags productos
+// @Produce json
+// @Param id path string true "ID del producto"
+// @Param rev path int true "Número de revisión"
+// @Param If-Match header string false "ETag obtenido al leer el producto"
+// @Param Idempotency-Key header string false "Clave única de la operación; un reintento con la misma clave y la misma petición devuelve la respuesta guardada"
+// @Success 200 {object} dominio.Respuesta{data=Producto}
+// @Failure 400 {object} dominio.RespuestaError
+// @Failure 404 {object} dominio.RespuestaError
+// @Failure 412 {object} dominio.RespuestaError
+// @Failure 422 {object} dominio.RespuestaError
+// @Router /productos/{id}/revisiones/{rev}/revertir [post]
+func revertProductRevision(c *gin.Context) {
+	version, ok := condicional.VersionIfMatch(c)
+	if !ok {
+		responderError(c, ErrConflictoVersion)
+		return
+	}
+	numero, err := strconv.Atoi(c.Param("rev"))
+	if err != nil {
+		responderMensaje(c, http.StatusBadRequest, traducir(c, "rev debe ser un número de revisión"))
+		return
+	}
+
+	revision, err := repo.Revision(c.Request.Context(), c.Param("id"), numero)
+	if err != nil {
+		responderError(c, err)
+		return
+	}
+	var producto Producto
+	if err := json.Unmarshal(revision.Producto, &producto); err != nil {
+		responderError(c, err)
+		return
+	}
+
+	producto.ID = c.Param("id")
+	guardarProducto(c, &producto, version)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"catalogo-comun/condicional"
+)
+
+// TestRevisionBase comprueba que un producto sin revisiones, como los
+// anteriores a la migración 12, recibe una revisión base en la primera
+// escritura y que esa revisión se puede revertir
+func TestRevisionBase(t *testing.T) {
+	srv := nuevoServidorPrueba(t)
+	categoriaID := crearCategoriaPrueba(t, srv, "Decoración")
+	jarron := crearProductoPrueba(t, srv, "Jarrón", categoriaID)
+	memoria := repo.(*repositorioMemoria)
+	delete(memoria.revisiones, jarron.ID)
+
+	cambios := productoPrueba("Jarrón azul", categoriaID)
+	ruta := "/api/v1/productos/" + jarron.ID
+	if estado, _, cuerpo := peticion(t, srv, http.MethodPut, ruta, cambios, "If-Match", condicional.ETag(jarron.Version)); estado != http.StatusOK {
+		t.Fatalf("actualizar: %d %s", estado, cuerpo)
+	}
+
+	revisiones, err := repo.Revisiones(context.Background(), jarron.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisiones) != 2 || revisiones[0].Numero != jarron.Version || revisiones[0].Actor != actorSistema || revisiones[1].Numero != jarron.Version+1 {
+		t.Fatalf("revisiones tras la primera escritura: %+v", revisiones)
+	}
+
+	estado, _, cuerpo := peticion(t, srv, http.MethodPost, ruta+"/revisiones/1/revertir", nil)
+	if estado != http.StatusOK {
+		t.Fatalf("revertir a la revisión base: %d %s", estado, cuerpo)
+	}
+	if p := datos[Producto](t, cuerpo); p.Nombre != jarron.Nombre || p.Version != jarron.Version+2 {
+		t.Errorf("producto revertido: %+v", p)
+	}
+}
+
+func TestRevisionBaseIniciales(t *testing.T) {
+	p := Producto{ID: "inicial", Nombre: "Inicial", Version: 3}
+	r := newRepositorioMemoria(p)
+	revisiones, err := r.Revisiones(context.Background(), p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisiones) != 1 || revisiones[0].Numero != p.Version || revisiones[0].Actor != actorSistema {
+		t.Errorf("revisiones de un producto inicial: %+v", revisiones)
+	}
+}
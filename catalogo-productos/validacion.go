@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	dominio "catalogo-dominio"
+)
+
+// longitudMaximaSKU limita el código de referencia del proveedor
+const longitudMaximaSKU = 64
+
+// validarProducto aplica las reglas de dominio de un producto. Los campos
+// obligatorios son los marcados con validate:"required" en Producto, que es
+// lo que documenta la especificación OpenAPI
+func validarProducto(p Producto) error {
+	var errs dominio.ErroresValidacion
+
+	if p.SKU != strings.TrimSpace(p.SKU) || len(p.SKU) > longitudMaximaSKU {
+		errs.Agregar("sku", fmt.Sprintf("no puede tener espacios al inicio o al final ni más de %d caracteres", longitudMaximaSKU))
+	}
+	if strings.TrimSpace(p.Nombre) == "" {
+		errs.Agregar("nombre", "es obligatorio")
+	}
+	if strings.TrimSpace(p.Descripcion) == "" {
+		errs.Agregar("descripcion", "es obligatoria")
+	}
+	errsPrecio := p.PrecioBase.Validar(true)
+	for _, sub := range []string{"importe", "moneda"} {
+		if msg, ok := errsPrecio[sub]; ok {
+			errs.Agregar("precio_base."+sub, msg)
+		}
+	}
+	p.Dimensiones.Validar("dimensiones", &errs)
+	if strings.TrimSpace(p.CategoriaID) == "" {
+		errs.Agregar("categoria_id", "es obligatoria")
+	}
+	p.Estado.Validar(&errs)
+	p.Traducciones.validar(&errs)
+	validarEtiquetas(p.Etiquetas, &errs)
+	validarComponentes(p, &errs)
+	validarProgramacion(p, &errs)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validarProductoNuevo valida un producto que se va a crear; además de las
+// reglas generales exige que empiece en un estado inicial del ciclo de vida
+func validarProductoNuevo(p Producto) error {
+	err := validarProducto(p)
+	if p.Estado.Inicial() {
+		return err
+	}
+
+	errs, _ := err.(dominio.ErroresValidacion)
+	if p.Estado.Valido() {
+		errs.Agregar("estado", fmt.Sprintf("un producto nuevo debe estar en %q o %q", dominio.EstadoBorrador, dominio.EstadoDisponible))
+	}
+	return errs
+}
@@ -0,0 +1,3 @@
+-- Se ejecuta solo la primera vez que arranca el contenedor de PostgreSQL, con
+-- el volumen vacío. La base de datos productos la crea POSTGRES_DB
+CREATE DATABASE materiales;